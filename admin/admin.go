@@ -0,0 +1,77 @@
+// Package admin provides an optional embedded HTTP dashboard for a ChatsManager.
+// It renders a minimal web UI with active chats, last activity, estimated token usage,
+// MCP server status and storage health, and exposes the same data as JSON, so small
+// deployments get basic observability without extra tooling.
+//
+// Example:
+//
+//	http.Handle("/admin/", http.StripPrefix("/admin", admin.Handler(cm)))
+package admin
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/xyzj/llm"
+
+	"github.com/xyzj/toolbox/json"
+)
+
+//go:embed dashboard.html
+var dashboardHTML string
+
+var dashboard = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	// ago measures on the clock of the manager, see llm.Stats.Now
+	"ago": func(t, now time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return now.Sub(t).Truncate(time.Second).String()
+	},
+}).Parse(dashboardHTML))
+
+// Source provides the data displayed by the dashboard. It is implemented by *llm.ChatsManager.
+type Source interface {
+	List() []llm.ChatInfo
+	Stats() *llm.Stats
+}
+
+// Handler returns an http.Handler serving the dashboard.
+//
+// Routes (relative to where the handler is mounted):
+//   - GET /: HTML dashboard, refreshed every 10 seconds
+//   - GET /api/stats: manager statistics as JSON
+//   - GET /api/chats: active chat sessions as JSON
+func Handler(src Source) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, src.Stats())
+	})
+	mux.HandleFunc("GET /api/chats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, src.List())
+	})
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := dashboard.Execute(w, map[string]any{
+			"Stats": src.Stats(),
+			"Chats": src.List(),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	return mux
+}
+
+// writeJSON writes v as a JSON response.
+func writeJSON(w http.ResponseWriter, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,51 @@
+package llm
+
+import (
+	"context"
+	"errors"
+
+	"github.com/xyzj/llm/provider"
+)
+
+// ErrToolDenied is the error of tool calls rejected by the approval function of the turn,
+// see ApprovalContext. It is sent to the model as the tool result.
+var ErrToolDenied = errors.New("tool call denied by the user")
+
+// ToolApprovalFunc decides whether a tool call requested by the model may run, e.g. by
+// asking the user. It is called concurrently for the calls of one model response.
+//
+// Parameters:
+//   - ctx: Context of the turn
+//   - chatID: Internal key of the chat
+//   - tc: The requested tool call
+//
+// Returns:
+//   - bool: Whether the call may run
+//   - error: Any error deciding, the call does not run then
+type ToolApprovalFunc func(ctx context.Context, chatID string, tc *provider.ToolCall) (bool, error)
+
+// approvalKey is the context key of the ToolApprovalFunc of a turn.
+type approvalKey struct{}
+
+// ApprovalContext returns a copy of ctx making every tool call of the turns run with it,
+// see ChatContext, wait for the approval of f. Calls that are not approved are answered
+// with ErrToolDenied, so the model can react to the refusal.
+func ApprovalContext(ctx context.Context, f ToolApprovalFunc) context.Context {
+	return context.WithValue(ctx, approvalKey{}, f)
+}
+
+// approve asks the approval function of ctx, if any, whether tc may run.
+func approve(ctx context.Context, chatID string, tc *provider.ToolCall) error {
+	f, ok := ctx.Value(approvalKey{}).(ToolApprovalFunc)
+	if !ok || f == nil {
+		return nil
+	}
+	ok, err := f(ctx, chatID, tc)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrToolDenied
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// running tracks the cancel functions of the turns in flight per chat, see Cancel.
+type running struct {
+	sync.Mutex
+	turns  map[string]map[*context.CancelCauseFunc]struct{} // Cancel functions of the running turns by chat
+	wg     sync.WaitGroup                                   // Running turns, see close
+	closed bool                                             // Whether new turns are refused, see close
+}
+
+// start returns a copy of ctx canceled by Cancel for the chat id, and the function
+// ending the turn. After close the context is canceled with ErrClosed.
+func (r *running) start(ctx context.Context, id string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		cancel(ErrClosed)
+		return ctx, func() {}
+	}
+	r.wg.Add(1)
+	if r.turns == nil {
+		r.turns = make(map[string]map[*context.CancelCauseFunc]struct{})
+	}
+	if r.turns[id] == nil {
+		r.turns[id] = make(map[*context.CancelCauseFunc]struct{})
+	}
+	r.turns[id][&cancel] = struct{}{}
+	r.Unlock()
+	return ctx, func() {
+		r.Lock()
+		delete(r.turns[id], &cancel)
+		if len(r.turns[id]) == 0 {
+			delete(r.turns, id)
+		}
+		r.Unlock()
+		cancel(nil)
+		r.wg.Done()
+	}
+}
+
+// close refuses new turns and waits for the running ones to end. Turns still running
+// when ctx is done are canceled like with cancel, and waited for to end.
+//
+// Returns:
+//   - error: ctx.Err() if turns had to be canceled
+func (r *running) close(ctx context.Context) error {
+	r.Lock()
+	r.closed = true
+	r.Unlock()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	r.Lock()
+	for _, turns := range r.turns {
+		for cancel := range turns {
+			(*cancel)(ErrTurnCanceled)
+		}
+	}
+	r.Unlock()
+	<-done
+	return ctx.Err()
+}
+
+// cancel cancels the running turns of the chat id and reports whether there were any.
+func (r *running) cancel(id string) bool {
+	r.Lock()
+	defer r.Unlock()
+	for cancel := range r.turns[id] {
+		(*cancel)(ErrTurnCanceled)
+	}
+	return len(r.turns[id]) > 0
+}
+
+// active reports whether a turn of the chat id is running.
+func (r *running) active(id string) bool {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.turns[id]) > 0
+}
+
+// Cancel aborts the running turns of a chat session, e.g. for a "stop generating"
+// button: the in-flight completion or stream and the tool calls are canceled and the
+// turns end with an error of kind ErrTurnCanceled, see ChatE. The user message stays in
+// the history, the answer streamed so far is not stored.
+//
+// Parameters:
+//   - id: Identifier of the chat session as passed to Chat
+//
+// Returns:
+//   - bool: Whether a turn of the session was running
+func (cm *ChatsManager) Cancel(id string) bool {
+	return cm.running.cancel(id)
+}
+
+// canceledError is the error of a request of a turn canceled with Cancel. It reads as
+// its cause and matches ErrTurnCanceled, the kind of the turn.
+type canceledError struct {
+	err error // Error of the request, e.g. context.Canceled
+}
+
+func (e *canceledError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns ErrTurnCanceled and the cause of the error.
+func (e *canceledError) Unwrap() []error {
+	return []error{ErrTurnCanceled, e.err}
+}
+
+// canceled returns err of a request as a *canceledError if its turn was canceled with
+// Cancel.
+func canceled(ctx context.Context, err error) error {
+	if errors.Is(context.Cause(ctx), ErrTurnCanceled) && !errors.Is(err, ErrTurnCanceled) {
+		return &canceledError{err: err}
+	}
+	return err
+}
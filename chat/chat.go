@@ -1,44 +1,138 @@
-// Package chat provides chat functionality with AI models through the VolcEngine ARK runtime.
+// Package chat provides chat functionality with AI models through a pluggable provider.Provider,
+// the VolcEngine ARK runtime by default.
 // It supports both streaming and non-streaming chat completions, tool calling, and chat history management.
 package chat
 
 import (
 	"context"
 	"io"
+	"maps"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/xyzj/llm/clock"
 	"github.com/xyzj/llm/history"
+	"github.com/xyzj/llm/provider"
+	"github.com/xyzj/llm/transform"
 
-	"github.com/volcengine/volcengine-go-sdk/service/arkruntime"
-	"github.com/volcengine/volcengine-go-sdk/service/arkruntime/model"
 	"github.com/volcengine/volcengine-go-sdk/volcengine"
 	"github.com/xyzj/toolbox/json"
+	"github.com/xyzj/toolbox/mapfx"
 )
 
 type (
 	// Opt contains options for individual chat requests.
 	Opt struct {
-		toolcalled []*model.ChatCompletionMessage // Previously called tool messages to include in the chat
-		roleSystem []*model.ChatCompletionMessage // System role messages to include in the chat
-		tools      []*model.Tool                  // Available tools for the chat completion
-		writeFunc  func(data []byte) error        // Function to write streaming response data
-		model      string                         // Model name to use for this specific request
-		stream     bool                           // Whether to use streaming response
+		toolcalled  []*provider.Message     // Previously called tool messages to include in the chat
+		message     *Message                // Additional parts of the user message, e.g. images
+		roleSystem  []*provider.Message     // System role messages to include in the chat
+		context     []*provider.Message     // Context of the request sent before the last user message, not stored
+		tools       []*provider.Tool        // Available tools for the chat completion
+		builtin     []*provider.Tool        // Provider-native tools executed by the provider itself
+		writeFunc   func(data []byte) error // Function to write streaming response data
+		handler     StreamHandler           // Receives content, tool call fragments and completion, may be nil
+		transform   transform.Stage         // Post-processing applied to the assistant output
+		model       string                  // Model name to use for this specific request
+		temperature *float32                // Sampling temperature, nil for the model default
+		maxTokens   *int                    // Maximum tokens to generate, nil for the model default
+		topP        *float32                // Nucleus sampling probability mass, nil for the model default
+		freqPenalty *float32                // Frequency penalty, nil for the model default
+		presPenalty *float32                // Presence penalty, nil for the model default
+		stop        []string                // Sequences that stop the generation
+		thinking    *int                    // Reasoning token budget of thinking models, nil for the model default
+		logProbs    *int                    // Alternatives returned with the log probability of each token, nil for no log probabilities
+		flushEvery  time.Duration           // Maximum time streamed chunks are batched
+		flushSize   int                     // Batched bytes that trigger a write
+		maxPayload  int                     // Maximum encoded request size in bytes, 0 for no limit
+		cacheTTL    time.Duration           // Time the prompt prefix should stay cached, see WithPromptCache
+		cache       bool                    // Whether the system prompt and tools are marked as cacheable
+		onDownscale func(*PayloadReport)    // Called when the request was downscaled to fit maxPayload
+		onRequest   RequestHook             // Inspects or rewrites the request before it is sent, may be nil
+		regenerate  bool                    // Whether the reply to the last user message is removed before sending
+		stream      bool                    // Whether to use streaming response
 	}
 	// Opts is a function type for configuring chat request options.
 	Opts func(opt *Opt)
+	// RequestHook inspects or rewrites a completion request before it is sent, see
+	// WithRequestHook.
+	RequestHook func(ctx context.Context, req *provider.Request) error
 
 	// ChatOpt contains configuration options for creating a new Chat instance.
 	ChatOpt struct {
-		maxhistory int    // Maximum number of messages to keep in history
-		apikey     string // API key for VolcEngine ARK runtime
+		provider     provider.Provider // Completion backend, defaults to VolcEngine ARK
+		pricing      map[string]Price  // Model prices used to compute the cost of each turn
+		clock        clock.Clock       // Clock for the session timestamps
+		retry        *retryPolicy      // Retry policy for transient errors, nil to fail immediately
+		maxhistory   int               // Maximum number of messages to keep in history
+		targetTokens int               // Prompt tokens the adaptive window aims for, 0 to send the complete history
+		histOpts     []history.Opts    // Options of the chat history, e.g. its token budget
+		summarizer   Summarizer        // Condenses messages dropped from the history, nil to discard them
+		keepReason   bool              // Whether the reasoning of thinking models is stored in the history
+		apikey       string            // API key for VolcEngine ARK runtime
+	}
+
+	// Price is the price of a model in an arbitrary currency per million tokens.
+	Price struct {
+		Prompt     float64 `json:"prompt"`           // Price per million prompt tokens
+		Completion float64 `json:"completion"`       // Price per million completion tokens
+		Cached     float64 `json:"cached,omitempty"` // Price per million prompt tokens served from the cache, 0 for the prompt price
 	}
 	// ChatOpts is a function type for configuring Chat creation options.
 	ChatOpts func(opt *ChatOpt)
+
+	// Result is the outcome of a chat completion request.
+	Result struct {
+		Message      *provider.Message             // Assistant message as stored in the history, nil if the model only called tools
+		Reasoning    string                        // Reasoning of thinking models, returned separately from the message content
+		Refusal      string                        // Refusal of the model to answer, also the message content, see provider.FinishReasonRefusal
+		LogProbs     []*provider.LogProb           // Log probabilities of the generated tokens, see WithLogProbs
+		FinishReason provider.FinishReason         // Reason the model stopped, e.g. provider.FinishReasonToolCalls
+		Usage        *provider.Usage               // Token usage reported by the provider, nil if none was reported
+		ToolCalls    map[string]*provider.ToolCall // Function tool calls to execute, keyed by tool call id
+		turn         *history.Turn                 // Metrics recorded with the assistant message
+		order        []string                      // Tool call ids in the order the model made the calls
+	}
 )
 
+// Calls returns the function tool calls of ToolCalls in the order the model made them.
+func (r *Result) Calls() []*provider.ToolCall {
+	calls := make([]*provider.ToolCall, 0, len(r.ToolCalls))
+	for _, id := range r.order {
+		if tc, ok := r.ToolCalls[id]; ok {
+			calls = append(calls, tc)
+		}
+	}
+	if len(calls) < len(r.ToolCalls) {
+		// calls added to ToolCalls by the caller, ordered by id
+		for _, id := range slices.Sorted(maps.Keys(r.ToolCalls)) {
+			if !slices.Contains(r.order, id) {
+				calls = append(calls, r.ToolCalls[id])
+			}
+		}
+	}
+	return calls
+}
+
+// Content returns the text of the assistant message, or an empty string if there is none.
+func (r *Result) Content() string {
+	if r == nil || r.Message == nil || r.Message.Content == nil || r.Message.Content.StringValue == nil {
+		return ""
+	}
+	return *r.Message.Content.StringValue
+}
+
+// Turn returns the model, latency, token usage and cost of the request, as recorded with
+// the assistant message, see Chat.Records.
+func (r *Result) Turn() *history.Turn {
+	if r == nil {
+		return nil
+	}
+	return r.turn
+}
+
 // WithMaxHistory sets the maximum number of messages to keep in chat history.
 // When the limit is exceeded, older messages are automatically removed.
 func WithMaxHistory(n int) ChatOpts {
@@ -47,6 +141,24 @@ func WithMaxHistory(n int) ChatOpts {
 	}
 }
 
+// WithTokenBudget limits the chat history to n tokens counted by tokenizer in addition to
+// the message count, dropping the oldest messages first, see history.WithTokenBudget.
+// A nil tokenizer uses history.EstimateTokens.
+func WithTokenBudget(n int, tokenizer history.Tokenizer) ChatOpts {
+	return func(opt *ChatOpt) {
+		opt.histOpts = append(opt.histOpts, history.WithTokenBudget(n, tokenizer))
+	}
+}
+
+// WithKeepReasoning stores the reasoning of thinking models with the assistant messages in
+// the history, e.g. to show it again with a restored conversation. It is never sent back
+// to the model. By default the reasoning is only returned with the Result.
+func WithKeepReasoning(keep bool) ChatOpts {
+	return func(opt *ChatOpt) {
+		opt.keepReason = keep
+	}
+}
+
 // WithAPIKey sets the API key for VolcEngine ARK runtime authentication.
 func WithAPIKey(k string) ChatOpts {
 	return func(opt *ChatOpt) {
@@ -54,18 +166,64 @@ func WithAPIKey(k string) ChatOpts {
 	}
 }
 
+// WithProvider sets the completion backend of the chat.
+// When not set, a VolcEngine ARK provider using the API key is created.
+func WithProvider(p provider.Provider) ChatOpts {
+	return func(opt *ChatOpt) {
+		opt.provider = p
+	}
+}
+
+// WithClock sets the clock used for the session timestamps, see LastMessage and Started.
+// Defaults to the wall clock.
+func WithClock(c clock.Clock) ChatOpts {
+	return func(opt *ChatOpt) {
+		if c != nil {
+			opt.clock = c
+		}
+	}
+}
+
+// WithPricing sets the model prices used to compute the cost recorded with each
+// assistant message, see history.Turn. Models without a price are recorded with zero cost.
+func WithPricing(prices map[string]Price) ChatOpts {
+	return func(opt *ChatOpt) {
+		opt.pricing = prices
+	}
+}
+
 // WithRoleSystem sets the system role messages for the chat completion.
 // System messages are used to set the behavior and context of the AI assistant.
 // Multiple system messages can be provided and will be prepended to the conversation.
-func WithRoleSystem(msg ...*model.ChatCompletionMessage) Opts {
+func WithRoleSystem(msg ...*provider.Message) Opts {
 	return func(opt *Opt) {
 		opt.roleSystem = msg
 	}
 }
 
+// WithContextMessages sends msgs, e.g. retrieved documents, right before the last user
+// message of the request. They belong to this request only and are not stored in the
+// history, so the history and the cacheable prefix of the request stay unchanged.
+func WithContextMessages(msgs ...*provider.Message) Opts {
+	return func(opt *Opt) {
+		opt.context = msgs
+	}
+}
+
+// WithPromptCache marks the system prompt and the tool definitions of the request as
+// cacheable prefix, see provider.CacheHint, so providers with prompt or context caching
+// serve them from their cache on the following requests and charge less for them.
+// ttl is the time the prefix should stay cached, 0 for the provider default.
+func WithPromptCache(ttl time.Duration) Opts {
+	return func(opt *Opt) {
+		opt.cache = true
+		opt.cacheTTL = ttl
+	}
+}
+
 // WithToolCalled includes previously called tool messages in the chat request.
 // This is used when continuing a conversation that involved tool calls.
-func WithToolCalled(toolcalled []*model.ChatCompletionMessage) Opts {
+func WithToolCalled(toolcalled []*provider.Message) Opts {
 	return func(opt *Opt) {
 		opt.toolcalled = toolcalled
 	}
@@ -79,6 +237,120 @@ func WithWriteFunc(f func(data []byte) error) Opts {
 	}
 }
 
+// WithTransform sets a post-processing stage for the assistant output of this request.
+// The stage is applied to every streamed delta before it reaches the write function,
+// and the transformed text is what gets stored in the chat history.
+func WithTransform(st transform.Stage) Opts {
+	return func(opt *Opt) {
+		opt.transform = st
+	}
+}
+
+// WithTemperature sets the sampling temperature of the request.
+func WithTemperature(t float32) Opts {
+	return func(opt *Opt) {
+		opt.temperature = &t
+	}
+}
+
+// WithMaxTokens sets the maximum number of tokens to generate.
+func WithMaxTokens(n int) Opts {
+	return func(opt *Opt) {
+		opt.maxTokens = &n
+	}
+}
+
+// WithTopP sets the nucleus sampling probability mass of the request, e.g. 0.9
+// samples only from the tokens making up the top 90% of the probability mass.
+func WithTopP(p float32) Opts {
+	return func(opt *Opt) {
+		opt.topP = &p
+	}
+}
+
+// WithFrequencyPenalty sets the frequency penalty of the request. Positive values
+// penalize tokens by how often they already appeared, reducing repetition.
+func WithFrequencyPenalty(p float32) Opts {
+	return func(opt *Opt) {
+		opt.freqPenalty = &p
+	}
+}
+
+// WithPresencePenalty sets the presence penalty of the request. Positive values
+// penalize tokens that already appeared, encouraging new topics.
+func WithPresencePenalty(p float32) Opts {
+	return func(opt *Opt) {
+		opt.presPenalty = &p
+	}
+}
+
+// WithStop sets sequences at which the model stops generating.
+// The stop sequences are not part of the returned content.
+func WithStop(sequences ...string) Opts {
+	return func(opt *Opt) {
+		opt.stop = sequences
+	}
+}
+
+// WithThinking switches the reasoning of thinking models, e.g. doubao thinking or
+// DeepSeek-R1, on for the request and limits it to budget tokens. The reasoning is
+// returned separately from the assistant text, see Result.Reasoning and ReasoningHandler.
+// A budget of 0 leaves the limit to the model and a negative budget switches the
+// reasoning off. Providers without a budget parameter only receive the switch, the
+// OpenAI-compatible provider sends it as thinking_budget.
+func WithThinking(budget int) Opts {
+	return func(opt *Opt) {
+		opt.thinking = &budget
+	}
+}
+
+// WithLogProbs requests the log probabilities of the generated tokens, returned in
+// Result.LogProbs, together with the top most likely alternatives of each token, e.g. to
+// evaluate the confidence of an answer. A top of 0 returns no alternatives. The tokens
+// are the ones generated by the model, before the transform stage of the request.
+func WithLogProbs(top int) Opts {
+	return func(opt *Opt) {
+		opt.logProbs = &top
+	}
+}
+
+// WithCoalesce batches streamed chunks before invoking the write function, reducing
+// flush overhead for SSE endpoints under high concurrency. Pending data is written once
+// it reaches size bytes or interval after it was first buffered, whichever comes first.
+// A zero interval or size disables the respective trigger; both zero disables batching.
+func WithCoalesce(interval time.Duration, size int) Opts {
+	return func(opt *Opt) {
+		opt.flushEvery = interval
+		opt.flushSize = size
+	}
+}
+
+// WithMaxPayload limits the encoded size of the request sent to the provider. Larger
+// requests are downscaled before sending: data URL images are compressed, then the oldest
+// tool results and images are replaced by placeholders, then the oldest messages are dropped.
+// The stored history is not changed. If the request still exceeds the limit, ErrPayloadTooLarge
+// is returned instead of sending it.
+//
+// Parameters:
+//   - n: Maximum request size in bytes, 0 for no limit
+//   - report: Optional function called with a report of the reductions if the request was downscaled
+func WithMaxPayload(n int, report func(*PayloadReport)) Opts {
+	return func(opt *Opt) {
+		opt.maxPayload = n
+		opt.onDownscale = report
+	}
+}
+
+// WithRequestHook sets a function called with the completion request right before it is
+// sent, after the payload limit was applied. The messages of the request are shared with
+// the history, so the hook replaces messages instead of modifying them. An error of the
+// hook fails the request without sending it.
+func WithRequestHook(f RequestHook) Opts {
+	return func(opt *Opt) {
+		opt.onRequest = f
+	}
+}
+
 // WithModel overrides the default model for this specific chat request.
 func WithModel(m string) Opts {
 	return func(opt *Opt) {
@@ -95,15 +367,56 @@ func WithStream(stream bool) Opts {
 }
 
 // WithTools provides available tools that the AI model can call during the conversation.
-func WithTools(tools []*model.Tool) Opts {
+func WithTools(tools []*provider.Tool) Opts {
 	return func(opt *Opt) {
 		opt.tools = tools
 	}
 }
 
+// Metadata keys holding per-session generation settings. They are applied on every
+// request of the session unless the request sets the corresponding option itself, and
+// persisted with the session by ChatsManager.
+const (
+	// MetaTemperature holds the sampling temperature, e.g. "0.2".
+	MetaTemperature = "temperature"
+	// MetaMaxTokens holds the maximum number of tokens to generate, e.g. "1024".
+	MetaMaxTokens = "max_tokens"
+	// MetaAllowedTools holds a comma separated list of the tool names offered to the model.
+	MetaAllowedTools = "allowed_tools"
+)
+
+const (
+	// ToolTypeWebSearch is the provider-native web search tool type.
+	ToolTypeWebSearch provider.ToolType = "web_search"
+	// ToolTypeCodeInterpreter is the provider-native code interpreter tool type.
+	ToolTypeCodeInterpreter provider.ToolType = "code_interpreter"
+)
+
+// RoleBuiltinTool is the role of the history messages recording a call of a provider-native
+// tool, see WithBuiltinTools. They follow the assistant message of the response and are
+// persisted with the history, but never sent to the model.
+const RoleBuiltinTool = "builtin_tool"
+
+// WithBuiltinTools adds provider-native tools, e.g. web search or code interpreter,
+// to the request. They are sent alongside the function tools given by WithTools.
+// Calls of these tools are executed by the provider, so they are not returned in
+// Result.ToolCalls; instead each call is recorded after the assistant message as a
+// RoleBuiltinTool message holding the output reported by the provider.
+func WithBuiltinTools(tools ...*provider.Tool) Opts {
+	return func(opt *Opt) {
+		opt.builtin = tools
+	}
+}
+
+// BuiltinTool returns a provider-native tool definition of the given type,
+// e.g. BuiltinTool(ToolTypeWebSearch).
+func BuiltinTool(t provider.ToolType) *provider.Tool {
+	return &provider.Tool{Type: t}
+}
+
 // New creates a new Chat instance with the specified ID and model name.
 // The Chat instance manages conversation history and provides methods for
-// interacting with AI models through the VolcEngine ARK runtime.
+// interacting with AI models through the configured provider.
 //
 // Parameters:
 //   - id: Unique identifier for this chat session
@@ -115,31 +428,64 @@ func New(id, modelName string, opts ...ChatOpts) *Chat {
 	co := &ChatOpt{
 		maxhistory: 500,
 		apikey:     "your_api_key",
+		clock:      clock.Real(),
 	}
 	for _, o := range opts {
 		o(co)
 	}
-	return &Chat{
-		locker:  sync.Mutex{},
-		id:      id,
-		apikey:  co.apikey,
-		history: *history.New(co.maxhistory),
-		model:   modelName,
-		cli:     arkruntime.NewClientWithApiKey(co.apikey),
+	if co.provider == nil {
+		co.provider = provider.NewArk(co.apikey)
 	}
+	c := &Chat{
+		id:           id,
+		apikey:       co.apikey,
+		histOpts:     co.histOpts,
+		summarizer:   co.summarizer,
+		meta:         mapfx.NewBaseMap[string](),
+		started:      co.clock.Now(),
+		clock:        co.clock,
+		model:        modelName,
+		keepReason:   co.keepReason,
+		cli:          co.provider,
+		pricing:      co.pricing,
+		retry:        co.retry,
+		targetTokens: co.targetTokens,
+	}
+	c.history = c.newHistory(co.maxhistory)
+	return c
 }
 
 // Chat represents a chat session with an AI model.
 // It maintains conversation history, handles both streaming and non-streaming responses,
 // and supports tool calling functionality.
+//
+// Requests are serialized, while the history, metadata and settings can be read at any
+// time, e.g. to render the conversation while a response is streaming. Messages appear
+// in the history once they are complete.
 type Chat struct {
-	locker      sync.Mutex         // Mutex for thread-safe operations
-	history     history.History    // Conversation history manager
-	cli         *arkruntime.Client // VolcEngine ARK runtime client
-	lastMessage time.Time          // Timestamp of the last message sent or received
-	apikey      string             // API key for authentication
-	model       string             // Default model name for this chat session
-	id          string             // Unique identifier for this chat session
+	locker       sync.Mutex             // Serializes requests and Reset
+	mu           sync.RWMutex           // Guards the session state below, never held during a request
+	history      *history.History       // Conversation history manager, replaced by Reset
+	histOpts     []history.Opts         // Options of the history, applied again by Reset
+	summarizer   Summarizer             // Condenses messages dropped from the history, may be nil
+	pending      []*provider.Message    // Dropped messages waiting for the summarizer
+	summarizing  bool                   // Whether the summarizer is running
+	generation   int                    // Number of resets, discards summaries of a previous conversation
+	cli          provider.Provider      // Completion backend
+	pricing      map[string]Price       // Model prices used to compute turn costs
+	clock        clock.Clock            // Clock for lastMessage and started
+	retry        *retryPolicy           // Retry policy of the completion requests, may be nil
+	targetTokens int                    // Prompt tokens the adaptive window aims for, 0 to disable it
+	keepReason   bool                   // Whether the reasoning is stored with the assistant messages
+	window       int                    // History messages sent with the next request, 0 for all
+	meta         *mapfx.BaseMap[string] // Free-form session metadata
+	roleSystem   []*provider.Message    // Session system prompt used when a request sets none
+	lastMessage  time.Time              // Timestamp of the last message sent or received
+	started      time.Time              // Start of the current conversation, reset by Reset
+	turns        int                    // Number of user messages since started
+	apikey       string                 // API key for authentication
+	model        string                 // Default model name for this chat session
+	id           string                 // Unique identifier for this chat session
 }
 
 // ID returns the unique identifier of this chat session.
@@ -150,31 +496,184 @@ func (c *Chat) ID() string {
 // LastMessage returns the timestamp of the last message sent or received in this chat.
 // This can be used to determine chat activity and implement timeout logic.
 func (c *Chat) LastMessage() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.lastMessage
 }
 
+// Touch marks the chat as active now without a message, extending its lifetime.
+func (c *Chat) Touch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastMessage = c.clock.Now()
+}
+
+// Started returns the start time of the current conversation.
+// It is the creation time of the chat, or the time of the last Reset.
+func (c *Chat) Started() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.started
+}
+
+// Turns returns the number of user messages sent since the conversation started.
+func (c *Chat) Turns() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.turns
+}
+
 // History returns a slice of all messages in the current conversation history.
 // The returned slice contains both user and assistant messages in chronological order.
-func (c *Chat) History() []*model.ChatCompletionMessage {
-	return c.history.Slice()
+func (c *Chat) History() []*provider.Message {
+	return c.hist().Slice()
+}
+
+// Records returns the conversation history with the time each message was stored and
+// the annotations of the generated messages. Both are kept for the lifetime of the
+// session only, see history.Record.
+func (c *Chat) Records() []history.Record {
+	return c.hist().Records()
+}
+
+// Tokens returns the estimated number of tokens occupied by the conversation history.
+func (c *Chat) Tokens() int {
+	return c.hist().Tokens()
+}
+
+// hist returns the current history, which Reset may replace.
+func (c *Chat) hist() *history.History {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.history
 }
 
 // SetHistory replaces the current conversation history with the provided messages.
 // This is useful for restoring a conversation from persistent storage or
 // initializing a chat with predefined context.
-func (c *Chat) SetHistory(h []*model.ChatCompletionMessage) {
-	c.history.StoreMany(h...)
+// Pinned messages are kept, see Pin.
+func (c *Chat) SetHistory(h []*provider.Message) {
+	his := c.hist()
+	his.Clear()
+	his.StoreMany(h...)
+}
+
+// Pin adds a message that is sent with every request after the system prompt and never
+// evicted from the history, e.g. key facts of the conversation, see history.History.Pin.
+// Pinned messages are kept by Reset.
+func (c *Chat) Pin(msg *provider.Message) {
+	c.hist().Pin(msg)
 }
 
-// Chat sends a message to the AI model and returns any tool calls made by the model.
+// Pinned returns the messages added with Pin in the order they were pinned.
+func (c *Chat) Pinned() []*provider.Message {
+	return c.hist().Pinned()
+}
+
+// Unpin removes a message added with Pin and reports whether it was pinned.
+func (c *Chat) Unpin(msg *provider.Message) bool {
+	return c.hist().Unpin(msg)
+}
+
+// Model returns the default model name of this chat session.
+func (c *Chat) Model() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.model
+}
+
+// SetModel changes the default model name used by subsequent requests of this chat session.
+func (c *Chat) SetModel(m string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.model = m
+}
+
+// MaxHistory returns the maximum number of messages kept in the history of this chat session.
+func (c *Chat) MaxHistory() int {
+	return c.hist().Cap()
+}
+
+// SetMaxHistory changes the maximum number of messages kept in the history of this chat
+// session, see WithMaxHistory. Messages beyond the new limit are dropped, oldest first,
+// and condensed by the summarizer with the next request if one is set.
+func (c *Chat) SetMaxHistory(n int) {
+	c.hist().Resize(n)
+}
+
+// Reset discards the conversation history while keeping the session settings and the
+// pinned messages, and starts a new conversation. A running request is completed first.
+func (c *Chat) Reset() {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	// the history calls back into the chat with its lock held, see dropped, so it must
+	// not be locked while mu is held
+	old := c.hist()
+	h := c.newHistory(old.Cap())
+	for _, msg := range old.Pinned() {
+		h.Pin(msg)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.history = h
+	c.started = c.clock.Now()
+	c.turns = 0
+	c.window = 0
+	c.pending = nil
+	c.generation++
+	c.meta.Delete(MetaSummary)
+}
+
+// SystemPrompt returns the system role messages of this chat session.
+func (c *Chat) SystemPrompt() []*provider.Message {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.roleSystem
+}
+
+// SetSystemPrompt sets the system role messages of this chat session.
+// They are sent with every request that does not provide its own WithRoleSystem option.
+func (c *Chat) SetSystemPrompt(msgs ...*provider.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roleSystem = msgs
+}
+
+// Metadata returns a copy of the session metadata.
+func (c *Chat) Metadata() map[string]string {
+	return c.meta.Clone()
+}
+
+// SetMetadata sets a session metadata value. An empty value removes the key.
+func (c *Chat) SetMetadata(key, value string) {
+	if value == "" {
+		c.meta.Delete(key)
+		return
+	}
+	c.meta.Store(key, value)
+}
+
+// Chat sends a message to the AI model and returns the result of the request.
+// It is ChatContext with context.Background().
+func (c *Chat) Chat(message string, opts ...Opts) (*Result, error) {
+	return c.ChatContext(context.Background(), message, opts...)
+}
+
+// ChatContext sends a message to the AI model and returns the result of the request,
+// holding the assistant message, finish reason, token usage and tool calls. The result
+// is complete in streaming mode too, so a write function is optional.
 // This is the main method for interacting with the AI model in a conversational manner.
+// Cancelling ctx aborts the in-flight completion; if ctx has no deadline, the request
+// is limited to 180 seconds.
 //
 // Parameters:
+//   - ctx: Context controlling cancellation and deadline of the request.
 //   - message: The user's message to send to the AI model. Can be empty if only processing tool calls.
+//     Images and other parts are added with WithMessage.
 //   - opts: Optional configuration functions to customize this specific request.
 //
 // Returns:
-//   - map[string]*model.ToolCall: A map of tool call IDs to their corresponding tool calls made by the model.
+//   - *Result: The assistant message, finish reason, token usage and tool calls of the model.
 //   - error: Any error that occurred during the chat completion request.
 //
 // The method automatically:
@@ -183,79 +682,266 @@ func (c *Chat) SetHistory(h []*model.ChatCompletionMessage) {
 //   - Handles both streaming and non-streaming responses based on configuration
 //   - Processes tool calls if any are made by the model
 //   - Manages conversation history including tool call results
-func (c *Chat) Chat(message string, opts ...Opts) (map[string]*model.ToolCall, error) {
+func (c *Chat) ChatContext(ctx context.Context, message string, opts ...Opts) (*Result, error) {
 	defer func() {
-		c.lastMessage = time.Now()
+		c.mu.Lock()
+		c.lastMessage = c.clock.Now()
+		c.mu.Unlock()
 		c.locker.Unlock()
 	}()
 	c.locker.Lock()
 	co := &Opt{
 		stream:     false,
 		writeFunc:  func(data []byte) error { return nil },
-		model:      c.model,
-		tools:      make([]*model.Tool, 0),
-		roleSystem: make([]*model.ChatCompletionMessage, 0),
+		model:      c.Model(),
+		tools:      make([]*provider.Tool, 0),
+		roleSystem: make([]*provider.Message, 0),
 	}
 	for _, o := range opts {
 		o(co)
 	}
-	if len(message) > 0 {
-		c.history.Store(&model.ChatCompletionMessage{
-			Role: model.ChatMessageRoleUser,
-			Content: &model.ChatCompletionMessageContent{
-				StringValue: volcengine.String(message),
-			},
-		})
+	c.applySettings(co)
+	if co.message != nil && co.message.err != nil {
+		return nil, co.message.err
+	}
+	if co.regenerate {
+		if err := c.rewind(); err != nil {
+			return nil, err
+		}
+	}
+	if msg := userMessage(message, co.message); msg != nil {
+		c.mu.Lock()
+		c.turns++
+		c.mu.Unlock()
+		c.history.Store(msg)
 	}
-	msgs := make([]*model.ChatCompletionMessage, 0, c.history.Len()+len(co.toolcalled)+1)
-	req := model.CreateChatCompletionRequest{
+	msgs := make([]*provider.Message, 0, c.history.Count()+len(co.toolcalled)+1)
+	req := provider.Request{
 		Model: co.model,
 		// Messages: c.history.Slice(),
-		Stream: &co.stream,
+		Stream:           &co.stream,
+		Temperature:      co.temperature,
+		MaxTokens:        co.maxTokens,
+		TopP:             co.topP,
+		FrequencyPenalty: co.freqPenalty,
+		PresencePenalty:  co.presPenalty,
+		Stop:             co.stop,
 	}
 	if len(co.roleSystem) > 0 {
 		msgs = append(msgs, co.roleSystem...)
+	} else if sys := c.SystemPrompt(); len(sys) > 0 {
+		msgs = append(msgs, sys...)
+	}
+	prefix := len(msgs)
+	if sm := c.summaryMessage(); sm != nil {
+		msgs = append(msgs, sm)
 	}
 	if len(co.toolcalled) > 0 {
 		c.history.StoreMany(co.toolcalled...)
-	} else {
-		if len(co.tools) > 0 {
-			req.Tools = co.tools
+	}
+	if len(co.tools)+len(co.builtin) > 0 {
+		req.Tools = append(append(make([]*provider.Tool, 0, len(co.tools)+len(co.builtin)), co.tools...), co.builtin...)
+	}
+	pinned, his := c.history.Parts()
+	sent := c.windowed(his)
+	msgs = append(append(msgs, pinned...), withContext(sent, co.context)...)
+	req.Messages = withoutReasoning(msgs)
+	if co.logProbs != nil {
+		req.LogProbs = volcengine.Bool(true)
+		if *co.logProbs > 0 {
+			req.TopLogProbs = co.logProbs
+		}
+	}
+	if co.thinking != nil {
+		req.Thinking = &provider.Thinking{Type: provider.ThinkingEnabled}
+		if *co.thinking < 0 {
+			req.Thinking.Type = provider.ThinkingDisabled
 		}
+		ctx = provider.WithThinkingBudget(ctx, *co.thinking)
 	}
-	msgs = append(msgs, c.history.Slice()...)
-	req.Messages = msgs
 	if co.stream {
-		return c.doStream(req, co.writeFunc)
+		req.StreamOptions = &provider.StreamOptions{IncludeUsage: true}
+	}
+	if co.maxPayload > 0 {
+		if err := guardPayload(&req, co.maxPayload, co.onDownscale); err != nil {
+			return nil, err
+		}
+	}
+	if co.cache {
+		ctx = provider.WithCacheHint(ctx, provider.CacheHint{Messages: prefix, Tools: len(req.Tools) > 0, TTL: co.cacheTTL})
 	}
-	return c.do(req, co.writeFunc)
+	if co.onRequest != nil {
+		if err := co.onRequest(ctx, &req); err != nil {
+			return nil, err
+		}
+	}
+	if co.writeFunc == nil {
+		co.writeFunc = func([]byte) error { return nil }
+	}
+	if co.handler == nil {
+		co.handler = nopHandler
+	}
+	var res *Result
+	var err error
+	if co.stream {
+		if co.flushEvery > 0 || co.flushSize > 0 {
+			cw := newCoalescer(co.writeFunc, co.flushEvery, co.flushSize)
+			res, err = c.doStream(ctx, req, cw.Write, co.handler, co.transform)
+			if ferr := cw.Flush(); err == nil {
+				err = ferr
+			}
+		} else {
+			res, err = c.doStream(ctx, req, co.writeFunc, co.handler, co.transform)
+		}
+	} else {
+		res, err = c.do(ctx, req, co.writeFunc, co.handler, co.transform)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.adapt(len(sent), len(his), res.Usage)
+	c.storeAssistant(res, builtinRecords(res))
+	c.summarize()
+	return res, nil
+}
+
+// applySettings applies the generation settings stored in the session metadata
+// to the request options that were not set explicitly.
+func (c *Chat) applySettings(co *Opt) {
+	meta := c.meta.Clone()
+	if v, ok := meta[MetaTemperature]; ok && co.temperature == nil {
+		if t, err := strconv.ParseFloat(v, 32); err == nil {
+			co.temperature = volcengine.Float32(float32(t))
+		}
+	}
+	if v, ok := meta[MetaMaxTokens]; ok && co.maxTokens == nil {
+		if n, err := strconv.Atoi(v); err == nil {
+			co.maxTokens = &n
+		}
+	}
+	if v, ok := meta[MetaAllowedTools]; ok && len(co.tools) > 0 {
+		allowed := strings.Split(strings.ReplaceAll(v, " ", ""), ",")
+		tools := make([]*provider.Tool, 0, len(co.tools))
+		for _, t := range co.tools {
+			if t.Function != nil && slices.Contains(allowed, t.Function.Name) {
+				tools = append(tools, t)
+			}
+		}
+		co.tools = tools
+	}
+}
+
+// setUsage records the token usage reported by the provider on turn and computes its cost
+// from the price of the reported model, falling back to the price of the requested model,
+// e.g. when an endpoint id was requested.
+func (c *Chat) setUsage(turn *history.Turn, u *provider.Usage, requested string) {
+	turn.PromptTokens = u.PromptTokens
+	turn.CompletionTokens = u.CompletionTokens
+	turn.CachedTokens = u.PromptTokensDetails.CachedTokens
+	p, ok := c.pricing[turn.Model]
+	if !ok {
+		p, ok = c.pricing[requested]
+	}
+	if ok {
+		cached := p.Cached
+		if cached == 0 {
+			cached = p.Prompt
+		}
+		uncached := u.PromptTokens - turn.CachedTokens
+		turn.Cost = (float64(uncached)*p.Prompt + float64(turn.CachedTokens)*cached + float64(u.CompletionTokens)*p.Completion) / 1e6
+	}
+}
+
+// storeAssistant stores the assistant message of res in the history, followed by the
+// records of its builtin tool calls. Function tool calls are attached to the message, so
+// the tool results sent back later follow the call.
+func (c *Chat) storeAssistant(res *Result, builtin []*provider.Message) {
+	if len(res.ToolCalls)+len(builtin) > 0 && res.Message == nil {
+		res.Message = &provider.Message{
+			Role:    provider.RoleAssistant,
+			Content: &provider.MessageContent{StringValue: volcengine.String("")},
+		}
+	}
+	if len(res.ToolCalls) > 0 {
+		res.Message.ToolCalls = res.Calls()
+	}
+	if res.Message != nil {
+		if c.keepReason && res.Reasoning != "" {
+			res.Message.ReasoningContent = volcengine.String(res.Reasoning)
+		}
+		c.history.StoreTurn(res.Message, res.turn)
+	}
+	c.history.StoreMany(builtin...)
+}
+
+// withoutReasoning replaces the messages of msgs carrying reasoning, see WithKeepReasoning,
+// by copies without it, as providers reject or ignore reasoning sent back to them.
+func withoutReasoning(msgs []*provider.Message) []*provider.Message {
+	for i, m := range msgs {
+		if m.ReasoningContent != nil {
+			cp := *m
+			cp.ReasoningContent = nil
+			msgs[i] = &cp
+		}
+	}
+	return msgs
+}
+
+// builtinRecords moves calls of provider-native tools out of the tool calls of res and
+// returns their RoleBuiltinTool records. The provider ran them already, so they must not
+// be stored as tool calls the model expects results for.
+func builtinRecords(res *Result) []*provider.Message {
+	var records []*provider.Message
+	for _, tc := range res.Calls() {
+		if tc.Type == "" || tc.Type == provider.ToolTypeFunction {
+			continue
+		}
+		records = append(records, &provider.Message{
+			Role:       RoleBuiltinTool,
+			Content:    &provider.MessageContent{StringValue: volcengine.String(tc.Function.Arguments)},
+			Name:       volcengine.String(tc.Function.Name),
+			ToolCallID: tc.ID,
+		})
+		delete(res.ToolCalls, tc.ID)
+	}
+	return records
 }
 
 // doStream handles streaming chat completions from the LLM client. It sends each chunk of assistant response content
-// to the provided writer callback `w` as it is received. The function also accumulates tool call information from the
+// to the provided writer callback `w` and the handler `h` as it is received. The function also accumulates tool call information from the
 // stream, mapping tool call IDs to their corresponding ToolCall objects, and handles the progressive filling of tool
-// call arguments. Upon completion, it stores the assistant's full response message in the chat history if any content
-// was received. Returns a map of tool call IDs to ToolCall objects, or an error if the streaming process fails.
+// call arguments, passing every fragment to `h`. Reasoning deltas are passed to `h` as well, if it implements
+// ReasoningHandler, and collected in Result.Reasoning. Upon completion, the assistant's full response message is set on the Result if any content was
+// received; storing it is left to the caller. Returns the Result assembled from the stream, or an error if the
+// streaming process fails.
 //
 // Parameters:
-//   - req: The CreateChatCompletionRequest containing the chat prompt and options.
+//   - ctx: Context controlling cancellation and deadline of the request.
+//   - req: The Request containing the chat prompt and options.
 //   - w: A callback function that processes each chunk of assistant response content.
+//   - st: Optional post-processing stage applied to the content before it is written and stored.
 //
 // Returns:
-//   - map[string]*model.ToolCall: A map of tool call IDs to ToolCall objects extracted from the stream.
+//   - *Result: The assistant message, finish reason, usage and tool calls extracted from the stream.
 //   - error: An error if the streaming or processing fails, or nil on success.
-func (c *Chat) doStream(req model.CreateChatCompletionRequest, w func(data []byte) error) (map[string]*model.ToolCall, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 180*time.Second)
+func (c *Chat) doStream(ctx context.Context, req provider.Request, w func(data []byte) error, h StreamHandler, st transform.Stage) (*Result, error) {
+	ctx, cancel := withDefaultTimeout(ctx, 180*time.Second)
 	defer cancel()
-	stream, err := c.cli.CreateChatCompletionStream(ctx, req)
+	start := time.Now()
+	stream, err := withRetry(ctx, c.retry, func() (provider.Stream, error) {
+		return c.cli.CreateCompletionStream(ctx, req)
+	})
 	if err != nil {
 		return nil, err
 	}
 	defer stream.Close()
-	toolCallMap := make(map[string]*model.ToolCall)
+	turn := &history.Turn{Model: req.Model}
+	res := &Result{turn: turn}
+	toolCallMap := make(map[string]*provider.ToolCall)
+	callIDs := make(map[int]string) // Tool call ids by the index of their call
 	var lastCallID string
-	var message = strings.Builder{}
-	for !stream.IsFinished {
+	var message, reasoning strings.Builder
+	for {
 		recv, err := stream.Recv()
 		if err != nil {
 			if err == io.EOF {
@@ -263,82 +949,198 @@ func (c *Chat) doStream(req model.CreateChatCompletionRequest, w func(data []byt
 			}
 			return nil, err
 		}
+		if recv.Model != "" {
+			turn.Model = recv.Model
+		}
+		if recv.Usage != nil {
+			c.setUsage(turn, recv.Usage, req.Model)
+			res.Usage = recv.Usage
+		}
 		if len(recv.Choices) > 0 {
-			if recv.Choices[0].Delta.Role == model.ChatMessageRoleAssistant && recv.Choices[0].Delta.Content != "" {
-				err = w([]byte(recv.Choices[0].Delta.Content))
-				if err != nil {
+			if recv.Choices[0].FinishReason != "" {
+				res.FinishReason = recv.Choices[0].FinishReason
+			}
+			if lp := recv.Choices[0].LogProbs; lp != nil {
+				res.LogProbs = append(res.LogProbs, lp.Content...)
+			}
+			if r := recv.Choices[0].Delta.ReasoningContent; r != nil && *r != "" {
+				if turn.FirstToken == 0 {
+					turn.FirstToken = time.Since(start)
+				}
+				if err = onReasoning(h, *r); err != nil {
 					return nil, err
 				}
-				message.WriteString(recv.Choices[0].Delta.Content)
+				reasoning.WriteString(*r)
+			}
+			// providers send the role with the first chunk only
+			if recv.Choices[0].Delta.Content != "" {
+				content := recv.Choices[0].Delta.Content
+				if st != nil {
+					content = st.Write(content)
+				}
+				if content != "" {
+					if turn.FirstToken == 0 {
+						turn.FirstToken = time.Since(start)
+					}
+					if err = h.OnContent(content); err != nil {
+						return nil, err
+					}
+					err = w([]byte(content))
+					if err != nil {
+						return nil, err
+					}
+					message.WriteString(content)
+				}
 			}
 			if len(recv.Choices[0].Delta.ToolCalls) > 0 {
 				for _, tc := range recv.Choices[0].Delta.ToolCalls {
-					if tc.ID != "" {
-						if toolCallMap[tc.ID] == nil {
-							toolCallMap[tc.ID] = &model.ToolCall{
-								ID:       tc.ID,
-								Function: model.FunctionCall{Name: tc.Function.Name, Arguments: tc.Function.Arguments},
-								Type:     tc.Type,
-							}
+					if tc.ID == "" && tc.Index != nil {
+						// fragments of parallel calls are told apart by the index of their call
+						tc.ID = callIDs[*tc.Index]
+					}
+					if tc.ID != "" && toolCallMap[tc.ID] == nil {
+						toolCallMap[tc.ID] = &provider.ToolCall{
+							ID:       tc.ID,
+							Function: provider.FunctionCall{Name: tc.Function.Name, Arguments: tc.Function.Arguments},
+							Type:     tc.Type,
+						}
+						res.order = append(res.order, tc.ID)
+						if tc.Index != nil {
+							callIDs[*tc.Index] = tc.ID
 						}
 						lastCallID = tc.ID
-					} else { // tc.ID == "" indicates we're filling arguments for the previous tool call ID
-						toolCallMap[lastCallID].Function.Arguments += tc.Function.Arguments
+						err = h.OnToolCallDelta(ToolCallDelta{ID: tc.ID, Type: tc.Type, Name: tc.Function.Name, Arguments: tc.Function.Arguments})
+					} else {
+						if tc.ID != "" {
+							lastCallID = tc.ID
+						}
+						// a fragment without id fills the arguments of the previous tool call
+						if prev, ok := toolCallMap[lastCallID]; ok {
+							prev.Function.Arguments += tc.Function.Arguments
+							err = h.OnToolCallDelta(ToolCallDelta{ID: prev.ID, Name: prev.Function.Name, Arguments: tc.Function.Arguments})
+						}
+					}
+					if err != nil {
+						return nil, err
 					}
 				}
 			}
 		}
 	}
+	if st != nil {
+		if content := st.Flush(); content != "" {
+			if err = h.OnContent(content); err != nil {
+				return nil, err
+			}
+			err = w([]byte(content))
+			if err != nil {
+				return nil, err
+			}
+			message.WriteString(content)
+		}
+	}
+	h.OnFinish(res.Usage, res.FinishReason)
+	res.Reasoning = reasoning.String()
 	if message.Len() > 0 {
-		c.history.Store(&model.ChatCompletionMessage{
-			Role: model.ChatMessageRoleAssistant,
-			Content: &model.ChatCompletionMessageContent{
+		turn.Latency = time.Since(start)
+		res.Message = &provider.Message{
+			Role: provider.RoleAssistant,
+			Content: &provider.MessageContent{
 				StringValue: volcengine.String(message.String()),
 			},
-		})
+		}
+		if res.FinishReason == provider.FinishReasonRefusal {
+			res.Refusal = message.String()
+		}
 	}
-	return toolCallMap, nil
+	res.ToolCalls = toolCallMap
+	return res, nil
 }
 
-// do sends a chat completion request using the provided model.CreateChatCompletionRequest,
+// do sends a chat completion request using the provided provider.Request,
 // processes the response, and invokes the callback function 'w' with the assistant's message content.
-// It returns a map of tool call IDs to ToolCall objects if any tool calls are present in the response.
-// The function also stores the assistant's message in the chat history.
+// It returns the Result holding the assistant message, finish reason, usage and the tool calls of the response.
+// The assistant's message is set on the Result after applying the optional post-processing
+// stage st; storing it is left to the caller.
 // If an error occurs during the request or callback execution, it returns the error.
-func (c *Chat) do(req model.CreateChatCompletionRequest, w func(data []byte) error) (map[string]*model.ToolCall, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 180*time.Second)
+func (c *Chat) do(ctx context.Context, req provider.Request, w func(data []byte) error, h StreamHandler, st transform.Stage) (*Result, error) {
+	ctx, cancel := withDefaultTimeout(ctx, 180*time.Second)
 	defer cancel()
-	resp, err := c.cli.CreateChatCompletion(ctx, req)
+	start := time.Now()
+	resp, err := withRetry(ctx, c.retry, func() (provider.Response, error) {
+		return c.cli.CreateCompletion(ctx, req)
+	})
 	if err != nil {
 		return nil, err
 	}
-	toolCallMap := make(map[string]*model.ToolCall)
+	turn := &history.Turn{Model: req.Model, Latency: time.Since(start)}
+	if resp.Model != "" {
+		turn.Model = resp.Model
+	}
+	c.setUsage(turn, &resp.Usage, req.Model)
+	res := &Result{Usage: &resp.Usage, turn: turn}
+	toolCallMap := make(map[string]*provider.ToolCall)
 	if len(resp.Choices) > 0 {
-		if resp.Choices[0].Message.Role == model.ChatMessageRoleAssistant && resp.Choices[0].Message.Content.StringValue != nil {
-			err = w(json.Bytes(*resp.Choices[0].Message.Content.StringValue))
+		res.FinishReason = resp.Choices[0].FinishReason
+		if lp := resp.Choices[0].LogProbs; lp != nil {
+			res.LogProbs = lp.Content
+		}
+		if r := resp.Choices[0].Message.ReasoningContent; r != nil && *r != "" {
+			if err = onReasoning(h, *r); err != nil {
+				return nil, err
+			}
+			res.Reasoning = *r
+		}
+		if resp.Choices[0].Message.Role == provider.RoleAssistant && resp.Choices[0].Message.Content != nil &&
+			resp.Choices[0].Message.Content.StringValue != nil {
+			content := *resp.Choices[0].Message.Content.StringValue
+			if st != nil {
+				content = st.Write(content) + st.Flush()
+			}
+			if err = h.OnContent(content); err != nil {
+				return nil, err
+			}
+			err = w(json.Bytes(content))
 			if err != nil {
 				return nil, err
 			}
-			c.history.Store(&model.ChatCompletionMessage{
+			res.Message = &provider.Message{
 				Role: resp.Choices[0].Message.Role,
-				Content: &model.ChatCompletionMessageContent{
-					StringValue: volcengine.String(*resp.Choices[0].Message.Content.StringValue),
+				Content: &provider.MessageContent{
+					StringValue: volcengine.String(content),
 				},
-			})
+			}
+			if res.FinishReason == provider.FinishReasonRefusal {
+				res.Refusal = content
+			}
 		}
 		if len(resp.Choices[0].Message.ToolCalls) > 0 {
 			for _, tc := range resp.Choices[0].Message.ToolCalls {
 				if tc.ID != "" {
 					if toolCallMap[tc.ID] == nil {
-						toolCallMap[tc.ID] = &model.ToolCall{
+						toolCallMap[tc.ID] = &provider.ToolCall{
 							ID:       tc.ID,
-							Function: model.FunctionCall{Name: tc.Function.Name, Arguments: tc.Function.Arguments},
+							Function: provider.FunctionCall{Name: tc.Function.Name, Arguments: tc.Function.Arguments},
 							Type:     tc.Type,
 						}
+						res.order = append(res.order, tc.ID)
+						if err = h.OnToolCallDelta(ToolCallDelta{ID: tc.ID, Type: tc.Type, Name: tc.Function.Name, Arguments: tc.Function.Arguments}); err != nil {
+							return nil, err
+						}
 					}
 				}
 			}
 		}
 	}
-	return toolCallMap, nil
+	h.OnFinish(res.Usage, res.FinishReason)
+	res.ToolCalls = toolCallMap
+	return res, nil
+}
+
+// withDefaultTimeout returns a copy of ctx limited to d, unless ctx already has a deadline.
+func withDefaultTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
 }
@@ -0,0 +1,174 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xyzj/llm/provider"
+	"github.com/xyzj/llm/storage"
+
+	"github.com/xyzj/toolbox/json"
+)
+
+// openAIServer returns a chat whose provider is a test server answering every request
+// with the chunks, as server-sent events if there are several.
+func openAIServer(t *testing.T, chunks ...string) *Chat {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(chunks) == 1 {
+			fmt.Fprint(w, chunks[0])
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, c := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", c)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(srv.Close)
+	return New("test", "m", WithProvider(provider.NewOpenAI(srv.URL, "")))
+}
+
+func TestStreamContentWithoutRole(t *testing.T) {
+	c := openAIServer(t,
+		`{"model":"m","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}`,
+		`{"model":"m","choices":[{"index":0,"delta":{"content":"lo"}}]}`,
+		`{"model":"m","choices":[{"index":0,"delta":{"content":"!"},"finish_reason":"stop"}]}`,
+	)
+	var written strings.Builder
+	res, err := c.ChatContext(context.Background(), "hi", WithStream(true), WithWriteFunc(func(b []byte) error {
+		written.Write(b)
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Content(); got != "Hello!" {
+		t.Errorf("content = %q, want %q", got, "Hello!")
+	}
+	if got := written.String(); got != "Hello!" {
+		t.Errorf("written = %q, want %q", got, "Hello!")
+	}
+	if n := len(c.History()); n != 2 {
+		t.Errorf("history has %d messages, want 2", n)
+	}
+}
+
+func TestToolCallWithNullContent(t *testing.T) {
+	c := openAIServer(t, `{"model":"m","choices":[{"index":0,"finish_reason":"tool_calls","message":{"role":"assistant","content":null,`+
+		`"tool_calls":[{"id":"call_1","type":"function","function":{"name":"echo","arguments":"{}"}}]}}]}`)
+	res, err := c.ChatContext(context.Background(), "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := res.Calls(); len(calls) != 1 || calls[0].Function.Name != "echo" {
+		t.Errorf("calls = %v, want one call of echo", calls)
+	}
+	if got := res.Content(); got != "" {
+		t.Errorf("content = %q, want none", got)
+	}
+}
+
+func TestBuiltinCallsPersisted(t *testing.T) {
+	var requests []provider.Request
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := provider.Request{}
+		b, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(b, &req); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		first := len(requests) == 1
+		mu.Unlock()
+		if first {
+			// the response holds nothing but the builtin call
+			fmt.Fprint(w, `{"model":"m","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":null,`+
+				`"tool_calls":[{"id":"ws_1","type":"web_search","function":{"name":"web_search","arguments":"{\"results\":[\"sunny\"]}"}}]}}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"model":"m","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"It is sunny."}}]}`)
+	}))
+	t.Cleanup(srv.Close)
+	p := provider.NewOpenAI(srv.URL, "")
+	c := New("test", "m", WithProvider(p))
+	res, err := c.ChatContext(context.Background(), "weather?", WithBuiltinTools(BuiltinTool(ToolTypeWebSearch)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.ToolCalls) != 0 {
+		t.Errorf("tool calls = %v, want none to execute", res.ToolCalls)
+	}
+
+	st, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "chats.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.(io.Closer).Close()
+	if err = st.Store(context.Background(), "test", c.History()); err != nil {
+		t.Fatal(err)
+	}
+	his, err := st.Load(context.Background(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(his) != 3 || his[1].Role != provider.RoleAssistant || his[2].Role != RoleBuiltinTool {
+		t.Fatalf("stored history = %v, want the user message, the answer and the builtin call", his)
+	}
+	if rec := his[2]; rec.ToolCallID != "ws_1" || *rec.Content.StringValue != `{"results":["sunny"]}` {
+		t.Errorf("builtin record = %+v, want the call and its output", rec)
+	}
+
+	restored := New("test", "m", WithProvider(p))
+	restored.SetHistory(his)
+	if _, err = restored.ChatContext(context.Background(), "and tomorrow?"); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range requests[1].Messages {
+		if m.Role == RoleBuiltinTool {
+			t.Error("builtin call record sent to the model")
+		}
+	}
+}
+
+func TestConcurrentReadsDuringRequests(t *testing.T) {
+	c := openAIServer(t,
+		`{"model":"m","choices":[{"index":0,"delta":{"role":"assistant","content":"ok"}}]}`,
+		`{"model":"m","choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":1,"total_tokens":11}}`,
+	)
+	c.SetMaxHistory(6)
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Go(func() {
+			for range 20 {
+				if _, err := c.ChatContext(context.Background(), "hi", WithStream(true)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		})
+	}
+	wg.Go(func() {
+		for i := range 200 {
+			c.History()
+			c.Records()
+			c.Tokens()
+			c.Metadata()
+			c.SetMetadata(MetaTemperature, "0.5")
+			if i%50 == 0 {
+				c.Reset()
+			}
+		}
+	})
+	wg.Wait()
+	if n := len(c.History()); n > 6 {
+		t.Errorf("history has %d messages, more than its capacity", n)
+	}
+}
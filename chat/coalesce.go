@@ -0,0 +1,72 @@
+package chat
+
+import (
+	"sync"
+	"time"
+)
+
+// coalescer batches streamed chunks before handing them to the write function.
+// Buffered data is flushed once it reaches size bytes, or interval after the first
+// buffered chunk, whichever comes first. A zero size or interval disables that trigger.
+type coalescer struct {
+	locker   sync.Mutex              // Serializes writes from the stream and the flush timer
+	w        func(data []byte) error // Underlying write function
+	buf      []byte                  // Pending data
+	timer    *time.Timer             // Pending time based flush, nil if none
+	err      error                   // First error returned by w
+	interval time.Duration           // Maximum time data is held back
+	size     int                     // Buffer size that triggers a flush
+}
+
+func newCoalescer(w func(data []byte) error, interval time.Duration, size int) *coalescer {
+	return &coalescer{
+		w:        w,
+		interval: interval,
+		size:     size,
+	}
+}
+
+// Write buffers data and flushes if the size limit is reached.
+// It returns the first error reported by the underlying write function.
+func (c *coalescer) Write(data []byte) error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.buf = append(c.buf, data...)
+	if c.size > 0 && len(c.buf) >= c.size {
+		c.flush()
+		return c.err
+	}
+	if c.interval > 0 && c.timer == nil {
+		c.timer = time.AfterFunc(c.interval, func() {
+			c.locker.Lock()
+			defer c.locker.Unlock()
+			c.timer = nil
+			c.flush()
+		})
+	}
+	return c.err
+}
+
+// Flush writes any pending data and stops the flush timer.
+func (c *coalescer) Flush() error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.flush()
+	return c.err
+}
+
+// flush writes pending data, the caller must hold the locker.
+func (c *coalescer) flush() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if len(c.buf) == 0 || c.err != nil {
+		return
+	}
+	c.err = c.w(c.buf)
+	c.buf = nil
+}
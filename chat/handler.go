@@ -0,0 +1,144 @@
+package chat
+
+import (
+	"slices"
+
+	"github.com/xyzj/llm/provider"
+)
+
+type (
+	// StreamHandler receives the parts of a response as they arrive, so user interfaces
+	// can render tool invocations while the model is still generating them. Non-streamed
+	// responses are delivered the same way at once, every tool call as a single delta.
+	// Returning an error from OnContent or OnToolCallDelta aborts the request.
+	StreamHandler interface {
+		// OnContent is called with every delta of the assistant text, after the transform
+		// stage of the request and before any coalescing of the write function.
+		OnContent(text string) error
+		// OnToolCallDelta is called with every fragment of a tool call.
+		OnToolCallDelta(delta ToolCallDelta) error
+		// OnFinish is called once the response is complete, with the token usage if the
+		// provider reported it and the reason the model stopped.
+		OnFinish(usage *provider.Usage, reason provider.FinishReason)
+	}
+	// ReasoningHandler is implemented by stream handlers receiving the reasoning of thinking
+	// models, which they return separately from the assistant text, see WithThinking.
+	ReasoningHandler interface {
+		// OnReasoning is called with every delta of the reasoning. Returning an error aborts
+		// the request.
+		OnReasoning(text string) error
+	}
+	// ToolCallDelta is a fragment of a tool call. The first fragment of a call carries its
+	// name, the arguments are the JSON text to append to the previous fragments.
+	ToolCallDelta struct {
+		ID        string            // Tool call id, also set on fragments the provider sent without one
+		Type      provider.ToolType // Type of the tool, set on the first fragment
+		Name      string            // Name of the called tool
+		Arguments string            // Next fragment of the JSON encoded arguments
+	}
+	// StreamFuncs implements StreamHandler with optional functions, nil functions are skipped.
+	StreamFuncs struct {
+		Content       func(text string) error
+		Reasoning     func(text string) error
+		ToolCallDelta func(delta ToolCallDelta) error
+		Finish        func(usage *provider.Usage, reason provider.FinishReason)
+	}
+	// handlers calls several stream handlers in order, see WithStreamHandler.
+	handlers []StreamHandler
+)
+
+// OnContent calls f.Content if set.
+func (f StreamFuncs) OnContent(text string) error {
+	if f.Content == nil {
+		return nil
+	}
+	return f.Content(text)
+}
+
+// OnReasoning calls f.Reasoning if set.
+func (f StreamFuncs) OnReasoning(text string) error {
+	if f.Reasoning == nil {
+		return nil
+	}
+	return f.Reasoning(text)
+}
+
+// OnToolCallDelta calls f.ToolCallDelta if set.
+func (f StreamFuncs) OnToolCallDelta(delta ToolCallDelta) error {
+	if f.ToolCallDelta == nil {
+		return nil
+	}
+	return f.ToolCallDelta(delta)
+}
+
+// OnFinish calls f.Finish if set.
+func (f StreamFuncs) OnFinish(usage *provider.Usage, reason provider.FinishReason) {
+	if f.Finish != nil {
+		f.Finish(usage, reason)
+	}
+}
+
+// WithStreamHandler sets a handler receiving the assistant text, the tool call fragments
+// and the completion of the response, in addition to the write function, see StreamHandler.
+// Handlers implementing ReasoningHandler receive the reasoning too. Handlers set by
+// several options are called in the order they were set.
+func WithStreamHandler(h StreamHandler) Opts {
+	return func(opt *Opt) {
+		switch prev := opt.handler.(type) {
+		case nil:
+			opt.handler = h
+		case handlers:
+			opt.handler = append(slices.Clip(prev), h)
+		default:
+			opt.handler = handlers{prev, h}
+		}
+	}
+}
+
+// OnContent calls the handlers until one fails.
+func (hs handlers) OnContent(text string) error {
+	for _, h := range hs {
+		if err := h.OnContent(text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OnReasoning calls the handlers implementing ReasoningHandler until one fails.
+func (hs handlers) OnReasoning(text string) error {
+	for _, h := range hs {
+		if err := onReasoning(h, text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OnToolCallDelta calls the handlers until one fails.
+func (hs handlers) OnToolCallDelta(delta ToolCallDelta) error {
+	for _, h := range hs {
+		if err := h.OnToolCallDelta(delta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OnFinish calls the handlers.
+func (hs handlers) OnFinish(usage *provider.Usage, reason provider.FinishReason) {
+	for _, h := range hs {
+		h.OnFinish(usage, reason)
+	}
+}
+
+// onReasoning passes a reasoning delta to h if it implements ReasoningHandler.
+func onReasoning(h StreamHandler, text string) error {
+	if rh, ok := h.(ReasoningHandler); ok {
+		return rh.OnReasoning(text)
+	}
+	return nil
+}
+
+// nopHandler is the StreamHandler of requests without one.
+var nopHandler StreamHandler = StreamFuncs{}
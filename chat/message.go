@@ -0,0 +1,121 @@
+package chat
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xyzj/llm/provider"
+
+	"github.com/volcengine/volcengine-go-sdk/volcengine"
+)
+
+// Message builds a multi-part user message, e.g. a question with screenshots or photos
+// for vision-capable models, see WithMessage. The methods add parts in the order they
+// are called and can be chained; the first error, e.g. of an unreadable file, is kept and
+// returned by Err.
+//
+// Example:
+//
+//	msg := chat.NewMessage("What is wrong in this screenshot?").ImageFile("/tmp/screen.png")
+//	res, err := c.Chat("", chat.WithMessage(msg))
+type Message struct {
+	parts []*provider.ContentPart
+	err   error
+}
+
+// NewMessage creates a message starting with the text part s, empty text is left out.
+func NewMessage(s string) *Message {
+	return (&Message{}).Text(s)
+}
+
+// Text adds a text part, empty text is ignored.
+func (m *Message) Text(s string) *Message {
+	if s != "" {
+		m.parts = append(m.parts, &provider.ContentPart{Type: provider.ContentPartText, Text: s})
+	}
+	return m
+}
+
+// ImageURL adds an image part referencing an image by its URL, which must be reachable
+// by the provider, or by a data URL.
+func (m *Message) ImageURL(url string) *Message {
+	m.parts = append(m.parts, &provider.ContentPart{
+		Type:     provider.ContentPartImageURL,
+		ImageURL: &provider.ImageURL{URL: url},
+	})
+	return m
+}
+
+// Image adds an image part embedding the image data as base64 data URL. An empty
+// mimeType is detected from the data.
+func (m *Message) Image(mimeType string, data []byte) *Message {
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(mimeType, "image/") {
+		return m.fail(fmt.Errorf("unsupported image type [%s]", mimeType))
+	}
+	return m.ImageURL("data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data))
+}
+
+// ImageFile adds an image part embedding the image file at path, its type is derived
+// from the file extension or, if unknown, the content.
+func (m *Message) ImageFile(path string) *Message {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m.fail(err)
+	}
+	mimeType, _, _ := strings.Cut(mime.TypeByExtension(filepath.Ext(path)), ";")
+	return m.Image(mimeType, data)
+}
+
+// Err returns the first error adding a part, nil if all parts were added.
+func (m *Message) Err() error {
+	return m.err
+}
+
+// Parts returns the parts of the message.
+func (m *Message) Parts() []*provider.ContentPart {
+	return m.parts
+}
+
+// fail records the first error of the message.
+func (m *Message) fail(err error) *Message {
+	if m.err == nil {
+		m.err = err
+	}
+	return m
+}
+
+// WithMessage adds the parts of m to the user message of the request, after the text
+// passed to ChatContext if any. The request fails with the error of m if building it
+// failed.
+func WithMessage(m *Message) Opts {
+	return func(opt *Opt) {
+		opt.message = m
+	}
+}
+
+// userMessage returns the user message of a request from its text and the parts of msg,
+// nil if both are empty. Messages without parts besides text keep a plain string content.
+func userMessage(text string, msg *Message) *provider.Message {
+	if msg == nil || len(msg.parts) == 0 {
+		if text == "" {
+			return nil
+		}
+		return &provider.Message{
+			Role:    provider.RoleUser,
+			Content: &provider.MessageContent{StringValue: volcengine.String(text)},
+		}
+	}
+	parts := (&Message{}).Text(text).parts
+	return &provider.Message{
+		Role:    provider.RoleUser,
+		Content: &provider.MessageContent{ListValue: append(parts, msg.parts...)},
+	}
+}
@@ -0,0 +1,223 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // register decoders for images sent as data URLs
+	"image/jpeg"
+	_ "image/png"
+	"strings"
+
+	"github.com/xyzj/llm/provider"
+
+	"github.com/xyzj/toolbox/json"
+)
+
+// ErrPayloadTooLarge is returned if a request exceeds the payload limit even after downscaling.
+var ErrPayloadTooLarge = errors.New("request payload too large")
+
+// PayloadReport describes how a request was downscaled to fit the payload limit.
+type PayloadReport struct {
+	Limit   int      `json:"limit"`   // Payload limit in bytes
+	Before  int      `json:"before"`  // Estimated payload size before downscaling
+	After   int      `json:"after"`   // Estimated payload size after downscaling
+	Removed []string `json:"removed"` // Description of every reduction, in the order applied
+}
+
+// maxImageSide is the longest side images are downsampled to when they are compressed.
+const maxImageSide = 1024
+
+// guardPayload checks the encoded size of req against limit and downscales the request
+// messages until it fits, in this order, oldest messages first:
+//  1. images sent as data URLs are re-encoded as smaller JPEGs
+//  2. tool results are replaced by a placeholder
+//  3. images are replaced by a placeholder
+//  4. messages are dropped, keeping the system messages and the last message
+//
+// The messages in the history are not changed. report is called if the request was downscaled.
+func guardPayload(req *provider.Request, limit int, report func(*PayloadReport)) error {
+	sizes := make([]int, len(req.Messages))
+	total := requestOverhead(req)
+	for i, m := range req.Messages {
+		sizes[i] = messageSize(m)
+		total += sizes[i]
+	}
+	if total <= limit {
+		return nil
+	}
+	rp := &PayloadReport{Limit: limit, Before: total}
+	msgs := make([]*provider.Message, len(req.Messages))
+	copy(msgs, req.Messages)
+	last := len(msgs) - 1
+	// replace updates message i and the running total
+	replace := func(i int, m *provider.Message, what string) {
+		msgs[i] = m
+		n := messageSize(m)
+		total += n - sizes[i]
+		sizes[i] = n
+		rp.Removed = append(rp.Removed, what)
+	}
+	for i := 0; i < len(msgs) && total > limit; i++ {
+		if m, n := compressImages(msgs[i]); n > 0 {
+			replace(i, m, fmt.Sprintf("compressed %d image(s) of message %d", n, i))
+		}
+	}
+	for i := 0; i < last && total > limit; i++ {
+		if msgs[i].Role == provider.RoleTool {
+			m := *msgs[i]
+			m.Content = textContent(fmt.Sprintf("[tool result removed: %d bytes]", sizes[i]))
+			replace(i, &m, fmt.Sprintf("removed tool result of message %d (%s)", i, msgs[i].ToolCallID))
+		}
+	}
+	for i := 0; i < last && total > limit; i++ {
+		if m, n := removeImages(msgs[i]); n > 0 {
+			replace(i, m, fmt.Sprintf("removed %d image(s) of message %d", n, i))
+		}
+	}
+	dropped := make([]bool, len(msgs))
+	for i := 0; i < last && total > limit; i++ {
+		if msgs[i].Role == provider.RoleSystem {
+			continue
+		}
+		dropped[i] = true
+		total -= sizes[i]
+		rp.Removed = append(rp.Removed, fmt.Sprintf("dropped %s message %d", msgs[i].Role, i))
+		// tool results must not outlive the assistant message calling them
+		for i+1 < last && msgs[i+1].Role == provider.RoleTool {
+			i++
+			dropped[i] = true
+			total -= sizes[i]
+			rp.Removed = append(rp.Removed, fmt.Sprintf("dropped %s message %d", msgs[i].Role, i))
+		}
+	}
+	kept := make([]*provider.Message, 0, len(msgs))
+	for i, m := range msgs {
+		if !dropped[i] {
+			kept = append(kept, m)
+		}
+	}
+	req.Messages = kept
+	rp.After = total
+	if report != nil {
+		report(rp)
+	}
+	if total > limit {
+		return fmt.Errorf("%w: %d bytes after downscaling, limit %d", ErrPayloadTooLarge, total, limit)
+	}
+	return nil
+}
+
+// requestOverhead returns the encoded size of req without its messages.
+func requestOverhead(req *provider.Request) int {
+	r := *req
+	r.Messages = nil
+	b, _ := json.Marshal(r)
+	return len(b)
+}
+
+// messageSize returns the encoded size of m including its separator.
+func messageSize(m *provider.Message) int {
+	b, _ := json.Marshal(m)
+	return len(b) + 1
+}
+
+func textContent(s string) *provider.MessageContent {
+	return &provider.MessageContent{StringValue: &s}
+}
+
+// mapImages returns a copy of m with every image part replaced by f, and the number of
+// parts f changed. m itself is returned if nothing changed.
+func mapImages(m *provider.Message, f func(p *provider.ContentPart) *provider.ContentPart) (*provider.Message, int) {
+	if m.Content == nil || len(m.Content.ListValue) == 0 {
+		return m, 0
+	}
+	parts := make([]*provider.ContentPart, len(m.Content.ListValue))
+	n := 0
+	for i, p := range m.Content.ListValue {
+		parts[i] = p
+		if p.Type == provider.ContentPartImageURL && p.ImageURL != nil {
+			if np := f(p); np != nil {
+				parts[i] = np
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return m, 0
+	}
+	c := *m
+	c.Content = &provider.MessageContent{ListValue: parts}
+	return &c, n
+}
+
+// compressImages re-encodes the data URL images of m as downsampled JPEGs.
+func compressImages(m *provider.Message) (*provider.Message, int) {
+	return mapImages(m, func(p *provider.ContentPart) *provider.ContentPart {
+		u, ok := compressDataURL(p.ImageURL.URL)
+		if !ok {
+			return nil
+		}
+		np := *p
+		iu := *p.ImageURL
+		iu.URL = u
+		np.ImageURL = &iu
+		return &np
+	})
+}
+
+// removeImages replaces the images of m by a text placeholder.
+func removeImages(m *provider.Message) (*provider.Message, int) {
+	return mapImages(m, func(p *provider.ContentPart) *provider.ContentPart {
+		return &provider.ContentPart{Type: provider.ContentPartText, Text: "[image removed]"}
+	})
+}
+
+// compressDataURL re-encodes a base64 data URL image as a JPEG of at most maxImageSide
+// pixels per side. It reports false if the URL is no decodable image or would not shrink.
+func compressDataURL(u string) (string, bool) {
+	header, data, ok := strings.Cut(u, ",")
+	if !ok || !strings.HasPrefix(header, "data:image/") || !strings.HasSuffix(header, ";base64") {
+		return "", false
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", false
+	}
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return "", false
+	}
+	buf := bytes.Buffer{}
+	if err = jpeg.Encode(&buf, downsample(img, maxImageSide), &jpeg.Options{Quality: 60}); err != nil {
+		return "", false
+	}
+	out := "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	if len(out) >= len(u) {
+		return "", false
+	}
+	return out, true
+}
+
+// downsample scales img with nearest neighbor sampling so its longest side is at most side.
+func downsample(img image.Image, side int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= side && h <= side {
+		return img
+	}
+	nw, nh := side, h*side/w
+	if h > w {
+		nw, nh = w*side/h, side
+	}
+	nw, nh = max(nw, 1), max(nh, 1)
+	dst := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	for y := 0; y < nh; y++ {
+		for x := 0; x < nw; x++ {
+			dst.Set(x, y, img.At(b.Min.X+x*w/nw, b.Min.Y+y*h/nh))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,46 @@
+package chat
+
+import (
+	"context"
+
+	"github.com/xyzj/llm/provider"
+)
+
+// Prime sends the prompt of the next request, i.e. the system prompt, the running summary
+// and the history, with a completion limit of one token, so providers with prompt prefix
+// caching have the conversation cached before the next user message arrives. Nothing is
+// stored in the history. Chats without history are not primed.
+//
+// Parameters:
+//   - ctx: Context controlling cancellation and deadline of the request
+//   - sys: System prompt of the next request, the session system prompt if empty
+//   - tools: Tools offered with the next request, as they are part of the cached prefix
+//
+// Returns:
+//   - bool: Whether a request was sent
+//   - error: Any error of the request
+func (c *Chat) Prime(ctx context.Context, sys []*provider.Message, tools []*provider.Tool) (bool, error) {
+	pinned, his := c.hist().Parts()
+	if len(pinned)+len(his) == 0 {
+		return false, nil
+	}
+	if len(sys) == 0 {
+		sys = c.SystemPrompt()
+	}
+	msgs := append([]*provider.Message{}, sys...)
+	if sm := c.summaryMessage(); sm != nil {
+		msgs = append(msgs, sm)
+	}
+	msgs = append(append(msgs, pinned...), c.windowed(his)...)
+	one := 1
+	req := provider.Request{
+		Model:     c.Model(),
+		Messages:  msgs,
+		MaxTokens: &one,
+		Tools:     tools,
+	}
+	_, err := withRetry(ctx, c.retry, func() (provider.Response, error) {
+		return c.cli.CreateCompletion(ctx, req)
+	})
+	return true, err
+}
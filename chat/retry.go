@@ -0,0 +1,75 @@
+package chat
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/xyzj/llm/provider"
+)
+
+// maxBackoff caps the delay between two attempts, unless the backend requests more.
+const maxBackoff = 30 * time.Second
+
+// retryPolicy configures how failed completion requests are retried, see WithRetry.
+type retryPolicy struct {
+	attempts int           // Maximum number of attempts, including the first one
+	backoff  time.Duration // Delay before the first retry, doubled for each further retry
+}
+
+// WithRetry retries completion requests failing with a transient error, i.e. rate
+// limiting (429), server errors (5xx) or network errors, see provider.Retryable.
+// The delay before the n-th retry is backoff*2^(n-1) with random jitter, capped at 30s,
+// or the delay requested by a Retry-After header if it is longer. Streamed requests are
+// only retried if the stream could not be opened, never after content was received.
+//
+// Parameters:
+//   - maxAttempts: Maximum number of attempts including the first one, values below 2 disable retries
+//   - backoff: Delay before the first retry
+func WithRetry(maxAttempts int, backoff time.Duration) ChatOpts {
+	return func(opt *ChatOpt) {
+		if maxAttempts < 2 {
+			opt.retry = nil
+			return
+		}
+		opt.retry = &retryPolicy{attempts: maxAttempts, backoff: max(backoff, 0)}
+	}
+}
+
+// delay returns the wait before the given retry, starting at 1.
+func (p *retryPolicy) delay(retry int, after time.Duration) time.Duration {
+	d := p.backoff
+	for i := 1; i < retry && d < maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, maxBackoff)
+	// jitter between half and the full delay, so clients don't retry in lockstep
+	if d > 1 {
+		d = d/2 + rand.N(d/2+1)
+	}
+	return max(d, after)
+}
+
+// withRetry calls f until it succeeds, fails with an error that is not transient, the
+// attempts of p are exhausted or ctx is done. A nil policy calls f once.
+func withRetry[T any](ctx context.Context, p *retryPolicy, f func() (T, error)) (T, error) {
+	v, err := f()
+	if p == nil {
+		return v, err
+	}
+	for retry := 1; err != nil && retry < p.attempts; retry++ {
+		ok, after := provider.Retryable(err)
+		if !ok {
+			break
+		}
+		t := time.NewTimer(p.delay(retry, after))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return v, err
+		case <-t.C:
+		}
+		v, err = f()
+	}
+	return v, err
+}
@@ -0,0 +1,85 @@
+package chat
+
+import (
+	"errors"
+
+	"github.com/xyzj/llm/provider"
+)
+
+// ErrNoUserMessage is returned when a reply is regenerated or a user message is edited in
+// a history without user message, e.g. because it was evicted.
+var ErrNoUserMessage = errors.New("no user message in the chat history")
+
+// WithRegenerate answers the last user message of the history again: its reply, i.e. the
+// assistant messages and the tool calls and results following it, is removed from the
+// history before the request is sent. The request fails with ErrNoUserMessage if the
+// history holds no user message. It is meant for requests without a new message.
+func WithRegenerate() Opts {
+	return func(opt *Opt) {
+		opt.regenerate = true
+	}
+}
+
+// LastUserMessage returns the last user message of the history, or nil if there is none.
+func (c *Chat) LastUserMessage() *provider.Message {
+	_, recent := c.hist().Parts()
+	if i := lastUser(recent); i >= 0 {
+		return recent[i]
+	}
+	return nil
+}
+
+// EditLastUserMessage replaces the text of the last user message of the history and
+// removes the reply to it, which no longer fits. Images of the message are kept. Send a
+// request with WithRegenerate to answer the edited message.
+//
+// Returns:
+//   - error: ErrNoUserMessage if the history holds no user message
+func (c *Chat) EditLastUserMessage(text string) error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	h := c.hist()
+	_, recent := h.Parts()
+	i := lastUser(recent)
+	if i < 0 {
+		return ErrNoUserMessage
+	}
+	msg := *recent[i]
+	content := &provider.MessageContent{StringValue: &text}
+	if msg.Content != nil && len(msg.Content.ListValue) > 0 {
+		parts := (&Message{}).Text(text).parts
+		for _, p := range msg.Content.ListValue {
+			if p.Type != provider.ContentPartText {
+				parts = append(parts, p)
+			}
+		}
+		content = &provider.MessageContent{ListValue: parts}
+	}
+	msg.Content = content
+	h.DropLast(len(recent) - i)
+	h.Store(&msg)
+	return nil
+}
+
+// rewind removes the reply to the last user message from the history. The caller must
+// hold the request lock.
+func (c *Chat) rewind() error {
+	h := c.hist()
+	_, recent := h.Parts()
+	i := lastUser(recent)
+	if i < 0 {
+		return ErrNoUserMessage
+	}
+	h.DropLast(len(recent) - i - 1)
+	return nil
+}
+
+// lastUser returns the index of the last user message of msgs, or -1.
+func lastUser(msgs []*provider.Message) int {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role == provider.RoleUser {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,166 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xyzj/llm/history"
+	"github.com/xyzj/llm/provider"
+)
+
+// MetaSummary holds the running summary of the messages dropped from the history,
+// see WithSummarizer. It is sent as a system message after the system prompt.
+const MetaSummary = "summary"
+
+const (
+	// summaryBatch is the number of dropped messages collected before they are summarized.
+	summaryBatch = 6
+	// maxPending caps the dropped messages waiting for summarization, e.g. while the
+	// summarizer keeps failing; the oldest ones are discarded beyond it.
+	maxPending = 200
+	// summaryTimeout bounds one summarizer call.
+	summaryTimeout = 2 * time.Minute
+)
+
+// Summarizer condenses messages dropped from the history into the running summary of
+// the conversation.
+//
+// Parameters:
+//   - ctx: Context controlling cancellation and deadline of the call
+//   - summary: Current summary, empty for the first call
+//   - dropped: Messages dropped since the last call, in chronological order
+//
+// Returns:
+//   - string: The new summary covering the current summary and the dropped messages
+//   - error: If summarizing failed, the messages are passed again with the next call
+type Summarizer func(ctx context.Context, summary string, dropped []*provider.Message) (string, error)
+
+// WithSummarizer condenses the messages the history drops when it overflows into a
+// running summary, stored as MetaSummary and sent as a system message with every
+// request, so long-term context survives within a bounded window. Dropped messages are
+// summarized in the background in batches, so a summary may lag a few messages behind.
+func WithSummarizer(s Summarizer) ChatOpts {
+	return func(opt *ChatOpt) {
+		opt.summarizer = s
+	}
+}
+
+// summaryPrompt instructs the model of ModelSummarizer.
+const summaryPrompt = "You maintain a running summary of a conversation between a user and an assistant. " +
+	"Update the summary with the new messages. Keep facts, names, decisions, open questions and user " +
+	"preferences; drop small talk. Answer with the updated summary only, at most 300 words."
+
+// ModelSummarizer returns a Summarizer asking a model, e.g. a small and cheap one, to
+// update the summary.
+//
+// Parameters:
+//   - p: Provider serving the model
+//   - model: Name of the model writing the summaries
+func ModelSummarizer(p provider.Provider, model string) Summarizer {
+	return func(ctx context.Context, summary string, dropped []*provider.Message) (string, error) {
+		var b strings.Builder
+		if summary != "" {
+			b.WriteString("Current summary:\n" + summary + "\n\n")
+		}
+		b.WriteString("New messages:\n")
+		for _, m := range dropped {
+			writeTranscript(&b, m)
+		}
+		system, user := summaryPrompt, b.String()
+		temp := float32(0)
+		resp, err := p.CreateCompletion(ctx, provider.Request{
+			Model:       model,
+			Temperature: &temp,
+			Messages: []*provider.Message{
+				{Role: provider.RoleSystem, Content: &provider.MessageContent{StringValue: &system}},
+				{Role: provider.RoleUser, Content: &provider.MessageContent{StringValue: &user}},
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(resp.Choices) == 0 || resp.Choices[0].Message.Content == nil || resp.Choices[0].Message.Content.StringValue == nil {
+			return "", errors.New("summarizer returned no content")
+		}
+		return strings.TrimSpace(*resp.Choices[0].Message.Content.StringValue), nil
+	}
+}
+
+// writeTranscript writes a message as a transcript line for the summarizer.
+func writeTranscript(b *strings.Builder, m *provider.Message) {
+	text := ""
+	if m.Content != nil && m.Content.StringValue != nil {
+		text = *m.Content.StringValue
+	}
+	for _, tc := range m.ToolCalls {
+		text += fmt.Sprintf(" [called %s(%s)]", tc.Function.Name, tc.Function.Arguments)
+	}
+	if text = strings.TrimSpace(text); text != "" {
+		fmt.Fprintf(b, "%s: %s\n", m.Role, text)
+	}
+}
+
+// dropped collects messages dropped from the history for the summarizer.
+// It is the eviction function of the history and runs with the history locked, so the
+// chat must never lock the history while holding mu.
+func (c *Chat) dropped(msgs []*provider.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(c.pending, msgs...)
+	if n := len(c.pending) - maxPending; n > 0 {
+		c.pending = c.pending[n:]
+	}
+}
+
+// summaryMessage returns the system message carrying the running summary, or nil.
+func (c *Chat) summaryMessage() *provider.Message {
+	summary, ok := c.meta.Load(MetaSummary)
+	if !ok || summary == "" {
+		return nil
+	}
+	s := "Summary of the earlier conversation:\n" + summary
+	return &provider.Message{Role: provider.RoleSystem, Content: &provider.MessageContent{StringValue: &s}}
+}
+
+// summarize starts summarizing the pending dropped messages in the background once a
+// batch is complete, unless a summarization is already running.
+func (c *Chat) summarize() {
+	if c.summarizer == nil {
+		return
+	}
+	c.mu.Lock()
+	if c.summarizing || len(c.pending) < summaryBatch {
+		c.mu.Unlock()
+		return
+	}
+	batch, gen := c.pending, c.generation
+	c.pending = nil
+	c.summarizing = true
+	c.mu.Unlock()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), summaryTimeout)
+		defer cancel()
+		prev, _ := c.meta.Load(MetaSummary)
+		summary, err := c.summarizer(ctx, prev, batch)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.summarizing = false
+		if gen != c.generation {
+			// the conversation was reset meanwhile
+			return
+		}
+		if err != nil {
+			c.pending = append(batch, c.pending...)
+			return
+		}
+		c.meta.Store(MetaSummary, summary)
+	}()
+}
+
+// newHistory creates an empty history with the options of the chat.
+func (c *Chat) newHistory(size int) *history.History {
+	return history.New(size, append(c.histOpts, history.WithEvictFunc(c.dropped))...)
+}
@@ -0,0 +1,92 @@
+package chat
+
+import (
+	"slices"
+
+	"github.com/xyzj/llm/provider"
+)
+
+// minWindow is the smallest number of history messages the adaptive window sends.
+const minWindow = 2
+
+// WithAdaptiveWindow adapts the number of history messages sent with each request to the
+// prompt tokens the provider reported for the previous one, shrinking the window when
+// prompts exceed target and growing it again when they fall below, so prompts stay near
+// a target utilization of the context window instead of a fixed message count.
+// The window changes at most by a factor of two per request, and tool results are never
+// sent without the assistant message calling the tool. The history itself is not changed.
+//
+// Parameters:
+//   - target: Prompt tokens to aim for, 0 or less sends the complete history
+func WithAdaptiveWindow(target int) ChatOpts {
+	return func(opt *ChatOpt) {
+		opt.targetTokens = max(target, 0)
+	}
+}
+
+// Window returns the number of history messages sent with the next request when
+// WithAdaptiveWindow is set, or 0 if the complete history is sent.
+func (c *Chat) Window() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.window
+}
+
+// windowed returns the most recent messages of msgs fitting the adaptive window, without
+// the records of builtin tool calls, which are not sent to the model.
+func (c *Chat) windowed(msgs []*provider.Message) []*provider.Message {
+	if slices.ContainsFunc(msgs, isBuiltinRecord) {
+		msgs = slices.DeleteFunc(slices.Clone(msgs), isBuiltinRecord)
+	}
+	c.mu.RLock()
+	window := c.window
+	c.mu.RUnlock()
+	if window <= 0 || window >= len(msgs) {
+		return msgs
+	}
+	start := len(msgs) - window
+	// tool results must follow the assistant message calling the tool
+	for start < len(msgs)-1 && msgs[start].Role == provider.RoleTool {
+		start++
+	}
+	return msgs[start:]
+}
+
+// adapt resizes the adaptive window after a request that sent sent history messages
+// and consumed prompt tokens.
+func (c *Chat) adapt(sent, total int, usage *provider.Usage) {
+	if c.targetTokens <= 0 || usage == nil || usage.PromptTokens <= 0 || sent == 0 {
+		return
+	}
+	next := sent * c.targetTokens / usage.PromptTokens
+	next = min(max(next, sent/2, minWindow), sent*2)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if next >= total && usage.PromptTokens <= c.targetTokens {
+		// everything fits
+		c.window = 0
+		return
+	}
+	c.window = next
+}
+
+// withContext returns msgs with extra inserted before the last user message, or appended
+// if there is none.
+func withContext(msgs, extra []*provider.Message) []*provider.Message {
+	if len(extra) == 0 {
+		return msgs
+	}
+	i := len(msgs)
+	for j := len(msgs) - 1; j >= 0; j-- {
+		if msgs[j].Role == provider.RoleUser {
+			i = j
+			break
+		}
+	}
+	return slices.Concat(msgs[:i], extra, msgs[i:])
+}
+
+// isBuiltinRecord reports whether m records a builtin tool call, see RoleBuiltinTool.
+func isBuiltinRecord(m *provider.Message) bool {
+	return m.Role == RoleBuiltinTool
+}
@@ -3,24 +3,27 @@ package llm
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/xyzj/llm/chat"
+	"github.com/xyzj/llm/clock"
+	"github.com/xyzj/llm/history"
 	mcpcli "github.com/xyzj/llm/mcp"
+	"github.com/xyzj/llm/provider"
 	"github.com/xyzj/llm/storage"
+	"github.com/xyzj/llm/tools"
+	"github.com/xyzj/llm/transform"
 
-	"github.com/volcengine/volcengine-go-sdk/service/arkruntime/model"
-	"github.com/xyzj/toolbox/crypto"
-	"github.com/xyzj/toolbox/logger"
+	"github.com/volcengine/volcengine-go-sdk/volcengine"
 	"github.com/xyzj/toolbox/loopfunc"
 	"github.com/xyzj/toolbox/mapfx"
-)
-
-const (
-	chatErrorFmt = "chat [%s] error: %v"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 // NewChatsManager creates a new ChatsManager instance with the specified configuration options.
@@ -28,15 +31,19 @@ const (
 // and manages persistent storage of chat histories.
 //
 // Default configuration:
-//   - Base URI: "http://127.0.0.1:11434" (for local Ollama-like services)
 //   - Model: "qwen3:8b"
+//   - Provider: OpenAI-compatible endpoint if a base URI is configured (see WithBaseURI),
+//     VolcEngine ARK runtime using the configured API key otherwise
 //   - Chat lifetime: 7 days
 //   - Max history: 500 messages per chat
 //   - Storage: File-based storage in default cache directory, fallback to memory
+//   - ID mapping: SHA1 hash of the external identifier
 //
-// The manager automatically starts a background goroutine that:
-//   - Saves chat histories every 5 minutes
-//   - Removes expired chat sessions based on configurable lifetime
+// The manager automatically starts a background goroutine, stopped by Close, that:
+//   - Saves chat histories every 5 minutes, asynchronously (see WithPersistEvery and Flush)
+//   - Removes expired chat sessions based on configurable lifetime every 5 minutes (see
+//     WithCleanupInterval), persisting their final history and reporting them to the
+//     WithOnExpire and WithOnChatEvicted callbacks
 //   - Performs cleanup to prevent memory leaks
 //
 // Parameters:
@@ -45,39 +52,79 @@ const (
 // Returns a fully initialized and ready-to-use ChatsManager instance.
 func NewChatsManager(opts ...Opts) *ChatsManager {
 	opt := &Opt{
-		baseURI:      "http://127.0.0.1:11434",
-		modelName:    "qwen3:8b",
-		apiKey:       "your_api_key",
-		chatLifeTime: 7 * 24 * time.Hour,
-		maxHistory:   500,
-		dataStorage:  storage.NewMemoryStorage(),
-		roleSystem:   make([]*model.ChatCompletionMessage, 0),
-		logg:         logger.NewNilLogger(),
+		modelName:     "qwen3:8b",
+		apiKey:        "your_api_key",
+		chatLifeTime:  7 * 24 * time.Hour,
+		persistEvery:  5 * time.Minute,
+		cleanupEvery:  5 * time.Minute,
+		maxHistory:    500,
+		dataStorage:   storage.NewMemoryStorage(),
+		roleSystem:    make([]*provider.Message, 0),
+		logg:          nopLogger,
+		idMapper:      HashIDMapper,
+		handoffPrompt: defaultHandoffPrompt,
+		maxToolRounds: 1,
+		toolWorkers:   8,
+		clock:         clock.Real(),
+		jobWorkers:    4,
+		jobQueue:      100,
+		jobRetention:  time.Hour,
 	}
 	for _, o := range opts {
 		o(opt)
 	}
+	if opt.provider == nil {
+		if opt.baseURI != "" {
+			opt.provider = provider.NewOpenAI(opt.baseURI, opt.apiKey)
+		} else {
+			opt.provider = provider.NewArk(opt.apiKey)
+		}
+	}
+	if opt.rateLimit != nil {
+		opt.provider = provider.NewLimiter(opt.provider, opt.rateLimit...)
+	}
+	if opt.metrics != nil {
+		opt.provider = opt.metrics.Provider(opt.provider)
+		opt.dataStorage = opt.metrics.Storage(opt.dataStorage)
+	}
+	var tracer trace.Tracer = noop.Tracer{}
+	if opt.tracing != nil {
+		tracer = opt.tracing.Tracer(tracerName)
+		opt.provider = provider.NewTracing(opt.provider, opt.tracing)
+		opt.dataStorage = storage.NewTracedStorage(opt.dataStorage, opt.tracing)
+		opt.mcpOpts = append(slices.Clone(opt.mcpOpts), mcpcli.WithTracerProvider(opt.tracing))
+	}
 	cm := &ChatsManager{
-		chats:  mapfx.NewStructMap[string, chat.Chat](),
-		mcpCli: mcpcli.New(),
-		cnf:    opt,
+		chats:    mapfx.NewStructMap[string, chat.Chat](),
+		ids:      newIDMap(),
+		warned:   mapfx.NewBaseMap[float64](),
+		cnf:      opt,
+		started:  opt.clock.Now(),
+		cmds:     &commands{cmds: make(map[string]Command)},
+		writes:   writes{inflight: make(map[string]chan struct{})},
+		traces:   mapfx.NewStructMap[string, RunTrace](),
+		settings: mapfx.NewStructMap[string, SessionOpt](),
+		states:   mapfx.NewBaseMap[string](),
+		sessions: sessionStorage(opt.dataStorage),
+		jobs:     newJobQueue(opt.jobQueue),
+		costs:    newCostTracker(opt.clock.Now()),
+		tracer:   tracer,
+		done:     make(chan struct{}),
+	}
+	cm.mcpCli = mcpcli.New(append(slices.Clone(opt.mcpOpts), mcpcli.WithToolsChanged(cm.toolsChanged))...)
+	if opt.prefetch > 0 {
+		cm.pf = newPrefetcher(opt.prefetch, opt.clock)
+	}
+	if opt.metrics != nil {
+		opt.metrics.ActiveChats(cm.chats.Len)
+	}
+	cm.loadIDMap()
+	if opt.builtinCmds {
+		cm.registerBuiltinCommands()
 	}
 	// Start background goroutine for periodic chat history persistence and cleanup
 	go loopfunc.LoopFunc(func(params ...any) {
-		t := time.NewTicker(time.Minute * 5)
-		defer t.Stop()
-		for range t.C {
-			// Periodically save chat histories and remove expired chats
-			cm.chats.ForEach(func(key string, value *chat.Chat) bool {
-				if time.Since(value.LastMessage()) > cm.cnf.chatLifeTime {
-					cm.chats.Delete(key)
-					cm.cnf.logg.Warning(fmt.Sprintf("chat [%s] expired and removed", key))
-					return true
-				}
-				cm.cnf.dataStorage.Store(key, value.History())
-				return true
-			})
-		}
+		cm.maintain()
 	}, "save history", io.Discard)
 	return cm
 }
@@ -92,14 +139,94 @@ func NewChatsManager(opts ...Opts) *ChatsManager {
 //   - Handling chat session lifecycle (creation, expiration, cleanup)
 //   - Providing thread-safe access to chat operations
 type ChatsManager struct {
-	chats  *mapfx.StructMap[string, chat.Chat] // Thread-safe map of active chat sessions
-	mcpCli *mcpcli.McpClient                   // MCP client for tool calling capabilities
-	cnf    *Opt                                // Configuration options for the manager
+	chats    *mapfx.StructMap[string, chat.Chat]  // Thread-safe map of active chat sessions
+	ids      idMap                                // External identifier to internal chat key mapping
+	warned   *mapfx.BaseMap[float64]              // Highest context warning threshold reported per chat
+	mcpCli   *mcpcli.McpClient                    // MCP client for tool calling capabilities
+	cnf      *Opt                                 // Configuration options for the manager
+	started  time.Time                            // Creation time of the manager
+	cmds     *commands                            // Slash-command registry
+	pf       *prefetcher                          // Speculative prefetching, nil if disabled
+	writes   writes                               // Asynchronous history writes in flight
+	running  running                              // Turns in flight, see Cancel
+	opening  opening                              // Sessions being restored, see openSession
+	traces   *mapfx.StructMap[string, RunTrace]   // Trace of the last turn per chat
+	settings *mapfx.StructMap[string, SessionOpt] // Session settings set with Configure per chat
+	states   *mapfx.BaseMap[string]               // Last persisted session state per internal key, see sessionState
+	sessions storage.Storage                      // Storage of the session states, see sessionStorage
+	jobs     *jobQueue                            // Chat turns submitted with Submit
+	costs    *costTracker                         // Usage and cost of the model requests per chat and model
+	tracer   trace.Tracer                         // Tracer of the turns and tool calls, see WithTracerProvider
+
+	done      chan struct{} // Closed by Close to stop the background goroutine
+	closeOnce sync.Once     // Closes done once
+	sweeping  sync.Mutex    // Held while the background goroutine saves and expires sessions
+}
+
+// allTools returns the tools of the local tool providers followed by the MCP tools,
+// with the configured tool hints applied.
+func (cm *ChatsManager) allTools() []*provider.Tool {
+	tls := make([]*provider.Tool, 0, cm.mcpCli.ToolCount())
+	for _, p := range cm.cnf.toolProviders {
+		tls = append(tls, p.Tools()...)
+	}
+	return applyToolHints(append(tls, cm.mcpCli.Tools()...), cm.cnf.toolHints)
+}
+
+// turnTools returns the tools offered in a turn of ch and the request options needed
+// to offer them. With WithDegradedMode, the MCP tools are left out while all MCP servers
+// are unreachable, the degradation notice is added to the system prompt sys and an
+// EventToolsUnavailable is delivered to ev.
+func (cm *ChatsManager) turnTools(ctx context.Context, ch *chat.Chat, sys []*provider.Message, ev func(e *Event) error) ([]*provider.Tool, []chat.Opts) {
+	if cm.cnf.degradeNotice == "" || len(cm.mcpCli.Servers()) == 0 || cm.mcpCli.Reachable(ctx) {
+		return cm.allTools(), nil
+	}
+	tls := make([]*provider.Tool, 0)
+	for _, p := range cm.cnf.toolProviders {
+		tls = append(tls, p.Tools()...)
+	}
+	sys = append(slices.Clone(sys), &provider.Message{
+		Role: provider.RoleSystem,
+		Content: &provider.MessageContent{
+			StringValue: volcengine.String(cm.cnf.degradeNotice),
+		},
+	})
+	cm.emit(ev, &Event{
+		Type:    EventToolsUnavailable,
+		ChatID:  ChatID(ch.ID()),
+		Message: "all MCP servers are unreachable, continuing without their tools",
+	})
+	return applyToolHints(tls, cm.cnf.toolHints), []chat.Opts{chat.WithRoleSystem(sys...)}
+}
+
+// toolsChanged logs the tool list changes announced by the MCP servers. Turns pick up the
+// new tools by themselves, as they query the MCP client for every request.
+func (cm *ChatsManager) toolsChanged(uri string, tls []*provider.Tool, err error) {
+	if err != nil {
+		cm.cnf.logg.Warn("mcp tool refresh failed", "server", uri, LogKeyError, err)
+		return
+	}
+	cm.cnf.logg.Info("mcp tools changed", "server", uri, "tools", len(tls))
+}
+
+// callTool executes a tool call through the first local tool provider offering the tool,
+// falling back to the MCP servers. The call is limited to 60 seconds within ctx.
+func (cm *ChatsManager) callTool(ctx context.Context, tc *provider.ToolCall) (*provider.Message, error) {
+	for _, p := range cm.cnf.toolProviders {
+		if tools.Has(p, tc.Function.Name) {
+			ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+			defer cancel()
+			return p.Call(ctx, tc)
+		}
+	}
+	return cm.mcpCli.CallContext(ctx, tc, mcpcli.WithTimeout(60*time.Second))
 }
 
 // InitMcp initializes MCP (Model Context Protocol) clients with the provided URIs.
 // Each URI represents an MCP server that provides tools for the AI model to use.
 // Failed initializations are logged but don't prevent other URIs from being processed.
+// With mcpcli.WithLazyConnect (see WithMcpOptions) the servers are only registered here
+// and connected on the first chat turn.
 //
 // Parameters:
 //   - mcpuri: Variable number of MCP server URIs to connect to
@@ -111,121 +238,714 @@ func (cm *ChatsManager) InitMcp(mcpuri ...string) {
 	for _, u := range mcpuri {
 		err := cm.mcpCli.AddTools(u)
 		if err != nil {
-			cm.cnf.logg.Error(fmt.Sprintf("init mcp client [%s] error: %v", u, err))
+			cm.cnf.logg.Error("init mcp client failed", "uri", u, LogKeyError, err)
 			continue
 		}
 	}
 }
 
+// RemoveMcp disconnects an MCP server added with InitMcp and removes its tools, see
+// mcpcli.McpClient.RemoveServer. Turns started afterwards no longer offer its tools.
+func (cm *ChatsManager) RemoveMcp(mcpuri string) error {
+	return cm.mcpCli.RemoveServer(mcpuri)
+}
+
+// ExportMcpSchemas writes the tools of the configured MCP servers to w as OpenAI
+// function-calling JSON or as a markdown catalogue, see mcpcli.McpClient.ExportSchemas.
+func (cm *ChatsManager) ExportMcpSchemas(w io.Writer, format mcpcli.SchemaFormat) error {
+	return cm.mcpCli.ExportSchemas(w, format)
+}
+
 // History retrieves the conversation history for a specific chat session.
-// Returns an empty slice if the chat session doesn't exist or has no history.
+// Returns an empty slice if the chat session isn't active or has no history, see
+// LoadHistory to restore inactive sessions from storage.
 //
 // Parameters:
 //   - id: Unique identifier of the chat session
 //
 // Returns:
-//   - []*model.ChatCompletionMessage: Slice of messages in chronological order
-func (cm *ChatsManager) History(id string) []*model.ChatCompletionMessage {
-	var his []*model.ChatCompletionMessage
-	if ch, ok := cm.chats.Load(id); ok {
+//   - []*provider.Message: Slice of messages in chronological order
+func (cm *ChatsManager) History(id string) []*provider.Message {
+	var his []*provider.Message
+	if ch, ok := cm.chats.LoadForUpdate(id); ok {
 		his = ch.History()
 	}
 	return his
 }
 
+// LoadHistory returns the conversation history of a chat session like History, restoring
+// the session from persistent storage if it is not active, e.g. to show a returning
+// user the conversation so far.
+//
+// Parameters:
+//   - ctx: Context controlling cancellation and deadline of the storage read
+//   - id: Unique identifier of the chat session
+//
+// Returns:
+//   - []*provider.Message: Slice of messages in chronological order
+//   - error: Any error loading the history from storage
+func (cm *ChatsManager) LoadHistory(ctx context.Context, id string) ([]*provider.Message, error) {
+	ch, _, err := cm.openSession(ctx, id)
+	return ch.History(), err
+}
+
+// ReadHistory returns the conversation history of a chat session like LoadHistory, but
+// reads the history of an inactive session from persistent storage without restoring the
+// session, e.g. to serve lookups of arbitrary ids.
+//
+// Parameters:
+//   - ctx: Context controlling cancellation and deadline of the storage read
+//   - id: Unique identifier of the chat session
+//
+// Returns:
+//   - []*provider.Message: Slice of messages in chronological order
+//   - error: An error matching storage.ErrNotFound if the session is neither active nor
+//     stored, or any error loading the history from storage
+func (cm *ChatsManager) ReadHistory(ctx context.Context, id string) ([]*provider.Message, error) {
+	if ch, ok := cm.chats.LoadForUpdate(id); ok {
+		return ch.History(), nil
+	}
+	key := cm.lookupID(id)
+	cm.awaitWrites(key)
+	his, err := cm.cnf.dataStorage.Load(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("chat [%s] not found: %w", id, err)
+	}
+	return his, storageError(err)
+}
+
+// SetHistory replaces the conversation history of a chat session, creating the session
+// if necessary, e.g. to continue a conversation whose messages are kept by the client.
+// The history is stored right away when saving after every turn, see WithPersistEvery,
+// and with the next background save otherwise.
+//
+// Parameters:
+//   - id: Unique identifier of the chat session
+//   - his: Messages in chronological order
+func (cm *ChatsManager) SetHistory(id string, his []*provider.Message) {
+	ctx, cancel := storageContext()
+	defer cancel()
+	ch := cm.session(ctx, id)
+	ch.SetHistory(his)
+	if cm.cnf.persistEvery == 0 {
+		if err := cm.flush(ch); err != nil {
+			cm.cnf.logg.Error("store chat history failed", LogKeyChatID, ch.ID(), LogKeyError, err)
+		}
+	}
+}
+
+// newChat creates a chat with the manager's provider and history settings.
+// Additional options override the defaults.
+func (cm *ChatsManager) newChat(key, modelName string, opts ...chat.ChatOpts) *chat.Chat {
+	if cm.cnf.summaryModel != "" {
+		opts = append([]chat.ChatOpts{chat.WithSummarizer(chat.ModelSummarizer(cm.cnf.provider, cm.cnf.summaryModel))}, opts...)
+	}
+	return chat.New(key, modelName, append([]chat.ChatOpts{
+		chat.WithAPIKey(cm.cnf.apiKey),
+		chat.WithMaxHistory(cm.cnf.maxHistory),
+		chat.WithProvider(cm.cnf.provider),
+		chat.WithPricing(cm.cnf.pricing),
+		chat.WithClock(cm.cnf.clock),
+		chat.WithRetry(cm.cnf.retryAttempts, cm.cnf.retryBackoff),
+		chat.WithAdaptiveWindow(int(cm.cnf.adaptiveUse * float64(cm.cnf.contextWin))),
+		chat.WithTokenBudget(cm.cnf.tokenBudget, cm.cnf.tokenizer),
+		chat.WithKeepReasoning(cm.cnf.keepReasoning),
+	}, opts...)...)
+}
+
+// session returns the active chat session for id, creating it if necessary.
+// New sessions restore their history from persistent storage.
+func (cm *ChatsManager) session(ctx context.Context, id string) *chat.Chat {
+	ch, _, err := cm.openSession(ctx, id)
+	if err != nil {
+		cm.cnf.logg.Error("load chat history failed", LogKeyChatID, ch.ID(), LogKeyError, err)
+	}
+	return ch
+}
+
+// opening tracks the sessions being restored per chat, so concurrent callers share one
+// session instead of restoring competing copies of it.
+type opening struct {
+	sync.Mutex
+	calls map[string]*openCall // Sessions being restored by chat
+}
+
+// openCall is a session being restored. Its results are set before done is closed.
+type openCall struct {
+	done     chan struct{} // Closed when the session is active
+	ch       *chat.Chat    // Restored session
+	restored bool          // Whether a history was restored from storage
+	err      error         // Error of restoring the session
+}
+
+// openSession returns the active chat session for id, creating it if necessary, and
+// reports whether a history was restored from persistent storage. A session is returned
+// even if loading its history failed. Callers opening the same session concurrently wait
+// for the first one and share its results.
+func (cm *ChatsManager) openSession(ctx context.Context, id string) (*chat.Chat, bool, error) {
+	if ch, ok := cm.chats.LoadForUpdate(id); ok {
+		return ch, false, nil
+	}
+	cm.opening.Lock()
+	if c, ok := cm.opening.calls[id]; ok {
+		cm.opening.Unlock()
+		<-c.done
+		return c.ch, c.restored, c.err
+	}
+	// the session may have become active since the first check
+	if ch, ok := cm.chats.LoadForUpdate(id); ok {
+		cm.opening.Unlock()
+		return ch, false, nil
+	}
+	if cm.opening.calls == nil {
+		cm.opening.calls = make(map[string]*openCall)
+	}
+	c := &openCall{done: make(chan struct{})}
+	cm.opening.calls[id] = c
+	cm.opening.Unlock()
+	defer func() {
+		cm.opening.Lock()
+		delete(cm.opening.calls, id)
+		cm.opening.Unlock()
+		close(c.done)
+	}()
+	c.ch, c.restored, c.err = cm.restoreSession(ctx, id)
+	return c.ch, c.restored, c.err
+}
+
+// restoreSession creates the session for id, restores it from persistent storage and
+// makes it active, see openSession.
+func (cm *ChatsManager) restoreSession(ctx context.Context, id string) (*chat.Chat, bool, error) {
+	keyid := cm.mapID(id)
+	// Create new chat session
+	ch := cm.newChat(keyid, cm.cnf.modelName)
+	if so, ok := cm.settings.Load(id); ok {
+		so.apply(ch)
+	}
+	// Load chat history from persistent storage, after pending writes of an evicted session
+	cm.awaitWrites(keyid)
+	his, err := cm.cnf.dataStorage.Load(ctx, keyid)
+	if errors.Is(err, storage.ErrNotFound) {
+		err = nil
+	}
+	st, serr := cm.readState(ctx, keyid)
+	if len(his) > 0 {
+		restoreHistory(ch, his, st)
+	}
+	cm.applyState(ch, st)
+	err = errors.Join(storageError(err), serr)
+	cm.chats.Store(id, ch)
+	cm.evictLRU(id)
+	return ch, len(his) > 0, err
+}
+
+// Metadata returns a copy of the metadata of a chat session,
+// or nil if the session is not active.
+func (cm *ChatsManager) Metadata(id string) map[string]string {
+	if ch, ok := cm.chats.LoadForUpdate(id); ok {
+		return ch.Metadata()
+	}
+	return nil
+}
+
+// SetMetadata sets a metadata value of a chat session, creating the session if necessary.
+// An empty value removes the key. The keys chat.MetaTemperature, chat.MetaMaxTokens and
+// chat.MetaAllowedTools hold generation settings that are applied on every turn of the session.
+// MetaRetriever selects the knowledge base of the session, see WithRetriever. The metadata
+// is persisted with the history of the session.
+func (cm *ChatsManager) SetMetadata(id, key, value string) {
+	ctx, cancel := storageContext()
+	defer cancel()
+	cm.session(ctx, id).SetMetadata(key, value)
+}
+
+// SystemPrompt returns the system prompt sent with the requests of a chat session, i.e. its
+// own one set with SetSystemPrompt or the default set with WithRoleSystem, or nil if the
+// session is not active. Templates are returned unrendered, see WithPrompts.
+func (cm *ChatsManager) SystemPrompt(id string) []*provider.Message {
+	ch, ok := cm.chats.LoadForUpdate(id)
+	if !ok {
+		return nil
+	}
+	if sys := ch.SystemPrompt(); len(sys) > 0 {
+		return sys
+	}
+	return cm.cnf.roleSystem
+}
+
+// SetSystemPrompt sets the system prompt of a chat session, creating the session if
+// necessary. It replaces the default set with WithRoleSystem on every request of the
+// session, including tool follow-ups; without messages the session returns to the default.
+// The prompt is persisted with the history of the session.
+func (cm *ChatsManager) SetSystemPrompt(id string, msgs ...*provider.Message) {
+	ctx, cancel := storageContext()
+	defer cancel()
+	cm.session(ctx, id).SetSystemPrompt(msgs...)
+}
+
+// Clone duplicates a chat session into a new session, enabling "try a different approach"
+// workflows without touching the original conversation. The history, system prompt,
+// metadata and session settings, see Configure, of the source are copied. If the source is
+// not active, its history, system prompt and metadata are read from storage instead.
+//
+// Parameters:
+//   - srcID: Identifier of the chat session to copy
+//   - dstID: Identifier of the new chat session, which must be neither active nor stored
+//
+// Returns:
+//   - error: If the source does not exist, the destination already exists, or copying fails
+func (cm *ChatsManager) Clone(srcID, dstID string) error {
+	return cm.fork(srcID, dstID, -1)
+}
+
+// Fork branches a chat session into a new session sharing the history up to a point,
+// e.g. to explore an alternative answer to an earlier question. The new session keeps
+// the messages before atIndex in the history returned by History and is persisted on its
+// own, so neither session affects the other afterwards. Everything else is copied like
+// with Clone.
+//
+// Parameters:
+//   - srcID: Identifier of the chat session to branch
+//   - newID: Identifier of the new chat session, which must be neither active nor stored
+//   - atIndex: Number of leading messages of the source history to keep
+//
+// Returns:
+//   - error: If the source does not exist, the destination already exists, atIndex is out
+//     of range or splits the results of a tool call from their request, or copying fails
+func (cm *ChatsManager) Fork(srcID, newID string, atIndex int) error {
+	if atIndex < 0 {
+		return fmt.Errorf("fork index %d out of range", atIndex)
+	}
+	return cm.fork(srcID, newID, atIndex)
+}
+
+// fork copies the session srcID into the new session dstID, keeping the first at messages
+// of the history, or all if at is negative.
+func (cm *ChatsManager) fork(srcID, dstID string, at int) error {
+	ctx, cancel := storageContext()
+	defer cancel()
+	// the destination may be stored from an earlier session, which is not active
+	if _, err := cm.ReadHistory(ctx, dstID); err == nil {
+		return fmt.Errorf("chat [%s] already exists", dstID)
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	his, err := cm.ReadHistory(ctx, srcID)
+	if err != nil {
+		return err
+	}
+	state := &sessionState{}
+	if src, ok := cm.chats.LoadForUpdate(srcID); ok {
+		state.SystemPrompt, state.Metadata, state.Pinned = src.SystemPrompt(), src.Metadata(), len(src.Pinned())
+	} else if len(his) == 0 {
+		return fmt.Errorf("chat [%s] not found", srcID)
+	} else if st, err := cm.readState(ctx, cm.lookupID(srcID)); err != nil {
+		return err
+	} else if st != nil {
+		state = st
+	}
+	if at >= 0 {
+		if at > len(his) {
+			return fmt.Errorf("fork index %d out of range, chat [%s] has %d messages", at, srcID, len(his))
+		}
+		// the model rejects tool results without the request of the call
+		if at < len(his) && his[at].Role == provider.RoleTool {
+			return fmt.Errorf("fork index %d splits the tool calls of chat [%s]", at, srcID)
+		}
+		his = his[:at]
+	}
+	his, err = history.CloneMessages(his)
+	if err != nil {
+		return err
+	}
+	dst := cm.newChat(cm.mapID(dstID), cm.cnf.modelName)
+	if so, ok := cm.settings.Load(srcID); ok {
+		cp := *so
+		cm.settings.Store(dstID, &cp)
+		cp.apply(dst)
+	}
+	restoreHistory(dst, his, state)
+	if len(state.SystemPrompt) > 0 {
+		dst.SetSystemPrompt(state.SystemPrompt...)
+	}
+	for k, v := range state.Metadata {
+		// the archives stay with the source, so deleting the clone keeps them
+		if k != MetaArchives {
+			dst.SetMetadata(k, v)
+		}
+	}
+	cm.chats.Store(dstID, dst)
+	cm.evictLRU(dstID)
+	cm.saveIDMap()
+	if err = cm.cnf.dataStorage.Store(ctx, dst.ID(), his); err != nil {
+		return storageError(err)
+	}
+	return cm.storeState(ctx, dst)
+}
+
+// Delete ends a chat session and removes its persisted history, the conversations archived
+// by its handoffs and its id mapping, e.g. when a user deletes a conversation. Pending background writes of the session are awaited first, so they
+// cannot restore the history afterwards.
+//
+// Parameters:
+//   - ctx: Context controlling cancellation and deadline of the storage operation
+//   - id: Identifier of the chat session
+//
+// Returns:
+//   - error: An ErrStorage matching storage.ErrNotFound if the session is neither active
+//     nor stored, or any error removing the history from storage
+func (cm *ChatsManager) Delete(ctx context.Context, id string) error {
+	key := cm.lookupID(id)
+	ch, active := cm.chats.LoadForUpdate(id)
+	cm.chats.Delete(id)
+	cm.ids.delete(id, "")
+	cm.saveIDMap()
+	cm.warned.Delete(key)
+	cm.traces.Delete(id)
+	cm.settings.Delete(id)
+	if cm.pf != nil {
+		cm.pf.drop(key)
+	}
+	var meta map[string]string
+	if active {
+		meta = ch.Metadata()
+	} else if st, err := cm.readState(ctx, key); err != nil {
+		return err
+	} else if st != nil {
+		meta = st.Metadata
+	}
+	if err := cm.deleteArchives(ctx, meta); err != nil {
+		return err
+	}
+	if err := cm.deleteState(ctx, key); err != nil {
+		return err
+	}
+	cm.awaitWrites(key)
+	err := cm.cnf.dataStorage.Delete(ctx, key)
+	if active && errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	return storageError(err)
+}
+
+// Close shuts the manager down, e.g. when the application exits: it stops the background
+// goroutine and the job workers, waiting for the jobs already submitted, waits for the
+// running turns, canceling those still running when ctx is done, persists the histories
+// of all active sessions and waits for their pending background writes, then
+// disconnects the MCP servers and closes the storage backend if it implements io.Closer.
+// Submit fails with ErrClosed afterwards; the manager must not be used otherwise. Later
+// calls of Close return nil.
+//
+// Parameters:
+//   - ctx: Context bounding the wait for the jobs, the turns and the storage operations
+//
+// Returns:
+//   - error: The joined errors of persisting the histories and closing the storage
+//     backend, and ctx.Err() if the jobs, turns or writes did not finish in time
+func (cm *ChatsManager) Close(ctx context.Context) error {
+	first := false
+	cm.closeOnce.Do(func() {
+		close(cm.done)
+		first = true
+	})
+	if !first {
+		return nil
+	}
+	// wait for a running save of the background goroutine
+	cm.sweeping.Lock()
+	cm.sweeping.Unlock()
+	var errs []error
+	if err := cm.jobs.close(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	// the turns store their histories, so they end before the storage is closed
+	if err := cm.running.close(ctx); err != nil && len(errs) == 0 {
+		errs = append(errs, err)
+	}
+	if err := cm.flushAll(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	cm.mcpCli.Close()
+	if c, ok := cm.cnf.dataStorage.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			errs = append(errs, storageError(err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isClosed reports whether Close was called.
+func (cm *ChatsManager) isClosed() bool {
+	select {
+	case <-cm.done:
+		return true
+	default:
+		return false
+	}
+}
+
 // Chat processes a message in the specified chat session and handles any resulting tool calls.
+// It is ChatContext with context.Background().
+func (cm *ChatsManager) Chat(id, message string, w func(data []byte) error, opts ...TurnOpts) {
+	cm.ChatContext(context.Background(), id, message, w, opts...)
+}
+
+// ChatContext processes a message in the specified chat session and handles any resulting tool calls.
 // This is the main method for interacting with AI models through the ChatsManager.
+// Cancelling ctx aborts the in-flight completions and tool calls, and the deadline of ctx
+// bounds the whole turn.
 //
 // The method performs the following operations:
-//  1. Creates or retrieves the chat session (using SHA1 hash of ID for storage key)
-//  2. Restores chat history from persistent storage if available
-//  3. Sends the user message to the AI model with available MCP tools
-//  4. Processes any tool calls made by the model through MCP clients
-//  5. Sends tool results back to the model for final response generation
-//  6. Streams responses through the provided write function
+//  1. Handles registered slash-commands without calling the model
+//  2. Runs the message through the configured preprocessors, dropping it if it ends up empty
+//  3. Creates or retrieves the chat session (using the configured IDMapper to derive the storage key)
+//  4. Restores chat history from persistent storage if available
+//  5. Closes a time-boxed conversation and starts over from its handoff summary
+//  6. Sends the user message to the AI model with available local and MCP tools, leaving
+//     out the MCP tools while all MCP servers are unreachable (see WithDegradedMode) and
+//     the tools withheld by opts (see WithAllowedTools)
+//  7. Processes any tool calls made by the model through the tool providers or MCP clients
+//     in parallel (see WithToolConcurrency), after asking the approval function of ctx if
+//     set with ApprovalContext; calls to tools withheld by opts are answered with
+//     ErrToolNotOffered
+//  8. Sends tool results back to the model, repeating 7 and 8 while the model calls tools,
+//     up to the configured number of rounds (see WithMaxToolRounds)
+//  9. Streams responses through the provided write function
+//  10. Emits context warning events when configured thresholds are crossed
+//  11. Speculatively prepares the next turn in the background, see WithPrefetch
+//  12. Records the model requests and tool calls of the turn, see LastTrace
+//
+// The model requests and tool calls share the time budget set with WithTurnDeadline.
+// Tool results are always sent back in a follow-up request, even once the budget ran
+// out, so they are recorded in the history before the turn stops.
 //
 // Parameters:
-//   - id: Unique identifier for the chat session (will be hashed for internal storage)
+//   - ctx: Context controlling cancellation and deadline of the turn
+//   - id: Unique identifier for the chat session (mapped to an internal key, see WithIDMapper)
 //   - message: User's message to send to the AI model
 //   - w: Write function called with streaming response data chunks
+//   - opts: Optional configuration of the turn, e.g. WithAllowedTools or WithMessage
 //
 // Error handling:
-//   - Errors are logged but don't propagate to prevent cascading failures
-//   - Failed tool calls are logged and skipped, allowing conversation to continue
+//   - Errors are logged but don't propagate to prevent cascading failures, see ChatE to
+//     receive them
+//   - Failed tool calls are logged and their error is sent to the model as the tool result,
+//     allowing the conversation to continue
 //   - Chat session remains valid even if individual operations fail
-func (cm *ChatsManager) Chat(id, message string, w func(data []byte) error) {
-	keyid := crypto.GetSHA1(id)
-	var ok bool
-	var ch *chat.Chat
-	if ch, ok = cm.chats.LoadForUpdate(keyid); !ok {
-		// Create new chat session
-		ch = chat.New(keyid, cm.cnf.modelName,
-			chat.WithAPIKey(cm.cnf.apiKey),
-			chat.WithMaxHistory(cm.cnf.maxHistory),
-		)
-		// Load chat history from persistent storage
-		his, err := cm.cnf.dataStorage.Load(keyid)
-		if err != nil {
-			cm.cnf.logg.Error(fmt.Sprintf("load chat history error: %v", err))
+func (cm *ChatsManager) ChatContext(ctx context.Context, id, message string, w func(data []byte) error, opts ...TurnOpts) {
+	cm.turn(ctx, id, message, w, opts...)
+}
+
+// ChatE is ChatContext returning the outcome of the turn, for callers telling their users
+// that a request failed.
+//
+// Returns:
+//   - string: The assistant text of the last model response, empty if the message was
+//     handled by a command or dropped by the preprocessors
+//   - error: A *TurnError of kind ErrRequestFailed, ErrTurnTimeout or ErrTurnCanceled if
+//     the turn failed, or ErrToolCallFailed along with the reply if tool calls failed;
+//     nil otherwise
+//
+// Example:
+//
+//	reply, err := cm.ChatE(ctx, "user123", "hello", w)
+//	if errors.Is(err, llm.ErrTurnTimeout) {
+//		// tell the user to try again later
+//	}
+func (cm *ChatsManager) ChatE(ctx context.Context, id, message string, w func(data []byte) error, opts ...TurnOpts) (string, error) {
+	trace := cm.turn(ctx, id, message, w, opts...)
+	if trace == nil {
+		return "", nil
+	}
+	return trace.Reply(), trace.Err()
+}
+
+// turn runs a chat turn, see ChatContext, and returns its trace, or nil if the message
+// was handled by a command or dropped by the preprocessors.
+func (cm *ChatsManager) turn(ctx context.Context, id, message string, w func(data []byte) error, opts ...TurnOpts) *RunTrace {
+	opt := newTurnOpt(opts)
+	if !opt.regenerate {
+		if cm.runCommand(id, message, w) {
+			return nil
+		}
+		// messages of images only skip the preprocessors, which work on text
+		if message != "" || opt.message == nil {
+			if message = cm.preprocess(id, message); message == "" {
+				return nil
+			}
+		}
+	}
+	ctx, span := cm.tracer.Start(ctx, "llm.turn")
+	ctx, done := cm.running.start(ctx, id)
+	defer done()
+	ch := cm.session(ctx, id)
+	// Regenerated turns send no new message, see Regenerate
+	input := message
+	if opt.regenerate {
+		input, message = "", userText(ch.LastUserMessage())
+	}
+	// Close time-boxed conversations and continue from their handoff summary
+	if cm.boxExpired(ch) {
+		if err := cm.handoff(ctx, ch, opt.events); err != nil {
+			cm.cnf.logg.Error("chat handoff failed", LogKeyChatID, ch.ID(), LogKeyError, err)
+		}
+	}
+	trace := &RunTrace{ChatID: ChatID(ch.ID()), Message: message, Start: time.Now()}
+	defer func() {
+		trace.Duration = time.Since(trace.Start)
+		cm.traces.Store(id, trace)
+		if opt.onTrace != nil {
+			opt.onTrace(trace)
 		}
-		if len(his) > 0 {
-			ch.SetHistory(his)
+		endTurn(span, ch, trace)
+		// store the session of the turn, which may have been evicted meanwhile
+		if cm.cnf.persistEvery == 0 {
+			if err := cm.flush(ch); err != nil {
+				cm.cnf.logg.Error("store chat history failed", LogKeyChatID, ch.ID(), LogKeyError, err)
+			}
 		}
-		cm.chats.Store(id, ch)
+	}()
+	// Bound the model requests and tool calls of the turn by one shared budget
+	parent := ctx
+	if cm.cnf.turnDeadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cm.cnf.turnDeadline)
+		defer cancel()
 	}
 	// Send message to AI model with available tools
-	toolcall, err := ch.Chat(message,
-		chat.WithTools(cm.mcpCli.Tools()),
+	sys := cm.systemPrompt(ch, opt)
+	tls, degraded := cm.turnTools(ctx, ch, sys, opt.events)
+	speech, flush := cm.speech(ctx, ch, opt.events)
+	defer flush()
+	extra := slices.Concat(degraded, speech, cm.reasoning(ch, opt.events), cm.retrieve(ctx, ch, message))
+	if cm.cnf.promptCache {
+		extra = append(extra, chat.WithPromptCache(cm.cnf.cacheTTL))
+	}
+	if cm.cnf.logProbs != nil {
+		extra = append(extra, chat.WithLogProbs(*cm.cnf.logProbs))
+	}
+	if len(cm.cnf.middlewares) > 0 {
+		extra = append(extra, chat.WithRequestHook(cm.cnf.middlewares.request(ch.ID())))
+	}
+	extra = append(extra, opt.chatOpts...)
+	tls = opt.filter(tls)
+	start := time.Now()
+	first := append([]chat.Opts{
+		chat.WithTools(tls),
+		chat.WithBuiltinTools(cm.cnf.builtinTools...),
 		chat.WithWriteFunc(w),
-		chat.WithStream(cm.mcpCli.ToolCount() == 0), // enable streaming if tools are not available
-	)
+		chat.WithTransform(transform.Chain(cm.cnf.transformers...)),
+		chat.WithCoalesce(cm.cnf.flushEvery, cm.cnf.flushSize),
+		chat.WithStream(true),
+		chat.WithMaxPayload(cm.cnf.maxPayload, cm.payloadReport(ch.ID(), opt.events)),
+		chat.WithMessage(opt.message),
+		chat.WithRoleSystem(sys...),
+	}, extra...)
+	if opt.regenerate {
+		first = append(first, chat.WithRegenerate())
+	}
+	res, err := ch.ChatContext(ctx, input, first...)
 	if err != nil {
-		cm.cnf.logg.Error(fmt.Sprintf(chatErrorFmt, ch.ID(), err))
-		return
+		err = canceled(ctx, err)
+		trace.fail(err)
+		if !cm.turnExpired(ctx, parent, err, trace, opt.events) && !errors.Is(err, ErrTurnCanceled) {
+			cm.cnf.logg.Error("chat request failed", LogKeyChatID, ch.ID(), LogKeyModel, ch.Model(), LogKeyLatency, time.Since(start), LogKeyError, err)
+		}
+		cm.cnf.middlewares.error(parent, ch.ID(), err)
+		return trace
 	}
-	// Process any tool calls made by the model
-	if l := len(toolcall); l > 0 {
-		wg := sync.WaitGroup{}
-		wg.Add(l)
-		msgs := make([]*model.ChatCompletionMessage, 0)
-		chanMsgs := make(chan *model.ChatCompletionMessage, l)
-		ctxdone, cancel := context.WithCancel(context.Background())
-		loopfunc.GoFunc(func(params ...any) {
-			for msg := range chanMsgs {
-				if msg.Role == "shut me down" {
-					cancel()
-					return
-				}
-				msgs = append(msgs, msg)
-			}
-		}, "recv tool msg", nil)
-		for _, v := range toolcall {
-			wg.Go(func() {
-				msg, err := cm.mcpCli.Call(v, mcpcli.WithTimeout(60*time.Second))
-				if err != nil {
-					cm.cnf.logg.Error(fmt.Sprintf("mcp call %s error: %v", v.Function.Name, err))
-					return
-				}
-				chanMsgs <- msg
-			})
-		}
-		wg.Wait()
-		chanMsgs <- &model.ChatCompletionMessage{Role: "shut me down"}
-		<-ctxdone.Done()
-		// Close the channel to signal completion
-		close(chanMsgs)
-		// Send tool results back to model for final response
-		if len(msgs) > 0 {
-			_, err = ch.Chat("",
-				chat.WithToolCalled(msgs),
-				chat.WithStream(true),
-				chat.WithWriteFunc(w),
-				chat.WithRoleSystem(cm.cnf.roleSystem...),
-			)
-			if err != nil {
-				cm.cnf.logg.Error(fmt.Sprintf(chatErrorFmt, ch.ID(), err))
-				return
+	round := trace.addRound(ch, res, len(tls), start)
+	cm.account(id, ch.Model(), res.Turn())
+	cm.cnf.middlewares.response(ctx, ch.ID(), res)
+	cm.cnf.logg.Debug("chat request completed", LogKeyChatID, ch.ID(), LogKeyModel, ch.Model(), LogKeyLatency, time.Since(start))
+	defer cm.prefetch(ch)
+	defer cm.checkContext(ch, opt.events)
+	// Execute the tool calls made by the model and send the results back, until the model
+	// stops calling tools or the round limit is reached
+	for rounds := 1; len(res.ToolCalls) > 0 && rounds <= cm.cnf.maxToolRounds; rounds++ {
+		msgs := cm.runTools(ctx, ch.ID(), res.Calls(), round, opt)
+		if len(msgs) == 0 {
+			return trace
+		}
+		// offer the tools again unless this is the last round
+		var more []*provider.Tool
+		if rounds < cm.cnf.maxToolRounds {
+			more = tls
+		}
+		start = time.Now()
+		res, err = ch.ChatContext(ctx, "", append([]chat.Opts{
+			chat.WithToolCalled(msgs),
+			chat.WithTools(more),
+			chat.WithStream(true),
+			chat.WithWriteFunc(w),
+			chat.WithTransform(transform.Chain(cm.cnf.transformers...)),
+			chat.WithCoalesce(cm.cnf.flushEvery, cm.cnf.flushSize),
+			chat.WithRoleSystem(sys...),
+			chat.WithMaxPayload(cm.cnf.maxPayload, cm.payloadReport(ch.ID(), opt.events)),
+		}, extra...)...)
+		if err != nil {
+			err = canceled(ctx, err)
+			trace.fail(err)
+			if !cm.turnExpired(ctx, parent, err, trace, opt.events) && !errors.Is(err, ErrTurnCanceled) {
+				cm.cnf.logg.Error("chat request failed", LogKeyChatID, ch.ID(), LogKeyModel, ch.Model(), LogKeyLatency, time.Since(start), LogKeyError, err)
 			}
+			cm.cnf.middlewares.error(parent, ch.ID(), err)
+			return trace
 		}
+		round = trace.addRound(ch, res, len(more), start)
+		cm.account(id, ch.Model(), res.Turn())
+		cm.cnf.middlewares.response(ctx, ch.ID(), res)
+		cm.cnf.logg.Debug("chat request completed", LogKeyChatID, ch.ID(), LogKeyModel, ch.Model(), LogKeyLatency, time.Since(start))
+	}
+	return trace
+}
+
+// runTools executes the tool calls on a pool of up to toolWorkers goroutines and returns
+// their result messages in the order of the calls. Failed calls are answered with the
+// error, as every call of the assistant message needs a result. Calls to tools withheld
+// by opt are not run. Every call is recorded in round.
+func (cm *ChatsManager) runTools(ctx context.Context, chatid string, calls []*provider.ToolCall, round *TraceRound, opt *TurnOpt) []*provider.Message {
+	msgs := make([]*provider.Message, len(calls))
+	round.ToolCalls = make([]*TraceToolCall, len(calls))
+	next := make(chan int)
+	wg := sync.WaitGroup{}
+	for range min(cm.cnf.toolWorkers, len(calls)) {
+		wg.Go(func() {
+			for i := range next {
+				msgs[i], round.ToolCalls[i] = cm.runTool(ctx, chatid, calls[i], opt)
+			}
+		})
+	}
+	for i := range calls {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return msgs
+}
+
+// runTool executes a single tool call of runTools and returns its result message and trace.
+func (cm *ChatsManager) runTool(ctx context.Context, chatid string, tc *provider.ToolCall, opt *TurnOpt) (*provider.Message, *TraceToolCall) {
+	ctx, span := cm.startTool(ctx, tc)
+	start := time.Now()
+	var msg *provider.Message
+	var err error
+	if !opt.offers(tc.Function.Name) {
+		err = fmt.Errorf("%w [%s]", ErrToolNotOffered, tc.Function.Name)
+	} else if err = cm.cnf.middlewares.toolCall(ctx, chatid, tc); err == nil {
+		err = approve(ctx, chatid, tc)
+	}
+	if err == nil {
+		msg, err = cm.callTool(ctx, tc)
+	}
+	trace := traceToolCall(tc, msg, err, start)
+	endTool(span, err)
+	if cm.cnf.metrics != nil {
+		cm.cnf.metrics.ObserveToolCall(tc.Function.Name, trace.Duration, err)
+	}
+	if err != nil {
+		cm.cnf.logg.Error("tool call failed", LogKeyChatID, chatid, LogKeyTool, tc.Function.Name, LogKeyLatency, time.Since(start), LogKeyError, err)
+		msg = tools.Result(tc, fmt.Sprintf("error: %v", err))
 	}
+	return cm.cnf.middlewares.toolResult(ctx, chatid, tc, msg, err), trace
 }
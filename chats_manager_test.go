@@ -0,0 +1,402 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xyzj/llm/chat"
+	"github.com/xyzj/llm/clock"
+	"github.com/xyzj/llm/provider"
+	"github.com/xyzj/llm/storage"
+
+	"github.com/volcengine/volcengine-go-sdk/service/arkruntime/model"
+)
+
+// testProvider answers every request with "re: " and the text of its last message.
+// Requests whose last message is "wait" report their start to started and wait until
+// hold is closed.
+type testProvider struct {
+	hold    chan struct{} // Closed to release the waiting requests
+	started chan struct{} // Receives the start of the waiting requests
+}
+
+func newTestProvider() *testProvider {
+	return &testProvider{hold: make(chan struct{}), started: make(chan struct{}, 16)}
+}
+
+func (p *testProvider) reply(ctx context.Context, req provider.Request) (string, error) {
+	text := ""
+	if n := len(req.Messages); n > 0 && req.Messages[n-1].Content != nil && req.Messages[n-1].Content.StringValue != nil {
+		text = *req.Messages[n-1].Content.StringValue
+	}
+	if text == "wait" {
+		p.started <- struct{}{}
+		select {
+		case <-p.hold:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	return "re: " + text, nil
+}
+
+func (p *testProvider) CreateCompletion(ctx context.Context, req provider.Request) (provider.Response, error) {
+	var resp provider.Response
+	text, err := p.reply(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	resp.Choices = []*model.ChatCompletionChoice{{
+		Message:      provider.Message{Role: provider.RoleAssistant, Content: &provider.MessageContent{StringValue: &text}},
+		FinishReason: provider.FinishReasonStop,
+	}}
+	return resp, nil
+}
+
+func (p *testProvider) CreateCompletionStream(ctx context.Context, req provider.Request) (provider.Stream, error) {
+	text, err := p.reply(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &testStream{chunks: []provider.StreamResponse{{Choices: []*provider.StreamChoice{{
+		Delta:        provider.StreamDelta{Role: provider.RoleAssistant, Content: text},
+		FinishReason: provider.FinishReasonStop,
+	}}}}}, nil
+}
+
+// testStream streams the chunks.
+type testStream struct {
+	chunks []provider.StreamResponse
+}
+
+func (s *testStream) Recv() (provider.StreamResponse, error) {
+	if len(s.chunks) == 0 {
+		return provider.StreamResponse{}, io.EOF
+	}
+	c := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return c, nil
+}
+
+func (s *testStream) Close() error {
+	return nil
+}
+
+// newTestManager returns a manager answering with p and storing in memory, closed at the
+// end of the test.
+func newTestManager(t *testing.T, p provider.Provider, opts ...Opts) (*ChatsManager, storage.Storage) {
+	st := storage.NewMemoryStorage()
+	cm := NewChatsManager(append([]Opts{WithProvider(p), WithStorage(st), WithPersistEvery(0)}, opts...)...)
+	t.Cleanup(func() { cm.Close(context.Background()) })
+	return cm, st
+}
+
+func discard([]byte) error { return nil }
+
+func TestEvictLRUKeepsRunningTurns(t *testing.T) {
+	p := newTestProvider()
+	cm, st := newTestManager(t, p, WithMaxChats(1))
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		cm.Chat("busy", "wait", discard)
+	})
+	<-p.started
+	// opening another session exceeds WithMaxChats while the busy session is mid-turn
+	if _, err := cm.ChatE(context.Background(), "idle", "hi", discard); err != nil {
+		t.Fatal(err)
+	}
+	if !cm.chats.Has("busy") {
+		t.Error("session with a running turn was evicted")
+	}
+	close(p.hold)
+	wg.Wait()
+	his, err := st.Load(context.Background(), cm.mapID("busy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(his) != 2 {
+		t.Errorf("stored %d messages of the busy session, want 2", len(his))
+	}
+}
+
+func TestPersistDuringTurns(t *testing.T) {
+	cm, st := newTestManager(t, newTestProvider(), WithPersistEvery(time.Hour))
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for _, id := range []string{"a", "b"} {
+		wg.Go(func() {
+			for range 20 {
+				if _, err := cm.ChatE(ctx, id, "hi", discard); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		})
+	}
+	wg.Go(func() {
+		for range 20 {
+			cm.sweeping.Lock()
+			cm.save()
+			cm.sweeping.Unlock()
+			if err := cm.FlushAll(); err != nil {
+				t.Error(err)
+			}
+			cm.History("a")
+			cm.List()
+		}
+	})
+	wg.Wait()
+	if err := cm.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b"} {
+		his, err := st.Load(ctx, cm.lookupID(id))
+		if err != nil || len(his) != 40 {
+			t.Errorf("stored %d messages of %s, %v, want 40", len(his), id, err)
+		}
+	}
+}
+
+func TestSetHistoryReplaces(t *testing.T) {
+	cm, st := newTestManager(t, newTestProvider())
+	ctx := context.Background()
+	if _, err := cm.ChatE(ctx, "a", "hi", discard); err != nil {
+		t.Fatal(err)
+	}
+	text := "replaced"
+	cm.SetHistory("a", []*provider.Message{{Role: provider.RoleUser, Content: &provider.MessageContent{StringValue: &text}}})
+	if his := cm.History("a"); len(his) != 1 || *his[0].Content.StringValue != text {
+		t.Errorf("history has %d messages after SetHistory, want 1", len(his))
+	}
+	his, err := st.Load(ctx, cm.lookupID("a"))
+	if err != nil || len(his) != 1 {
+		t.Errorf("stored %d messages after SetHistory, %v, want 1", len(his), err)
+	}
+}
+
+func TestReadHistoryDoesNotOpen(t *testing.T) {
+	cm, _ := newTestManager(t, newTestProvider())
+	ctx := context.Background()
+	if _, err := cm.ReadHistory(ctx, "unknown"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("ReadHistory of an unknown chat = %v, want ErrNotFound", err)
+	}
+	if _, err := cm.ChatE(ctx, "a", "hi", discard); err != nil {
+		t.Fatal(err)
+	}
+	ch, _ := cm.chats.Load("a")
+	cm.sweeping.Lock()
+	cm.evict("a", ch, EvictExpired)
+	cm.sweeping.Unlock()
+	his, err := cm.ReadHistory(ctx, "a")
+	if err != nil || len(his) != 2 {
+		t.Errorf("ReadHistory = %d messages, %v, want 2", len(his), err)
+	}
+	if cm.chats.Len() != 0 {
+		t.Errorf("ReadHistory opened %d sessions", cm.chats.Len())
+	}
+}
+
+func TestCloneInactiveSource(t *testing.T) {
+	ctx := context.Background()
+	cm, _ := newTestManager(t, newTestProvider(), WithMaxChats(1))
+	prompt := "You are a travel agent."
+	cm.SetSystemPrompt("src", &provider.Message{Role: provider.RoleSystem, Content: &provider.MessageContent{StringValue: &prompt}})
+	cm.SetMetadata("src", "plan", "premium")
+	if _, err := cm.ChatE(ctx, "src", "hi", discard); err != nil {
+		t.Fatal(err)
+	}
+	// opening another session evicts the source
+	if _, err := cm.ChatE(ctx, "other", "hi", discard); err != nil {
+		t.Fatal(err)
+	}
+	if cm.chats.Has("src") {
+		t.Fatal("source session not evicted")
+	}
+	if err := cm.Clone("src", "dst"); err != nil {
+		t.Fatal(err)
+	}
+	if sys := cm.SystemPrompt("dst"); len(sys) != 1 || *sys[0].Content.StringValue != prompt {
+		t.Errorf("cloned system prompt = %v, want %q", sys, prompt)
+	}
+	if v := cm.Metadata("dst")["plan"]; v != "premium" {
+		t.Errorf("cloned metadata = %q, want %q", v, "premium")
+	}
+	if n := len(cm.History("dst")); n != 2 {
+		t.Errorf("cloned history has %d messages, want 2", n)
+	}
+	// evicts the clone, which stays stored
+	if _, err := cm.ChatE(ctx, "other", "hi", discard); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.Clone("other", "dst"); err == nil {
+		t.Error("Clone overwrote the stored session dst")
+	}
+}
+
+// gatedStorage holds the history loads of the wrapped storage until release is closed and
+// reports them to entered.
+type gatedStorage struct {
+	storage.Storage
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *gatedStorage) Load(ctx context.Context, chatid string) ([]*provider.Message, error) {
+	if !strings.HasSuffix(chatid, "_session") {
+		s.entered <- struct{}{}
+		<-s.release
+	}
+	return s.Storage.Load(ctx, chatid)
+}
+
+func TestConcurrentOpenSharesSession(t *testing.T) {
+	st := &gatedStorage{Storage: storage.NewMemoryStorage(), entered: make(chan struct{}, 16), release: make(chan struct{})}
+	cm, _ := newTestManager(t, newTestProvider(), WithStorage(st))
+	ctx := context.Background()
+	opened := make([]*chat.Chat, 8)
+	var wg sync.WaitGroup
+	for i := range opened {
+		wg.Go(func() {
+			opened[i], _, _ = cm.openSession(ctx, "user")
+		})
+	}
+	<-st.entered
+	// give the other callers time to reach the session being restored
+	time.Sleep(20 * time.Millisecond)
+	close(st.release)
+	wg.Wait()
+	for i, ch := range opened {
+		if ch != opened[0] {
+			t.Errorf("caller %d got another session", i)
+		}
+	}
+	if n := len(st.entered); n != 0 {
+		t.Errorf("history loaded %d times, want once", n+1)
+	}
+}
+
+func TestEventsSeparateFromText(t *testing.T) {
+	cm, _ := newTestManager(t, newTestProvider(), WithMaxHistory(10), WithContextWarnings(0.1))
+	var text strings.Builder
+	var events []*Event
+	_, err := cm.ChatE(context.Background(), "a", "hi", func(data []byte) error {
+		text.Write(data)
+		return nil
+	}, WithEventFunc(func(e *Event) error {
+		events = append(events, e)
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := text.String(); got != "re: hi" {
+		t.Errorf("text = %q, want %q", got, "re: hi")
+	}
+	if len(events) != 1 || events[0].Type != EventContextWarning {
+		t.Errorf("events = %v, want one context warning", events)
+	}
+}
+
+func TestConfigureDoesNotChangeClones(t *testing.T) {
+	cm, _ := newTestManager(t, newTestProvider())
+	cm.Configure("src", WithSessionModel("small"))
+	if err := cm.Clone("src", "dst"); err != nil {
+		t.Fatal(err)
+	}
+	cm.Configure("src", WithSessionModel("large"))
+	if so, _ := cm.settings.Load("dst"); so.model != "small" {
+		t.Errorf("clone settings model = %q, want %q", so.model, "small")
+	}
+	if ch, _ := cm.chats.Load("dst"); ch.Model() != "small" {
+		t.Errorf("clone model = %q, want %q", ch.Model(), "small")
+	}
+}
+
+func TestDeleteRemovesHandoffArchives(t *testing.T) {
+	ctx := context.Background()
+	cm, st := newTestManager(t, newTestProvider(), WithSessionBox(0, 1))
+	for _, msg := range []string{"hi", "and now?"} {
+		if _, err := cm.ChatE(ctx, "user", msg, discard); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cm.Metadata("user")[MetaArchives] == "" {
+		t.Fatal("no handoff archived")
+	}
+	if err := cm.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, _ := newTestManager(t, newTestProvider(), WithStorage(st))
+	if err := restarted.Delete(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := st.(storage.Lister).List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("stored after Delete: %v, want none", ids)
+	}
+}
+
+func TestCloseCancelsRunningTurns(t *testing.T) {
+	p := newTestProvider()
+	cm, st := newTestManager(t, p)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := cm.ChatE(context.Background(), "user", "wait", discard)
+		errc <- err
+	}()
+	<-p.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := cm.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close = %v, want context.DeadlineExceeded", err)
+	}
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrTurnCanceled) {
+			t.Errorf("turn error = %v, want ErrTurnCanceled", err)
+		}
+	default:
+		t.Fatal("turn still running after Close")
+	}
+	his, err := st.Load(context.Background(), HashIDMapper.MapID("user"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(his) == 0 || *his[0].Content.StringValue != "wait" {
+		t.Errorf("stored history = %v, want the user message", his)
+	}
+}
+
+func TestJobsUseClock(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	clk := clock.NewManual(start)
+	finished := make(chan struct{})
+	cm, _ := newTestManager(t, newTestProvider(), WithClock(clk), WithJobRetention(time.Minute),
+		WithJobCallback(func(*Job) { close(finished) }))
+	id, err := cm.Submit("user", "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-finished
+	job, ok := cm.Poll(id)
+	if !ok {
+		t.Fatal("job not found")
+	}
+	if !job.Created.Equal(start) || !job.Finished.Equal(start) {
+		t.Errorf("job times = %v, %v, want %v", job.Created, job.Finished, start)
+	}
+	clk.Advance(2 * time.Minute)
+	if _, ok := cm.Poll(id); ok {
+		t.Error("job kept after its retention")
+	}
+}
@@ -0,0 +1,139 @@
+// Package clock abstracts the passing of time for the lifecycle logic of chat sessions,
+// e.g. session expiry, time-boxed conversations and the periodic persistence of histories.
+// Production code uses Real; tests and simulations use a Manual clock and advance it
+// explicitly, so the lifecycle behavior is deterministic.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// Clock tells the current time and creates tickers.
+	Clock interface {
+		// Now returns the current time.
+		Now() time.Time
+		// NewTicker returns a ticker sending the time every d, see time.NewTicker.
+		NewTicker(d time.Duration) Ticker
+	}
+	// Ticker delivers ticks at intervals, see time.Ticker.
+	Ticker interface {
+		// C returns the channel the ticks are sent to.
+		C() <-chan time.Time
+		// Stop turns off the ticker.
+		Stop()
+	}
+)
+
+// Real returns the wall clock.
+func Real() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{t: time.NewTicker(d)}
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (r realTicker) C() <-chan time.Time {
+	return r.t.C
+}
+
+func (r realTicker) Stop() {
+	r.t.Stop()
+}
+
+// Manual is a Clock that only moves when it is advanced. Tickers fire, at most once
+// per Advance like time.Ticker drops ticks for slow receivers, when the clock passes
+// their next tick.
+type Manual struct {
+	locker  sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+}
+
+// NewManual returns a Manual clock set to start.
+func NewManual(start time.Time) *Manual {
+	return &Manual{now: start}
+}
+
+// Now returns the current time of the clock.
+func (m *Manual) Now() time.Time {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	return m.now
+}
+
+// NewTicker returns a ticker firing every d of clock time.
+func (m *Manual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	t := &manualTicker{
+		clock: m,
+		every: d,
+		next:  m.now.Add(d),
+		c:     make(chan time.Time, 1),
+	}
+	m.tickers = append(m.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d and fires the tickers that are due.
+func (m *Manual) Advance(d time.Duration) {
+	m.Set(m.Now().Add(d))
+}
+
+// Set moves the clock to t and fires the tickers that are due.
+// Setting an earlier time does not fire any ticker.
+func (m *Manual) Set(t time.Time) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.now = t
+	for _, tk := range m.tickers {
+		if t.Before(tk.next) {
+			continue
+		}
+		for !t.Before(tk.next) {
+			tk.next = tk.next.Add(tk.every)
+		}
+		select {
+		case tk.c <- t:
+		default:
+		}
+	}
+}
+
+type manualTicker struct {
+	clock *Manual
+	every time.Duration
+	next  time.Time
+	c     chan time.Time
+}
+
+func (t *manualTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *manualTicker) Stop() {
+	t.clock.locker.Lock()
+	defer t.clock.locker.Unlock()
+	for i, tk := range t.clock.tickers {
+		if tk == t {
+			t.clock.tickers = append(t.clock.tickers[:i], t.clock.tickers[i+1:]...)
+			return
+		}
+	}
+}
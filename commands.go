@@ -0,0 +1,136 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/xyzj/llm/storage"
+
+	"github.com/xyzj/toolbox/json"
+)
+
+// Command is a manager action triggered by a slash-command chat message.
+// It receives the external chat identifier and the text following the command name,
+// and returns the reply written to the chat's write callback.
+type Command func(cm *ChatsManager, id, args string) (string, error)
+
+// commands is the slash-command registry of a ChatsManager.
+type commands struct {
+	locker sync.RWMutex
+	cmds   map[string]Command
+}
+
+// RegisterCommand registers a slash-command, e.g. RegisterCommand("/ping", f).
+// Messages starting with a registered command are handled by the command and
+// never reach the model. Registering a nil command removes it.
+func (cm *ChatsManager) RegisterCommand(name string, cmd Command) {
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+	cm.cmds.locker.Lock()
+	defer cm.cmds.locker.Unlock()
+	if cmd == nil {
+		delete(cm.cmds.cmds, name)
+		return
+	}
+	cm.cmds.cmds[name] = cmd
+}
+
+// Commands returns the names of all registered slash-commands in sorted order.
+func (cm *ChatsManager) Commands() []string {
+	cm.cmds.locker.RLock()
+	defer cm.cmds.locker.RUnlock()
+	names := make([]string, 0, len(cm.cmds.cmds))
+	for name := range cm.cmds.cmds {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// runCommand executes the slash-command in message, if any, and writes its reply through w.
+// It reports whether the message was handled as a command.
+func (cm *ChatsManager) runCommand(id, message string, w func(data []byte) error) bool {
+	if !strings.HasPrefix(message, "/") {
+		return false
+	}
+	name, args, _ := strings.Cut(strings.TrimSpace(message), " ")
+	cm.cmds.locker.RLock()
+	cmd, ok := cm.cmds.cmds[name]
+	cm.cmds.locker.RUnlock()
+	if !ok {
+		return false
+	}
+	reply, err := cmd(cm, id, strings.TrimSpace(args))
+	if err != nil {
+		cm.cnf.logg.Error("chat command failed", LogKeyChatID, id, "command", name, LogKeyError, err)
+		reply = fmt.Sprintf("%s failed: %v", name, err)
+	}
+	if reply != "" {
+		if err = w([]byte(reply)); err != nil {
+			cm.cnf.logg.Error("write chat reply failed", LogKeyChatID, id, LogKeyError, err)
+		}
+	}
+	return true
+}
+
+// registerBuiltinCommands registers the builtin slash-commands:
+//   - /help: lists the registered commands
+//   - /reset: clears the chat history, in memory and in storage
+//   - /model [name]: shows or changes the model used by the chat
+//   - /export: returns the chat history as JSON
+func (cm *ChatsManager) registerBuiltinCommands() {
+	cm.RegisterCommand("/help", func(cm *ChatsManager, id, args string) (string, error) {
+		return "available commands: " + strings.Join(cm.Commands(), ", "), nil
+	})
+	cm.RegisterCommand("/reset", func(cm *ChatsManager, id, args string) (string, error) {
+		ctx, cancel := storageContext()
+		defer cancel()
+		// an inactive session is restored first, so its stored state, e.g. the summary of
+		// chat.MetaSummary, is reset as well
+		ch := cm.session(ctx, id)
+		ch.Reset()
+		key := cm.lookupID(id)
+		if cm.pf != nil {
+			cm.pf.drop(key)
+		}
+		if err := cm.storeState(ctx, ch); err != nil {
+			return "", err
+		}
+		cm.awaitWrites(key)
+		if err := cm.cnf.dataStorage.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return "", storageError(err)
+		}
+		return "chat history cleared", nil
+	})
+	cm.RegisterCommand("/model", func(cm *ChatsManager, id, args string) (string, error) {
+		if args == "" {
+			if ch, ok := cm.chats.LoadForUpdate(id); ok {
+				return "current model: " + ch.Model(), nil
+			}
+			if so, ok := cm.settings.Load(id); ok && so.model != "" {
+				return "current model: " + so.model, nil
+			}
+			return "current model: " + cm.cnf.modelName, nil
+		}
+		// kept as a session setting, so the model survives the eviction of the session
+		cm.Configure(id, WithSessionModel(args))
+		return "model changed to " + args, nil
+	})
+	cm.RegisterCommand("/export", func(cm *ChatsManager, id, args string) (string, error) {
+		ctx, cancel := storageContext()
+		defer cancel()
+		his, err := cm.LoadHistory(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		b, err := json.Marshal(his)
+		if err != nil {
+			return "", err
+		}
+		return json.String(b), nil
+	})
+}
@@ -0,0 +1,87 @@
+package llm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/xyzj/llm/chat"
+)
+
+func TestResetInactiveChat(t *testing.T) {
+	ctx := context.Background()
+	cm, st := newTestManager(t, newTestProvider())
+	cm.SetMetadata("user", chat.MetaSummary, "the user asked about flights")
+	if _, err := cm.ChatE(ctx, "user", "hi", discard); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, _ := newTestManager(t, newTestProvider(), WithStorage(st), WithBuiltinCommands())
+	var reply string
+	if _, err := restarted.ChatE(ctx, "user", "/reset", func(b []byte) error { reply = string(b); return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if reply != "chat history cleared" {
+		t.Fatalf("reply = %q", reply)
+	}
+	if err := restarted.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	again, _ := newTestManager(t, newTestProvider(), WithStorage(st))
+	his, err := again.LoadHistory(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(his) != 0 {
+		t.Errorf("history after /reset = %v, want none", his)
+	}
+	if v := again.Metadata("user")[chat.MetaSummary]; v != "" {
+		t.Errorf("summary after /reset = %q, want none", v)
+	}
+}
+
+func TestModelAndExportInactiveChat(t *testing.T) {
+	ctx := context.Background()
+	cm, _ := newTestManager(t, newTestProvider(), WithMaxChats(1), WithBuiltinCommands())
+	var reply string
+	w := func(b []byte) error { reply = string(b); return nil }
+	if _, err := cm.ChatE(ctx, "first", "hi", discard); err != nil {
+		t.Fatal(err)
+	}
+	// opening a second session evicts the first one
+	if _, err := cm.ChatE(ctx, "second", "hello", discard); err != nil {
+		t.Fatal(err)
+	}
+	if cm.chats.Has("first") {
+		t.Fatal("first session not evicted")
+	}
+
+	if _, err := cm.ChatE(ctx, "first", "/export", w); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(reply, "re: hi") {
+		t.Errorf("/export of an evicted chat = %q, want its history", reply)
+	}
+	if _, err := cm.ChatE(ctx, "first", "/model qwen3:32b", w); err != nil {
+		t.Fatal(err)
+	}
+	if reply != "model changed to qwen3:32b" {
+		t.Fatalf("reply = %q", reply)
+	}
+	if _, err := cm.ChatE(ctx, "second", "hello", discard); err != nil {
+		t.Fatal(err)
+	}
+	if cm.chats.Has("first") {
+		t.Fatal("first session not evicted")
+	}
+	if _, err := cm.ChatE(ctx, "first", "/model", w); err != nil {
+		t.Fatal(err)
+	}
+	if reply != "current model: qwen3:32b" {
+		t.Errorf("model after eviction: %q, want qwen3:32b", reply)
+	}
+}
@@ -0,0 +1,226 @@
+package llm
+
+import (
+	"cmp"
+	"encoding/csv"
+	"io"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xyzj/llm/history"
+
+	"github.com/xyzj/toolbox/json"
+)
+
+type (
+	// Cost is the accumulated token usage of model requests and its cost in the currency of
+	// the prices configured with WithPricing.
+	Cost struct {
+		Requests         int     `json:"requests"`           // Number of model requests
+		PromptTokens     int     `json:"prompt_tokens"`      // Prompt tokens reported by the provider
+		CachedTokens     int     `json:"cached_tokens"`      // Prompt tokens served from the provider's prompt cache
+		CompletionTokens int     `json:"completion_tokens"`  // Completion tokens reported by the provider
+		Amount           float64 `json:"amount"`             // Cost of the priced requests
+		Unpriced         int     `json:"unpriced,omitempty"` // Requests to models without a price, not part of Amount
+	}
+	// CostLine is the cost of the requests of one chat session to one model.
+	CostLine struct {
+		Chat  string `json:"chat"`  // Identifier of the chat session as passed to Chat
+		Model string `json:"model"` // Model reported by the provider
+		Cost
+	}
+	// CostReport is the cost of all chat sessions since the manager was created or the
+	// costs were reset, see ChatsManager.CostReport.
+	CostReport struct {
+		Since    time.Time       `json:"since"`              // Start of the reported period
+		Until    time.Time       `json:"until"`              // End of the reported period
+		Currency string          `json:"currency,omitempty"` // Currency of the amounts, see WithCurrency
+		Total    Cost            `json:"total"`              // Cost of all requests
+		Models   map[string]Cost `json:"models"`             // Cost per model
+		Lines    []CostLine      `json:"lines"`              // Cost per chat session and model, ordered by chat and model
+	}
+)
+
+// add adds the usage and cost of a request recorded in turn.
+func (c *Cost) add(turn *history.Turn, priced bool) {
+	c.Requests++
+	c.PromptTokens += turn.PromptTokens
+	c.CachedTokens += turn.CachedTokens
+	c.CompletionTokens += turn.CompletionTokens
+	c.Amount += turn.Cost
+	if !priced {
+		c.Unpriced++
+	}
+}
+
+// merge adds the totals of o.
+func (c *Cost) merge(o Cost) {
+	c.Requests += o.Requests
+	c.PromptTokens += o.PromptTokens
+	c.CachedTokens += o.CachedTokens
+	c.CompletionTokens += o.CompletionTokens
+	c.Amount += o.Amount
+	c.Unpriced += o.Unpriced
+}
+
+// WriteJSON writes the JSON encoding of the report to w.
+func (r *CostReport) WriteJSON(w io.Writer) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// WriteCSV writes the lines of the report to w as CSV with a header row, e.g. for
+// spreadsheets or billing imports. The amounts are written with 6 decimals.
+func (r *CostReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"chat", "model", "requests", "prompt_tokens", "cached_tokens", "completion_tokens", "amount", "currency"})
+	for _, l := range r.Lines {
+		cw.Write([]string{
+			l.Chat,
+			l.Model,
+			strconv.Itoa(l.Requests),
+			strconv.Itoa(l.PromptTokens),
+			strconv.Itoa(l.CachedTokens),
+			strconv.Itoa(l.CompletionTokens),
+			strconv.FormatFloat(l.Amount, 'f', 6, 64),
+			r.Currency,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// costKey identifies the requests of a chat session to a model.
+type costKey struct {
+	chat  string
+	model string
+}
+
+// costTracker accumulates the cost of the model requests per chat session and model.
+type costTracker struct {
+	locker sync.Mutex
+	since  time.Time
+	costs  map[costKey]*Cost
+}
+
+// newCostTracker creates a tracker starting its period at since.
+func newCostTracker(since time.Time) *costTracker {
+	return &costTracker{since: since, costs: make(map[costKey]*Cost)}
+}
+
+// add records a request of chat id, priced reports whether its model has a price.
+func (t *costTracker) add(id string, turn *history.Turn, priced bool) {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	k := costKey{chat: id, model: turn.Model}
+	c, ok := t.costs[k]
+	if !ok {
+		c = &Cost{}
+		t.costs[k] = c
+	}
+	c.add(turn, priced)
+}
+
+// chat returns the cost of all requests of chat id.
+func (t *costTracker) chat(id string) (Cost, bool) {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	total, found := Cost{}, false
+	for k, c := range t.costs {
+		if k.chat == id {
+			total.merge(*c)
+			found = true
+		}
+	}
+	return total, found
+}
+
+// chats returns the cost of all requests per chat.
+func (t *costTracker) chats() map[string]Cost {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	costs := make(map[string]Cost)
+	for k, c := range t.costs {
+		total := costs[k.chat]
+		total.merge(*c)
+		costs[k.chat] = total
+	}
+	return costs
+}
+
+// report returns the report of the period ending at now and starts a new period if reset.
+func (t *costTracker) report(now time.Time, currency string, reset bool) *CostReport {
+	t.locker.Lock()
+	r := &CostReport{
+		Since:    t.since,
+		Until:    now,
+		Currency: currency,
+		Models:   make(map[string]Cost),
+		Lines:    make([]CostLine, 0, len(t.costs)),
+	}
+	for k, c := range t.costs {
+		r.Lines = append(r.Lines, CostLine{Chat: k.chat, Model: k.model, Cost: *c})
+	}
+	if reset {
+		t.since = now
+		t.costs = make(map[costKey]*Cost)
+	}
+	t.locker.Unlock()
+	slices.SortFunc(r.Lines, func(a, b CostLine) int {
+		return cmp.Or(cmp.Compare(a.Chat, b.Chat), cmp.Compare(a.Model, b.Model))
+	})
+	for _, l := range r.Lines {
+		r.Total.merge(l.Cost)
+		m := r.Models[l.Model]
+		m.merge(l.Cost)
+		r.Models[l.Model] = m
+	}
+	return r
+}
+
+// account records the usage and cost of a model request of chat id made with the model
+// requested.
+func (cm *ChatsManager) account(id, requested string, turn *history.Turn) {
+	if turn == nil {
+		return
+	}
+	_, priced := cm.cnf.pricing[turn.Model]
+	if !priced {
+		_, priced = cm.cnf.pricing[requested]
+	}
+	cm.costs.add(id, turn, priced)
+}
+
+// Cost returns the accumulated token usage and cost of the model requests of a chat
+// session since the manager was created or the costs were reset, see ResetCosts. Costs
+// are kept in memory across session expiry and deletion.
+//
+// Parameters:
+//   - id: Identifier of the chat session as passed to Chat
+//
+// Returns:
+//   - Cost: The usage and cost of the session
+//   - bool: false if the session made no model request
+func (cm *ChatsManager) Cost(id string) (Cost, bool) {
+	return cm.costs.chat(id)
+}
+
+// CostReport returns the cost of all chat sessions per session and model since the
+// manager was created or the costs were reset, exportable with CostReport.WriteJSON and
+// CostReport.WriteCSV.
+func (cm *ChatsManager) CostReport() *CostReport {
+	return cm.costs.report(cm.cnf.clock.Now(), cm.cnf.currency, false)
+}
+
+// ResetCosts returns the report of CostReport and starts a new accounting period, e.g.
+// at the end of a billing period. No request is lost or counted twice between the report
+// and the reset.
+func (cm *ChatsManager) ResetCosts() *CostReport {
+	return cm.costs.report(cm.cnf.clock.Now(), cm.cnf.currency, true)
+}
@@ -0,0 +1,137 @@
+package embeddings
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/xyzj/llm/provider"
+
+	"github.com/volcengine/volcengine-go-sdk/service/arkruntime"
+	"github.com/volcengine/volcengine-go-sdk/service/arkruntime/model"
+	"github.com/xyzj/toolbox/json"
+)
+
+// NewArk creates a Backend using the embeddings API of the VolcEngine ARK runtime.
+//
+// Parameters:
+//   - apikey: API key for VolcEngine ARK runtime authentication
+//   - opts: Optional ARK client configuration, e.g. arkruntime.WithBaseUrl
+func NewArk(apikey string, opts ...arkruntime.ConfigOption) Backend {
+	return &ark{cli: arkruntime.NewClientWithApiKey(apikey, opts...)}
+}
+
+// ark adapts *arkruntime.Client to the Backend interface.
+type ark struct {
+	cli *arkruntime.Client // VolcEngine ARK runtime client
+}
+
+func (a *ark) Embed(ctx context.Context, m string, input []string) ([][]float32, error) {
+	resp, err := a.cli.CreateEmbeddings(ctx, model.EmbeddingRequestStrings{
+		Input: input,
+		Model: m,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vectors(resp.Data), nil
+}
+
+type (
+	// OpenAIOpt configures the OpenAI-compatible backend.
+	OpenAIOpt struct {
+		client *http.Client // HTTP client sending the requests
+	}
+	// OpenAIOpts is a function type for configuring the OpenAI-compatible backend.
+	OpenAIOpts func(opt *OpenAIOpt)
+)
+
+// WithHTTPClient sets the HTTP client of the OpenAI-compatible backend, e.g. to
+// configure proxies or TLS.
+func WithHTTPClient(c *http.Client) OpenAIOpts {
+	return func(opt *OpenAIOpt) {
+		if c != nil {
+			opt.client = c
+		}
+	}
+}
+
+// NewOpenAI creates a Backend for OpenAI-compatible embeddings endpoints
+// (POST /v1/embeddings), e.g. OpenAI, Ollama or vLLM.
+//
+// Parameters:
+//   - baseURI: Base URI of the service, e.g. "https://api.openai.com"; "/v1" is appended unless present
+//   - apikey: API key sent as bearer token, empty to send none
+//   - opts: Optional configuration, e.g. WithHTTPClient
+func NewOpenAI(baseURI, apikey string, opts ...OpenAIOpts) Backend {
+	opt := &OpenAIOpt{
+		client: &http.Client{},
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	base := strings.TrimSuffix(baseURI, "/")
+	if !strings.HasSuffix(base, "/v1") {
+		base += "/v1"
+	}
+	return &openai{base: base, apikey: apikey, cnf: opt}
+}
+
+// openai is a Backend speaking the OpenAI embeddings HTTP API.
+type openai struct {
+	base   string     // Base URI including the /v1 prefix
+	apikey string     // API key sent as bearer token
+	cnf    *OpenAIOpt // Configuration options
+}
+
+func (o *openai) Embed(ctx context.Context, m string, input []string) ([][]float32, error) {
+	b, err := json.Marshal(map[string]any{
+		"model": m,
+		"input": input,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, o.base+"/embeddings", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if o.apikey != "" {
+		r.Header.Set("Authorization", "Bearer "+o.apikey)
+	}
+	resp, err := o.cnf.client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, provider.NewStatusError(resp.StatusCode, resp.Header, body)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	res := &model.EmbeddingResponse{}
+	if err := json.Unmarshal(body, res); err != nil {
+		return nil, err
+	}
+	return vectors(res.Data), nil
+}
+
+// vectors returns the vectors of data ordered by their index.
+func vectors(data []model.Embedding) [][]float32 {
+	data = slices.Clone(data)
+	slices.SortStableFunc(data, func(a, b model.Embedding) int {
+		return a.Index - b.Index
+	})
+	vecs := make([][]float32, len(data))
+	for i, d := range data {
+		vecs[i] = d.Embedding
+	}
+	return vecs
+}
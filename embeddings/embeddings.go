@@ -0,0 +1,209 @@
+// Package embeddings converts text to embedding vectors, e.g. for semantic search over
+// documents or chat histories. Embeddings wraps a Backend, the ARK runtime or an
+// OpenAI-compatible service, and adds batching of large inputs, retries of transient
+// failures and client-side rate limiting.
+package embeddings
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/xyzj/llm/provider"
+)
+
+// maxBackoff caps the delay between two attempts, unless the backend requests more.
+const maxBackoff = 30 * time.Second
+
+// Backend creates embeddings for a batch of texts.
+type Backend interface {
+	// Embed returns the embedding vectors of input, in the order of input.
+	//
+	// Parameters:
+	//   - ctx: Context bounding the request
+	//   - model: Embedding model or endpoint id
+	//   - input: Texts to embed
+	//
+	// Returns:
+	//   - [][]float32: One vector per text of input
+	//   - error: Any error of the request, preferably one understood by provider.Retryable
+	Embed(ctx context.Context, model string, input []string) ([][]float32, error)
+}
+
+type (
+	// Opt configures Embeddings.
+	Opt struct {
+		batchSize int           // Maximum number of texts per request
+		attempts  int           // Maximum number of attempts per request, including the first one
+		backoff   time.Duration // Delay before the first retry, doubled for each further retry
+		interval  time.Duration // Minimum time between the starts of two requests, 0 for no limit
+	}
+	// Opts is a function type for configuring Embeddings.
+	Opts func(opt *Opt)
+)
+
+// WithBatchSize sets the maximum number of texts sent in one request, larger inputs are
+// split into several requests. Defaults to 64.
+func WithBatchSize(n int) Opts {
+	return func(opt *Opt) {
+		if n > 0 {
+			opt.batchSize = n
+		}
+	}
+}
+
+// WithRetry retries requests failing with a transient error, see provider.Retryable.
+// The delay before the n-th retry is backoff*2^(n-1) with random jitter, capped at 30s,
+// or the delay requested by a Retry-After header if it is longer. Defaults to 3 attempts
+// with a backoff of 500ms.
+//
+// Parameters:
+//   - maxAttempts: Maximum number of attempts including the first one, values below 2 disable retries
+//   - backoff: Delay before the first retry
+func WithRetry(maxAttempts int, backoff time.Duration) Opts {
+	return func(opt *Opt) {
+		opt.attempts = max(maxAttempts, 1)
+		opt.backoff = max(backoff, 0)
+	}
+}
+
+// WithRateLimit limits the requests sent to the backend to n per period, spread evenly,
+// e.g. WithRateLimit(600, time.Minute) starts a request at most every 100ms. The limit
+// is shared by all callers of the Embeddings. Defaults to no limit.
+func WithRateLimit(n int, period time.Duration) Opts {
+	return func(opt *Opt) {
+		if n > 0 && period > 0 {
+			opt.interval = period / time.Duration(n)
+			return
+		}
+		opt.interval = 0
+	}
+}
+
+// Embeddings creates embedding vectors with a fixed model. It is safe for concurrent use.
+type Embeddings struct {
+	backend Backend
+	model   string
+	cnf     *Opt
+	locker  sync.Mutex
+	next    time.Time // Earliest start of the next request when rate limited
+}
+
+// New creates Embeddings for the given backend and model.
+//
+// Parameters:
+//   - b: Backend serving the requests, e.g. NewArk or NewOpenAI
+//   - model: Embedding model or endpoint id, e.g. "text-embedding-3-small"
+//   - opts: Optional configuration, e.g. WithBatchSize
+//
+// Example:
+//
+//	emb := embeddings.New(embeddings.NewOpenAI("https://api.openai.com", key), "text-embedding-3-small",
+//		embeddings.WithRateLimit(3000, time.Minute))
+//	vecs, err := emb.Embed(ctx, []string{"first text", "second text"})
+func New(b Backend, model string, opts ...Opts) *Embeddings {
+	opt := &Opt{
+		batchSize: 64,
+		attempts:  3,
+		backoff:   500 * time.Millisecond,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	return &Embeddings{backend: b, model: model, cnf: opt}
+}
+
+// Embed returns the embedding vectors of texts, in the order of texts. The texts are sent
+// in batches of the configured size; the first batch failing after all retries fails the
+// call.
+func (e *Embeddings) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vecs := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += e.cnf.batchSize {
+		batch := texts[start:min(start+e.cnf.batchSize, len(texts))]
+		v, err := e.embedBatch(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		if len(v) != len(batch) {
+			return nil, fmt.Errorf("embeddings: got %d vectors for %d texts", len(v), len(batch))
+		}
+		vecs = append(vecs, v...)
+	}
+	return vecs, nil
+}
+
+// EmbedOne returns the embedding vector of a single text, e.g. a search query.
+func (e *Embeddings) EmbedOne(ctx context.Context, text string) ([]float32, error) {
+	vecs, err := e.Embed(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vecs[0], nil
+}
+
+// embedBatch sends one batch, retrying transient failures.
+func (e *Embeddings) embedBatch(ctx context.Context, batch []string) ([][]float32, error) {
+	for retry := 0; ; retry++ {
+		if err := e.wait(ctx); err != nil {
+			return nil, err
+		}
+		vecs, err := e.backend.Embed(ctx, e.model, batch)
+		if err == nil || retry+1 >= e.cnf.attempts {
+			return vecs, err
+		}
+		ok, after := provider.Retryable(err)
+		if !ok {
+			return nil, err
+		}
+		t := time.NewTimer(e.delay(retry+1, after))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, err
+		case <-t.C:
+		}
+	}
+}
+
+// delay returns the wait before the given retry, starting at 1.
+func (e *Embeddings) delay(retry int, after time.Duration) time.Duration {
+	d := e.cnf.backoff
+	for i := 1; i < retry && d < maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, maxBackoff)
+	// jitter between half and the full delay, so clients don't retry in lockstep
+	if d > 1 {
+		d = d/2 + rand.N(d/2+1)
+	}
+	return max(d, after)
+}
+
+// wait blocks until the rate limit allows the next request or ctx is done.
+func (e *Embeddings) wait(ctx context.Context) error {
+	if e.cnf.interval == 0 {
+		return nil
+	}
+	e.locker.Lock()
+	now := time.Now()
+	at := e.next
+	if at.Before(now) {
+		at = now
+	}
+	e.next = at.Add(e.cnf.interval)
+	e.locker.Unlock()
+	d := at.Sub(now)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
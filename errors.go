@@ -0,0 +1,74 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/xyzj/llm/provider"
+)
+
+// The errors of the package wrap the errors they are caused by, so callers branch on the
+// kind of a failure with errors.Is, e.g. errors.Is(err, llm.ErrRateLimited) for the error
+// of ChatE, without matching messages.
+var (
+	// ErrRateLimited is matched by errors of requests the provider rate limited or the
+	// limiter of WithRateLimit rejected.
+	ErrRateLimited = provider.ErrRateLimited
+	// ErrContextLengthExceeded is matched by errors of requests exceeding the context
+	// window of the model.
+	ErrContextLengthExceeded = provider.ErrContextLengthExceeded
+	// ErrAuth is matched by errors of requests with a missing or invalid API key.
+	ErrAuth = provider.ErrAuth
+	// ErrToolNotFound is matched by errors of calls to tools no tool provider or MCP
+	// server offers.
+	ErrToolNotFound = provider.ErrToolNotFound
+	// ErrStorage is matched by errors of the storage backend, e.g. of Delete or
+	// LoadHistory, which also match the error of the backend.
+	ErrStorage = errors.New("chat storage failed")
+	// ErrClosed is returned by Submit after the manager was closed, see Close.
+	ErrClosed = errors.New("chat manager is closed")
+)
+
+var (
+	// ErrRequestFailed is the kind of turns ended by a failed model request, e.g. a
+	// provider error or a request rejected by a middleware.
+	ErrRequestFailed = errors.New("chat request failed")
+	// ErrTurnTimeout is the kind of turns that ran out of time, see WithTurnDeadline, or
+	// whose context expired.
+	ErrTurnTimeout = errors.New("chat turn timed out")
+	// ErrTurnCanceled is the kind of turns aborted with Cancel.
+	ErrTurnCanceled = errors.New("chat turn canceled")
+	// ErrToolCallFailed is the kind of turns in which tool calls failed. The model was
+	// answered with the errors, so the reply of the turn accounts for them.
+	ErrToolCallFailed = errors.New("tool call failed")
+)
+
+// TurnError is the error of a chat turn returned by ChatE. It matches its kind, one of
+// ErrRequestFailed, ErrTurnTimeout, ErrTurnCanceled or ErrToolCallFailed, as well as its
+// cause with errors.Is and errors.As.
+type TurnError struct {
+	ChatID ChatID   // Internal key of the chat
+	Kind   error    // Kind of the failure
+	Tools  []string // Names of the failed tools of ErrToolCallFailed
+	Err    error    // Cause of the failure, the joined errors of the calls of ErrToolCallFailed
+}
+
+func (e *TurnError) Error() string {
+	if len(e.Tools) > 0 {
+		return fmt.Sprintf("%v %v: %v", e.Kind, e.Tools, e.Err)
+	}
+	return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+}
+
+// Unwrap returns the kind and the cause of the error.
+func (e *TurnError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// storageError wraps an error of the storage backend with ErrStorage.
+func storageError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrStorage, err)
+}
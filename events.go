@@ -0,0 +1,179 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/xyzj/llm/chat"
+
+	"github.com/xyzj/toolbox/json"
+)
+
+// EventType identifies the kind of an Event.
+type EventType string
+
+const (
+	// EventContextWarning is emitted when a chat's history usage crosses one of the
+	// thresholds configured with WithContextWarnings.
+	EventContextWarning EventType = "context_warning"
+	// EventPayloadDownscaled is emitted when a request exceeded the payload limit configured
+	// with WithMaxPayload and was downscaled before sending. Data holds the chat.PayloadReport.
+	EventPayloadDownscaled EventType = "payload_downscaled"
+	// EventToolsUnavailable is emitted when all MCP servers are unreachable and the turn
+	// continues without their tools, see WithDegradedMode.
+	EventToolsUnavailable EventType = "tools_unavailable"
+	// EventTurnDeadline is emitted when a turn stopped because its time budget set with
+	// WithTurnDeadline ran out. Data holds the progress made before, the answer streamed
+	// so far may be incomplete.
+	EventTurnDeadline EventType = "turn_deadline"
+	// EventAudio carries the speech of a sentence of the response, see WithTTS. Message
+	// holds the spoken text, Data the "format" (MIME type) and the base64 encoded "audio".
+	EventAudio EventType = "audio"
+	// EventReasoning carries a delta of the reasoning of a thinking model in Message,
+	// written before the answer it leads to, see WithThinking.
+	EventReasoning EventType = "reasoning"
+)
+
+// Event is a structured notification of a chat turn, delivered to the function set with
+// WithEventFunc in between the response chunks streamed to the write callback of
+// ChatsManager.Chat. Events are never written to the write callback, so they cannot be
+// mistaken for assistant content. Bytes returns the JSON encoding to send to clients.
+type Event struct {
+	Type    EventType      `json:"event"`             // Kind of the event
+	ChatID  ChatID         `json:"chat_id"`           // Internal key of the chat the event belongs to
+	Message string         `json:"message,omitempty"` // Human readable description
+	Data    map[string]any `json:"data,omitempty"`    // Event specific payload
+}
+
+// Bytes returns the JSON encoding of the event.
+func (e *Event) Bytes() []byte {
+	b, _ := json.Marshal(e)
+	return b
+}
+
+// emit delivers an event to the event function of the turn, see WithEventFunc.
+// Errors are logged, as events are advisory and must not break the chat turn.
+func (cm *ChatsManager) emit(ev func(e *Event) error, e *Event) {
+	if ev == nil {
+		return
+	}
+	if err := ev(e); err != nil {
+		cm.cnf.logg.Error("emit chat event failed", LogKeyChatID, e.ChatID, "event", e.Type, LogKeyError, err)
+	}
+}
+
+// reasoning returns the request options of the turns of ch switching the reasoning of
+// thinking models as configured, see WithThinking, and streaming it as EventReasoning.
+func (cm *ChatsManager) reasoning(ch *chat.Chat, ev func(e *Event) error) []chat.Opts {
+	opts := []chat.Opts{chat.WithStreamHandler(chat.StreamFuncs{
+		Reasoning: func(text string) error {
+			cm.emit(ev, &Event{Type: EventReasoning, ChatID: ChatID(ch.ID()), Message: text})
+			return nil
+		},
+	})}
+	if cm.cnf.thinking != nil {
+		opts = append(opts, chat.WithThinking(*cm.cnf.thinking))
+	}
+	return opts
+}
+
+// payloadReport returns a function emitting an EventPayloadDownscaled for the given chat.
+func (cm *ChatsManager) payloadReport(id string, ev func(e *Event) error) func(*chat.PayloadReport) {
+	return func(r *chat.PayloadReport) {
+		cm.emit(ev, &Event{
+			Type:    EventPayloadDownscaled,
+			ChatID:  ChatID(id),
+			Message: fmt.Sprintf("request downscaled from %d to %d bytes to fit the limit of %d bytes", r.Before, r.After, r.Limit),
+			Data: map[string]any{
+				"limit":   r.Limit,
+				"before":  r.Before,
+				"after":   r.After,
+				"removed": r.Removed,
+			},
+		})
+	}
+}
+
+// contextUsage returns the fraction of the available context occupied by the chat history.
+// When a context window is configured the usage is measured in estimated tokens,
+// otherwise in messages relative to the max history size.
+func (cm *ChatsManager) contextUsage(ch *chat.Chat) (float64, int, int) {
+	used, limit := len(ch.History()), cm.cnf.maxHistory
+	if cm.cnf.contextWin > 0 {
+		used, limit = ch.Tokens(), cm.cnf.contextWin
+	}
+	if limit <= 0 {
+		return 0, used, limit
+	}
+	return float64(used) / float64(limit), used, limit
+}
+
+// checkContext emits an EventContextWarning the first time the chat's context usage
+// crosses each configured threshold. The warning state is reset once usage falls back
+// below the lowest threshold, e.g. after the history was cleared.
+func (cm *ChatsManager) checkContext(ch *chat.Chat, ev func(e *Event) error) {
+	if len(cm.cnf.contextWarns) == 0 {
+		return
+	}
+	usage, used, limit := cm.contextUsage(ch)
+	warned, _ := cm.warned.Load(ch.ID())
+	if usage < cm.cnf.contextWarns[0] {
+		if warned > 0 {
+			cm.warned.Delete(ch.ID())
+		}
+		return
+	}
+	// find the highest threshold reached
+	idx, _ := slices.BinarySearch(cm.cnf.contextWarns, usage)
+	if idx == len(cm.cnf.contextWarns) || cm.cnf.contextWarns[idx] > usage {
+		idx--
+	}
+	level := cm.cnf.contextWarns[idx]
+	if level <= warned {
+		return
+	}
+	cm.warned.Store(ch.ID(), level)
+	cm.emit(ev, &Event{
+		Type:    EventContextWarning,
+		ChatID:  ChatID(ch.ID()),
+		Message: fmt.Sprintf("chat context is %.0f%% full, consider starting a new chat", usage*100),
+		Data: map[string]any{
+			"threshold": level,
+			"usage":     usage,
+			"used":      used,
+			"limit":     limit,
+		},
+	})
+}
+
+// turnExpired reports whether err ended the turn because the budget of WithTurnDeadline ran
+// out while parent was still live, and emits an EventTurnDeadline with the progress of the
+// turn if so.
+func (cm *ChatsManager) turnExpired(ctx, parent context.Context, err error, trace *RunTrace, ev func(e *Event) error) bool {
+	if !errors.Is(err, context.DeadlineExceeded) || ctx.Err() == nil || parent.Err() != nil {
+		return false
+	}
+	calls := 0
+	for _, r := range trace.Rounds {
+		for _, tc := range r.ToolCalls {
+			if tc != nil && tc.Error == "" {
+				calls++
+			}
+		}
+	}
+	cm.emit(ev, &Event{
+		Type:    EventTurnDeadline,
+		ChatID:  trace.ChatID,
+		Message: fmt.Sprintf("turn stopped after exceeding its time budget of %s, the answer may be incomplete", cm.cnf.turnDeadline),
+		Data: map[string]any{
+			"deadline_ms": cm.cnf.turnDeadline.Milliseconds(),
+			"elapsed_ms":  time.Since(trace.Start).Milliseconds(),
+			"rounds":      len(trace.Rounds),
+			"tool_calls":  calls,
+		},
+	})
+	return true
+}
@@ -0,0 +1,134 @@
+package llm
+
+import (
+	"time"
+
+	"github.com/xyzj/llm/chat"
+)
+
+// EvictReason tells why a chat session was removed from memory, see WithOnChatEvicted.
+type EvictReason string
+
+const (
+	EvictExpired  EvictReason = "expired"  // Inactive for longer than the chat lifetime, see WithChatLifeTime
+	EvictCapacity EvictReason = "capacity" // Least recently active session beyond WithMaxChats
+)
+
+// maintain saves the histories of the active sessions and removes the expired ones at
+// the configured intervals until the manager is closed.
+func (cm *ChatsManager) maintain() {
+	cleanup := cm.cnf.clock.NewTicker(cm.cnf.cleanupEvery)
+	defer cleanup.Stop()
+	var save <-chan time.Time // nil in write-through mode
+	if cm.cnf.persistEvery > 0 {
+		t := cm.cnf.clock.NewTicker(cm.cnf.persistEvery)
+		defer t.Stop()
+		save = t.C()
+	}
+	for {
+		var expire bool
+		select {
+		case <-cm.done:
+			return
+		case <-cleanup.C():
+			expire = true
+		case <-save:
+		}
+		// skip the work if the manager was closed meanwhile, see Close
+		cm.sweeping.Lock()
+		if cm.isClosed() {
+			cm.sweeping.Unlock()
+			return
+		}
+		if expire {
+			cm.expire()
+		} else {
+			cm.save()
+		}
+		cm.sweeping.Unlock()
+	}
+}
+
+// save persists the histories of the active sessions asynchronously. The caller must
+// hold the sweeping lock.
+func (cm *ChatsManager) save() {
+	cm.saveIDMap()
+	// Sessions are not copied, copies would race with running requests
+	for _, key := range cm.chats.Keys() {
+		if ch, ok := cm.chats.LoadForUpdate(key); ok {
+			cm.storeAsync(ch.ID(), ch.History())
+			cm.storeStateAsync(ch)
+		}
+	}
+}
+
+// expire removes the sessions inactive for longer than the chat lifetime. Sessions with
+// running turns are kept. The caller must hold the sweeping lock.
+func (cm *ChatsManager) expire() {
+	for _, key := range cm.chats.Keys() {
+		ch, ok := cm.chats.LoadForUpdate(key)
+		if ok && cm.cnf.clock.Now().Sub(ch.LastMessage()) > cm.cnf.chatLifeTime && !cm.running.active(key) {
+			cm.evict(key, ch, EvictExpired)
+		}
+	}
+}
+
+// evictLRU removes the least recently active sessions other than keep while there are
+// more than the maximum set with WithMaxChats. Sessions with running turns are kept, as
+// their turns store the messages in the session afterwards, so the manager may hold more
+// sessions while all of them are busy.
+func (cm *ChatsManager) evictLRU(keep string) {
+	if cm.cnf.maxChats <= 0 || cm.chats.Len() <= cm.cnf.maxChats {
+		return
+	}
+	cm.sweeping.Lock()
+	defer cm.sweeping.Unlock()
+	for cm.chats.Len() > cm.cnf.maxChats {
+		var lru *chat.Chat
+		var lruKey string
+		var lruActive time.Time
+		for _, key := range cm.chats.Keys() {
+			ch, ok := cm.chats.LoadForUpdate(key)
+			if !ok || key == keep || cm.running.active(key) {
+				continue
+			}
+			// new sessions have no message until their first turn completed
+			active := ch.LastMessage()
+			if started := ch.Started(); started.After(active) {
+				active = started
+			}
+			if lru == nil || active.Before(lruActive) {
+				lru, lruKey, lruActive = ch, key, active
+			}
+		}
+		if lru == nil {
+			return
+		}
+		cm.evict(lruKey, lru, EvictCapacity)
+	}
+}
+
+// evict removes the session ch stored under key from memory after persisting its final
+// history and reporting it to the callbacks. The caller must hold the sweeping lock.
+func (cm *ChatsManager) evict(key string, ch *chat.Chat, reason EvictReason) {
+	// persist the final history, restores of the chat wait for the write
+	his := ch.History()
+	if reason == EvictExpired && cm.cnf.onExpire != nil {
+		cm.cnf.onExpire(key, his)
+	}
+	if cm.cnf.onEvicted != nil {
+		cm.cnf.onEvicted(key, his, reason)
+	}
+	cm.saveIDMap()
+	cm.storeAsync(ch.ID(), his)
+	cm.storeStateAsync(ch)
+	cm.states.Delete(ch.ID())
+	cm.chats.Delete(key)
+	cm.forgetID(key, ch.ID())
+	cm.warned.Delete(ch.ID())
+	cm.traces.Delete(key)
+	if cm.pf != nil {
+		cm.pf.drop(ch.ID())
+	}
+	cm.cnf.logg.Warn("chat removed", LogKeyChatID, ch.ID(), "reason", string(reason))
+}
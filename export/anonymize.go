@@ -0,0 +1,247 @@
+package export
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/xyzj/llm/history"
+	"github.com/xyzj/llm/provider"
+)
+
+// Pseudonym kinds of the built-in PII detectors.
+const (
+	KindEmail = "EMAIL"
+	KindPhone = "PHONE"
+	KindCard  = "CARD"
+	KindIP    = "IP"
+)
+
+type (
+	// detector finds one kind of PII.
+	detector struct {
+		kind string
+		re   *regexp.Regexp
+	}
+	// identifier is a known value, e.g. a user name, replaced wherever it occurs as a whole word.
+	identifier struct {
+		kind  string
+		value string
+	}
+	// AnonOpt configures the Anonymizer created by NewAnonymizer.
+	AnonOpt struct {
+		identifiers []identifier // Known identifiers, replaced after the detectors ran
+		detectors   []detector   // Additional PII patterns
+		noDefaults  bool         // Whether the built-in detectors are disabled
+	}
+	// AnonOpts is a function type for configuring the Anonymizer.
+	AnonOpts func(opt *AnonOpt)
+)
+
+// defaultDetectors are the built-in PII detectors, in the order they are applied.
+var defaultDetectors = []detector{
+	{KindEmail, regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{KindIP, regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`)},
+	{KindCard, regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)},
+	{KindPhone, regexp.MustCompile(`\+?\b\d{1,3}[\s-]?\(?\d{2,4}\)?[\s-]?\d{3,4}[\s-]?\d{4}\b|\b1[3-9]\d{9}\b`)},
+}
+
+// WithIdentifiers replaces the given values, e.g. user names, account or customer IDs,
+// wherever they occur as whole words, with pseudonyms of the given kind, e.g. "USER".
+func WithIdentifiers(kind string, values ...string) AnonOpts {
+	return func(opt *AnonOpt) {
+		for _, v := range values {
+			if v != "" {
+				opt.identifiers = append(opt.identifiers, identifier{kind: kind, value: v})
+			}
+		}
+	}
+}
+
+// WithPattern detects an additional kind of PII, e.g. order numbers, by regular expression.
+// Patterns run after the built-in detectors in the order they were added.
+func WithPattern(kind string, re *regexp.Regexp) AnonOpts {
+	return func(opt *AnonOpt) {
+		opt.detectors = append(opt.detectors, detector{kind: kind, re: re})
+	}
+}
+
+// WithoutDefaultDetectors disables the built-in detectors of e-mail addresses, IP
+// addresses, card numbers and phone numbers, leaving the identifiers and patterns given.
+func WithoutDefaultDetectors() AnonOpts {
+	return func(opt *AnonOpt) {
+		opt.noDefaults = true
+	}
+}
+
+// Anonymizer replaces user identifiers and detected PII in chat histories with stable
+// pseudonyms like <EMAIL_1> or <USER_2>, so conversations can be shared with model vendors
+// or annotators without exposing customer data. The same value gets the same pseudonym
+// across all messages and all transcripts anonymized by one Anonymizer, keeping the
+// conversations readable. It is safe for concurrent use.
+type Anonymizer struct {
+	locker      sync.Mutex
+	identifiers map[string]string // Kinds of the known identifiers by value
+	idPattern   *regexp.Regexp    // Matches any known identifier, nil if there are none
+	detectors   []detector
+	pseudonyms  map[string]string // Pseudonyms by kind and value
+	counts      map[string]int    // Pseudonyms issued per kind
+}
+
+// NewAnonymizer creates an Anonymizer with the built-in PII detectors and the given options.
+//
+// Example:
+//
+//	a := export.NewAnonymizer(export.WithIdentifiers("USER", "alice", "alice_w"))
+func NewAnonymizer(opts ...AnonOpts) *Anonymizer {
+	opt := &AnonOpt{}
+	for _, o := range opts {
+		o(opt)
+	}
+	a := &Anonymizer{
+		identifiers: make(map[string]string),
+		pseudonyms:  make(map[string]string),
+		counts:      make(map[string]int),
+	}
+	if !opt.noDefaults {
+		a.detectors = append(a.detectors, defaultDetectors...)
+	}
+	a.detectors = append(a.detectors, opt.detectors...)
+	if len(opt.identifiers) == 0 {
+		return a
+	}
+	// longer identifiers first, so a value containing another is replaced as a whole
+	slices.SortStableFunc(opt.identifiers, func(x, y identifier) int {
+		return cmp.Compare(len(y.value), len(x.value))
+	})
+	alts := make([]string, 0, len(opt.identifiers))
+	for _, id := range opt.identifiers {
+		a.identifiers[id.value] = id.kind
+		alt := regexp.QuoteMeta(id.value)
+		if wordRune(id.value, 0) {
+			alt = `\b` + alt
+		}
+		if wordRune(id.value, len(id.value)-1) {
+			alt += `\b`
+		}
+		alts = append(alts, alt)
+	}
+	a.idPattern = regexp.MustCompile(strings.Join(alts, "|"))
+	return a
+}
+
+// Pseudonym returns the pseudonym of a value of the given kind, issuing a new one the
+// first time the value is seen.
+func (a *Anonymizer) Pseudonym(kind, value string) string {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	key := kind + "\x00" + value
+	if p, ok := a.pseudonyms[key]; ok {
+		return p
+	}
+	a.counts[kind]++
+	p := fmt.Sprintf("<%s_%d>", kind, a.counts[kind])
+	a.pseudonyms[key] = p
+	return p
+}
+
+// Text returns s with detected PII and all identifiers replaced by their pseudonyms.
+// Detectors run first, so an e-mail address containing a user name is replaced as a whole.
+func (a *Anonymizer) Text(s string) string {
+	for _, d := range a.detectors {
+		s = d.re.ReplaceAllStringFunc(s, func(v string) string {
+			return a.Pseudonym(d.kind, v)
+		})
+	}
+	if a.idPattern != nil {
+		s = a.idPattern.ReplaceAllStringFunc(s, func(v string) string {
+			return a.Pseudonym(a.identifiers[v], v)
+		})
+	}
+	return s
+}
+
+// wordRune reports whether the byte of s at i is an ASCII word character, so the
+// identifier needs a word boundary there.
+func wordRune(s string, i int) bool {
+	c := s[i]
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// Message returns an anonymized copy of msg. Text content, reasoning, the name and tool
+// call arguments are anonymized; image and video parts are replaced by a placeholder.
+func (a *Anonymizer) Message(msg *provider.Message) *provider.Message {
+	if msg == nil {
+		return nil
+	}
+	m := *msg
+	m.Content = a.content(msg.Content)
+	m.ReasoningContent = a.textPtr(msg.ReasoningContent)
+	m.Name = a.textPtr(msg.Name)
+	if msg.FunctionCall != nil {
+		fc := *msg.FunctionCall
+		fc.Arguments = a.Text(fc.Arguments)
+		m.FunctionCall = &fc
+	}
+	if msg.ToolCalls != nil {
+		m.ToolCalls = make([]*provider.ToolCall, 0, len(msg.ToolCalls))
+		for _, tc := range msg.ToolCalls {
+			c := *tc
+			c.Function.Arguments = a.Text(tc.Function.Arguments)
+			m.ToolCalls = append(m.ToolCalls, &c)
+		}
+	}
+	return &m
+}
+
+// Messages returns anonymized copies of msgs.
+func (a *Anonymizer) Messages(msgs []*provider.Message) []*provider.Message {
+	out := make([]*provider.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		out = append(out, a.Message(msg))
+	}
+	return out
+}
+
+// Records returns copies of records with anonymized messages.
+func (a *Anonymizer) Records(records []history.Record) []history.Record {
+	out := make([]history.Record, 0, len(records))
+	for _, r := range records {
+		r.Message = a.Message(r.Message)
+		out = append(out, r)
+	}
+	return out
+}
+
+// content returns an anonymized copy of message content.
+func (a *Anonymizer) content(c *provider.MessageContent) *provider.MessageContent {
+	if c == nil {
+		return nil
+	}
+	out := &provider.MessageContent{StringValue: a.textPtr(c.StringValue)}
+	if c.ListValue != nil {
+		out.ListValue = make([]*provider.ContentPart, 0, len(c.ListValue))
+		for _, p := range c.ListValue {
+			if p.ImageURL != nil || p.VideoURL != nil {
+				out.ListValue = append(out.ListValue, &provider.ContentPart{Type: provider.ContentPartText, Text: "[media removed]"})
+				continue
+			}
+			cp := *p
+			cp.Text = a.Text(p.Text)
+			out.ListValue = append(out.ListValue, &cp)
+		}
+	}
+	return out
+}
+
+// textPtr returns a pointer to the anonymized text of s, or nil if s is nil.
+func (a *Anonymizer) textPtr(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	v := a.Text(*s)
+	return &v
+}
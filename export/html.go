@@ -0,0 +1,56 @@
+// Package export renders chat histories into shareable formats, e.g. standalone
+// HTML transcripts for debugging sessions and compliance reviews.
+package export
+
+import (
+	_ "embed"
+	"html/template"
+	"io"
+	"time"
+
+	"github.com/xyzj/llm/history"
+	"github.com/xyzj/llm/provider"
+)
+
+//go:embed transcript.html
+var transcriptHTML string
+
+var transcript = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"content": func(msg *provider.Message) string {
+		if msg.Content == nil || msg.Content.StringValue == nil {
+			return ""
+		}
+		return *msg.Content.StringValue
+	},
+	"reasoning": func(msg *provider.Message) string {
+		if msg.ReasoningContent == nil {
+			return ""
+		}
+		return *msg.ReasoningContent
+	},
+	"stamp": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format("2006-01-02 15:04:05")
+	},
+}).Parse(transcriptHTML))
+
+// HTML writes a standalone HTML transcript of the given history records to w.
+// User and assistant messages are rendered as chat bubbles with timestamps, while
+// reasoning sections, tool calls and tool results are rendered as collapsible blocks.
+//
+// Parameters:
+//   - w: Destination of the HTML document
+//   - title: Document title, e.g. the chat id
+//   - records: History records in chronological order, see history.History.Records
+//
+// Returns:
+//   - error: Any error encountered while rendering or writing
+func HTML(w io.Writer, title string, records []history.Record) error {
+	return transcript.Execute(w, map[string]any{
+		"Title":    title,
+		"Exported": time.Now(),
+		"Records":  records,
+	})
+}
@@ -0,0 +1,112 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xyzj/llm/chat"
+	"github.com/xyzj/llm/provider"
+	"github.com/xyzj/llm/storage"
+
+	"github.com/volcengine/volcengine-go-sdk/volcengine"
+)
+
+// EventHandoff is emitted when a time-boxed session is closed and a fresh
+// conversation is started from its handoff summary.
+const EventHandoff EventType = "handoff"
+
+// MetaArchives is the metadata key listing the storage keys of the conversations archived
+// by the handoffs of a chat session, one per line, so they are removed with the session,
+// see ChatsManager.Delete.
+const MetaArchives = "archives"
+
+const defaultHandoffPrompt = "Summarize the conversation so far for a colleague who takes over. " +
+	"Include the user's goal, the facts established, what has been tried and what is still open. " +
+	"Answer with the summary only."
+
+// boxExpired reports whether the chat's current conversation exceeded the
+// configured session duration or turn count.
+func (cm *ChatsManager) boxExpired(ch *chat.Chat) bool {
+	if cm.cnf.boxDuration > 0 && cm.cnf.clock.Now().Sub(ch.Started()) >= cm.cnf.boxDuration {
+		return true
+	}
+	return cm.cnf.boxTurns > 0 && ch.Turns() >= cm.cnf.boxTurns
+}
+
+// handoff closes the chat's current conversation: the history is archived in storage under
+// "<chat key>_<unix time>", a handoff summary is generated by the model, and the chat is
+// restarted with the summary as its only message. A summary prefetched for the same history
+// is used instead of generating a new one, see WithPrefetch. An EventHandoff carrying the
+// summary and the archive key is delivered to ev.
+func (cm *ChatsManager) handoff(ctx context.Context, ch *chat.Chat, ev func(e *Event) error) error {
+	his := ch.History()
+	if len(his) == 0 {
+		ch.Reset()
+		return nil
+	}
+	archive := fmt.Sprintf("%s_%d", ch.ID(), cm.cnf.clock.Now().Unix())
+	if err := cm.cnf.dataStorage.Store(ctx, archive, his); err != nil {
+		return err
+	}
+	archives := ch.Metadata()[MetaArchives]
+	if archives != "" {
+		archives += "\n"
+	}
+	ch.SetMetadata(MetaArchives, archives+archive)
+	summary, ok := "", false
+	if cm.pf != nil {
+		summary, ok = cm.pf.summary(ch.ID(), his)
+	}
+	if !ok {
+		var err error
+		if summary, err = cm.summarize(ctx, ch, his); err != nil {
+			return err
+		}
+	}
+	ch.Reset()
+	ch.SetHistory([]*provider.Message{{
+		Role: provider.RoleSystem,
+		Content: &provider.MessageContent{
+			StringValue: volcengine.String("Summary of the previous conversation:\n" + summary),
+		},
+	}})
+	cm.emit(ev, &Event{
+		Type:    EventHandoff,
+		ChatID:  ChatID(ch.ID()),
+		Message: summary,
+		Data: map[string]any{
+			"archive":  archive,
+			"messages": len(his),
+		},
+	})
+	return nil
+}
+
+// summarize generates the handoff summary of the given history in a scratch chat,
+// so the prompt does not end up in the session history.
+func (cm *ChatsManager) summarize(ctx context.Context, ch *chat.Chat, his []*provider.Message) (string, error) {
+	tmp := cm.newChat(ch.ID(), ch.Model(), chat.WithMaxHistory(len(his)+2))
+	tmp.SetHistory(his)
+	res, err := tmp.ChatContext(ctx, cm.cnf.handoffPrompt, chat.WithStream(false))
+	if err != nil {
+		return "", err
+	}
+	return res.Content(), nil
+}
+
+// deleteArchives removes the conversations archived by the handoffs of a session, listed
+// by its metadata meta, see MetaArchives.
+func (cm *ChatsManager) deleteArchives(ctx context.Context, meta map[string]string) error {
+	if meta[MetaArchives] == "" {
+		return nil
+	}
+	var errs []error
+	for _, archive := range strings.Split(meta[MetaArchives], "\n") {
+		if err := cm.cnf.dataStorage.Delete(ctx, archive); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			errs = append(errs, storageError(err))
+		}
+	}
+	return errors.Join(errs...)
+}
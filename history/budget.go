@@ -0,0 +1,85 @@
+package history
+
+import (
+	"container/ring"
+	"unicode/utf8"
+
+	"github.com/xyzj/llm/provider"
+)
+
+// truncatedMark is appended to message text shortened to fit the token budget.
+const truncatedMark = "\n[truncated]"
+
+// trim drops the oldest messages until the stored messages fit the token budget,
+// see WithTokenBudget, and returns evicted with the dropped messages appended.
+// Pinned messages are never dropped, the other messages share the rest of the budget.
+// The caller must hold the write lock.
+func (u *History) trim(evicted []*provider.Message) []*provider.Message {
+	if u.budget <= 0 {
+		return evicted
+	}
+	budget := u.budget
+	for _, e := range u.pinned {
+		budget -= e.Tokens(u.tokenizer)
+	}
+	// the newest message is kept even if the pinned messages take the whole budget
+	budget = max(budget, 1)
+	// stored entries from the oldest to the newest
+	slots := make([]*ring.Ring, 0, u.data.Len())
+	total := 0
+	r := u.data
+	for range u.data.Len() {
+		if r.Value != nil {
+			slots = append(slots, r)
+			total += r.Value.(*entry).Tokens(u.tokenizer)
+		}
+		r = r.Next()
+	}
+	if total <= budget || len(slots) == 0 {
+		return evicted
+	}
+	i := 0
+	drop := func() {
+		e := slots[i].Value.(*entry)
+		total -= e.Tokens(u.tokenizer)
+		if u.onEvict != nil {
+			evicted = append(evicted, e.msg)
+		}
+		slots[i].Value = nil
+		i++
+	}
+	for total > budget && i < len(slots)-1 {
+		drop()
+	}
+	// tool results must follow the assistant message calling the tool
+	for i < len(slots)-1 && slots[i].Value.(*entry).msg.Role == provider.RoleTool {
+		drop()
+	}
+	if total > budget {
+		u.truncate(slots[i].Value.(*entry), budget)
+	}
+	return evicted
+}
+
+// truncate shortens the text of the entry's message until it fits the budget.
+// The message is copied, so messages shared with the caller are not changed.
+func (u *History) truncate(e *entry, budget int) {
+	if e.msg.Content == nil || e.msg.Content.StringValue == nil {
+		return
+	}
+	text := *e.msg.Content.StringValue
+	runes := utf8.RuneCountInString(text)
+	msg := *e.msg
+	for range 16 {
+		tokens := u.tokenizer(&msg)
+		if tokens <= budget || runes == 0 {
+			break
+		}
+		// shrink in proportion to the excess, at least by one rune
+		runes = min(runes*budget/tokens, runes-1)
+		s := string([]rune(text)[:max(runes, 0)]) + truncatedMark
+		msg.Content = &provider.MessageContent{StringValue: &s}
+	}
+	e.msg = &msg
+	e.tokens = -1
+}
@@ -5,23 +5,81 @@ package history
 
 import (
 	"container/ring"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/xyzj/llm/provider"
 
-	"github.com/volcengine/volcengine-go-sdk/service/arkruntime/model"
 	"github.com/xyzj/toolbox/json"
 )
 
+// Tokenizer returns the number of tokens of a message, e.g. computed with the tokenizer
+// of the model. EstimateTokens is used when none is set.
+type Tokenizer func(msg *provider.Message) int
+
+type (
+	// Opt contains configuration options for creating a History.
+	Opt struct {
+		budget    int                            // Maximum number of tokens of all stored messages, 0 for no limit
+		tokenizer Tokenizer                      // Token counter of the messages
+		onEvict   func(msgs []*provider.Message) // Called with the messages dropped from the history
+	}
+	// Opts is a function type for configuring a History.
+	Opts func(opt *Opt)
+)
+
+// WithTokenBudget limits the stored messages to n tokens in addition to the message
+// count, as a count alone can still exceed the context window of the model. Whenever a
+// message is stored, the oldest messages are dropped until the history fits, together
+// with tool results whose calling assistant message was dropped; the newest message is
+// always kept and its text truncated if it exceeds the budget on its own. Leave room for
+// the system prompt and the tool definitions when choosing n.
+//
+// Parameters:
+//   - n: Token budget of the history, 0 or less for no limit
+//   - tokenizer: Token counter, nil for EstimateTokens
+func WithTokenBudget(n int, tokenizer Tokenizer) Opts {
+	return func(opt *Opt) {
+		opt.budget = max(n, 0)
+		if tokenizer != nil {
+			opt.tokenizer = tokenizer
+		}
+	}
+}
+
+// WithEvictFunc sets a function called with the messages the history drops, i.e. the
+// oldest messages overwritten when the buffer is full or dropped to fit the token budget,
+// e.g. to condense them into a summary. f is called while the history is locked, so it
+// must not access the history and should return quickly.
+func WithEvictFunc(f func(msgs []*provider.Message)) Opts {
+	return func(opt *Opt) {
+		opt.onEvict = f
+	}
+}
+
 // New creates a new History instance with the specified context size.
 // The context size determines how many messages can be stored in the circular buffer.
 // When the buffer is full, new messages will overwrite the oldest messages.
 //
 // Parameters:
 //   - context: Maximum number of messages to store in the history buffer
+//   - opts: Optional configuration, e.g. WithTokenBudget
 //
 // Returns a new History instance ready for use.
-func New(context int) *History {
+func New(context int, opts ...Opts) *History {
+	opt := &Opt{
+		tokenizer: EstimateTokens,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
 	return &History{
 		data:       ring.New(context),
 		maxContext: context * 2,
+		budget:     opt.budget,
+		tokenizer:  opt.tokenizer,
+		onEvict:    opt.onEvict,
 	}
 }
 
@@ -32,11 +90,68 @@ func New(context int) *History {
 // The History struct ensures:
 //   - Fixed memory footprint regardless of conversation length
 //   - Preservation of most recent messages when capacity is exceeded
+//   - Optionally a token limit of the stored messages, see WithTokenBudget
+//   - Pinned messages that are never evicted, see Pin
 //   - Thread-safe operations for concurrent access patterns
 //   - JSON serialization support for persistence
 type History struct {
-	data       *ring.Ring // Circular buffer storing the messages
-	maxContext int        // Maximum context size (currently unused, kept for future use)
+	locker     sync.RWMutex                   // Guards data, readers don't block each other
+	data       *ring.Ring                     // Circular buffer storing the messages as *entry values
+	pinned     []*entry                       // Pinned messages in the order they were pinned
+	maxContext int                            // Maximum context size (currently unused, kept for future use)
+	budget     int                            // Token budget of the stored messages, 0 for no limit
+	tokenizer  Tokenizer                      // Token counter of the messages
+	onEvict    func(msgs []*provider.Message) // Called with the dropped messages, may be nil
+}
+
+// Record is a stored message together with the time it was added to the history.
+// Times and turn annotations live in memory only: persisted histories store the messages
+// alone, so restored messages carry the time they were restored and no annotations.
+type Record struct {
+	Message *provider.Message `json:"message"`        // The stored message
+	Time    time.Time         `json:"time"`           // Time the message was stored
+	Turn    *Turn             `json:"turn,omitempty"` // Request annotations of assistant messages
+}
+
+// Turn annotates an assistant message with the model request that generated it,
+// so spend and latency can be attributed to individual turns. Annotations are not
+// persisted with the history, see Record.
+type Turn struct {
+	Model            string        `json:"model"`                   // Model that generated the message
+	Latency          time.Duration `json:"latency"`                 // Duration of the complete request
+	FirstToken       time.Duration `json:"first_token,omitempty"`   // Time to the first streamed content, 0 if not streamed
+	PromptTokens     int           `json:"prompt_tokens"`           // Prompt tokens reported by the provider
+	CachedTokens     int           `json:"cached_tokens,omitempty"` // Prompt tokens served from the provider's prompt cache
+	CompletionTokens int           `json:"completion_tokens"`       // Completion tokens reported by the provider
+	Cost             float64       `json:"cost"`                    // Cost of the request, 0 if the model has no price
+}
+
+// entry is a stored message together with its cached token count.
+type entry struct {
+	msg    *provider.Message // The stored message
+	at     time.Time         // Time the message was stored
+	turn   *Turn             // Request annotations, nil for non-generated messages
+	src    *string           // Content the token count was computed for
+	size   int               // Content length the token count was computed for
+	tokens int               // Cached token count, valid while src and size match
+}
+
+// Tokens returns the token count of the message computed by tk. The count is cached and
+// only recomputed when the message content was replaced or changed in length.
+func (e *entry) Tokens(tk Tokenizer) int {
+	var src *string
+	if e.msg.Content != nil {
+		src = e.msg.Content.StringValue
+	}
+	size := 0
+	if src != nil {
+		size = len(*src)
+	}
+	if e.tokens < 0 || src != e.src || size != e.size {
+		e.tokens = tk(e.msg)
+		e.src, e.size = src, size
+	}
+	return e.tokens
 }
 
 // Store adds a single message to the history buffer.
@@ -47,58 +162,285 @@ type History struct {
 //   - msg: The chat completion message to store
 //
 // Returns true to indicate successful storage.
-func (u *History) Store(msg *model.ChatCompletionMessage) bool {
-	u.data.Value = msg
-	u.data = u.data.Next()
+func (u *History) Store(msg *provider.Message) bool {
+	u.locker.Lock()
+	defer u.locker.Unlock()
+	evicted := u.put(&entry{msg: msg, at: time.Now(), tokens: -1}, nil)
+	u.evict(u.trim(evicted))
 	return true
 }
 
+// StoreTurn adds an assistant message to the history buffer together with the
+// annotations of the request that generated it. See Store for the overflow behavior.
+//
+// Parameters:
+//   - msg: The assistant message to store
+//   - turn: Request annotations, may be nil
+func (u *History) StoreTurn(msg *provider.Message, turn *Turn) {
+	u.locker.Lock()
+	defer u.locker.Unlock()
+	evicted := u.put(&entry{msg: msg, at: time.Now(), turn: turn, tokens: -1}, nil)
+	u.evict(u.trim(evicted))
+}
+
 // StoreMany adds multiple messages to the history buffer in sequence.
 // Each message is stored using the same overflow behavior as Store().
 // This is more efficient than calling Store() multiple times.
 //
 // Parameters:
 //   - msgs: Variable number of chat completion messages to store
-func (u *History) StoreMany(msgs ...*model.ChatCompletionMessage) {
+func (u *History) StoreMany(msgs ...*provider.Message) {
+	u.locker.Lock()
+	defer u.locker.Unlock()
+	now := time.Now()
+	var evicted []*provider.Message
 	for _, msg := range msgs {
-		u.data.Value = msg
-		u.data = u.data.Next()
+		evicted = u.put(&entry{msg: msg, at: now, tokens: -1}, evicted)
+	}
+	u.evict(u.trim(evicted))
+}
+
+// put writes e to the next slot of the buffer and appends the message it overwrote
+// to evicted. The caller must hold the write lock.
+func (u *History) put(e *entry, evicted []*provider.Message) []*provider.Message {
+	if old, ok := u.data.Value.(*entry); ok && u.onEvict != nil {
+		evicted = append(evicted, old.msg)
+	}
+	u.data.Value = e
+	u.data = u.data.Next()
+	return evicted
+}
+
+// evict passes dropped messages to the function set with WithEvictFunc.
+func (u *History) evict(msgs []*provider.Message) {
+	if len(msgs) > 0 && u.onEvict != nil {
+		u.onEvict(msgs)
+	}
+}
+
+// Pin adds a message that is never evicted, e.g. a persona, constraints or key facts
+// the conversation depends on. Pinned messages are kept outside the circular buffer and
+// returned before all other messages by Slice and Records, independent of the rolling
+// window. They count against the token budget, see WithTokenBudget, leaving less room
+// for the other messages.
+//
+// Pinned messages are stored like any other message when the history is persisted, and
+// restored as ordinary messages; pin them again after restoring a history if needed, as
+// the sessions of llm.ChatsManager do.
+//
+// Parameters:
+//   - msg: The message to pin
+func (u *History) Pin(msg *provider.Message) {
+	u.locker.Lock()
+	defer u.locker.Unlock()
+	u.pinned = append(u.pinned, &entry{msg: msg, at: time.Now(), tokens: -1})
+	u.evict(u.trim(nil))
+}
+
+// Unpin removes a message pinned with Pin, compared by identity. It does not add the
+// message to the rolling window.
+//
+// Returns:
+//   - bool: Whether msg was pinned
+func (u *History) Unpin(msg *provider.Message) bool {
+	u.locker.Lock()
+	defer u.locker.Unlock()
+	for i, e := range u.pinned {
+		if e.msg == msg {
+			u.pinned = slices.Delete(u.pinned, i, i+1)
+			return true
+		}
+	}
+	return false
+}
+
+// Pinned returns the pinned messages in the order they were pinned.
+func (u *History) Pinned() []*provider.Message {
+	u.locker.RLock()
+	defer u.locker.RUnlock()
+	return u.pinnedMessages()
+}
+
+// pinnedMessages returns the pinned messages. The caller must hold the lock.
+func (u *History) pinnedMessages() []*provider.Message {
+	x := make([]*provider.Message, 0, len(u.pinned))
+	for _, e := range u.pinned {
+		x = append(x, e.msg)
 	}
+	return x
 }
 
 // Clear removes all messages from the history buffer by setting all
 // ring elements to nil. The buffer structure remains intact and ready for new messages.
+// Pinned messages are kept, see Unpin. Cleared messages are not passed to the
+// function set with WithEvictFunc.
 func (u *History) Clear() {
+	u.locker.Lock()
+	defer u.locker.Unlock()
+	r := u.data
+	for range r.Len() {
+		r.Value = nil
+		r = r.Next()
+	}
+}
+
+// Cap returns the capacity of the history buffer, i.e. the maximum number of messages
+// kept besides the pinned messages.
+func (u *History) Cap() int {
+	u.locker.RLock()
+	defer u.locker.RUnlock()
+	return u.data.Len()
+}
+
+// DropLast removes the n most recent messages of the rolling window, e.g. to answer a
+// message again, and returns them in chronological order. Pinned messages are kept. The
+// removed messages are not passed to the function set with WithEvictFunc.
+func (u *History) DropLast(n int) []*provider.Message {
+	u.locker.Lock()
+	defer u.locker.Unlock()
+	kept := make([]*entry, 0, u.data.Len())
+	u.data.Do(func(a any) {
+		if a != nil {
+			kept = append(kept, a.(*entry))
+		}
+	})
+	n = min(max(n, 0), len(kept))
+	dropped := make([]*provider.Message, 0, n)
+	for _, e := range kept[len(kept)-n:] {
+		dropped = append(dropped, e.msg)
+	}
+	u.data = ring.New(u.data.Len())
+	for _, e := range kept[:len(kept)-n] {
+		u.put(e, nil)
+	}
+	return dropped
+}
+
+// Resize changes the capacity of the history buffer to n messages, keeping the most
+// recent messages with their annotations. Messages that no longer fit are passed to the
+// function set with WithEvictFunc. It has no effect if n is not positive.
+func (u *History) Resize(n int) {
+	if n <= 0 {
+		return
+	}
+	u.locker.Lock()
+	defer u.locker.Unlock()
+	kept := make([]*entry, 0, u.data.Len())
+	u.data.Do(func(a any) {
+		if a != nil {
+			kept = append(kept, a.(*entry))
+		}
+	})
+	var evicted []*provider.Message
+	if len(kept) > n {
+		for _, e := range kept[:len(kept)-n] {
+			evicted = append(evicted, e.msg)
+		}
+		kept = kept[len(kept)-n:]
+	}
+	u.data = ring.New(n)
+	u.maxContext = n * 2
+	for _, e := range kept {
+		u.put(e, nil)
+	}
+	u.evict(evicted)
+}
+
+// Count returns the number of stored messages including the pinned messages, which is
+// the length of Slice and of the array written by MarshalJSON.
+func (u *History) Count() int {
+	u.locker.RLock()
+	defer u.locker.RUnlock()
+	n := len(u.pinned)
 	u.data.Do(func(a any) {
-		u.data.Value = nil
+		if a != nil {
+			n++
+		}
 	})
+	return n
 }
 
 // Len returns the capacity of the history buffer (not the number of stored messages).
-// This represents the maximum number of messages that can be stored.
+//
+// Deprecated: Use Cap for the capacity or Count for the number of stored messages.
 func (u *History) Len() int {
-	return u.data.Len()
+	return u.Cap()
 }
 
 // Slice returns all non-nil messages from the history buffer as a slice.
-// Messages are returned in the order they were stored, with nil entries filtered out.
-// This is the primary method for retrieving the conversation history.
+// Messages are returned in the order they were stored, with nil entries filtered out,
+// after the pinned messages. This is the primary method for retrieving the conversation history.
 //
 // Returns:
-//   - []*model.ChatCompletionMessage: Slice of stored messages in chronological order
-func (u *History) Slice() []*model.ChatCompletionMessage {
-	x := make([]*model.ChatCompletionMessage, 0, u.data.Len())
+//   - []*provider.Message: Slice of stored messages in chronological order
+func (u *History) Slice() []*provider.Message {
+	pinned, recent := u.Parts()
+	return append(pinned, recent...)
+}
+
+// Parts returns the pinned messages and the messages of the rolling window separately,
+// taken at the same time, e.g. to shorten the window without dropping pinned messages.
+//
+// Returns:
+//   - pinned: Pinned messages in the order they were pinned
+//   - recent: Other stored messages in chronological order
+func (u *History) Parts() (pinned, recent []*provider.Message) {
+	u.locker.RLock()
+	defer u.locker.RUnlock()
+	pinned = u.pinnedMessages()
+	recent = make([]*provider.Message, 0, u.data.Len())
+	u.data.Do(func(a any) {
+		if a == nil {
+			return
+		}
+		recent = append(recent, a.(*entry).msg)
+	})
+	return pinned, recent
+}
+
+// Records returns all stored messages with the time they were stored and the request
+// annotations of generated assistant messages, in chronological order after the pinned
+// messages. Messages restored in bulk, e.g. from storage, carry the time they were restored.
+func (u *History) Records() []Record {
+	u.locker.RLock()
+	defer u.locker.RUnlock()
+	x := make([]Record, 0, len(u.pinned)+u.data.Len())
+	for _, e := range u.pinned {
+		x = append(x, Record{Message: e.msg, Time: e.at, Turn: e.turn})
+	}
 	u.data.Do(func(a any) {
 		if a == nil {
 			return
 		}
-		x = append(x, a.(*model.ChatCompletionMessage))
+		e := a.(*entry)
+		x = append(x, Record{Message: e.msg, Time: e.at, Turn: e.turn})
 	})
 	return x
 }
 
+// Tokens returns the number of tokens occupied by all stored messages, estimated with
+// EstimateTokens unless a tokenizer was set with WithTokenBudget.
+// Per-message counts are cached, so repeated calls only tokenize new or edited messages.
+func (u *History) Tokens() int {
+	// counting updates the cached counts of the entries
+	u.locker.Lock()
+	defer u.locker.Unlock()
+	n := 0
+	for _, e := range u.pinned {
+		n += e.Tokens(u.tokenizer)
+	}
+	u.data.Do(func(a any) {
+		if a == nil {
+			return
+		}
+		n += a.(*entry).Tokens(u.tokenizer)
+	})
+	return n
+}
+
 // MarshalJSON implements the json.Marshaler interface for the History type.
-// It serializes the history as a JSON array of chat completion messages.
+// It serializes the history as a JSON array of chat completion messages, the pinned
+// messages first, like Slice.
 //
 // Returns:
 //   - []byte: JSON representation of the message history
@@ -121,7 +463,9 @@ func (u *History) ToJSON() string {
 }
 
 // FromJSON populates the history from a JSON string representation.
-// The existing history is cleared before loading the new messages.
+// The existing history is cleared before loading the new messages, see Clear; the
+// pinned messages are kept, so load histories written by MarshalJSON into a history
+// without pinned messages to get the same messages back.
 //
 // Parameters:
 //   - s: JSON string containing an array of chat completion messages
@@ -129,11 +473,33 @@ func (u *History) ToJSON() string {
 // Returns:
 //   - error: Any error encountered during unmarshaling or invalid JSON format
 func (u *History) FromJSON(s string) error {
-	a := make([]*model.ChatCompletionMessage, 0)
+	a := make([]*provider.Message, 0)
 	err := json.Unmarshal(json.Bytes(s), &a)
 	if err != nil {
 		return err
 	}
+	u.Clear()
 	u.StoreMany(a...)
 	return nil
 }
+
+// CloneMessages returns a deep copy of the given messages, so the copy can be
+// stored and modified independently of the original history.
+//
+// Parameters:
+//   - msgs: Messages to copy
+//
+// Returns:
+//   - []*provider.Message: Copied messages in the same order
+//   - error: Any error encountered while copying the message contents
+func CloneMessages(msgs []*provider.Message) ([]*provider.Message, error) {
+	b, err := json.Marshal(msgs)
+	if err != nil {
+		return nil, err
+	}
+	x := make([]*provider.Message, 0, len(msgs))
+	if err = json.Unmarshal(b, &x); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
@@ -0,0 +1,82 @@
+package history
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/xyzj/llm/provider"
+)
+
+func textMessage(text string) *provider.Message {
+	return &provider.Message{Role: provider.RoleUser, Content: &provider.MessageContent{StringValue: &text}}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	evicted := 0
+	var mu sync.Mutex
+	h := New(8, WithTokenBudget(200, nil), WithEvictFunc(func(msgs []*provider.Message) {
+		mu.Lock()
+		evicted += len(msgs)
+		mu.Unlock()
+	}))
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			for range 200 {
+				h.Store(textMessage("a message of the conversation"))
+				h.StoreMany(textMessage("first"), textMessage("second"))
+			}
+		})
+	}
+	wg.Go(func() {
+		for range 200 {
+			for _, msg := range h.Slice() {
+				if msg == nil {
+					t.Error("Slice returned a nil message")
+					return
+				}
+			}
+			h.Records()
+			h.Tokens()
+			h.Count()
+		}
+	})
+	wg.Go(func() {
+		for i := range 50 {
+			if i%10 == 0 {
+				h.Clear()
+			}
+			h.Pin(textMessage("pinned"))
+			h.ToJSON()
+		}
+	})
+	wg.Go(func() {
+		for range 20 {
+			h.Resize(4)
+			h.Resize(8)
+			h.DropLast(1)
+		}
+	})
+	wg.Wait()
+	if n := h.Count(); n > 8+len(h.Pinned()) {
+		t.Errorf("history holds %d messages, more than its capacity", n)
+	}
+}
+
+func TestStoreAndClear(t *testing.T) {
+	h := New(3)
+	for _, text := range []string{"one", "two", "three", "four"} {
+		h.Store(textMessage(text))
+	}
+	msgs := h.Slice()
+	if len(msgs) != 3 || *msgs[0].Content.StringValue != "two" || *msgs[2].Content.StringValue != "four" {
+		t.Fatalf("history = %v, want the last three messages", msgs)
+	}
+	h.Clear()
+	if n := len(h.Slice()); n != 0 {
+		t.Errorf("history holds %d messages after Clear, want none", n)
+	}
+	if c := h.Cap(); c != 3 {
+		t.Errorf("capacity after Clear = %d, want 3", c)
+	}
+}
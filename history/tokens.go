@@ -0,0 +1,48 @@
+package history
+
+import (
+	"unicode/utf8"
+
+	"github.com/xyzj/llm/provider"
+)
+
+// EstimateTokens returns a rough token count for a single message.
+// Latin text is counted as about four bytes per token, while mostly multi-byte
+// text (e.g. CJK) is counted as one token per rune. A small per-message overhead
+// covers role and framing. This is close enough for budget warnings without
+// depending on a model specific tokenizer.
+//
+// Parameters:
+//   - msg: The chat completion message to measure
+//
+// Returns the estimated number of tokens, 0 for nil messages.
+func EstimateTokens(msg *provider.Message) int {
+	if msg == nil {
+		return 0
+	}
+	var size, runes int
+	count := func(s string) {
+		size += len(s)
+		runes += utf8.RuneCountInString(s)
+	}
+	if msg.Content != nil && msg.Content.StringValue != nil {
+		count(*msg.Content.StringValue)
+	}
+	for _, tc := range msg.ToolCalls {
+		count(tc.Function.Name)
+		count(tc.Function.Arguments)
+	}
+	if runes*2 < size {
+		return runes + 4
+	}
+	return size/4 + 4
+}
+
+// EstimateTokensMany returns the sum of EstimateTokens for all messages.
+func EstimateTokensMany(msgs ...*provider.Message) int {
+	n := 0
+	for _, msg := range msgs {
+		n += EstimateTokens(msg)
+	}
+	return n
+}
@@ -0,0 +1,210 @@
+package llm
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/xyzj/toolbox"
+	"github.com/xyzj/toolbox/crypto"
+	"github.com/xyzj/toolbox/json"
+)
+
+// ChatID is the internal key of a chat session, derived from the identifier passed to
+// Chat by the IDMapper. Histories are stored under it, and events, traces and errors
+// report it, see ExternalID. Active sessions are held by the identifier passed to Chat.
+type ChatID string
+
+// IDMapper derives the internal chat key from an external identifier supplied by the caller.
+// The internal key is the storage key of the persisted chat history, see ChatID.
+type IDMapper interface {
+	// MapID returns the internal key for the given external identifier.
+	MapID(external string) string
+}
+
+// IDMapperFunc is an adapter that allows ordinary functions to be used as IDMapper.
+type IDMapperFunc func(external string) string
+
+// MapID calls f(external).
+func (f IDMapperFunc) MapID(external string) string {
+	return f(external)
+}
+
+var (
+	// HashIDMapper derives internal keys as the SHA1 hash of the external identifier.
+	// This is the default mapper and keeps the key format of earlier releases.
+	HashIDMapper IDMapper = IDMapperFunc(crypto.GetSHA1)
+
+	// PassthroughIDMapper uses the external identifier unchanged as the internal key.
+	// Useful when the caller already owns stable, storage-safe identifiers.
+	PassthroughIDMapper IDMapper = IDMapperFunc(func(external string) string {
+		return external
+	})
+
+	// UUIDIDMapper assigns a freshly generated UUID to every new external identifier.
+	// Since the result is not derivable from the input, it should be combined with
+	// WithIDMapFile so the assignments survive application restarts.
+	UUIDIDMapper IDMapper = IDMapperFunc(func(string) string {
+		return toolbox.GetUUID1()
+	})
+)
+
+// idMap holds the internal keys assigned to external identifiers. New assignments mark
+// the map dirty, it is written to the id map file in batches, see saveIDMap.
+type idMap struct {
+	mu       sync.Mutex
+	keys     map[string]string // Internal keys by external identifier
+	external map[string]string // External identifiers by internal key
+	dirty    bool              // Whether the map changed since it was written
+	saving   sync.Mutex        // Serializes writes of the file
+}
+
+// newIDMap returns an empty idMap.
+func newIDMap() idMap {
+	return idMap{keys: make(map[string]string), external: make(map[string]string)}
+}
+
+// set assigns key to external. The caller must hold mu.
+func (m *idMap) set(external, key string) {
+	m.keys[external] = key
+	m.external[key] = external
+}
+
+// load returns the internal key assigned to external.
+func (m *idMap) load(external string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[external]
+	return key, ok
+}
+
+// loadOrAssign returns the internal key assigned to external, assigning the key returned
+// by mint if there is none. Concurrent calls for one identifier return the same key.
+func (m *idMap) loadOrAssign(external string, mint func() string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if key, ok := m.keys[external]; ok {
+		return key
+	}
+	key := mint()
+	m.set(external, key)
+	m.dirty = true
+	return key
+}
+
+// delete removes the key assigned to external if it is key, or any key if key is empty.
+func (m *idMap) delete(external, key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if k, ok := m.keys[external]; ok && (key == "" || k == key) {
+		delete(m.keys, external)
+		if m.external[k] == external {
+			delete(m.external, k)
+		}
+		m.dirty = true
+	}
+}
+
+// reverse returns the external identifier key is assigned to.
+func (m *idMap) reverse(key string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	external, ok := m.external[key]
+	return external, ok
+}
+
+// LookupID returns the internal key assigned to an external identifier.
+// The second return value reports whether a mapping exists.
+func (cm *ChatsManager) LookupID(external string) (ChatID, bool) {
+	key, ok := cm.ids.load(external)
+	return ChatID(key), ok
+}
+
+// mapID resolves the internal key for an external identifier, consulting the
+// persisted mapping first and asking the configured IDMapper for unknown identifiers.
+// New assignments are written to the id map file with the next history write.
+func (cm *ChatsManager) mapID(external string) string {
+	return cm.ids.loadOrAssign(external, func() string {
+		return cm.cnf.idMapper.MapID(external)
+	})
+}
+
+// lookupID resolves the internal key for an external identifier like mapID without
+// assigning one, for operations on sessions that may not exist. Unknown identifiers
+// resolve to the key the IDMapper derives, which finds the histories of deterministic
+// mappers stored without the id map file.
+func (cm *ChatsManager) lookupID(external string) string {
+	if key, ok := cm.ids.load(external); ok {
+		return key
+	}
+	return cm.cnf.idMapper.MapID(external)
+}
+
+// forgetID drops the mapping of an evicted session if the IDMapper derives the same key
+// again, so the map only grows with the sessions in memory and the assignments that
+// cannot be derived, which are the only link to their stored histories.
+func (cm *ChatsManager) forgetID(external, key string) {
+	if cm.cnf.idMapper.MapID(external) == key {
+		cm.ids.delete(external, key)
+	}
+}
+
+// loadIDMap restores the external-to-internal id mapping from the configured file.
+func (cm *ChatsManager) loadIDMap() {
+	if cm.cnf.idMapFile == "" {
+		return
+	}
+	keys := make(map[string]string)
+	b, err := os.ReadFile(cm.cnf.idMapFile)
+	if err == nil {
+		err = json.Unmarshal(b, &keys)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		cm.cnf.logg.Error("load id map failed", "file", cm.cnf.idMapFile, LogKeyError, err)
+	}
+	cm.ids.mu.Lock()
+	defer cm.ids.mu.Unlock()
+	for external, key := range keys {
+		cm.ids.set(external, key)
+	}
+}
+
+// saveIDMap writes the external-to-internal id mapping to the configured file if it
+// changed. It is called before histories are written, so the assignments are stored no
+// later than the histories keyed by them, and new assignments are written in batches.
+func (cm *ChatsManager) saveIDMap() {
+	if cm.cnf.idMapFile == "" {
+		return
+	}
+	cm.ids.saving.Lock()
+	defer cm.ids.saving.Unlock()
+	cm.ids.mu.Lock()
+	if !cm.ids.dirty {
+		cm.ids.mu.Unlock()
+		return
+	}
+	b, err := json.Marshal(cm.ids.keys)
+	cm.ids.dirty = false
+	cm.ids.mu.Unlock()
+	if err == nil {
+		// replace the file at once, a crash while writing keeps the previous map
+		tmp := cm.cnf.idMapFile + ".tmp"
+		if err = os.WriteFile(tmp, b, 0o664); err == nil {
+			err = os.Rename(tmp, cm.cnf.idMapFile)
+		}
+	}
+	if err != nil {
+		cm.ids.mu.Lock()
+		cm.ids.dirty = true
+		cm.ids.mu.Unlock()
+		cm.cnf.logg.Error("save id map failed", "file", cm.cnf.idMapFile, LogKeyError, err)
+	}
+}
+
+// ExternalID returns the external identifier the internal key was assigned to, e.g. to
+// resolve the ChatID of events, traces and errors to the identifier passed to Chat.
+// The second return value reports whether a mapping exists.
+func (cm *ChatsManager) ExternalID(key ChatID) (string, bool) {
+	return cm.ids.reverse(string(key))
+}
@@ -0,0 +1,159 @@
+package llm
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xyzj/llm/clock"
+	"github.com/xyzj/llm/provider"
+	"github.com/xyzj/llm/storage"
+)
+
+func TestMapIDConcurrent(t *testing.T) {
+	cm, _ := newTestManager(t, newTestProvider(), WithIDMapper(UUIDIDMapper))
+	keys := make([]string, 16)
+	var wg sync.WaitGroup
+	for i := range keys {
+		wg.Go(func() {
+			keys[i] = cm.mapID("user")
+		})
+	}
+	wg.Wait()
+	for _, key := range keys[1:] {
+		if key != keys[0] {
+			t.Fatalf("concurrent first calls assigned %q and %q", keys[0], key)
+		}
+	}
+}
+
+func TestLookupIDDoesNotAssign(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ids.json")
+	cm, _ := newTestManager(t, newTestProvider(), WithIDMapper(UUIDIDMapper), WithIDMapFile(file))
+	cm.Delete(context.Background(), "unknown")
+	cm.Flush("unknown")
+	cm.ExportHTML("unknown", io.Discard)
+	if _, ok := cm.LookupID("unknown"); ok {
+		t.Error("read operations assigned a key to an unknown identifier")
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("id map file written without assignments: %v", err)
+	}
+}
+
+func TestIDMapFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ids.json")
+	cm, _ := newTestManager(t, newTestProvider(), WithIDMapper(UUIDIDMapper), WithIDMapFile(file))
+	cm.mapID("other")
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("id map file written before a history: %v", err)
+	}
+	if _, err := cm.ChatE(context.Background(), "user", "hi", discard); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := cm.LookupID("user")
+	cm.Delete(context.Background(), "other")
+	restarted, _ := newTestManager(t, newTestProvider(), WithIDMapper(UUIDIDMapper), WithIDMapFile(file))
+	if got, ok := restarted.LookupID("user"); !ok || got != key {
+		t.Errorf("restored key = %q, %v, want %q", got, ok, key)
+	}
+	if _, ok := restarted.LookupID("other"); ok {
+		t.Error("deleted assignment restored")
+	}
+}
+
+// countingStorage counts the histories loaded from the wrapped storage, not counting
+// session states.
+type countingStorage struct {
+	storage.Storage
+	loads atomic.Int32
+}
+
+func (s *countingStorage) Load(ctx context.Context, chatid string) ([]*provider.Message, error) {
+	if !strings.HasSuffix(chatid, "_session") {
+		s.loads.Add(1)
+	}
+	return s.Storage.Load(ctx, chatid)
+}
+
+func TestSessionIdentity(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewManual(time.Now())
+	st := &countingStorage{Storage: storage.NewMemoryStorage()}
+	cm, _ := newTestManager(t, newTestProvider(), WithStorage(st), WithClock(clk), WithChatLifeTime(time.Hour))
+
+	// create
+	if _, err := cm.ChatE(ctx, "user", "one", discard); err != nil {
+		t.Fatal(err)
+	}
+	key, ok := cm.LookupID("user")
+	if !ok || string(key) != HashIDMapper.MapID("user") {
+		t.Fatalf("key = %q, %v, want the hash of the identifier", key, ok)
+	}
+	if tr, _ := cm.LastTrace("user"); tr == nil || tr.ChatID != key {
+		t.Errorf("trace reports chat %v, want %q", tr, key)
+	}
+	if external, ok := cm.ExternalID(key); !ok || external != "user" {
+		t.Errorf("external id = %q, %v, want %q", external, ok, "user")
+	}
+
+	// lookup: the active session is found again without loading its history
+	if _, err := cm.ChatE(ctx, "user", "two", discard); err != nil {
+		t.Fatal(err)
+	}
+	if n := st.loads.Load(); n != 1 {
+		t.Errorf("history loaded %d times, want once", n)
+	}
+	if n := len(cm.History("user")); n != 4 {
+		t.Errorf("history has %d messages, want 4", n)
+	}
+
+	// expiry: the session is dropped from memory and restored from storage
+	clk.Advance(2 * time.Hour)
+	cm.sweeping.Lock()
+	cm.expire()
+	cm.sweeping.Unlock()
+	if cm.chats.Has("user") {
+		t.Fatal("expired session still active")
+	}
+	his, err := cm.LoadHistory(ctx, "user")
+	if err != nil || len(his) != 4 {
+		t.Errorf("restored %d messages, %v, want 4", len(his), err)
+	}
+	if got, _ := cm.LookupID("user"); got != key {
+		t.Errorf("key after expiry = %q, want %q", got, key)
+	}
+}
+
+func TestSessionIdentityRestart(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "ids.json")
+	st := storage.NewMemoryStorage()
+	opts := []Opts{WithStorage(st), WithIDMapper(UUIDIDMapper), WithIDMapFile(file)}
+	cm, _ := newTestManager(t, newTestProvider(), opts...)
+	if _, err := cm.ChatE(ctx, "user", "one", discard); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := cm.LookupID("user")
+	if err := cm.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, _ := newTestManager(t, newTestProvider(), opts...)
+	his, err := restarted.LoadHistory(ctx, "user")
+	if err != nil || len(his) != 2 {
+		t.Errorf("restored %d messages, %v, want 2", len(his), err)
+	}
+	if got, _ := restarted.LookupID("user"); got != key {
+		t.Errorf("key after restart = %q, want %q", got, key)
+	}
+	if external, ok := restarted.ExternalID(key); !ok || external != "user" {
+		t.Errorf("external id after restart = %q, %v, want %q", external, ok, "user")
+	}
+}
@@ -0,0 +1,113 @@
+package importer
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xyzj/llm/provider"
+
+	"github.com/xyzj/toolbox/json"
+)
+
+type (
+	// gptConversation is a conversation of the conversations.json file of a ChatGPT export.
+	gptConversation struct {
+		ID             string              `json:"id"`
+		ConversationID string              `json:"conversation_id"`
+		Title          string              `json:"title"`
+		CreateTime     float64             `json:"create_time"`
+		CurrentNode    string              `json:"current_node"`
+		Mapping        map[string]*gptNode `json:"mapping"`
+	}
+	// gptNode is a node of the message tree of a conversation; edited prompts and
+	// regenerated answers branch off the tree.
+	gptNode struct {
+		Message *gptMessage `json:"message"`
+		Parent  string      `json:"parent"`
+	}
+	gptMessage struct {
+		Author struct {
+			Role string `json:"role"`
+		} `json:"author"`
+		Content struct {
+			ContentType string `json:"content_type"`
+			Parts       []any  `json:"parts"`
+		} `json:"content"`
+	}
+)
+
+// ChatGPT converts the conversations.json file of a ChatGPT data export. Only the branch
+// leading to the last shown message of each conversation is imported, and only the text
+// of user, assistant and system messages; images, code execution and browsing results
+// are skipped.
+//
+// Parameters:
+//   - data: Content of conversations.json
+//
+// Returns:
+//   - []*Conversation: Converted conversations in the order of the export
+//   - error: If data is not a ChatGPT conversation export
+func ChatGPT(data []byte) ([]*Conversation, error) {
+	src := make([]*gptConversation, 0)
+	if err := json.Unmarshal(data, &src); err != nil {
+		return nil, err
+	}
+	convs := make([]*Conversation, 0, len(src))
+	for _, gc := range src {
+		c := &Conversation{
+			ID:    gc.ConversationID,
+			Title: gc.Title,
+		}
+		if c.ID == "" {
+			c.ID = gc.ID
+		}
+		if gc.CreateTime > 0 {
+			sec, frac := math.Modf(gc.CreateTime)
+			c.Created = time.Unix(int64(sec), int64(frac*1e9))
+		}
+		// walk up from the current node, the map has no order
+		seen := make(map[string]bool)
+		for id := gc.CurrentNode; id != "" && !seen[id]; {
+			seen[id] = true
+			node := gc.Mapping[id]
+			if node == nil {
+				break
+			}
+			if msg := node.Message.convert(); msg != nil {
+				c.Messages = append(c.Messages, msg)
+			}
+			id = node.Parent
+		}
+		slices.Reverse(c.Messages)
+		convs = append(convs, c)
+	}
+	return convs, nil
+}
+
+// convert returns the message as provider message, or nil if it has no importable text.
+func (m *gptMessage) convert() *provider.Message {
+	if m == nil {
+		return nil
+	}
+	switch m.Author.Role {
+	case provider.RoleSystem, provider.RoleUser, provider.RoleAssistant:
+	default:
+		return nil
+	}
+	if m.Content.ContentType != "text" && m.Content.ContentType != "multimodal_text" {
+		return nil
+	}
+	parts := make([]string, 0, len(m.Content.Parts))
+	for _, p := range m.Content.Parts {
+		if s, ok := p.(string); ok && s != "" {
+			parts = append(parts, s)
+		}
+	}
+	text := strings.Join(parts, "\n")
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	return textMessage(m.Author.Role, text)
+}
@@ -0,0 +1,86 @@
+// Package importer converts conversations exported by other ecosystems into provider
+// messages, so users migrating to this module can keep their existing conversations.
+// Converted messages can be restored into a session with history.History.StoreMany or
+// chat.Chat.SetHistory, or persisted in a storage backend with Store.
+package importer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/xyzj/llm/provider"
+	"github.com/xyzj/llm/storage"
+
+	"github.com/xyzj/toolbox/json"
+)
+
+// Conversation is an imported conversation.
+type Conversation struct {
+	ID       string              // Identifier of the conversation in the source, used as storage key by Store
+	Title    string              // Title of the conversation, empty if the source has none
+	Created  time.Time           // Creation time, zero if the source has none
+	Messages []*provider.Message // Messages in chronological order
+}
+
+// OpenAI converts a JSON array of messages in the OpenAI chat completion format, as sent
+// to /v1/chat/completions, into provider messages. Content may be a string or an array
+// of content parts.
+//
+// Parameters:
+//   - data: JSON array of messages
+//
+// Returns:
+//   - []*provider.Message: Converted messages in their original order
+//   - error: If data is not a message array or a message has an unknown role
+func OpenAI(data []byte) ([]*provider.Message, error) {
+	msgs := make([]*provider.Message, 0)
+	if err := json.Unmarshal(data, &msgs); err != nil {
+		return nil, err
+	}
+	for i, msg := range msgs {
+		if msg == nil {
+			return nil, fmt.Errorf("message %d: null message", i)
+		}
+		switch msg.Role {
+		case provider.RoleSystem, provider.RoleUser, provider.RoleAssistant, provider.RoleTool:
+		default:
+			return nil, fmt.Errorf("message %d: unknown role [%s]", i, msg.Role)
+		}
+	}
+	return msgs, nil
+}
+
+// Store persists imported conversations in a storage backend, each under its ID.
+// A ChatsManager restores them for the chat whose internal key equals the ID, so
+// conversations should either be stored under their mapped keys or be used with
+// PassthroughIDMapper, see llm.WithIDMapper.
+//
+// Parameters:
+//   - ctx: Context controlling cancellation and deadline of the storage operations
+//   - s: Destination storage backend
+//   - convs: Conversations to store
+//
+// Returns:
+//   - error: The first error encountered, conversations stored before it are kept
+func Store(ctx context.Context, s storage.Storage, convs ...*Conversation) error {
+	for _, c := range convs {
+		if c.ID == "" {
+			return fmt.Errorf("conversation [%s] has no id", c.Title)
+		}
+		if err := s.Store(ctx, c.ID, c.Messages); err != nil {
+			return fmt.Errorf("conversation [%s]: %w", c.ID, err)
+		}
+	}
+	return nil
+}
+
+// textMessage creates a message with string content.
+func textMessage(role, text string) *provider.Message {
+	return &provider.Message{
+		Role: role,
+		Content: &provider.MessageContent{
+			StringValue: &text,
+		},
+	}
+}
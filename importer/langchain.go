@@ -0,0 +1,135 @@
+package importer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xyzj/llm/provider"
+
+	"github.com/xyzj/toolbox/json"
+)
+
+type (
+	// lcMessage is a LangChain message serialized by messages_to_dict ("type" and "data")
+	// or by dumpd ("lc", "id" and "kwargs").
+	lcMessage struct {
+		Type   string   `json:"type"`
+		Data   *lcData  `json:"data"`
+		ID     []string `json:"id"`
+		Kwargs *lcData  `json:"kwargs"`
+	}
+	lcData struct {
+		Type             string        `json:"type"`
+		Role             string        `json:"role"`
+		Content          any           `json:"content"`
+		ToolCalls        []*lcToolCall `json:"tool_calls"`
+		ToolCallID       string        `json:"tool_call_id"`
+		AdditionalKwargs struct {
+			ToolCalls []*provider.ToolCall `json:"tool_calls"`
+		} `json:"additional_kwargs"`
+	}
+	lcToolCall struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Args any    `json:"args"`
+	}
+)
+
+// lcClasses maps the class names of dumpd serialized messages to message types.
+var lcClasses = map[string]string{
+	"HumanMessage":  "human",
+	"AIMessage":     "ai",
+	"SystemMessage": "system",
+	"ToolMessage":   "tool",
+	"ChatMessage":   "chat",
+}
+
+// LangChain converts a JSON array of LangChain messages, as written by messages_to_dict
+// or dumpd, into provider messages. Human, AI, system, tool and chat messages are
+// supported, including the tool calls of AI messages; content blocks other than text
+// are skipped.
+//
+// Parameters:
+//   - data: JSON array of serialized messages
+//
+// Returns:
+//   - []*provider.Message: Converted messages in their original order
+//   - error: If data is not a message array or a message has an unsupported type
+func LangChain(data []byte) ([]*provider.Message, error) {
+	src := make([]*lcMessage, 0)
+	if err := json.Unmarshal(data, &src); err != nil {
+		return nil, err
+	}
+	msgs := make([]*provider.Message, 0, len(src))
+	for i, lm := range src {
+		if lm == nil {
+			return nil, fmt.Errorf("message %d: null message", i)
+		}
+		d, typ := lm.Data, lm.Type
+		if lm.Kwargs != nil {
+			d, typ = lm.Kwargs, lm.Kwargs.Type
+			if typ == "" && len(lm.ID) > 0 {
+				typ = lcClasses[lm.ID[len(lm.ID)-1]]
+			}
+		}
+		if d == nil {
+			return nil, fmt.Errorf("message %d: no message data", i)
+		}
+		var role string
+		switch typ {
+		case "human":
+			role = provider.RoleUser
+		case "ai":
+			role = provider.RoleAssistant
+		case "system":
+			role = provider.RoleSystem
+		case "tool":
+			role = provider.RoleTool
+		case "chat":
+			role = d.Role
+		default:
+			return nil, fmt.Errorf("message %d: unsupported message type [%s]", i, typ)
+		}
+		msg := textMessage(role, lcText(d.Content))
+		msg.ToolCallID = d.ToolCallID
+		for _, tc := range d.ToolCalls {
+			args, err := json.MarshalToString(tc.Args)
+			if err != nil {
+				return nil, fmt.Errorf("message %d: %w", i, err)
+			}
+			msg.ToolCalls = append(msg.ToolCalls, &provider.ToolCall{
+				ID:       tc.ID,
+				Type:     provider.ToolTypeFunction,
+				Function: provider.FunctionCall{Name: tc.Name, Arguments: args},
+			})
+		}
+		if len(msg.ToolCalls) == 0 {
+			msg.ToolCalls = d.AdditionalKwargs.ToolCalls
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// lcText returns the text of a LangChain message content, which is either a string or
+// a list of strings and content blocks.
+func lcText(content any) string {
+	switch v := content.(type) {
+	case string:
+		return v
+	case []any:
+		var b strings.Builder
+		for _, block := range v {
+			switch x := block.(type) {
+			case string:
+				b.WriteString(x)
+			case map[string]any:
+				if t, ok := x["text"].(string); ok && x["type"] == "text" {
+					b.WriteString(t)
+				}
+			}
+		}
+		return b.String()
+	}
+	return ""
+}
@@ -0,0 +1,224 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xyzj/toolbox/json"
+)
+
+// ErrQueueFull is returned by Submit when the job queue is full, see WithJobWorkers.
+var ErrQueueFull = errors.New("chat job queue is full")
+
+// JobStatus is the processing state of a Job.
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"  // Waiting for a worker
+	JobRunning JobStatus = "running" // Turn in progress
+	JobDone    JobStatus = "done"    // Turn completed
+	JobFailed  JobStatus = "failed"  // Turn ended with an error, see Job.Error
+)
+
+type (
+	// Job is a chat turn submitted with ChatsManager.Submit and processed by a worker.
+	Job struct {
+		ID       string    `json:"id"`               // Job identifier returned by Submit
+		ChatID   string    `json:"chat_id"`          // Identifier of the chat session as passed to Submit
+		Message  string    `json:"message"`          // User message of the turn
+		Status   JobStatus `json:"status"`           // Processing state
+		Reply    string    `json:"reply,omitempty"`  // Response written by the turn, without events
+		Events   []*Event  `json:"events,omitempty"` // Events emitted during the turn
+		Error    string    `json:"error,omitempty"`  // Error that ended the turn, if it failed
+		Trace    *RunTrace `json:"trace,omitempty"`  // Model requests and tool calls of the turn
+		Created  time.Time `json:"created"`          // Time the job was submitted
+		Started  time.Time `json:"started"`          // Time a worker picked up the job, zero while queued
+		Finished time.Time `json:"finished"`         // Time the turn completed, zero while not finished
+	}
+	// JobFunc is called with every finished job, see WithJobCallback.
+	JobFunc func(job *Job)
+)
+
+// jobQueue holds the submitted jobs and runs them on a pool of workers.
+type jobQueue struct {
+	locker sync.Mutex
+	jobs   map[string]*Job // Submitted jobs by ID, finished ones until their retention passed
+	queue  chan *Job       // Jobs waiting for a worker
+	start  sync.Once       // Starts the workers with the first submitted job
+	wg     sync.WaitGroup  // Running workers
+	closed bool            // Whether the queue was closed, see close
+}
+
+func newJobQueue(size int) *jobQueue {
+	return &jobQueue{
+		jobs:  make(map[string]*Job),
+		queue: make(chan *Job, size),
+	}
+}
+
+// Submit queues a chat turn for asynchronous processing by a pool of workers and returns
+// immediately, for batch processing or clients that cannot keep a stream open, e.g.
+// mobile apps notified by push. The turn runs like ChatContext; its result is retrieved
+// with Poll or delivered to the callback and webhook set with WithJobCallback and
+// WithJobWebhook. Jobs run in parallel, so submit the next turn of a chat session once
+// its previous job finished.
+//
+// Parameters:
+//   - id: Unique identifier for the chat session, see ChatContext
+//   - message: User's message to send to the AI model
+//
+// Returns:
+//   - string: Job identifier for Poll
+//   - error: ErrQueueFull if the queue is full, ErrClosed after Close
+func (cm *ChatsManager) Submit(id, message string) (string, error) {
+	q := cm.jobs
+	job := &Job{
+		ID:      rand.Text(),
+		ChatID:  id,
+		Message: message,
+		Status:  JobQueued,
+		Created: cm.cnf.clock.Now(),
+	}
+	q.locker.Lock()
+	defer q.locker.Unlock()
+	if q.closed {
+		return "", ErrClosed
+	}
+	q.start.Do(func() {
+		for range cm.cnf.jobWorkers {
+			q.wg.Go(cm.jobWorker)
+		}
+	})
+	cm.sweepJobs()
+	select {
+	case q.queue <- job:
+	default:
+		return "", ErrQueueFull
+	}
+	q.jobs[job.ID] = job
+	return job.ID, nil
+}
+
+// close stops accepting jobs and waits until the workers finished the queued jobs or ctx
+// is done.
+func (q *jobQueue) close(ctx context.Context) error {
+	q.locker.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.locker.Unlock()
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Poll returns the state of a job submitted with Submit. Finished jobs are kept for the
+// retention set with WithJobRetention.
+//
+// Parameters:
+//   - jobID: Job identifier returned by Submit
+//
+// Returns:
+//   - *Job: A copy of the job
+//   - bool: false if the job is unknown or its retention passed
+func (cm *ChatsManager) Poll(jobID string) (*Job, bool) {
+	q := cm.jobs
+	q.locker.Lock()
+	defer q.locker.Unlock()
+	cm.sweepJobs()
+	job, ok := q.jobs[jobID]
+	if !ok {
+		return nil, false
+	}
+	j := *job
+	return &j, true
+}
+
+// sweepJobs removes the finished jobs whose retention passed. The caller must hold the lock.
+func (cm *ChatsManager) sweepJobs() {
+	for id, job := range cm.jobs.jobs {
+		if !job.Finished.IsZero() && cm.cnf.clock.Now().Sub(job.Finished) > cm.cnf.jobRetention {
+			delete(cm.jobs.jobs, id)
+		}
+	}
+}
+
+// jobWorker runs queued jobs until the queue is closed.
+func (cm *ChatsManager) jobWorker() {
+	for job := range cm.jobs.queue {
+		cm.runJob(job)
+	}
+}
+
+// runJob runs the turn of a job and delivers the finished job.
+func (cm *ChatsManager) runJob(job *Job) {
+	q := cm.jobs
+	q.locker.Lock()
+	job.Status, job.Started = JobRunning, cm.cnf.clock.Now()
+	q.locker.Unlock()
+	var reply strings.Builder
+	var events []*Event
+	trace := cm.turn(context.Background(), job.ChatID, job.Message, func(data []byte) error {
+		reply.Write(data)
+		return nil
+	}, WithEventFunc(func(e *Event) error {
+		events = append(events, e)
+		return nil
+	}))
+	q.locker.Lock()
+	job.Reply, job.Events, job.Trace = reply.String(), events, trace
+	job.Status, job.Finished = JobDone, cm.cnf.clock.Now()
+	if trace != nil && trace.Error != "" {
+		job.Status, job.Error = JobFailed, trace.Error
+	}
+	done := *job
+	q.locker.Unlock()
+	if cm.cnf.onJob != nil {
+		cm.cnf.onJob(&done)
+	}
+	if cm.cnf.jobWebhook != "" {
+		if err := cm.postJob(&done); err != nil {
+			cm.cnf.logg.Error("job webhook failed", LogKeyChatID, done.ChatID, "job_id", done.ID, LogKeyError, err)
+		}
+	}
+}
+
+// postJob sends a finished job as JSON to the webhook set with WithJobWebhook.
+func (cm *ChatsManager) postJob(job *Job) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cm.cnf.jobWebhook, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook [%s]: http status %d", cm.cnf.jobWebhook, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+// Package llmhttp streams chat responses to HTTP clients as Server-Sent Events.
+// An SSEWriter is used as the write function of a chat turn: assistant text is sent as
+// message events, and the structured events of the ChatsManager, e.g. context warnings,
+// passed to WriteEvent as events named after their type. Idle connections are kept open
+// with heartbeats, and the turn is aborted when the client disconnects.
+//
+// Example:
+//
+//	http.HandleFunc("POST /chat", func(w http.ResponseWriter, r *http.Request) {
+//		sse, err := llmhttp.NewSSEWriter(w, r)
+//		if err != nil {
+//			http.Error(w, err.Error(), http.StatusInternalServerError)
+//			return
+//		}
+//		defer sse.Close()
+//		cm.ChatContext(sse.Context(), r.FormValue("id"), r.FormValue("message"), sse.Write,
+//			llm.WithEventFunc(sse.WriteEvent))
+//	})
+package llmhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xyzj/llm"
+)
+
+type (
+	// SSEOpt configures the SSEWriter created by NewSSEWriter.
+	SSEOpt struct {
+		heartbeat time.Duration // Interval of the heartbeat comments, 0 to disable
+		retry     time.Duration // Reconnection delay advised to the client, 0 to leave it to the client
+		doneEvent string        // Name of the event written by Close, empty to write none
+	}
+	// SSEOpts is a function type for configuring the SSEWriter.
+	SSEOpts func(opt *SSEOpt)
+)
+
+// WithHeartbeat sets the interval of the heartbeat comments keeping idle connections
+// open through proxies, e.g. while tools are running. Defaults to 15 seconds, zero or
+// negative d disables the heartbeat.
+func WithHeartbeat(d time.Duration) SSEOpts {
+	return func(opt *SSEOpt) {
+		opt.heartbeat = max(d, 0)
+	}
+}
+
+// WithRetry advises the client to reconnect after d if the connection is lost.
+func WithRetry(d time.Duration) SSEOpts {
+	return func(opt *SSEOpt) {
+		opt.retry = max(d, 0)
+	}
+}
+
+// WithDoneEvent sets the name of the event Close writes to tell the client the response
+// is complete. Defaults to "done", an empty name writes no event.
+func WithDoneEvent(name string) SSEOpts {
+	return func(opt *SSEOpt) {
+		opt.doneEvent = name
+	}
+}
+
+// SSEWriter writes Server-Sent Events to an HTTP response. It is safe for concurrent use.
+type SSEWriter struct {
+	locker sync.Mutex
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	ctx    context.Context    // Cancelled when the client disconnects or the writer is closed
+	cancel context.CancelFunc // Cancels ctx
+	cnf    *SSEOpt
+	closed bool
+}
+
+// NewSSEWriter starts an event stream response: the SSE headers are written and the
+// heartbeat is started. The response must support flushing.
+//
+// Parameters:
+//   - w: Response to write the events to
+//   - r: Request of the response, its context ends the stream when the client disconnects
+//   - opts: Optional configuration, e.g. WithHeartbeat
+//
+// Returns:
+//   - *SSEWriter: Writer whose Write method is the write function of a chat turn
+//   - error: If the response does not support flushing
+func NewSSEWriter(w http.ResponseWriter, r *http.Request, opts ...SSEOpts) (*SSEWriter, error) {
+	opt := &SSEOpt{
+		heartbeat: 15 * time.Second,
+		doneEvent: "done",
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	rc := http.NewResponseController(w)
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no") // disable response buffering of nginx
+	w.WriteHeader(http.StatusOK)
+	if opt.retry > 0 {
+		fmt.Fprintf(w, "retry: %d\n\n", opt.retry.Milliseconds())
+	}
+	if err := rc.Flush(); err != nil {
+		return nil, fmt.Errorf("response does not support streaming: %w", err)
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	s := &SSEWriter{w: w, rc: rc, ctx: ctx, cancel: cancel, cnf: opt}
+	if opt.heartbeat > 0 {
+		go s.heartbeat()
+	}
+	return s, nil
+}
+
+// Context returns a context that is cancelled when the client disconnects or the writer
+// is closed. Pass it to ChatsManager.ChatContext to stop the turn with the connection.
+func (s *SSEWriter) Context() context.Context {
+	return s.ctx
+}
+
+// Write sends a chunk of a chat response as a message event, empty chunks are skipped.
+// It returns an error once the client disconnected, which aborts the chat turn.
+func (s *SSEWriter) Write(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return s.Event("", data)
+}
+
+// WriteEvent sends a structured event of the ChatsManager as JSON, named after its type.
+// Pass it to llm.WithEventFunc.
+func (s *SSEWriter) WriteEvent(e *llm.Event) error {
+	return s.Event(string(e.Type), e.Bytes())
+}
+
+// Event sends an event with the given name, an empty name sends a message event.
+// Multi-line data is split into several data lines, as required by the format.
+func (s *SSEWriter) Event(name string, data []byte) error {
+	var b strings.Builder
+	if name != "" {
+		b.WriteString("event: " + name + "\n")
+	}
+	for line := range strings.SplitSeq(string(data), "\n") {
+		b.WriteString("data: " + strings.TrimSuffix(line, "\r") + "\n")
+	}
+	b.WriteString("\n")
+	return s.send(b.String())
+}
+
+// Close stops the heartbeat and writes the done event, see WithDoneEvent. Close does not
+// end the response, which ends when the handler returns.
+func (s *SSEWriter) Close() error {
+	var err error
+	if s.cnf.doneEvent != "" {
+		err = s.Event(s.cnf.doneEvent, []byte("[DONE]"))
+	}
+	s.locker.Lock()
+	s.closed = true
+	s.locker.Unlock()
+	s.cancel()
+	return err
+}
+
+// send writes and flushes a framed event.
+func (s *SSEWriter) send(frame string) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if s.closed {
+		return errors.New("event stream is closed")
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprint(s.w, frame); err != nil {
+		s.cancel()
+		return err
+	}
+	if err := s.rc.Flush(); err != nil {
+		s.cancel()
+		return err
+	}
+	return nil
+}
+
+// heartbeat sends a comment every heartbeat interval until the stream ends.
+func (s *SSEWriter) heartbeat() {
+	t := time.NewTicker(s.cnf.heartbeat)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-t.C:
+			if s.send(": ping\n\n") != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package llmhttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/xyzj/llm"
+	"github.com/xyzj/llm/provider"
+
+	"golang.org/x/net/websocket"
+)
+
+// Types of the frames exchanged by the Gateway.
+const (
+	FrameMessage  = "message"  // Client: user message in Text
+	FrameApproval = "approval" // Server: tool call to approve; client: the answer in Approved
+	FrameHistory  = "history"  // Server: history of the chat in Messages, sent after connecting
+	FrameToken    = "token"    // Server: chunk of the assistant response in Text
+	FrameEvent    = "event"    // Server: structured event of the ChatsManager in Event
+	FrameDone     = "done"     // Server: the turn completed, Error holds its error if any
+	FrameError    = "error"    // Server: a client frame could not be handled, see Error
+)
+
+type (
+	// Frame is a JSON message exchanged over the WebSocket connection of a Gateway.
+	Frame struct {
+		Type      string              `json:"type"`                // Kind of the frame, see the Frame constants
+		Text      string              `json:"text,omitempty"`      // User message or response chunk
+		Messages  []*provider.Message `json:"messages,omitempty"`  // Chat history
+		Event     json.RawMessage     `json:"event,omitempty"`     // Structured event, see llm.Event
+		CallID    string              `json:"call_id,omitempty"`   // Tool call id of an approval
+		Name      string              `json:"name,omitempty"`      // Tool name of an approval request
+		Arguments string              `json:"arguments,omitempty"` // Tool arguments of an approval request
+		Approved  bool                `json:"approved,omitempty"`  // Answer of an approval
+		Error     string              `json:"error,omitempty"`     // Error of a turn or frame
+	}
+	// GatewayOpt configures the Gateway created by NewGateway.
+	GatewayOpt struct {
+		sessionID       func(r *http.Request) string // Derives the chat identifier from the request
+		origins         []string                     // Allowed origins besides the host of the request
+		approvals       bool                         // Whether tool calls need the approval of the client
+		approvalTimeout time.Duration                // Time to wait for an approval before denying the call
+	}
+	// GatewayOpts is a function type for configuring the Gateway.
+	GatewayOpts func(opt *GatewayOpt)
+)
+
+// WithSessionID sets the function deriving the chat identifier of a connection from its
+// request, e.g. from the authenticated user. Connections without identifier are refused.
+// A gateway needs either this option or WithInsecureQuerySessionID.
+func WithSessionID(f func(r *http.Request) string) GatewayOpts {
+	return func(opt *GatewayOpt) {
+		if f != nil {
+			opt.sessionID = f
+		}
+	}
+}
+
+// WithInsecureQuerySessionID takes the chat identifier of a connection from the
+// "chat_id" query parameter of its request, e.g. for local development. Any client can
+// then connect to any chat session by its identifier, so it must not be used unless the
+// identifiers are secret or the gateway is not reachable by untrusted clients.
+func WithInsecureQuerySessionID() GatewayOpts {
+	return func(opt *GatewayOpt) {
+		opt.sessionID = func(r *http.Request) string {
+			return r.URL.Query().Get("chat_id")
+		}
+	}
+}
+
+// WithOrigins allows browsers on the given origins, e.g. "https://app.example.com", to
+// connect. Without it only pages served from the host of the gateway and clients that
+// send no Origin header, i.e. non-browser clients, can connect.
+func WithOrigins(origins ...string) GatewayOpts {
+	return func(opt *GatewayOpt) {
+		opt.origins = append(opt.origins, origins...)
+	}
+}
+
+// WithApprovals makes every tool call wait for the approval of the client: an approval
+// frame with the call is sent, and the call runs once the client answers with an
+// approval frame carrying the same call id and Approved set. Calls not approved within
+// timeout are denied, see llm.ApprovalContext. Zero or negative timeout waits 2 minutes.
+func WithApprovals(timeout time.Duration) GatewayOpts {
+	return func(opt *GatewayOpt) {
+		opt.approvals = true
+		if timeout > 0 {
+			opt.approvalTimeout = timeout
+		}
+	}
+}
+
+// Gateway serves chat sessions of a ChatsManager over WebSocket connections with JSON
+// frames, see Frame. After connecting, the client receives the history of its chat,
+// restored from storage if needed, so reconnecting clients continue where they left off.
+// Each message frame runs a turn whose response is streamed as token and event frames
+// and completed by a done frame; one turn runs at a time per connection. The running
+// turn is cancelled when the connection closes.
+type Gateway struct {
+	cm  *llm.ChatsManager
+	cnf *GatewayOpt
+	ws  websocket.Server
+}
+
+// NewGateway creates a WebSocket gateway to the chat sessions of cm. It panics without
+// WithSessionID or WithInsecureQuerySessionID, as the chat identifier of a connection
+// must not be chosen by the client unless explicitly allowed.
+//
+// Parameters:
+//   - cm: Manager of the chat sessions
+//   - opts: Configuration, WithSessionID and optionally e.g. WithApprovals
+//
+// Example:
+//
+//	http.Handle("/ws", llmhttp.NewGateway(cm,
+//		llmhttp.WithSessionID(userID),
+//		llmhttp.WithApprovals(time.Minute),
+//	))
+func NewGateway(cm *llm.ChatsManager, opts ...GatewayOpts) *Gateway {
+	opt := &GatewayOpt{
+		approvalTimeout: 2 * time.Minute,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	if opt.sessionID == nil {
+		panic("llmhttp: NewGateway without WithSessionID or WithInsecureQuerySessionID")
+	}
+	g := &Gateway{cm: cm, cnf: opt}
+	g.ws = websocket.Server{Handshake: g.handshake, Handler: g.serve}
+	return g
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection and serves its chat session.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if g.cnf.sessionID(r) == "" {
+		http.Error(w, "missing chat id", http.StatusBadRequest)
+		return
+	}
+	g.ws.ServeHTTP(w, r)
+}
+
+// handshake refuses connections from origins that are not allowed, see WithOrigins.
+func (g *Gateway) handshake(cnf *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(cnf, r)
+	if err != nil {
+		return err
+	}
+	cnf.Origin = origin
+	if origin == nil || origin.Host == r.Host || slices.Contains(g.cnf.origins, origin.Scheme+"://"+origin.Host) {
+		return nil
+	}
+	return errors.New("origin not allowed")
+}
+
+// serve runs the chat session of a connection until it closes.
+func (g *Gateway) serve(ws *websocket.Conn) {
+	defer ws.Close()
+	r := ws.Request()
+	id := g.cnf.sessionID(r)
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	c := &wsConn{ws: ws, approvals: make(map[string]chan bool), timeout: g.cnf.approvalTimeout}
+	his, err := g.cm.LoadHistory(ctx, id)
+	if err != nil {
+		c.send(&Frame{Type: FrameError, Error: err.Error()})
+	}
+	if c.send(&Frame{Type: FrameHistory, Messages: his}) != nil {
+		return
+	}
+	turns := make(chan string)
+	defer close(turns)
+	go func() {
+		for text := range turns {
+			g.turn(ctx, c, id, text)
+		}
+	}()
+	for {
+		f := &Frame{}
+		if err := websocket.JSON.Receive(ws, f); err != nil {
+			return
+		}
+		switch f.Type {
+		case FrameMessage:
+			select {
+			case turns <- f.Text:
+			default:
+				c.send(&Frame{Type: FrameError, Error: "a turn is already running"})
+			}
+		case FrameApproval:
+			c.answer(f.CallID, f.Approved)
+		default:
+			c.send(&Frame{Type: FrameError, Error: "unknown frame type [" + f.Type + "]"})
+		}
+	}
+}
+
+// turn runs a chat turn and streams its response to the connection.
+func (g *Gateway) turn(ctx context.Context, c *wsConn, id, text string) {
+	if g.cnf.approvals {
+		ctx = llm.ApprovalContext(ctx, c.approve)
+	}
+	var trace *llm.RunTrace
+	g.cm.ChatContext(ctx, id, text, func(data []byte) error {
+		if len(data) == 0 {
+			return nil
+		}
+		return c.send(&Frame{Type: FrameToken, Text: string(data)})
+	}, llm.WithEventFunc(func(e *llm.Event) error {
+		return c.send(&Frame{Type: FrameEvent, Event: e.Bytes()})
+	}), llm.WithTraceFunc(func(t *llm.RunTrace) { trace = t }))
+	done := &Frame{Type: FrameDone}
+	if trace != nil {
+		done.Error = trace.Error
+	}
+	c.send(done)
+}
+
+// wsConn is a gateway connection with its pending tool call approvals.
+type wsConn struct {
+	locker    sync.Mutex
+	ws        *websocket.Conn
+	approvals map[string]chan bool // Pending approvals by tool call id
+	timeout   time.Duration        // Time to wait for an approval
+}
+
+// send writes a frame; frames of the turn and the read loop are serialized.
+func (c *wsConn) send(f *Frame) error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return websocket.JSON.Send(c.ws, f)
+}
+
+// approve asks the client to approve a tool call and waits for the answer.
+// It implements llm.ToolApprovalFunc.
+func (c *wsConn) approve(ctx context.Context, chatID string, tc *provider.ToolCall) (bool, error) {
+	ch := make(chan bool, 1)
+	c.locker.Lock()
+	c.approvals[tc.ID] = ch
+	c.locker.Unlock()
+	defer func() {
+		c.locker.Lock()
+		delete(c.approvals, tc.ID)
+		c.locker.Unlock()
+	}()
+	err := c.send(&Frame{Type: FrameApproval, CallID: tc.ID, Name: tc.Function.Name, Arguments: tc.Function.Arguments})
+	if err != nil {
+		return false, err
+	}
+	t := time.NewTimer(c.timeout)
+	defer t.Stop()
+	select {
+	case ok := <-ch:
+		return ok, nil
+	case <-t.C:
+		return false, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// answer delivers the client's answer to a pending approval.
+func (c *wsConn) answer(callID string, approved bool) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	if ch, ok := c.approvals[callID]; ok {
+		ch <- approved
+		delete(c.approvals, callID)
+	}
+}
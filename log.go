@@ -0,0 +1,67 @@
+package llm
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xyzj/toolbox/logger"
+)
+
+// Keys of the fields attached to the log records of the ChatsManager.
+const (
+	LogKeyChatID  = "chat_id" // Internal key of the chat, or its external id before it was mapped
+	LogKeyModel   = "model"   // Model of the request
+	LogKeyTool    = "tool"    // Name of the called tool
+	LogKeyLatency = "latency" // Duration of the request, tool call or turn
+	LogKeyError   = "error"   // Error of the failed operation
+)
+
+// Logger receives the structured log records of the ChatsManager, as a message followed
+// by alternating keys and values, see the LogKey constants. *slog.Logger implements it,
+// so records can be sent to any slog handler, e.g. JSON for log aggregation systems.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// nopLogger discards all records.
+var nopLogger Logger = slog.New(slog.DiscardHandler)
+
+// textLogger adapts a toolbox logger.Logger, writing each record as a single line of
+// the message followed by key=value pairs.
+type textLogger struct {
+	l logger.Logger
+}
+
+func (t textLogger) Debug(msg string, args ...any) { t.l.Debug(formatRecord(msg, args)) }
+func (t textLogger) Info(msg string, args ...any)  { t.l.Info(formatRecord(msg, args)) }
+func (t textLogger) Warn(msg string, args ...any)  { t.l.Warning(formatRecord(msg, args)) }
+func (t textLogger) Error(msg string, args ...any) { t.l.Error(formatRecord(msg, args)) }
+
+// formatRecord formats a message and its fields as "msg key=value ...", quoting values
+// containing spaces.
+func formatRecord(msg string, args []any) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		key, val := fmt.Sprint(args[i]), "!MISSING"
+		if i+1 < len(args) {
+			switch v := args[i+1].(type) {
+			case time.Duration:
+				val = v.String()
+			default:
+				val = fmt.Sprint(v)
+			}
+		}
+		if strings.ContainsAny(val, " \t\n\"=") {
+			val = strconv.Quote(val)
+		}
+		b.WriteString(" " + key + "=" + val)
+	}
+	return b.String()
+}
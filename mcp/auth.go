@@ -0,0 +1,80 @@
+package mcpcli
+
+import (
+	"context"
+	"maps"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+)
+
+type (
+	// OAuthConfig configures the OAuth authentication of a server, see WithOAuth.
+	OAuthConfig = transport.OAuthConfig
+	// OAuthToken is an OAuth token kept in a TokenStore.
+	OAuthToken = transport.Token
+	// TokenStore stores the OAuth token of a server, e.g. in a database shared by several
+	// instances. See NewMemoryTokenStore.
+	TokenStore = transport.TokenStore
+)
+
+// ErrAuthorizationRequired is returned when a server with OAuth has no valid token and
+// the stored refresh token, if any, was rejected, so the user has to authorize again.
+var ErrAuthorizationRequired = transport.ErrOAuthAuthorizationRequired
+
+// NewMemoryTokenStore returns a TokenStore keeping the token in memory, seeded with
+// token, e.g. obtained by the authorization flow of the application.
+func NewMemoryTokenStore(token *OAuthToken) TokenStore {
+	s := transport.NewMemoryTokenStore()
+	if token != nil {
+		s.SaveToken(context.Background(), token)
+	}
+	return s
+}
+
+// WithHeaders sets headers sent with every request to the server, e.g. an API key.
+func WithHeaders(headers map[string]string) ServerOpts {
+	return func(opt *ServerOpt) {
+		if opt.headers == nil {
+			opt.headers = make(map[string]string, len(headers))
+		}
+		maps.Copy(opt.headers, headers)
+	}
+}
+
+// WithBearerToken authenticates the requests to the server with a static bearer token.
+func WithBearerToken(token string) ServerOpts {
+	return WithHeaders(map[string]string{"Authorization": "Bearer " + token})
+}
+
+// WithTokenFunc authenticates the requests to the server with a bearer token returned by
+// f, which is called for every request, so short-lived tokens can be rotated, e.g. from
+// a secret manager or an OAuth client credentials grant. f should cache the token while
+// it is valid. If f fails, the request is sent without token and rejected by the server.
+func WithTokenFunc(f func(ctx context.Context) (string, error)) ServerOpts {
+	return func(opt *ServerOpt) {
+		opt.token = f
+	}
+}
+
+// WithOAuth authenticates the requests to the server with the OAuth token in the
+// TokenStore of cfg. Expired tokens are refreshed automatically with their refresh token
+// at the token endpoint of the server's authorization server, discovered from the server
+// unless cfg sets AuthServerMetadataURL; refreshed tokens are saved in the TokenStore.
+// Connecting fails with ErrAuthorizationRequired if there is no usable token.
+func WithOAuth(cfg OAuthConfig) ServerOpts {
+	return func(opt *ServerOpt) {
+		if cfg.TokenStore == nil {
+			cfg.TokenStore = transport.NewMemoryTokenStore()
+		}
+		opt.oauth = &cfg
+	}
+}
+
+// authHeader returns the Authorization header with the token of WithTokenFunc.
+func (opt *ServerOpt) authHeader(ctx context.Context) map[string]string {
+	token, err := opt.token(ctx)
+	if err != nil || token == "" {
+		return nil
+	}
+	return map[string]string{"Authorization": "Bearer " + token}
+}
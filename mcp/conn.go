@@ -0,0 +1,271 @@
+package mcpcli
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// acquire returns the connection of mc, connecting the server if necessary, and marks
+// it in use until release is called.
+func (m *McpClient) acquire(ctx context.Context, mc *mclient) (*client.Client, error) {
+	mc.locker.Lock()
+	defer mc.locker.Unlock()
+	if mc.removed {
+		return nil, fmt.Errorf("unknown mcp server [%s]", mc.uri)
+	}
+	if mc.broken {
+		// the connection was lost, connect again
+		m.disconnect(mc)
+	}
+	if mc.cli == nil {
+		if time.Now().Before(mc.retryAt) {
+			return nil, fmt.Errorf("%w: [%s], retrying in %s", ErrServerDown, mc.uri, time.Until(mc.retryAt).Round(time.Millisecond))
+		}
+		if err := m.open(ctx, mc); err != nil {
+			return nil, err
+		}
+	}
+	mc.inuse++
+	mc.lastUsed = time.Now()
+	return mc.cli, nil
+}
+
+// open connects the server of mc in a free connection slot, the caller must hold the
+// locker of mc.
+func (m *McpClient) open(ctx context.Context, mc *mclient) error {
+	if err := m.takeSlot(ctx); err != nil {
+		return err
+	}
+	cli, err := connect(ctx, mc, m.notified(mc))
+	if err != nil {
+		m.freeSlot()
+		mc.markDown()
+		return err
+	}
+	mc.cli = cli
+	mc.markUp()
+	return nil
+}
+
+// release marks a connection returned by acquire as no longer used.
+func (m *McpClient) release(mc *mclient) {
+	mc.locker.Lock()
+	defer mc.locker.Unlock()
+	mc.inuse--
+	mc.lastUsed = time.Now()
+	if mc.inuse == 0 && m.slots != nil {
+		m.notifyIdle()
+	}
+}
+
+// setLoaded records the outcome of a tool discovery.
+func (mc *mclient) setLoaded(ok bool) {
+	mc.locker.Lock()
+	defer mc.locker.Unlock()
+	mc.loaded = ok
+	if !ok {
+		mc.failed = time.Now()
+	}
+}
+
+// connect establishes and initializes a connection to the MCP server of mc, passing the
+// notifications of the server to notify.
+func connect(ctx context.Context, mc *mclient, notify func(n mcp.JSONRPCNotification)) (*client.Client, error) {
+	cli, err := newClient(mc.cnf, mc.uri)
+	if err != nil {
+		return nil, err
+	}
+	cli.OnNotification(notify)
+	// The connection outlives the request that opened it
+	if err = cli.Start(context.Background()); err != nil {
+		cli.Close()
+		return nil, err
+	}
+	// Initialize MCP connection with protocol negotiation
+	initRequest := mcp.InitializeRequest{}
+	initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	initRequest.Params.ClientInfo = mcp.Implementation{
+		Name:    "aiagent-cli",
+		Version: "1.0.0",
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, err = cli.Initialize(ctx, initRequest); err != nil {
+		cli.Close()
+		return nil, err
+	}
+	return cli, nil
+}
+
+// takeSlot reserves a connection slot if the number of connections is limited.
+// If all slots are taken, the least recently used idle connection is closed, or
+// takeSlot waits for a connection to become idle until ctx is done.
+func (m *McpClient) takeSlot(ctx context.Context) error {
+	if m.slots == nil {
+		return nil
+	}
+	for {
+		select {
+		case m.slots <- struct{}{}:
+			return nil
+		default:
+		}
+		// taken before closing, so connections released meanwhile are not missed
+		idle := m.idleSignal()
+		m.closeLRU()
+		select {
+		case m.slots <- struct{}{}:
+			return nil
+		case <-idle:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// idleSignal returns a channel closed when the next connection becomes idle.
+func (m *McpClient) idleSignal() <-chan struct{} {
+	m.idleLocker.Lock()
+	defer m.idleLocker.Unlock()
+	if m.idle == nil {
+		m.idle = make(chan struct{})
+	}
+	return m.idle
+}
+
+// notifyIdle wakes up the goroutines waiting for a connection slot.
+func (m *McpClient) notifyIdle() {
+	m.idleLocker.Lock()
+	defer m.idleLocker.Unlock()
+	if m.idle != nil {
+		close(m.idle)
+		m.idle = nil
+	}
+}
+
+// freeSlot releases a slot reserved by takeSlot.
+func (m *McpClient) freeSlot() {
+	if m.slots != nil {
+		<-m.slots
+	}
+}
+
+// disconnect closes the connection of mc, the caller must hold the locker of mc.
+func (m *McpClient) disconnect(mc *mclient) {
+	if mc.cli == nil {
+		return
+	}
+	mc.cli.Close()
+	mc.cli = nil
+	mc.broken = false
+	m.freeSlot()
+}
+
+// closeLRU closes the least recently used idle connection. Servers locked by other
+// goroutines, e.g. while connecting, are skipped to avoid lock order issues.
+func (m *McpClient) closeLRU() {
+	var lru *mclient
+	for _, mc := range m.servers() {
+		if !mc.locker.TryLock() {
+			continue
+		}
+		if mc.cli != nil && mc.inuse == 0 && (lru == nil || mc.lastUsed.Before(lru.lastUsed)) {
+			if lru != nil {
+				lru.locker.Unlock()
+			}
+			lru = mc
+			continue
+		}
+		mc.locker.Unlock()
+	}
+	if lru != nil {
+		m.disconnect(lru)
+		lru.locker.Unlock()
+	}
+}
+
+// closeIdle periodically closes connections unused for the configured idle timeout,
+// until the client is closed.
+func (m *McpClient) closeIdle() {
+	t := time.NewTicker(max(m.cnf.idleTimeout/2, time.Second))
+	defer t.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-t.C:
+		}
+		for _, mc := range m.servers() {
+			mc.locker.Lock()
+			if mc.inuse == 0 && time.Since(mc.lastUsed) > m.cnf.idleTimeout {
+				m.disconnect(mc)
+			}
+			mc.locker.Unlock()
+		}
+	}
+}
+
+// servers returns a snapshot of the registered servers keyed like clis, so the server
+// lockers can be taken without holding the client locker.
+func (m *McpClient) servers() map[string]*mclient {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	return maps.Clone(m.clis)
+}
+
+// probeInterval is how long the result of a reachability probe is reused, and how long
+// a server that failed to connect is not connected again by a probe.
+const probeInterval = 30 * time.Second
+
+// Reachable reports whether at least one MCP server is reachable. Connected servers are
+// pinged and disconnected ones connected, except servers that failed within the last
+// 30 seconds. The result is reused for 30 seconds, so calling Reachable on every chat
+// turn is cheap. Without servers Reachable returns false.
+func (m *McpClient) Reachable(ctx context.Context) bool {
+	m.probeLocker.Lock()
+	defer m.probeLocker.Unlock()
+	if !m.probed.IsZero() && time.Since(m.probed) < probeInterval {
+		return m.reachable
+	}
+	m.reachable = false
+	for _, mc := range m.servers() {
+		if m.probe(ctx, mc) {
+			m.reachable = true
+			break
+		}
+	}
+	m.probed = time.Now()
+	return m.reachable
+}
+
+// probe reports whether mc is reachable by pinging it, connecting it if necessary.
+func (m *McpClient) probe(ctx context.Context, mc *mclient) bool {
+	mc.locker.Lock()
+	skip := mc.cli == nil && !mc.down.IsZero() && time.Since(mc.down) < probeInterval
+	mc.locker.Unlock()
+	if skip {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	cli, err := m.acquire(ctx, mc)
+	if err != nil {
+		return false
+	}
+	err = cli.Ping(ctx)
+	m.release(mc)
+	if err != nil {
+		// the connection is broken, calls reconnect
+		mc.locker.Lock()
+		mc.broken = true
+		mc.markDown()
+		mc.locker.Unlock()
+		return false
+	}
+	return true
+}
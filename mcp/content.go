@@ -0,0 +1,129 @@
+package mcpcli
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xyzj/llm/provider"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/volcengine/volcengine-go-sdk/volcengine"
+	"github.com/xyzj/toolbox/json"
+)
+
+// ErrToolFailed is wrapped by the errors of tool calls whose result the server marked as
+// failed, see WithToolErrors.
+var ErrToolFailed = errors.New("mcp tool failed")
+
+// WithToolErrors makes calls whose result the server marked as failed return an error
+// wrapping ErrToolFailed with the text of the result. Without it such results are
+// returned as tool messages starting with an error line, so the model sees the failure
+// and can recover, e.g. by retrying with other arguments.
+func WithToolErrors() ClientOpts {
+	return func(opt *ClientOpt) {
+		opt.toolErrors = true
+	}
+}
+
+// WithImageResults passes the images returned by tools, as image content or embedded
+// image resources, to the model as image parts of the tool message. Use it only with
+// models accepting images in tool messages; without it images are described by a short
+// text placeholder instead.
+func WithImageResults() ClientOpts {
+	return func(opt *ClientOpt) {
+		opt.images = true
+	}
+}
+
+// resultMessage renders the content blocks of a tool result as the tool message of the
+// call: text as is, embedded text resources with their URI, and images as image parts
+// or placeholders, see WithImageResults. The structured content is used for results
+// without content blocks, and failed results start with an error line naming the tool.
+func (m *McpClient) resultMessage(tc *provider.ToolCall, result *mcp.CallToolResult) *provider.Message {
+	parts := make([]*provider.ContentPart, 0, len(result.Content))
+	text := func(s string) {
+		parts = append(parts, &provider.ContentPart{Type: provider.ContentPartText, Text: s})
+	}
+	image := func(mime, data string) {
+		if !m.cnf.images {
+			text(fmt.Sprintf("[image: %s, %d bytes]", mime, decodedLen(data)))
+			return
+		}
+		parts = append(parts, &provider.ContentPart{
+			Type:     provider.ContentPartImageURL,
+			ImageURL: &provider.ImageURL{URL: "data:" + mime + ";base64," + data},
+		})
+	}
+	for _, c := range result.Content {
+		switch c := c.(type) {
+		case mcp.TextContent:
+			text(c.Text)
+		case mcp.ImageContent:
+			image(c.MIMEType, c.Data)
+		case mcp.AudioContent:
+			text(fmt.Sprintf("[audio: %s, %d bytes]", c.MIMEType, decodedLen(c.Data)))
+		case mcp.ResourceLink:
+			link := fmt.Sprintf("[resource: %s (%s)]", c.Name, c.URI)
+			if c.Description != "" {
+				link += " " + c.Description
+			}
+			text(link)
+		case mcp.EmbeddedResource:
+			switch r := c.Resource.(type) {
+			case mcp.TextResourceContents:
+				text(fmt.Sprintf("resource %s:\n%s", r.URI, r.Text))
+			case mcp.BlobResourceContents:
+				if strings.HasPrefix(r.MIMEType, "image/") {
+					image(r.MIMEType, r.Blob)
+					continue
+				}
+				text(fmt.Sprintf("[resource %s: %s, %d bytes]", r.URI, r.MIMEType, decodedLen(r.Blob)))
+			}
+		}
+	}
+	if len(parts) == 0 && result.StructuredContent != nil {
+		if s, err := json.MarshalToString(result.StructuredContent); err == nil {
+			text(s)
+		}
+	}
+	if result.IsError {
+		parts = append([]*provider.ContentPart{{
+			Type: provider.ContentPartText,
+			Text: fmt.Sprintf("error: tool [%s] failed:", tc.Function.Name),
+		}}, parts...)
+	}
+	msg := &provider.Message{
+		Role:       provider.RoleTool,
+		ToolCallID: tc.ID,
+	}
+	texts := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p.Type != provider.ContentPartText {
+			// images need the multi-part content
+			msg.Content = &provider.MessageContent{ListValue: parts}
+			return msg
+		}
+		texts = append(texts, p.Text)
+	}
+	msg.Content = &provider.MessageContent{StringValue: volcengine.String(strings.Join(texts, "\n"))}
+	return msg
+}
+
+// toolError returns the failed result of tc as error, see WithToolErrors.
+func toolError(tc *provider.ToolCall, result *mcp.CallToolResult) error {
+	texts := make([]string, 0, len(result.Content))
+	for _, c := range result.Content {
+		if t, ok := c.(mcp.TextContent); ok {
+			texts = append(texts, t.Text)
+		}
+	}
+	return fmt.Errorf("%w: [%s] %s", ErrToolFailed, tc.Function.Name, strings.Join(texts, "\n"))
+}
+
+// decodedLen returns the number of bytes encoded by the base64 string s.
+func decodedLen(s string) int {
+	s = strings.TrimRight(s, "=")
+	return base64.RawStdEncoding.DecodedLen(len(s))
+}
@@ -0,0 +1,157 @@
+package mcpcli
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/xyzj/llm/provider"
+)
+
+// State is the connection state of an MCP server, see McpClient.Status.
+type State string
+
+const (
+	StateIdle      State = "idle"      // Not connected, connected on first use
+	StateConnected State = "connected" // Connected and answering
+	StateDown      State = "down"      // Connection failed or lost, reconnected with backoff
+)
+
+// ErrServerDown is returned by calls to a server that is down while its reconnection
+// backoff is running.
+var ErrServerDown = errors.New("mcp server is down")
+
+// maxBackoff is the longest delay between reconnection attempts of a server that is down.
+const maxBackoff = time.Minute
+
+// WithHealthCheck pings the connected servers every interval and reconnects servers that
+// are down, with exponential backoff from 1 second up to 1 minute between attempts.
+// The tools of servers that are down are left out of Tools until they are reconnected,
+// so the model is not offered tools that cannot be called. 0 disables the health check.
+func WithHealthCheck(interval time.Duration) ClientOpts {
+	return func(opt *ClientOpt) {
+		opt.healthCheck = interval
+	}
+}
+
+// Status returns the connection state of all MCP servers known to the client, keyed by
+// their URI.
+func (m *McpClient) Status() map[string]State {
+	clis := m.servers()
+	st := make(map[string]State, len(clis))
+	for _, mc := range clis {
+		mc.locker.Lock()
+		st[mc.uri] = mc.state()
+		mc.locker.Unlock()
+	}
+	return st
+}
+
+// state returns the connection state of mc, the caller must hold the locker of mc.
+func (mc *mclient) state() State {
+	switch {
+	case !mc.down.IsZero():
+		return StateDown
+	case mc.cli != nil:
+		return StateConnected
+	}
+	return StateIdle
+}
+
+// markDown records a failed connect or a lost connection and schedules the next
+// reconnection attempt, the caller must hold the locker of mc.
+func (mc *mclient) markDown() {
+	if mc.down.IsZero() {
+		mc.down = time.Now()
+	}
+	mc.retries++
+	mc.retryAt = time.Now().Add(min(time.Second<<min(mc.retries-1, 6), maxBackoff))
+}
+
+// markUp records a successful connect, the caller must hold the locker of mc.
+func (mc *mclient) markUp() {
+	mc.down = time.Time{}
+	mc.retries = 0
+	mc.retryAt = time.Time{}
+}
+
+// lost marks the connection cli of mc as broken after a transport error, so the next
+// acquire reconnects the server. Errors returned by the tools themselves and calls that
+// timed out or were cancelled are not connection failures.
+func (m *McpClient) lost(mc *mclient, cli *client.Client, err error) {
+	if terr := (*transport.Error)(nil); !errors.As(err, &terr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return
+	}
+	mc.locker.Lock()
+	defer mc.locker.Unlock()
+	if cli != nil && mc.cli == cli {
+		mc.broken = true
+		mc.markDown()
+	}
+}
+
+// healthCheck periodically checks the servers, see WithHealthCheck, until the client is
+// closed.
+func (m *McpClient) healthCheck() {
+	t := time.NewTicker(m.cnf.healthCheck)
+	defer t.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-t.C:
+		}
+		for key, mc := range m.servers() {
+			m.check(key, mc)
+		}
+	}
+}
+
+// check pings a connected server and reconnects a server that is down once its backoff
+// passed. Idle servers are left alone.
+func (m *McpClient) check(key string, mc *mclient) {
+	mc.locker.Lock()
+	st, due, loaded := mc.state(), !time.Now().Before(mc.retryAt), mc.loaded
+	mc.locker.Unlock()
+	if st == StateIdle || st == StateDown && !due {
+		return
+	}
+	if !loaded {
+		m.loadTools(key, mc)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cli, err := m.acquire(ctx, mc)
+	if err != nil {
+		return
+	}
+	defer m.release(mc)
+	if err = cli.Ping(ctx); err != nil {
+		mc.locker.Lock()
+		mc.broken = true
+		mc.markDown()
+		mc.locker.Unlock()
+	}
+}
+
+// available returns the tools whose server is not down.
+func (m *McpClient) available(tls []*provider.Tool) []*provider.Tool {
+	down := make(map[string]bool)
+	for key, mc := range m.servers() {
+		mc.locker.Lock()
+		down[key] = mc.state() == StateDown
+		mc.locker.Unlock()
+	}
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	out := make([]*provider.Tool, 0, len(tls))
+	for _, t := range tls {
+		if !down[m.idx[t.Function.Name].key] {
+			out = append(out, t)
+		}
+	}
+	return out
+}
@@ -10,22 +10,43 @@ package mcpcli
 import (
 	"context"
 	"fmt"
+	"maps"
+	"sync"
 	"time"
 
+	"github.com/xyzj/llm/provider"
+
 	"github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/mcp"
-	"github.com/volcengine/volcengine-go-sdk/service/arkruntime/model"
-	"github.com/volcengine/volcengine-go-sdk/volcengine"
 	"github.com/xyzj/toolbox/crypto"
 	"github.com/xyzj/toolbox/json"
 	"github.com/xyzj/toolbox/mapfx"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type (
+	// Opt configures a single tool call.
 	Opt struct {
 		timeout time.Duration
 	}
+	// Opts is a function type for configuring a tool call.
 	Opts func(opt *Opt)
+
+	// ClientOpt contains configuration options for creating a new McpClient.
+	ClientOpt struct {
+		maxConns    int             // Maximum number of open server connections, 0 for no limit
+		idleTimeout time.Duration   // Idle time after which a connection is closed, 0 to keep connections open
+		lazy        bool            // Whether servers are connected on first use instead of when added
+		healthCheck time.Duration   // Interval of the health checks, 0 to disable them
+		collision   CollisionPolicy // Handling of tools with the same name on several servers
+		images      bool            // Whether images of tool results are passed as image parts
+		toolErrors  bool            // Whether failed tool results are returned as errors
+		tracer      trace.Tracer    // Tracer of the tool calls, nil to record no spans
+
+		onToolsChanged []ToolsChangedFunc // Called after the tools of a server changed
+	}
+	// ClientOpts is a function type for configuring McpClient creation options.
+	ClientOpts func(opt *ClientOpt)
 )
 
 func WithTimeout(t time.Duration) Opts {
@@ -34,23 +55,84 @@ func WithTimeout(t time.Duration) Opts {
 	}
 }
 
+// WithLazyConnect defers connecting to the servers added with AddTools until their tools
+// are first needed, i.e. the first call of Tools or ReloadTools, reducing the startup time
+// of deployments with many configured servers. Servers are then connected in parallel.
+func WithLazyConnect() ClientOpts {
+	return func(opt *ClientOpt) {
+		opt.lazy = true
+	}
+}
+
+// WithMaxConnections limits the number of open server connections. When the limit is
+// reached, the least recently used idle connection is closed to connect another server,
+// and if all connections are busy, the connect waits for one to become idle.
+// The tools of disconnected servers stay available, calls reconnect automatically.
+// 0 disables the limit.
+func WithMaxConnections(n int) ClientOpts {
+	return func(opt *ClientOpt) {
+		opt.maxConns = n
+	}
+}
+
+// WithIdleTimeout closes server connections unused for d. The tools of disconnected
+// servers stay available, calls reconnect automatically. 0 keeps connections open.
+func WithIdleTimeout(d time.Duration) ClientOpts {
+	return func(opt *ClientOpt) {
+		opt.idleTimeout = d
+	}
+}
+
 // New creates a new McpClient instance for managing MCP server connections and tools.
 // The client can connect to multiple MCP servers and aggregate their tools into
 // a unified interface for AI models to use.
 //
+// Parameters:
+//   - opts: Optional configuration, e.g. WithLazyConnect or WithMaxConnections
+//
 // Returns a new McpClient ready to connect to MCP servers and manage tools.
-func New() *McpClient {
-	return &McpClient{
+func New(opts ...ClientOpts) *McpClient {
+	opt := &ClientOpt{}
+	for _, o := range opts {
+		o(opt)
+	}
+	m := &McpClient{
 		clis:  make(map[string]*mclient),
-		tools: mapfx.NewUniqueSlice[*model.Tool](),
+		idx:   make(map[string]route),
+		tools: mapfx.NewUniqueSlice[*provider.Tool](),
+		cnf:   opt,
+		done:  make(chan struct{}),
+	}
+	if opt.maxConns > 0 {
+		m.slots = make(chan struct{}, opt.maxConns)
 	}
+	if opt.idleTimeout > 0 {
+		go m.closeIdle()
+	}
+	if opt.healthCheck > 0 {
+		go m.healthCheck()
+	}
+	return m
 }
 
 // mclient represents a connection to a single MCP server.
-// It maintains the server URI and the active client connection.
+// It maintains the server URI and the client connection, which is nil while disconnected.
 type mclient struct {
-	uri string         // URI of the MCP server
-	cli *client.Client // Active client connection to the MCP server
+	locker   sync.Mutex     // Guards the fields below, held while connecting
+	uri      string         // URI of the MCP server
+	cnf      *ServerOpt     // Connection options of the server
+	cli      *client.Client // Active client connection to the MCP server, nil while disconnected
+	loaded   bool           // Whether the tools of the server were discovered
+	failed   time.Time      // Time of the last failed tool discovery
+	lastUsed time.Time      // Time the connection was last used
+	inuse    int            // Number of requests using the connection
+	down     time.Time      // Time the server last failed to connect or answer, zero while reachable
+	retries  int            // Failed connects and lost connections since the server was last up
+	retryAt  time.Time      // Earliest time of the next connect while the server is down
+	broken   bool           // Whether the connection was lost and is replaced by the next acquire
+	removed  bool           // Whether the server was removed, see RemoveServer
+
+	tools []*provider.Tool // Tools discovered on the server, guarded by the locker of the McpClient
 }
 
 // McpClient manages multiple MCP server connections and provides a unified
@@ -58,14 +140,41 @@ type mclient struct {
 //
 // Key features:
 //   - Multiple MCP server support with connection pooling
+//   - Optional lazy connect, connection limit and idle disconnect
 //   - Automatic tool discovery and schema conversion
 //   - Tool call routing to appropriate MCP servers
 //   - Deduplication of tools across servers
 //   - Connection lifecycle management with timeouts
 type McpClient struct {
-	clis  map[string]*mclient             // Map of MCP server connections (keyed by SHA1 hash of URI)
-	idx   map[string]string               // Tool name to server key mapping for routing
-	tools *mapfx.UniqueSlice[*model.Tool] // Deduplicated collection of available tools
+	locker sync.RWMutex                       // Guards clis and idx
+	clis   map[string]*mclient                // Map of MCP server connections (keyed by SHA1 hash of URI)
+	idx    map[string]route                   // Offered tool name to server and tool name mapping for routing
+	tools  *mapfx.UniqueSlice[*provider.Tool] // Deduplicated collection of available tools
+	cnf    *ClientOpt                         // Configuration options
+	slots  chan struct{}                      // One element per open connection, nil if unlimited
+
+	idleLocker sync.Mutex    // Guards idle
+	idle       chan struct{} // Closed when a connection becomes idle, see takeSlot
+
+	probeLocker sync.Mutex // Guards probed and reachable, serializes probes
+	probed      time.Time  // Time of the last reachability probe
+	reachable   bool       // Result of the last reachability probe
+
+	done      chan struct{} // Closed by Close to stop the background loops
+	closeOnce sync.Once     // Closes done once
+}
+
+// Close stops the idle disconnect and health check loops and removes all servers, closing
+// their connections, see RemoveServer. Calls running on the servers fail. Close can be
+// called more than once.
+func (m *McpClient) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.done)
+	})
+	for _, mc := range m.servers() {
+		m.RemoveServer(mc.uri)
+	}
+	return nil
 }
 
 // Call executes a tool call through the appropriate MCP server and returns the result
@@ -76,15 +185,22 @@ type McpClient struct {
 //  1. Parse tool call arguments from JSON
 //  2. Route to appropriate MCP server based on tool name
 //  3. Execute tool call with timeout protection
-//  4. Format result as chat completion message for AI model consumption
+//  4. Render the content blocks of the result as a chat completion message, see WithImageResults
 //
 // Parameters:
 //   - tc: Tool call containing function name, arguments, and call ID
 //
 // Returns:
-//   - *model.ChatCompletionMessage: Formatted tool result message
-//   - error: Any error during argument parsing, routing, or execution
-func (m *McpClient) Call(tc *model.ToolCall, opts ...Opts) (*model.ChatCompletionMessage, error) {
+//   - *provider.Message: Formatted tool result message
+//   - error: Any error during argument parsing, routing, or execution, and failed
+//     results with WithToolErrors
+func (m *McpClient) Call(tc *provider.ToolCall, opts ...Opts) (*provider.Message, error) {
+	return m.CallContext(context.Background(), tc, opts...)
+}
+
+// CallContext is like Call, but the tool call is also cancelled when ctx is done.
+// The timeout option limits the call in addition to the deadline of ctx.
+func (m *McpClient) CallContext(ctx context.Context, tc *provider.ToolCall, opts ...Opts) (msg *provider.Message, err error) {
 	co := Opt{
 		timeout: 60 * time.Second,
 	}
@@ -92,35 +208,97 @@ func (m *McpClient) Call(tc *model.ToolCall, opts ...Opts) (*model.ChatCompletio
 		o(&co)
 	}
 	var arg = make(map[string]any)
-	err := json.UnmarshalFromString(tc.Function.Arguments, &arg)
+	err = json.UnmarshalFromString(tc.Function.Arguments, &arg)
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), co.timeout)
+	m.locker.RLock()
+	rt := m.idx[tc.Function.Name]
+	mc, ok := m.clis[rt.key]
+	m.locker.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w [%s]", provider.ErrToolNotFound, tc.Function.Name)
+	}
+	ctx, span := m.startCall(ctx, mc.uri, rt.name)
+	defer func() { endCall(span, err) }()
+	ctx, cancel := context.WithTimeout(ctx, co.timeout)
 	defer cancel()
+	cli, err := m.acquire(ctx, mc)
+	if err != nil {
+		return nil, err
+	}
+	defer m.release(mc)
 	request := mcp.CallToolRequest{}
-	request.Params.Name = tc.Function.Name
+	request.Params.Name = rt.name
 	request.Params.Arguments = arg
-	result, err := m.clis[m.idx[tc.Function.Name]].cli.CallTool(ctx, request)
+	result, err := cli.CallTool(ctx, request)
+	if sessionLost(err) {
+		// the server dropped the session, continue in a new one
+		if cli, err = m.resume(ctx, mc, cli); err == nil {
+			result, err = cli.CallTool(ctx, request)
+		}
+	}
 	if err != nil {
+		m.lost(mc, cli, err)
 		return nil, err
 	}
-	return &model.ChatCompletionMessage{
-		Role:       model.ChatMessageRoleTool,
-		Content:    &model.ChatCompletionMessageContent{StringValue: volcengine.String(fmt.Sprint(result.Content))},
-		ToolCallID: tc.ID,
-	}, nil
+	if result.IsError && m.cnf.toolErrors {
+		return nil, toolError(tc, result)
+	}
+	return m.resultMessage(tc, result), nil
 }
 
-// Tools returns all available tools from connected MCP servers.
+// Tools returns all available tools from the MCP servers.
 // The tools are deduplicated and formatted for use with AI language models.
+// Tools of servers not discovered yet, e.g. with WithLazyConnect, are discovered first;
+// servers failing discovery are retried after a minute.
 //
 // Returns:
-//   - []*model.Tool: Slice of all available tools across all connected MCP servers
-func (m *McpClient) Tools() []*model.Tool {
+//   - []*provider.Tool: Slice of all available tools across all MCP servers
+func (m *McpClient) Tools() []*provider.Tool {
+	m.discoverPending()
+	if m.cnf.healthCheck > 0 {
+		return m.available(m.tools.Slice())
+	}
 	return m.tools.Slice()
 }
 
+// ServerInfo describes a connected MCP server.
+type ServerInfo struct {
+	URI       string    `json:"uri"`       // URI of the MCP server
+	Transport Transport `json:"transport"` // Transport used to connect the server
+	State     State     `json:"state"`     // Connection state
+	Tools     int       `json:"tools"`     // Number of tools routed to this server
+	Connected bool      `json:"connected"` // Whether the client connection is initialized
+}
+
+// Servers returns the status of all MCP servers known to the client.
+func (m *McpClient) Servers() []ServerInfo {
+	clis := m.servers()
+	m.locker.RLock()
+	idx := maps.Clone(m.idx)
+	m.locker.RUnlock()
+	ss := make([]ServerInfo, 0, len(clis))
+	for key, cli := range clis {
+		cli.locker.Lock()
+		t, _ := cli.cnf.endpoint(cli.uri)
+		si := ServerInfo{
+			URI:       cli.uri,
+			Transport: t,
+			State:     cli.state(),
+			Connected: cli.cli != nil && cli.cli.IsInitialized(),
+		}
+		cli.locker.Unlock()
+		for _, r := range idx {
+			if r.key == key {
+				si.Tools++
+			}
+		}
+		ss = append(ss, si)
+	}
+	return ss
+}
+
 // ToolCount returns the number of elements in the tools collection managed by the McpClient.
 func (m *McpClient) ToolCount() int {
 	return m.tools.Len()
@@ -128,107 +306,202 @@ func (m *McpClient) ToolCount() int {
 
 // AddTools connects to an MCP server at the specified URI and loads its available tools.
 // The tools are automatically integrated into the client's unified tool collection.
+// With WithLazyConnect, the server is only registered and connected on first use.
 // Empty URIs are ignored without error.
 //
 // Parameters:
-//   - mcpUri: URI of the MCP server to connect to (e.g., "stdio://path/to/server")
+//   - mcpUri: URI of the MCP server to connect to (e.g., "https+mcp://host/mcp")
+//   - opts: Optional connection options of the server, e.g. WithTransport
 //
 // Returns:
 //   - error: Any error encountered during connection or tool loading
-func (m *McpClient) AddTools(mcpUri string) error {
+func (m *McpClient) AddTools(mcpUri string, opts ...ServerOpts) error {
 	if mcpUri == "" {
 		return nil
 	}
-	_, err := m.loadTools(mcpUri)
+	opt := &ServerOpt{}
+	for _, o := range opts {
+		o(opt)
+	}
+	clikey := crypto.GetSHA1(mcpUri)
+	m.locker.Lock()
+	mc, ok := m.clis[clikey]
+	if !ok {
+		mc = &mclient{uri: mcpUri, cnf: opt}
+		m.clis[clikey] = mc
+	}
+	m.locker.Unlock()
+	if m.cnf.lazy {
+		return nil
+	}
+	_, err := m.loadTools(clikey, mc)
+	if err != nil && !ok {
+		m.locker.Lock()
+		delete(m.clis, clikey)
+		m.locker.Unlock()
+	}
 	return err
 }
 
-// ReloadTools refreshes the tool list from all connected MCP servers.
+// RemoveServer disconnects an MCP server added with AddTools and removes its tools and
+// routing entries. Calls running on the server fail.
+//
+// Parameters:
+//   - mcpUri: URI of the server as passed to AddTools
+//
+// Returns:
+//   - error: If no server with the URI was added
+func (m *McpClient) RemoveServer(mcpUri string) error {
+	clikey := crypto.GetSHA1(mcpUri)
+	m.locker.Lock()
+	mc, ok := m.clis[clikey]
+	if !ok {
+		m.locker.Unlock()
+		return fmt.Errorf("unknown mcp server [%s]", mcpUri)
+	}
+	delete(m.clis, clikey)
+	for _, t := range mc.tools {
+		m.tools.Delete(t)
+	}
+	mc.tools = nil
+	for name, r := range m.idx {
+		if r.key == clikey {
+			delete(m.idx, name)
+		}
+	}
+	m.locker.Unlock()
+	mc.locker.Lock()
+	defer mc.locker.Unlock()
+	mc.removed = true
+	m.disconnect(mc)
+	return nil
+}
+
+// ReloadTools refreshes the tool list from all MCP servers.
 // This is useful when MCP servers have been updated or when tool availability changes.
-// The method clears the current tool collection and rebuilds it from all active connections.
+// The method clears the current tool collection and rebuilds it from all servers,
+// connecting disconnected servers.
 //
 // Returns:
-//   - []*model.Tool: Updated list of all available tools
+//   - []*provider.Tool: Updated list of all available tools
 //   - error: Any error encountered during tool reloading (individual server failures are ignored)
-func (m *McpClient) ReloadTools() ([]*model.Tool, error) {
-	if m.tools != nil {
-		m.tools.Clear()
-	} else {
-		m.tools = mapfx.NewUniqueSlice[*model.Tool]()
-	}
-	for _, cli := range m.clis {
-		mt, err := m.loadTools(cli.uri)
-		if err == nil {
-			m.tools.StoreMany(mt...)
+func (m *McpClient) ReloadTools() ([]*provider.Tool, error) {
+	m.locker.Lock()
+	m.tools.Clear()
+	clear(m.idx)
+	m.locker.Unlock()
+	for _, mc := range m.servers() {
+		mc.locker.Lock()
+		mc.loaded = false
+		mc.failed = time.Time{}
+		mc.locker.Unlock()
+	}
+	m.discoverPending()
+	return m.tools.Slice(), nil
+}
+
+// discoverPending loads the tools of all servers whose tools were not discovered yet,
+// in parallel. Servers whose discovery failed within the last minute are skipped.
+func (m *McpClient) discoverPending() {
+	pending := make(map[string]*mclient)
+	for key, mc := range m.servers() {
+		mc.locker.Lock()
+		if !mc.loaded && time.Since(mc.failed) > time.Minute {
+			pending[key] = mc
 		}
+		mc.locker.Unlock()
+	}
+	wg := sync.WaitGroup{}
+	for key, mc := range pending {
+		wg.Go(func() {
+			m.loadTools(key, mc)
+		})
 	}
-	return m.Tools(), nil
+	wg.Wait()
 }
 
-// loadTools establishes a connection to an MCP server and loads its available tools.
-// This method handles the complete MCP connection lifecycle including:
-//   - Connection establishment and initialization
-//   - Protocol version negotiation
-//   - Tool discovery and schema conversion
-//   - Tool registration and routing setup
-//
+// loadTools connects to an MCP server if necessary and loads its available tools.
 // The method converts MCP tool schemas to the format expected by AI language models
 // and maintains routing information for tool call execution.
 //
 // Parameters:
-//   - mcpUri: URI of the MCP server to connect to
+//   - clikey: Key of the server in the client map
+//   - mc: The server to load the tools from
 //
 // Returns:
-//   - []*model.Tool: List of tools loaded from the server
+//   - []*provider.Tool: List of tools loaded from the server
 //   - error: Any error during connection, initialization, or tool loading
-func (m *McpClient) loadTools(mcpUri string) ([]*model.Tool, error) {
-	var err error
-	clikey := crypto.GetSHA1(mcpUri)
-	cli, ok := m.clis[clikey]
-	if !ok {
-		cli.cli, err = client.NewSSEMCPClient(cli.uri)
-		if err != nil {
-			return nil, err
-		}
-		// Initialize MCP connection with protocol negotiation
-		initRequest := mcp.InitializeRequest{}
-		initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
-		initRequest.Params.ClientInfo = mcp.Implementation{
-			Name:    "aiagent-cli",
-			Version: "1.0.0",
-		}
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		_, err = cli.cli.Initialize(ctx, initRequest)
-		if err != nil {
-			return nil, err
-		}
-		m.clis[clikey] = cli
-	}
+func (m *McpClient) loadTools(clikey string, mc *mclient) ([]*provider.Tool, error) {
 	// Discover available tools from the MCP server
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
+	cli, err := m.acquire(ctx, mc)
+	if err != nil {
+		mc.setLoaded(false)
+		return nil, err
+	}
+	defer m.release(mc)
 	toolsRequest := mcp.ListToolsRequest{}
-	listToolsResult, err := cli.cli.ListTools(ctx, toolsRequest)
+	listToolsResult, err := cli.ListTools(ctx, toolsRequest)
+	if sessionLost(err) {
+		if cli, err = m.resume(ctx, mc, cli); err == nil {
+			listToolsResult, err = cli.ListTools(ctx, toolsRequest)
+		}
+	}
 	if err != nil {
+		m.lost(mc, cli, err)
+		mc.setLoaded(false)
 		return nil, err
 	}
 	// Convert MCP tool schemas to AI model tool format
+	tls := make([]*provider.Tool, 0, len(listToolsResult.Tools))
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if _, ok := m.clis[clikey]; !ok {
+		// removed while loading, see RemoveServer
+		return nil, fmt.Errorf("unknown mcp server [%s]", mc.uri)
+	}
+	names := make([]string, 0, len(listToolsResult.Tools))
 	for _, mcptool := range listToolsResult.Tools {
+		names = append(names, mc.cnf.toolName(mcptool.Name))
+	}
+	if err = m.collisions(clikey, names); err != nil && m.cnf.collision == CollisionError {
+		mc.setLoaded(false)
+		return nil, err
+	}
+	// replace the tools of an earlier discovery
+	for _, t := range mc.tools {
+		m.tools.Delete(t)
+		delete(m.idx, t.Function.Name)
+	}
+	for i, mcptool := range listToolsResult.Tools {
+		name := names[i]
+		if _, ok := m.idx[name]; ok {
+			if m.cnf.collision == CollisionKeepFirst {
+				continue
+			}
+			m.unroute(name)
+		}
 		var param = map[string]any{
 			"type":       "object",
 			"properties": mcptool.InputSchema.Properties,
 		}
-		vt := &model.Tool{
-			Type: model.ToolTypeFunction,
-			Function: &model.FunctionDefinition{
-				Name:        mcptool.Name,
+		if len(mcptool.InputSchema.Required) > 0 {
+			param["required"] = mcptool.InputSchema.Required
+		}
+		vt := &provider.Tool{
+			Type: provider.ToolTypeFunction,
+			Function: &provider.FunctionDefinition{
+				Name:        name,
 				Description: mcptool.Description,
 				Parameters:  param,
 			},
 		}
-		m.idx[mcptool.Name] = clikey
+		m.idx[name] = route{key: clikey, name: mcptool.Name}
 		m.tools.Store(vt)
+		tls = append(tls, vt)
 	}
-	return m.tools.Slice(), nil
+	mc.tools = tls
+	mc.setLoaded(true)
+	return tls, nil
 }
@@ -0,0 +1,112 @@
+package mcpcli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CollisionPolicy decides what happens when a server offers a tool whose name is already
+// routed to another server, see WithCollisionPolicy.
+type CollisionPolicy int
+
+const (
+	// CollisionOverride routes the name to the server discovered last, dropping the tool
+	// of the other server.
+	CollisionOverride CollisionPolicy = iota
+	// CollisionKeepFirst keeps the tool of the server discovered first and ignores the
+	// tools of later servers with the same name.
+	CollisionKeepFirst
+	// CollisionError refuses the tools of a server colliding with tools of other
+	// servers, AddTools then fails with ErrToolCollision.
+	CollisionError
+)
+
+// ErrToolCollision is returned by AddTools with CollisionError if a tool of the server
+// has the same name as a tool of another server.
+var ErrToolCollision = errors.New("mcp tool name collision")
+
+// route is the destination of a tool call.
+type route struct {
+	key  string // Key of the server in the client map
+	name string // Name of the tool on the server
+}
+
+// WithCollisionPolicy sets what happens when servers offer tools with the same name,
+// see CollisionPolicy. Collisions are best avoided with WithAlias. Defaults to
+// CollisionOverride.
+func WithCollisionPolicy(p CollisionPolicy) ClientOpts {
+	return func(opt *ClientOpt) {
+		opt.collision = p
+	}
+}
+
+// WithAlias namespaces the tools of the server: they are offered to the model as
+// alias_name, e.g. "github_search", and called on the server under their own name.
+// The alias should only contain letters, digits, underscores and dashes, like the tool
+// names accepted by the model APIs.
+func WithAlias(alias string) ServerOpts {
+	return func(opt *ServerOpt) {
+		opt.alias = alias
+	}
+}
+
+// toolName returns the name a tool of the server is offered under.
+func (opt *ServerOpt) toolName(name string) string {
+	if opt.alias == "" {
+		return name
+	}
+	return opt.alias + "_" + name
+}
+
+// ToolOrigin returns the URI of the server a tool is routed to.
+//
+// Parameters:
+//   - name: Name of the tool as offered to the model
+//
+// Returns:
+//   - string: URI of the server as passed to AddTools
+//   - bool: false if no server offers the tool
+func (m *McpClient) ToolOrigin(name string) (string, bool) {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	mc, ok := m.clis[m.idx[name].key]
+	if !ok {
+		return "", false
+	}
+	return mc.uri, true
+}
+
+// collisions returns the offered names of tools that are routed to servers other than
+// clikey, the caller must hold the locker of the client.
+func (m *McpClient) collisions(clikey string, names []string) error {
+	taken := make([]string, 0)
+	for _, name := range names {
+		if r, ok := m.idx[name]; ok && r.key != clikey {
+			taken = append(taken, fmt.Sprintf("%s (%s)", name, m.clis[r.key].uri))
+		}
+	}
+	if len(taken) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrToolCollision, strings.Join(taken, ", "))
+}
+
+// unroute drops the tool routed under name from the server it is routed to, the caller
+// must hold the locker of the client.
+func (m *McpClient) unroute(name string) {
+	r, ok := m.idx[name]
+	if !ok {
+		return
+	}
+	delete(m.idx, name)
+	if mc, ok := m.clis[r.key]; ok {
+		for i, t := range mc.tools {
+			if t.Function.Name == name {
+				m.tools.Delete(t)
+				mc.tools = append(mc.tools[:i:i], mc.tools[i+1:]...)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package mcpcli
+
+import (
+	"github.com/xyzj/llm/provider"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/xyzj/toolbox/crypto"
+)
+
+// ToolsChangedFunc is called after the tools of a server were refreshed because the
+// server announced a change of its tool list, see WithToolsChanged.
+//
+// Parameters:
+//   - uri: URI of the server as passed to AddTools
+//   - tools: Tools now offered by the server, nil if the refresh failed
+//   - err: Any error refreshing the tools, the earlier tools of the server are kept then
+type ToolsChangedFunc func(uri string, tools []*provider.Tool, err error)
+
+// WithToolsChanged registers f to be called whenever the tools of a server were refreshed
+// on its notifications/tools/list_changed notification. The client refreshes the tools
+// of the announcing server on its own, so later calls of Tools return the new list
+// without ReloadTools; f is for reacting to the change, e.g. logging it.
+func WithToolsChanged(f ToolsChangedFunc) ClientOpts {
+	return func(opt *ClientOpt) {
+		if f != nil {
+			opt.onToolsChanged = append(opt.onToolsChanged, f)
+		}
+	}
+}
+
+// notified returns the handler of the notifications received on the connection of mc.
+func (m *McpClient) notified(mc *mclient) func(n mcp.JSONRPCNotification) {
+	return func(n mcp.JSONRPCNotification) {
+		if n.Method == mcp.MethodNotificationToolsListChanged {
+			// the handler runs on the reader of the connection, which the refresh needs
+			go m.refresh(mc)
+		}
+	}
+}
+
+// refresh reloads the tools of mc and reports them to the ToolsChangedFuncs.
+func (m *McpClient) refresh(mc *mclient) {
+	tls, err := m.loadTools(crypto.GetSHA1(mc.uri), mc)
+	mc.locker.Lock()
+	removed := mc.removed
+	mc.locker.Unlock()
+	if removed {
+		return
+	}
+	for _, f := range m.cnf.onToolsChanged {
+		f(mc.uri, tls, err)
+	}
+}
@@ -0,0 +1,119 @@
+package mcpcli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/xyzj/llm/provider"
+)
+
+// SchemaFormat is an output format of ExportSchemas.
+type SchemaFormat string
+
+const (
+	// SchemaJSON is a JSON array of tools in the OpenAI function-calling format,
+	// as sent to the model.
+	SchemaJSON SchemaFormat = "json"
+	// SchemaMarkdown is a human-readable catalogue of the tools grouped by server,
+	// with a parameter table per tool.
+	SchemaMarkdown SchemaFormat = "markdown"
+)
+
+// ExportSchemas writes the aggregated tool list of all MCP servers to w, so the
+// capabilities granted to the assistant can be reviewed. Tools are sorted by name;
+// tools of servers not discovered yet are discovered first, see Tools.
+//
+// Parameters:
+//   - w: Destination of the export
+//   - format: SchemaJSON or SchemaMarkdown
+//
+// Returns:
+//   - error: If the format is unknown or writing fails
+func (m *McpClient) ExportSchemas(w io.Writer, format SchemaFormat) error {
+	tools := m.Tools()
+	slices.SortFunc(tools, func(a, b *provider.Tool) int {
+		return strings.Compare(a.Function.Name, b.Function.Name)
+	})
+	switch format {
+	case SchemaJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tools)
+	case SchemaMarkdown:
+		return m.writeMarkdown(w, tools)
+	default:
+		return fmt.Errorf("unknown schema format [%s]", format)
+	}
+}
+
+// writeMarkdown writes the markdown catalogue of tools, grouped by the URI of their server.
+func (m *McpClient) writeMarkdown(w io.Writer, tools []*provider.Tool) error {
+	clis := m.servers()
+	m.locker.RLock()
+	byServer := make(map[string][]*provider.Tool)
+	for _, t := range tools {
+		uri := "unknown server"
+		if mc, ok := clis[m.idx[t.Function.Name].key]; ok {
+			uri = mc.uri
+		}
+		byServer[uri] = append(byServer[uri], t)
+	}
+	m.locker.RUnlock()
+	var b strings.Builder
+	fmt.Fprintf(&b, "# MCP tools\n\n%d tools from %d servers.\n", len(tools), len(byServer))
+	for _, uri := range slices.Sorted(maps.Keys(byServer)) {
+		fmt.Fprintf(&b, "\n## %s\n", uri)
+		for _, t := range byServer[uri] {
+			fmt.Fprintf(&b, "\n### `%s`\n\n", t.Function.Name)
+			if t.Function.Description != "" {
+				b.WriteString(strings.TrimSpace(t.Function.Description) + "\n\n")
+			}
+			writeParams(&b, t.Function.Parameters)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeParams writes the parameter table of a JSON schema object.
+func writeParams(b *strings.Builder, params any) {
+	schema, _ := params.(map[string]any)
+	props, _ := schema["properties"].(map[string]any)
+	if len(props) == 0 {
+		b.WriteString("No parameters.\n")
+		return
+	}
+	required := make(map[string]bool)
+	switch rs := schema["required"].(type) {
+	case []string:
+		for _, r := range rs {
+			required[r] = true
+		}
+	case []any:
+		for _, r := range rs {
+			if s, ok := r.(string); ok {
+				required[s] = true
+			}
+		}
+	}
+	b.WriteString("| Parameter | Type | Required | Description |\n|---|---|---|---|\n")
+	for _, name := range slices.Sorted(maps.Keys(props)) {
+		p, _ := props[name].(map[string]any)
+		typ, _ := p["type"].(string)
+		desc, _ := p["description"].(string)
+		req := ""
+		if required[name] {
+			req = "yes"
+		}
+		fmt.Fprintf(b, "| `%s` | %s | %s | %s |\n", name, typ, req, mdCell(desc))
+	}
+}
+
+// mdCell escapes text for a markdown table cell.
+func mdCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\r\n", " ", "\n", " ").Replace(strings.TrimSpace(s))
+}
@@ -0,0 +1,47 @@
+package mcpcli
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the instrumentation scope of the spans of the package.
+const tracerName = "github.com/xyzj/llm/mcp"
+
+// WithTracerProvider records an OpenTelemetry span for every tool call, named
+// "tools/call <tool>" with the server URI and the tool name, so the calls appear in the
+// trace of the chat turn making them. The connect of a disconnected server is part of
+// the span.
+func WithTracerProvider(tp trace.TracerProvider) ClientOpts {
+	return func(opt *ClientOpt) {
+		if tp != nil {
+			opt.tracer = tp.Tracer(tracerName)
+		}
+	}
+}
+
+// startCall starts the span of a call of the tool name on the server uri.
+func (m *McpClient) startCall(ctx context.Context, uri, name string) (context.Context, trace.Span) {
+	tracer := m.cnf.tracer
+	if tracer == nil {
+		tracer = noop.Tracer{}
+	}
+	return tracer.Start(ctx, "tools/call "+name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("mcp.method.name", "tools/call"),
+		attribute.String("gen_ai.tool.name", name),
+		attribute.String("llm.mcp.server", uri),
+	))
+}
+
+// endCall records err on span and ends it.
+func endCall(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,116 @@
+package mcpcli
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+)
+
+// Transport is the protocol used to connect an MCP server.
+type Transport string
+
+const (
+	// TransportSSE is the HTTP+SSE transport of the 2024-11-05 MCP specification.
+	TransportSSE Transport = "sse"
+	// TransportStreamableHTTP is the streamable HTTP transport, the recommended remote
+	// transport since the 2025-03-26 MCP specification.
+	TransportStreamableHTTP Transport = "streamable-http"
+)
+
+type (
+	// ServerOpt contains the connection options of a single MCP server.
+	ServerOpt struct {
+		transport Transport                                 // Transport of the server, empty to derive it from the URI
+		headers   map[string]string                         // Headers sent with every request
+		token     func(ctx context.Context) (string, error) // Source of the bearer token of every request
+		oauth     *OAuthConfig                              // OAuth configuration, nil without OAuth
+		alias     string                                    // Prefix of the offered tool names, see WithAlias
+	}
+	// ServerOpts is a function type for configuring the connection to an MCP server.
+	ServerOpts func(opt *ServerOpt)
+)
+
+// WithTransport sets the transport used to connect the server. Without it, URIs with
+// the scheme http+mcp:// or https+mcp:// use the streamable HTTP transport and all
+// other URIs the SSE transport.
+func WithTransport(t Transport) ServerOpts {
+	return func(opt *ServerOpt) {
+		opt.transport = t
+	}
+}
+
+// endpoint returns the transport and the URL used to connect uri.
+func (opt *ServerOpt) endpoint(uri string) (Transport, string) {
+	for _, scheme := range []string{"http", "https"} {
+		if rest, ok := strings.CutPrefix(uri, scheme+"+mcp://"); ok {
+			uri = scheme + "://" + rest
+			if opt.transport == "" {
+				return TransportStreamableHTTP, uri
+			}
+		}
+	}
+	if opt.transport == "" {
+		return TransportSSE, uri
+	}
+	return opt.transport, uri
+}
+
+// newClient creates the client of a server for its transport and authentication.
+// The connection is established by Start.
+func newClient(opt *ServerOpt, uri string) (*client.Client, error) {
+	t, url := opt.endpoint(uri)
+	if t == TransportStreamableHTTP {
+		// the transport manages the Mcp-Session-Id of the connection, notifications outside
+		// of requests are received on a separate listening stream
+		opts := []transport.StreamableHTTPCOption{transport.WithHTTPHeaders(opt.headers), transport.WithContinuousListening()}
+		if opt.token != nil {
+			opts = append(opts, transport.WithHTTPHeaderFunc(opt.authHeader))
+		}
+		if opt.oauth != nil {
+			opts = append(opts, transport.WithHTTPOAuth(*opt.oauth))
+		}
+		return client.NewStreamableHttpClient(url, opts...)
+	}
+	opts := []transport.ClientOption{transport.WithHeaders(opt.headers)}
+	if opt.token != nil {
+		opts = append(opts, transport.WithHeaderFunc(opt.authHeader))
+	}
+	if opt.oauth != nil {
+		opts = append(opts, transport.WithOAuth(*opt.oauth))
+	}
+	return client.NewSSEMCPClient(url, opts...)
+}
+
+// resume replaces a connection whose session the server terminated, e.g. after a
+// restart of a streamable HTTP server, by a new connection with a new session.
+// Connections already replaced by another caller are returned as they are.
+func (m *McpClient) resume(ctx context.Context, mc *mclient, old *client.Client) (*client.Client, error) {
+	mc.locker.Lock()
+	defer mc.locker.Unlock()
+	if mc.cli == old {
+		m.disconnect(mc)
+	}
+	if mc.cli == nil {
+		if err := m.open(ctx, mc); err != nil {
+			return nil, err
+		}
+	}
+	return mc.cli, nil
+}
+
+// sessionLost reports whether err means the server no longer knows the session of the
+// connection. The specification answers such requests with status 404, some servers,
+// e.g. those built with mcp-go, with status 400.
+func sessionLost(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, transport.ErrSessionTerminated) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "status 400") && strings.Contains(strings.ToLower(msg), "session")
+}
@@ -0,0 +1,176 @@
+// Package metrics exports Prometheus metrics of chat managers: completion requests, token
+// usage and latency, time to the first streamed token, tool calls, active chats and
+// storage latency, see llm.WithMetrics. A Metrics is a prometheus.Collector, so it can be
+// registered with an existing registry or served on its own with Handler.
+package metrics
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type (
+	// Opt configures the metrics created by New.
+	Opt struct {
+		namespace string            // Prefix of the metric names
+		labels    prometheus.Labels // Constant labels of all metrics
+		buckets   []float64         // Buckets of the latency histograms in seconds
+	}
+	// Opts is a function type for configuring the metrics.
+	Opts func(opt *Opt)
+)
+
+// WithNamespace sets the prefix of the metric names, e.g. "llm_completions_total".
+// Defaults to "llm".
+func WithNamespace(ns string) Opts {
+	return func(opt *Opt) {
+		opt.namespace = ns
+	}
+}
+
+// WithConstLabels adds labels with fixed values to all metrics, e.g. to tell several
+// managers registered with one registry apart.
+func WithConstLabels(labels map[string]string) Opts {
+	return func(opt *Opt) {
+		opt.labels = labels
+	}
+}
+
+// WithBuckets sets the buckets of the latency histograms in seconds. Defaults to buckets
+// from 5ms to 2 minutes, covering storage operations as well as long completions.
+func WithBuckets(buckets ...float64) Opts {
+	return func(opt *Opt) {
+		if len(buckets) > 0 {
+			opt.buckets = buckets
+		}
+	}
+}
+
+// Metrics holds the Prometheus metrics of a chat manager. It is safe for concurrent use.
+type Metrics struct {
+	completions *prometheus.CounterVec     // Completion requests by model and status
+	tokens      *prometheus.CounterVec     // Tokens by model and type
+	latency     *prometheus.HistogramVec   // Completion latency by model
+	firstToken  *prometheus.HistogramVec   // Time to the first streamed token by model
+	toolCalls   *prometheus.HistogramVec   // Tool call duration by tool
+	toolErrors  *prometheus.CounterVec     // Failed tool calls by tool
+	storage     *prometheus.HistogramVec   // Storage operation latency by operation and status
+	active      prometheus.GaugeFunc       // Chat sessions held in memory
+	activeFunc  atomic.Pointer[func() int] // Reports the chat sessions held in memory, see ActiveChats
+}
+
+// New creates the metrics of a chat manager.
+//
+// Parameters:
+//   - opts: Optional configuration, e.g. WithNamespace or WithConstLabels
+//
+// Example:
+//
+//	m := metrics.New()
+//	prometheus.MustRegister(m)
+//	cm := llm.NewChatsManager(llm.WithMetrics(m))
+func New(opts ...Opts) *Metrics {
+	opt := &Opt{
+		namespace: "llm",
+		buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 40, 80, 120},
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	m := &Metrics{
+		completions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opt.namespace, Name: "completions_total", ConstLabels: opt.labels,
+			Help: "Completion requests sent to the provider by model and status.",
+		}, []string{"model", "status"}),
+		tokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opt.namespace, Name: "tokens_total", ConstLabels: opt.labels,
+			Help: "Tokens reported by the provider by model and type (prompt, cached, completion).",
+		}, []string{"model", "type"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opt.namespace, Name: "completion_duration_seconds", ConstLabels: opt.labels, Buckets: opt.buckets,
+			Help: "Duration of completion requests by model, streamed requests until the stream ended.",
+		}, []string{"model"}),
+		firstToken: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opt.namespace, Name: "stream_first_token_seconds", ConstLabels: opt.labels, Buckets: opt.buckets,
+			Help: "Time to the first token of streamed completion requests by model.",
+		}, []string{"model"}),
+		toolCalls: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opt.namespace, Name: "tool_call_duration_seconds", ConstLabels: opt.labels, Buckets: opt.buckets,
+			Help: "Duration of tool calls by tool.",
+		}, []string{"tool"}),
+		toolErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opt.namespace, Name: "tool_call_errors_total", ConstLabels: opt.labels,
+			Help: "Failed tool calls by tool.",
+		}, []string{"tool"}),
+		storage: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opt.namespace, Name: "storage_duration_seconds", ConstLabels: opt.labels, Buckets: opt.buckets,
+			Help: "Duration of storage operations by operation and status.",
+		}, []string{"operation", "status"}),
+	}
+	m.active = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: opt.namespace, Name: "active_chats", ConstLabels: opt.labels,
+		Help: "Chat sessions held in memory.",
+	}, func() float64 {
+		if f := m.activeFunc.Load(); f != nil {
+			return float64((*f)())
+		}
+		return 0
+	})
+	return m
+}
+
+// collectors returns the metrics as collectors.
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.completions, m.tokens, m.latency, m.firstToken, m.toolCalls, m.toolErrors, m.storage, m.active}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// Handler returns an HTTP handler serving the metrics in the Prometheus exposition
+// format, for applications without a registry of their own, e.g.
+//
+//	http.Handle("/metrics", m.Handler())
+func (m *Metrics) Handler() http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(m)
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
+
+// ActiveChats sets the function reporting the chat sessions held in memory, which is
+// called on every scrape.
+func (m *Metrics) ActiveChats(f func() int) {
+	m.activeFunc.Store(&f)
+}
+
+// ObserveToolCall records a tool call of the tool name that took d and failed if err is
+// not nil.
+func (m *Metrics) ObserveToolCall(name string, d time.Duration, err error) {
+	m.toolCalls.WithLabelValues(name).Observe(d.Seconds())
+	if err != nil {
+		m.toolErrors.WithLabelValues(name).Inc()
+	}
+}
+
+// status returns the status label of an operation that failed if err is not nil.
+func status(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/xyzj/llm/provider"
+)
+
+// Provider wraps p to record its completion requests, token usage, latency and time to
+// the first streamed token, labelled with the requested model. The returned provider also
+// implements provider.Warmer if p does.
+func (m *Metrics) Provider(p provider.Provider) provider.Provider {
+	return &metered{inner: p, m: m}
+}
+
+// metered is a Provider decorator recording the metrics of the requests.
+type metered struct {
+	inner provider.Provider
+	m     *Metrics
+}
+
+func (p *metered) CreateCompletion(ctx context.Context, req provider.Request) (provider.Response, error) {
+	start := time.Now()
+	resp, err := p.inner.CreateCompletion(ctx, req)
+	p.m.observeCompletion(req.Model, time.Since(start), &resp.Usage, err)
+	return resp, err
+}
+
+func (p *metered) CreateCompletionStream(ctx context.Context, req provider.Request) (provider.Stream, error) {
+	start := time.Now()
+	stream, err := p.inner.CreateCompletionStream(ctx, req)
+	if err != nil {
+		p.m.observeCompletion(req.Model, time.Since(start), nil, err)
+		return nil, err
+	}
+	return &meteredStream{Stream: stream, m: p.m, model: req.Model, start: start}, nil
+}
+
+// Warm passes warm-ups to the wrapped provider if it implements provider.Warmer.
+func (p *metered) Warm(ctx context.Context) error {
+	if wm, ok := p.inner.(provider.Warmer); ok {
+		return wm.Warm(ctx)
+	}
+	return nil
+}
+
+// observeCompletion records a completion request of model that took d and reported the
+// usage u, nil if none was reported.
+func (m *Metrics) observeCompletion(model string, d time.Duration, u *provider.Usage, err error) {
+	m.completions.WithLabelValues(model, status(err)).Inc()
+	if err != nil {
+		return
+	}
+	m.latency.WithLabelValues(model).Observe(d.Seconds())
+	if u != nil {
+		cached := u.PromptTokensDetails.CachedTokens
+		m.tokens.WithLabelValues(model, "prompt").Add(float64(u.PromptTokens - cached))
+		m.tokens.WithLabelValues(model, "cached").Add(float64(cached))
+		m.tokens.WithLabelValues(model, "completion").Add(float64(u.CompletionTokens))
+	}
+}
+
+// meteredStream records a streamed request once the stream ended or was closed.
+type meteredStream struct {
+	provider.Stream
+	m     *Metrics
+	model string
+	start time.Time
+	first bool            // Whether the first token was received
+	usage *provider.Usage // Usage reported by the stream
+	once  sync.Once
+}
+
+func (s *meteredStream) Recv() (provider.StreamResponse, error) {
+	resp, err := s.Stream.Recv()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			s.end(nil)
+		} else {
+			s.end(err)
+		}
+		return resp, err
+	}
+	if resp.Usage != nil {
+		s.usage = resp.Usage
+	}
+	if !s.first && len(resp.Choices) > 0 && (resp.Choices[0].Delta.Content != "" || len(resp.Choices[0].Delta.ToolCalls) > 0) {
+		s.first = true
+		s.m.firstToken.WithLabelValues(s.model).Observe(time.Since(s.start).Seconds())
+	}
+	return resp, nil
+}
+
+func (s *meteredStream) Close() error {
+	s.end(nil)
+	return s.Stream.Close()
+}
+
+// end records the request, which may happen more than once.
+func (s *meteredStream) end(err error) {
+	s.once.Do(func() {
+		s.m.observeCompletion(s.model, time.Since(s.start), s.usage, err)
+	})
+}
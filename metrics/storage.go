@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/xyzj/llm/provider"
+	"github.com/xyzj/llm/storage"
+)
+
+// Storage wraps s to record the duration of its operations. Loads of unknown chats are
+// recorded with status "ok", as they are the normal start of a new chat.
+func (m *Metrics) Storage(s storage.Storage) storage.Storage {
+	return &meteredStorage{inner: s, m: m}
+}
+
+// meteredStorage is a storage decorator recording the duration of the operations.
+type meteredStorage struct {
+	inner storage.Storage
+	m     *Metrics
+}
+
+// observe records the operation op started at start.
+func (s *meteredStorage) observe(op string, start time.Time, err error) {
+	if errors.Is(err, storage.ErrNotFound) {
+		err = nil
+	}
+	s.m.storage.WithLabelValues(op, status(err)).Observe(time.Since(start).Seconds())
+}
+
+func (s *meteredStorage) Store(ctx context.Context, chatid string, history []*provider.Message) error {
+	start := time.Now()
+	err := s.inner.Store(ctx, chatid, history)
+	s.observe("store", start, err)
+	return err
+}
+
+func (s *meteredStorage) Load(ctx context.Context, chatid string) ([]*provider.Message, error) {
+	start := time.Now()
+	his, err := s.inner.Load(ctx, chatid)
+	s.observe("load", start, err)
+	return his, err
+}
+
+func (s *meteredStorage) Delete(ctx context.Context, chatid string) error {
+	start := time.Now()
+	err := s.inner.Delete(ctx, chatid)
+	s.observe("delete", start, err)
+	return err
+}
+
+func (s *meteredStorage) Clear(ctx context.Context) error {
+	start := time.Now()
+	err := s.inner.Clear(ctx)
+	s.observe("clear", start, err)
+	return err
+}
+
+// List returns the IDs of the stored chats if the inner backend implements storage.Lister.
+func (s *meteredStorage) List(ctx context.Context) ([]string, error) {
+	l, ok := s.inner.(storage.Lister)
+	if !ok {
+		return nil, fmt.Errorf("storage %T cannot list chats: %w", s.inner, errors.ErrUnsupported)
+	}
+	return l.List(ctx)
+}
+
+// Ping checks the inner backend if it implements storage.HealthChecker.
+func (s *meteredStorage) Ping(ctx context.Context) error {
+	if hc, ok := s.inner.(storage.HealthChecker); ok {
+		return hc.Ping(ctx)
+	}
+	return nil
+}
+
+// Close closes the inner backend if it implements io.Closer.
+func (s *meteredStorage) Close() error {
+	if c, ok := s.inner.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// NamespaceName returns the namespace of the inner backend if it implements
+// storage.Namespaced.
+func (s *meteredStorage) NamespaceName() string {
+	if ns, ok := s.inner.(storage.Namespaced); ok {
+		return ns.NamespaceName()
+	}
+	return ""
+}
+
+// Namespace returns the given namespace of the inner backend with the same metrics. It
+// panics if the inner backend does not implement storage.Namespacer.
+func (s *meteredStorage) Namespace(prefix string) storage.Storage {
+	return &meteredStorage{inner: s.inner.(storage.Namespacer).Namespace(prefix), m: s.m}
+}
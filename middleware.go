@@ -0,0 +1,91 @@
+package llm
+
+import (
+	"context"
+
+	"github.com/xyzj/llm/chat"
+	"github.com/xyzj/llm/provider"
+)
+
+// Middleware hooks into the chat turns of a ChatsManager, e.g. for logging, redaction,
+// policy checks or analytics, see WithMiddleware. All hooks are optional. The chatID
+// passed to the hooks is the internal key of the chat. Hooks are called concurrently for
+// different chats and for the tool calls of one model response.
+type Middleware struct {
+	// OnRequest is called with every completion request before it is sent. It may rewrite
+	// the request, replacing rather than modifying its messages, which are shared with the
+	// history. An error fails the turn without sending the request.
+	OnRequest func(ctx context.Context, chatID string, req *provider.Request) error
+	// OnResponse is called with every completed model response, after it was stored in
+	// the history.
+	OnResponse func(ctx context.Context, chatID string, res *chat.Result)
+	// OnToolCall is called before a tool call requested by the model runs, before the
+	// approval of the turn, see ApprovalContext. It may change the arguments of tc. An
+	// error rejects the call and is sent to the model as the tool result.
+	OnToolCall func(ctx context.Context, chatID string, tc *provider.ToolCall) error
+	// OnToolResult is called with the result message of a tool call before it is sent to
+	// the model, err is the error of a failed call whose message reports it. It returns the
+	// message to send, e.g. a redacted copy, or msg itself.
+	OnToolResult func(ctx context.Context, chatID string, tc *provider.ToolCall, msg *provider.Message, err error) *provider.Message
+	// OnError is called with the error of a failed completion request, ending the turn.
+	OnError func(ctx context.Context, chatID string, err error)
+}
+
+// middlewares is the chain of the middlewares set with WithMiddleware, run in order.
+type middlewares []Middleware
+
+// request runs the OnRequest hooks until one fails.
+func (mws middlewares) request(chatID string) chat.RequestHook {
+	return func(ctx context.Context, req *provider.Request) error {
+		for _, mw := range mws {
+			if mw.OnRequest != nil {
+				if err := mw.OnRequest(ctx, chatID, req); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// response runs the OnResponse hooks.
+func (mws middlewares) response(ctx context.Context, chatID string, res *chat.Result) {
+	for _, mw := range mws {
+		if mw.OnResponse != nil {
+			mw.OnResponse(ctx, chatID, res)
+		}
+	}
+}
+
+// toolCall runs the OnToolCall hooks until one fails.
+func (mws middlewares) toolCall(ctx context.Context, chatID string, tc *provider.ToolCall) error {
+	for _, mw := range mws {
+		if mw.OnToolCall != nil {
+			if err := mw.OnToolCall(ctx, chatID, tc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// toolResult runs the OnToolResult hooks, each receiving the message of the previous one.
+func (mws middlewares) toolResult(ctx context.Context, chatID string, tc *provider.ToolCall, msg *provider.Message, err error) *provider.Message {
+	for _, mw := range mws {
+		if mw.OnToolResult != nil {
+			if m := mw.OnToolResult(ctx, chatID, tc, msg, err); m != nil {
+				msg = m
+			}
+		}
+	}
+	return msg
+}
+
+// error runs the OnError hooks.
+func (mws middlewares) error(ctx context.Context, chatID string, err error) {
+	for _, mw := range mws {
+		if mw.OnError != nil {
+			mw.OnError(ctx, chatID, err)
+		}
+	}
+}
@@ -4,12 +4,24 @@
 package llm
 
 import (
+	"slices"
 	"time"
 
+	"github.com/xyzj/llm/chat"
+	"github.com/xyzj/llm/clock"
+	"github.com/xyzj/llm/history"
+	mcpcli "github.com/xyzj/llm/mcp"
+	"github.com/xyzj/llm/metrics"
+	"github.com/xyzj/llm/prompts"
+	"github.com/xyzj/llm/provider"
+	"github.com/xyzj/llm/rag"
 	"github.com/xyzj/llm/storage"
+	"github.com/xyzj/llm/tools"
+	"github.com/xyzj/llm/transform"
+	"github.com/xyzj/llm/tts"
 
-	"github.com/volcengine/volcengine-go-sdk/service/arkruntime/model"
 	"github.com/xyzj/toolbox/logger"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type (
@@ -17,24 +29,86 @@ type (
 	// These options control various aspects of chat behavior including
 	// storage, model selection, API authentication, and chat lifecycle management.
 	Opt struct {
-		dataStorage  storage.Storage                // Storage backend for persisting chat history
-		chatLifeTime time.Duration                  // Maximum idle time before a chat session expires
-		logg         logger.Logger                  // Logger instance for debugging and monitoring
-		roleSystem   []*model.ChatCompletionMessage // System role message template
-		baseURI      string                         // Base URI for the LLM service endpoint
-		modelName    string                         // Name of the AI model to use for chat completions
-		apiKey       string                         // API key for authenticating with the LLM service
-		maxHistory   int                            // Maximum number of messages to retain in chat history
+		dataStorage   storage.Storage           // Storage backend for persisting chat history
+		chatLifeTime  time.Duration             // Maximum idle time before a chat session expires
+		persistEvery  time.Duration             // Interval of the background saves, 0 to save after every turn
+		cleanupEvery  time.Duration             // Interval of the removal of expired chats
+		maxChats      int                       // Maximum chat sessions held in memory, 0 for no limit
+		onEvicted     EvictFunc                 // Called before a chat is removed from memory
+		logg          Logger                    // Structured logger for debugging and monitoring
+		roleSystem    []*provider.Message       // System role message template
+		idMapper      IDMapper                  // Derives internal chat keys from external identifiers
+		idMapFile     string                    // File used to persist the external-to-internal id mapping
+		contextWarns  []float64                 // Sorted context usage fractions that trigger a warning event
+		contextWin    int                       // Model context window in tokens, 0 to measure usage in messages
+		transformers  []transform.Transformer   // Ordered post-processing applied to assistant output
+		preprocessors []Preprocessor            // Ordered rewriting applied to user messages
+		baseURI       string                    // Base URI for the LLM service endpoint
+		modelName     string                    // Name of the AI model to use for chat completions
+		apiKey        string                    // API key for authenticating with the LLM service
+		maxHistory    int                       // Maximum number of messages to retain in chat history
+		builtinCmds   bool                      // Whether the builtin slash-commands are registered
+		boxDuration   time.Duration             // Maximum duration of a session before it is handed off, 0 to disable
+		boxTurns      int                       // Maximum user turns of a session before it is handed off, 0 to disable
+		handoffPrompt string                    // Instruction used to summarize a session at handoff
+		builtinTools  []*provider.Tool          // Provider-native tools offered to the model
+		flushEvery    time.Duration             // Interval at which coalesced stream chunks are flushed
+		flushSize     int                       // Pending bytes that trigger a flush of coalesced stream chunks
+		provider      provider.Provider         // Completion backend shared by all chat sessions
+		rateLimit     []provider.LimitOpts      // Limits of the requests sent to the provider, nil for no limits
+		toolProviders []tools.Provider          // Local tool providers offered to the model besides MCP tools
+		pricing       map[string]chat.Price     // Model prices used to compute the cost of each turn
+		currency      string                    // Currency of the prices, reported with the costs
+		prefetch      int                       // Speculative background requests allowed per hour, 0 to disable
+		toolHints     map[string]ToolHint       // Tool selection hints keyed by tool name
+		maxPayload    int                       // Maximum encoded request size in bytes, 0 for no limit
+		tts           tts.Synthesizer           // Converts the assistant text to speech, nil to disable
+		ttsOpts       []tts.StreamOpts          // Options of the speech streams of the turns
+		retrievers    map[string]*rag.Retriever // Retrievers of the knowledge bases by name, "" for the default
+		prompts       *prompts.Library          // Renders the system prompts as templates, nil to send them as is
+		promptCache   bool                      // Whether the system prompt and tools are marked as cacheable
+		cacheTTL      time.Duration             // Time the prompt prefix should stay cached, 0 for the provider default
+		maxToolRounds int                       // Maximum rounds of tool calls per turn
+		toolWorkers   int                       // Maximum number of tool calls executed in parallel
+		mcpOpts       []mcpcli.ClientOpts       // Options of the MCP client
+		onExpire      ExpireFunc                // Called before an expired chat is removed
+		clock         clock.Clock               // Clock driving the session lifecycle
+		retryAttempts int                       // Maximum attempts of a completion request, see chat.WithRetry
+		retryBackoff  time.Duration             // Delay before the first retry of a completion request
+		degradeNotice string                    // System notice of turns without MCP tools, empty to offer the tools anyway
+		turnDeadline  time.Duration             // Time budget of a complete chat turn, 0 for no budget
+		adaptiveUse   float64                   // Targeted prompt share of the context window, 0 to send the complete history
+		tokenBudget   int                       // Token budget of each chat history, 0 for no limit
+		tokenizer     history.Tokenizer         // Token counter of the history budget, nil for history.EstimateTokens
+		summaryModel  string                    // Model summarizing messages dropped from the histories, empty to discard them
+		jobWorkers    int                       // Workers processing the jobs of Submit
+		jobQueue      int                       // Maximum jobs waiting for a worker
+		jobRetention  time.Duration             // Time finished jobs can be polled
+		onJob         JobFunc                   // Called with every finished job
+		jobWebhook    string                    // URL finished jobs are posted to, empty to disable
+		cachePriming  bool                      // Whether Warm primes the prompt cache of the restored chats
+		tracing       trace.TracerProvider      // Provider of the tracers of the OpenTelemetry spans, nil to disable tracing
+		metrics       *metrics.Metrics          // Prometheus metrics of the manager, nil to disable them
+		middlewares   middlewares               // Hooks into the chat turns, run in order
+		thinking      *int                      // Reasoning token budget of thinking models, nil for the model default
+		keepReasoning bool                      // Whether the reasoning is stored in the histories
+		logProbs      *int                      // Alternatives requested with the log probability of each token, nil for none
 	}
 	// Opts is a function type for configuring ChatsManager options.
 	Opts func(opt *Opt)
+	// ExpireFunc is called with the identifier and final history of an expired chat session.
+	ExpireFunc func(id string, history []*provider.Message)
+	// EvictFunc is called with the identifier, final history and removal reason of a chat
+	// session removed from memory.
+	EvictFunc func(id string, history []*provider.Message, reason EvictReason)
 )
 
-// WithRoleSystem configures the Opt to use the given system role messages.
+// WithRoleSystem sets the default system prompt, sent with every request of the chat
+// sessions that have none of their own, see ChatsManager.SetSystemPrompt.
 // The provided messages replace any existing roleSystem messages on the Opt.
 // Passing zero messages clears the roleSystem (sets it to nil). The returned
 // Opts function applies this configuration to the target *Opt.
-func WithRoleSystem(msg ...*model.ChatCompletionMessage) Opts {
+func WithRoleSystem(msg ...*provider.Message) Opts {
 	return func(opt *Opt) {
 		opt.roleSystem = msg
 	}
@@ -67,18 +141,47 @@ func WithChatLifeTime(t time.Duration) Opts {
 	}
 }
 
+// WithPersistEvery sets the interval at which the background goroutine saves the histories
+// of the active sessions. Defaults to 5 minutes, so a crash loses the turns of up to 5
+// minutes. 0 switches to write-through: the history of a session is saved at the end of
+// each of its turns, before ChatContext returns, at the cost of a storage write per turn.
+// See ChatsManager.Flush and ChatsManager.FlushAll for explicit checkpoints.
+func WithPersistEvery(d time.Duration) Opts {
+	return func(opt *Opt) {
+		opt.persistEvery = max(d, 0)
+	}
+}
+
 // WithLogger sets a custom logger instance for the ChatsManager.
 // This logger will be used for debugging, error reporting, and monitoring
-// chat operations throughout the system.
+// chat operations throughout the system. Each record is written as one line of
+// the message followed by its key=value fields; use WithStructuredLogger to keep
+// the fields separate.
 func WithLogger(l logger.Logger) Opts {
 	return func(opt *Opt) {
-		opt.logg = l
+		if l != nil {
+			opt.logg = textLogger{l: l}
+		}
+	}
+}
+
+// WithStructuredLogger sets a structured logger for the ChatsManager, e.g. a *slog.Logger,
+// receiving records with key/value fields like chat_id, model, tool, latency and error,
+// see the LogKey constants, so logs can be queried in aggregation systems.
+func WithStructuredLogger(l Logger) Opts {
+	return func(opt *Opt) {
+		if l != nil {
+			opt.logg = l
+		}
 	}
 }
 
 // WithBaseURI sets the base URI for the LLM service endpoint.
 // This is typically used when connecting to self-hosted or custom
 // language model services instead of cloud-based APIs.
+// When set, chats use an OpenAI-compatible client (see provider.NewOpenAI), so Ollama
+// ("http://127.0.0.1:11434"), vLLM and LM Studio endpoints work out of the box.
+// It has no effect if a provider is set with WithProvider.
 func WithBaseURI(u string) Opts {
 	return func(opt *Opt) {
 		opt.baseURI = u
@@ -101,3 +204,583 @@ func WithAPIKey(k string) Opts {
 		opt.apiKey = k
 	}
 }
+
+// WithIDMapper sets how internal chat keys are derived from the identifiers passed to Chat.
+// Builtin mappers are HashIDMapper (default), PassthroughIDMapper and UUIDIDMapper.
+func WithIDMapper(m IDMapper) Opts {
+	return func(opt *Opt) {
+		if m != nil {
+			opt.idMapper = m
+		}
+	}
+}
+
+// WithIDMapFile sets the file used to persist the mapping between external identifiers
+// and internal chat keys. The mapping is loaded on startup and rewritten before histories
+// are stored if identifiers were assigned since, which is required for non-deterministic
+// mappers like UUIDIDMapper.
+func WithIDMapFile(f string) Opts {
+	return func(opt *Opt) {
+		opt.idMapFile = f
+	}
+}
+
+// WithContextWarnings sets the context usage thresholds, as fractions between 0 and 1,
+// at which an EventContextWarning is delivered to the event function, see WithEventFunc.
+// Each threshold is reported once per chat, e.g. WithContextWarnings(0.8, 0.95)
+// warns at 80% and again at 95% usage. Values outside (0, 1] are ignored.
+//
+// The warnings are no longer written to the write callback of Chat as JSON: turns
+// started without WithEventFunc, e.g. plain Chat(id, message, w) calls, drop them.
+func WithContextWarnings(thresholds ...float64) Opts {
+	return func(opt *Opt) {
+		opt.contextWarns = make([]float64, 0, len(thresholds))
+		for _, t := range thresholds {
+			if t > 0 && t <= 1 {
+				opt.contextWarns = append(opt.contextWarns, t)
+			}
+		}
+		slices.Sort(opt.contextWarns)
+	}
+}
+
+// WithContextWindow sets the model context window size in tokens.
+// When set, context usage for warnings is measured in estimated tokens;
+// otherwise it is measured in messages relative to the max history size.
+func WithContextWindow(tokens int) Opts {
+	return func(opt *Opt) {
+		opt.contextWin = tokens
+	}
+}
+
+// WithTransformers sets the ordered output transformers applied to every assistant response.
+// They run on streamed deltas as well as complete responses, and the transformed text is
+// what gets stored in the chat history. See the transform package for builtin transformers,
+// e.g. WithTransformers(transform.StripThink(), transform.NormalizeMarkdown()).
+func WithTransformers(ts ...transform.Transformer) Opts {
+	return func(opt *Opt) {
+		opt.transformers = ts
+	}
+}
+
+// WithPreprocessors sets the ordered hooks that rewrite every user message before it is
+// stored and sent, e.g. WithPreprocessors(llm.TrimMessage(), llm.StripMarkup()).
+// A message that ends up empty is not sent.
+func WithPreprocessors(ps ...Preprocessor) Opts {
+	return func(opt *Opt) {
+		opt.preprocessors = ps
+	}
+}
+
+// WithBuiltinCommands registers the builtin slash-commands /help, /reset, /model and /export.
+// Further commands can be added with ChatsManager.RegisterCommand.
+func WithBuiltinCommands() Opts {
+	return func(opt *Opt) {
+		opt.builtinCmds = true
+	}
+}
+
+// WithSessionBox time-boxes conversations: once a chat's conversation is older than d
+// or has reached turns user messages, the next message closes it. The old history is
+// archived in storage, a handoff summary is generated and the chat continues as a fresh
+// conversation seeded with that summary. The archives are listed in the metadata of the
+// session, see MetaArchives, and removed with it by ChatsManager.Delete. A zero value
+// disables the respective limit.
+func WithSessionBox(d time.Duration, turns int) Opts {
+	return func(opt *Opt) {
+		opt.boxDuration = d
+		opt.boxTurns = turns
+	}
+}
+
+// WithHandoffPrompt sets the instruction used to generate the handoff summary
+// of time-boxed sessions, see WithSessionBox.
+func WithHandoffPrompt(p string) Opts {
+	return func(opt *Opt) {
+		if p != "" {
+			opt.handoffPrompt = p
+		}
+	}
+}
+
+// WithBuiltinTools sets provider-native tools, e.g. chat.BuiltinTool(chat.ToolTypeWebSearch),
+// that are offered to the model together with the MCP tools. They are executed by the
+// provider and their calls are recorded in the history, see chat.RoleBuiltinTool.
+func WithBuiltinTools(tools ...*provider.Tool) Opts {
+	return func(opt *Opt) {
+		opt.builtinTools = tools
+	}
+}
+
+// WithStreamCoalesce batches streamed response chunks before invoking the write callback,
+// flushing every interval or once size bytes are pending, e.g. WithStreamCoalesce(50*time.Millisecond, 4096).
+// This reduces syscall and flush overhead for SSE endpoints while keeping latency low.
+func WithStreamCoalesce(interval time.Duration, size int) Opts {
+	return func(opt *Opt) {
+		opt.flushEvery = interval
+		opt.flushSize = size
+	}
+}
+
+// WithProvider sets the completion backend shared by all chat sessions, e.g. a provider
+// wrapped with provider.NewChaos for fault injection testing.
+// When not set, a VolcEngine ARK provider using the configured API key is created.
+func WithProvider(p provider.Provider) Opts {
+	return func(opt *Opt) {
+		opt.provider = p
+	}
+}
+
+// WithRateLimit limits the completion requests of all chat sessions sharing the API key,
+// see provider.NewLimiter, so bursts of chats queue up instead of being answered with
+// HTTP 429 by the provider or exceeding a token budget. Turns whose requests are rejected
+// fail with provider.ErrRateLimited.
+//
+// Example:
+//
+//	llm.WithRateLimit(
+//		provider.WithRequestsPerMinute(60),
+//		provider.WithTokensPerMinute(100000),
+//		provider.WithMaxConcurrent(8),
+//		provider.WithMaxWait(30*time.Second),
+//	)
+func WithRateLimit(opts ...provider.LimitOpts) Opts {
+	return func(opt *Opt) {
+		opt.rateLimit = append(make([]provider.LimitOpts, 0, len(opts)), opts...)
+	}
+}
+
+// WithTracerProvider records OpenTelemetry spans of the chat turns, so a multi-tool
+// conversation shows as one trace: an "llm.turn" span per turn with the rounds, tool calls
+// and token usage, containing a span per completion request (see provider.NewTracing),
+// an "execute_tool" span per tool call, which contains the span of the MCP call (see
+// mcpcli.WithTracerProvider), and the spans of the storage operations made within the
+// turn (see storage.NewTracedStorage). The spans follow the GenAI semantic conventions
+// where they apply.
+//
+// Example:
+//
+//	llm.WithTracerProvider(otel.GetTracerProvider())
+func WithTracerProvider(tp trace.TracerProvider) Opts {
+	return func(opt *Opt) {
+		opt.tracing = tp
+	}
+}
+
+// WithMetrics records Prometheus metrics of the manager in m: completion requests, token
+// usage, completion latency and time to the first streamed token per model, tool call
+// durations and errors per tool, the active chat sessions and the storage latency per
+// operation. Register m with a registry or serve it with m.Handler.
+//
+// Example:
+//
+//	m := metrics.New()
+//	prometheus.MustRegister(m)
+//	cm := llm.NewChatsManager(llm.WithMetrics(m))
+func WithMetrics(m *metrics.Metrics) Opts {
+	return func(opt *Opt) {
+		opt.metrics = m
+	}
+}
+
+// WithMiddleware adds hooks into the chat turns, e.g. for logging, redaction or policy
+// checks. The hooks of several middlewares run in the order they were added; the first
+// error of OnRequest or OnToolCall stops the chain.
+//
+// Example:
+//
+//	llm.WithMiddleware(llm.Middleware{
+//		OnToolCall: func(ctx context.Context, chatID string, tc *provider.ToolCall) error {
+//			if tc.Function.Name == "delete_file" {
+//				return errors.New("not allowed")
+//			}
+//			return nil
+//		},
+//	})
+func WithMiddleware(mw ...Middleware) Opts {
+	return func(opt *Opt) {
+		opt.middlewares = append(opt.middlewares, mw...)
+	}
+}
+
+// WithToolProviders adds local tool providers, e.g. tools.LoadLocal("tools.d"), whose
+// tools are offered to the model together with the MCP tools. Calls to a tool are routed
+// to the first provider offering it, and to the MCP servers otherwise.
+func WithToolProviders(ps ...tools.Provider) Opts {
+	return func(opt *Opt) {
+		opt.toolProviders = append(opt.toolProviders, ps...)
+	}
+}
+
+// WithPricing sets the model prices, keyed by model name, used to compute the cost
+// recorded with every assistant message, e.g. {"doubao-pro": {Prompt: 0.8, Completion: 2}}.
+// Model, latency, token usage and cost of each turn are available through Chat.Records
+// while the session is active, as they are not persisted with the history; the
+// accumulated costs per chat session and model through ChatsManager.Cost and
+// ChatsManager.CostReport.
+func WithPricing(prices map[string]chat.Price) Opts {
+	return func(opt *Opt) {
+		opt.pricing = prices
+	}
+}
+
+// WithCurrency sets the currency of the prices of WithPricing, e.g. "USD", which is
+// reported with the costs, see ChatsManager.CostReport.
+func WithCurrency(code string) Opts {
+	return func(opt *Opt) {
+		opt.currency = code
+	}
+}
+
+// WithPrefetch enables experimental speculative prefetching: after responding, the manager
+// warms the provider connection (for providers implementing provider.Warmer) and generates
+// the handoff summary of sessions about to close their box (see WithSessionBox) in the
+// background, reducing the latency of the next turn.
+// budget limits the speculative requests per hour across all sessions; 0 disables prefetching.
+func WithPrefetch(budget int) Opts {
+	return func(opt *Opt) {
+		opt.prefetch = budget
+	}
+}
+
+// WithToolHints sets tool selection hints keyed by tool name, e.g.
+//
+//	llm.WithToolHints(map[string]llm.ToolHint{
+//		"internal_search": {Priority: 10, Over: []string{"web_search"}},
+//	})
+//
+// Hints are rendered into the tool descriptions and tools are offered in order of
+// priority, improving tool choice without editing the metadata of every server.
+func WithToolHints(hints map[string]ToolHint) Opts {
+	return func(opt *Opt) {
+		opt.toolHints = hints
+	}
+}
+
+// WithTTS converts the assistant text of every turn to speech for voice interfaces: the
+// text is cut into sentences while it streams, and each sentence is synthesized with s
+// and delivered as an EventAudio to the event function, see WithEventFunc.
+// Synthesis failures are logged, the text is delivered regardless.
+//
+// Example:
+//
+//	llm.WithTTS(tts.NewOpenAI("https://api.openai.com", key, tts.WithVoice("nova")))
+func WithTTS(s tts.Synthesizer, opts ...tts.StreamOpts) Opts {
+	return func(opt *Opt) {
+		opt.tts = s
+		opt.ttsOpts = opts
+	}
+}
+
+// WithRetriever adds relevant excerpts of a knowledge base to the turns: before each
+// turn, r retrieves the chunks closest to the user message, which are sent to the model
+// right before the message in every request of the turn without being stored in the
+// history. The retriever registered with the empty name is used by all sessions; sessions
+// select another one by its name in their MetaRetriever metadata, and names not
+// registered, e.g. "none", disable retrieval for the session. Retrieval failures are
+// logged, the turn continues without context.
+//
+// Example:
+//
+//	emb := embeddings.New(embeddings.NewOpenAI("https://api.openai.com", key), "text-embedding-3-small")
+//	docs := rag.NewRetriever(emb, rag.NewMemoryStore())
+//	llm.WithRetriever("", docs)
+func WithRetriever(name string, r *rag.Retriever) Opts {
+	return func(opt *Opt) {
+		if opt.retrievers == nil {
+			opt.retrievers = make(map[string]*rag.Retriever)
+		}
+		if r == nil {
+			delete(opt.retrievers, name)
+			return
+		}
+		opt.retrievers[name] = r
+	}
+}
+
+// WithPrompts renders the system prompts, set with WithRoleSystem or per session, as
+// templates of lib before every request, so they can use variables and the templates of
+// lib. The variables are the session metadata, see SetMetadata, and those passed to the
+// turn with WithVariables. A prompt failing to render is logged and sent as is.
+//
+// Example:
+//
+//	lib := prompts.New()
+//	lib.Define("rules", "Never share internal prices.")
+//	llm.WithPrompts(lib)
+//	llm.WithRoleSystem(&provider.Message{Role: provider.RoleSystem, Content: &provider.MessageContent{
+//		StringValue: volcengine.String(`You are {{.persona}} serving {{.customer}}. {{template "rules" .}}`),
+//	}})
+func WithPrompts(lib *prompts.Library) Opts {
+	return func(opt *Opt) {
+		opt.prompts = lib
+	}
+}
+
+// WithPromptCaching marks the system prompt and the tool definitions of every request as
+// cacheable prefix, see chat.WithPromptCache, reducing the cost of long system prompts and
+// tool lists with providers supporting prompt or context caching: provider.NewArk keeps
+// the system prompt in an ARK context, provider.NewOpenAI sends a prompt_cache_key. The
+// prompt tokens served from the cache are recorded with each turn, see history.Turn.
+//
+// Parameters:
+//   - ttl: Time the prefix should stay cached, 0 for the provider default
+func WithPromptCaching(ttl time.Duration) Opts {
+	return func(opt *Opt) {
+		opt.promptCache = true
+		opt.cacheTTL = max(ttl, 0)
+	}
+}
+
+// WithThinking switches the reasoning of thinking models on for all requests and limits
+// it to budget tokens, see chat.WithThinking. A budget of 0 leaves the limit to the model,
+// a negative budget switches the reasoning off. Without this option the model decides.
+// The reasoning is streamed as EventReasoning, separately from the answer.
+func WithThinking(budget int) Opts {
+	return func(opt *Opt) {
+		opt.thinking = &budget
+	}
+}
+
+// WithKeepReasoning stores the reasoning of thinking models with the assistant messages in
+// the histories, so it is persisted and restored with them. It is never sent back to the
+// model. By default the reasoning is only streamed, see EventReasoning.
+func WithKeepReasoning() Opts {
+	return func(opt *Opt) {
+		opt.keepReasoning = true
+	}
+}
+
+// WithLogProbs requests the log probabilities of the generated tokens with all requests,
+// with the top most likely alternatives of each token, see chat.WithLogProbs. They are
+// returned in the chat.Result passed to Middleware.OnResponse, e.g. for evaluation tooling.
+func WithLogProbs(top int) Opts {
+	return func(opt *Opt) {
+		opt.logProbs = &top
+	}
+}
+
+// WithMaxPayload limits the encoded size of the requests sent to the provider, e.g. to the
+// body limit of a gateway. Larger requests are downscaled instead of failing with an opaque
+// HTTP 413: images are compressed, the oldest tool results and images are replaced by
+// placeholders and finally the oldest messages are left out. The stored history is not
+// changed. Every downscaled request emits an EventPayloadDownscaled listing the reductions.
+// 0 disables the limit.
+func WithMaxPayload(bytes int) Opts {
+	return func(opt *Opt) {
+		opt.maxPayload = bytes
+	}
+}
+
+// WithMaxToolRounds enables the agent loop: after executing the tool calls of the model and
+// sending back the results, the tools are offered again, so the model can keep calling tools
+// until it answers or n rounds of tool calls were executed. The last round's follow-up
+// request offers no tools. Defaults to 1, a single round of tool calls.
+func WithMaxToolRounds(n int) Opts {
+	return func(opt *Opt) {
+		if n > 0 {
+			opt.maxToolRounds = n
+		}
+	}
+}
+
+// WithToolConcurrency limits the number of tool calls of one model response executed in
+// parallel; further calls wait for a running call to finish. The results are sent back
+// to the model in the order of the calls regardless. Defaults to 8.
+func WithToolConcurrency(n int) Opts {
+	return func(opt *Opt) {
+		if n > 0 {
+			opt.toolWorkers = n
+		}
+	}
+}
+
+// WithMcpOptions configures the MCP client used for the servers added with InitMcp, e.g.
+//
+//	llm.WithMcpOptions(mcpcli.WithLazyConnect(), mcpcli.WithMaxConnections(8), mcpcli.WithIdleTimeout(10*time.Minute))
+//
+// connects the servers on the first chat turn instead of in InitMcp, keeps at most 8
+// connections open and closes connections idle for 10 minutes.
+func WithMcpOptions(opts ...mcpcli.ClientOpts) Opts {
+	return func(opt *Opt) {
+		opt.mcpOpts = opts
+	}
+}
+
+// WithOnExpire sets a function called when an inactive chat session expires (see
+// WithChatLifeTime), before it is removed from memory, e.g. to archive the conversation,
+// notify the user or trigger a summary. It receives the identifier passed to Chat and
+// the final history, which is persisted to storage as before.
+// The function runs on the background maintenance goroutine, so long running work
+// should be started in a goroutine of its own.
+func WithOnExpire(f ExpireFunc) Opts {
+	return func(opt *Opt) {
+		opt.onExpire = f
+	}
+}
+
+// WithCleanupInterval sets the interval at which expired chat sessions are removed, see
+// WithChatLifeTime. Defaults to 5 minutes.
+func WithCleanupInterval(d time.Duration) Opts {
+	return func(opt *Opt) {
+		if d > 0 {
+			opt.cleanupEvery = d
+		}
+	}
+}
+
+// WithMaxChats limits the chat sessions held in memory. When a session is created beyond
+// the limit, the least recently active session is evicted: its history is persisted to
+// storage, from which it is restored on its next message, and it is reported to the
+// WithOnChatEvicted callback. Sessions with running turns are not evicted, so the limit
+// is exceeded while all sessions are busy. Defaults to 0, no limit.
+func WithMaxChats(n int) Opts {
+	return func(opt *Opt) {
+		opt.maxChats = max(n, 0)
+	}
+}
+
+// WithOnChatEvicted sets a function called before a chat session is removed from memory,
+// because it expired (EvictExpired) or to make room for another session (EvictCapacity),
+// e.g. to archive the conversation or notify the user. It receives the identifier passed
+// to Chat and the final history, which is persisted to storage as before.
+// The function is called while the sessions are swept, so long running work should be
+// started in a goroutine of its own.
+func WithOnChatEvicted(f EvictFunc) Opts {
+	return func(opt *Opt) {
+		opt.onEvicted = f
+	}
+}
+
+// WithClock sets the clock driving the session lifecycle: the last activity of chats,
+// their expiry, time-boxed conversations, the periodic persistence of histories, the
+// times and retention of jobs, the prefetch budget and the times of Stats.
+// Tests and simulations pass a clock.Manual to control time deterministically.
+// Defaults to the wall clock.
+func WithClock(c clock.Clock) Opts {
+	return func(opt *Opt) {
+		if c != nil {
+			opt.clock = c
+		}
+	}
+}
+
+// WithRetry retries model requests failing with rate limiting, server or network errors
+// up to maxAttempts times in total, waiting backoff before the first retry and doubling
+// the delay for each further retry, see chat.WithRetry. Disabled by default.
+func WithRetry(maxAttempts int, backoff time.Duration) Opts {
+	return func(opt *Opt) {
+		opt.retryAttempts = maxAttempts
+		opt.retryBackoff = backoff
+	}
+}
+
+// DefaultDegradeNotice is the system notice of WithDegradedMode if none is given.
+const DefaultDegradeNotice = "Tools are currently unavailable. Answer without calling tools and tell the user if a request needs them."
+
+// WithDegradedMode enables graceful degradation when all MCP servers are unreachable:
+// instead of offering tool definitions that would fail at call time, the turn continues
+// without the MCP tools and a notice is added to the system prompt. An
+// EventToolsUnavailable is emitted for such turns. Reachability is probed at most every
+// 30 seconds, see mcpcli.McpClient.Reachable. An empty notice uses DefaultDegradeNotice.
+func WithDegradedMode(notice string) Opts {
+	return func(opt *Opt) {
+		if notice == "" {
+			notice = DefaultDegradeNotice
+		}
+		opt.degradeNotice = notice
+	}
+}
+
+// WithTurnDeadline bounds every ChatsManager.Chat turn, including the initial completion,
+// all tool calls and the follow-up completions, to d in total, instead of limiting each
+// request and tool call on its own. When the budget runs out the turn stops, whatever was
+// streamed so far is kept, results of finished tool calls are recorded in the history
+// and an EventTurnDeadline is emitted. A deadline of the context passed to ChatContext
+// still applies if it is earlier. Zero or negative d disables the budget.
+func WithTurnDeadline(d time.Duration) Opts {
+	return func(opt *Opt) {
+		opt.turnDeadline = d
+	}
+}
+
+// WithAdaptiveHistory sends each chat only as many of its most recent history messages as
+// keep the prompt near the given share of the context window set with WithContextWindow,
+// e.g. 0.6, adapting the count per chat to the prompt tokens reported by the provider.
+// It has no effect without a context window. See chat.WithAdaptiveWindow.
+func WithAdaptiveHistory(utilization float64) Opts {
+	return func(opt *Opt) {
+		opt.adaptiveUse = min(max(utilization, 0), 1)
+	}
+}
+
+// WithTokenBudget limits the history of each chat to n tokens in addition to the message
+// count set with WithMaxHistory, dropping the oldest messages first, so the assembled
+// prompt stays within the context window. A nil tokenizer estimates the token counts,
+// see history.WithTokenBudget.
+func WithTokenBudget(n int, tokenizer history.Tokenizer) Opts {
+	return func(opt *Opt) {
+		opt.tokenBudget = n
+		opt.tokenizer = tokenizer
+	}
+}
+
+// WithSummaryModel condenses the messages a chat history drops when it overflows, see
+// WithMaxHistory and WithTokenBudget, into a running summary written by the given model,
+// e.g. a small and cheap one served by the same provider. The summary is sent as a system
+// message with every request, see chat.WithSummarizer.
+func WithSummaryModel(model string) Opts {
+	return func(opt *Opt) {
+		opt.summaryModel = model
+	}
+}
+
+// WithJobWorkers sets the number of workers processing the chat turns queued with
+// Submit, and how many turns may wait for a worker before Submit fails with ErrQueueFull.
+// Defaults to 4 workers and 100 waiting turns.
+func WithJobWorkers(workers, queue int) Opts {
+	return func(opt *Opt) {
+		if workers > 0 {
+			opt.jobWorkers = workers
+		}
+		if queue > 0 {
+			opt.jobQueue = queue
+		}
+	}
+}
+
+// WithJobRetention sets how long finished jobs can be retrieved with Poll.
+// Defaults to 1 hour.
+func WithJobRetention(d time.Duration) Opts {
+	return func(opt *Opt) {
+		if d > 0 {
+			opt.jobRetention = d
+		}
+	}
+}
+
+// WithJobCallback sets a function called with every job finished by the workers, see
+// Submit, e.g. to send a push notification. It runs on the worker, so slow callbacks
+// delay the following jobs.
+func WithJobCallback(f JobFunc) Opts {
+	return func(opt *Opt) {
+		opt.onJob = f
+	}
+}
+
+// WithJobWebhook posts every job finished by the workers as JSON to the given URL, see
+// Submit and Job. Failed deliveries are logged and not retried.
+func WithJobWebhook(url string) Opts {
+	return func(opt *Opt) {
+		opt.jobWebhook = url
+	}
+}
+
+// WithCachePriming makes Warm send the prompt of every restored chat with a completion
+// limit of one token, so providers caching prompt prefixes serve the first message of
+// the chat from the cache, see chat.Chat.Prime. Each primed chat costs one request.
+func WithCachePriming() Opts {
+	return func(opt *Opt) {
+		opt.cachePriming = true
+	}
+}
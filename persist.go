@@ -0,0 +1,153 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/xyzj/llm/chat"
+	"github.com/xyzj/llm/provider"
+	"github.com/xyzj/llm/storage"
+)
+
+// storageTimeout bounds the storage operations that are not part of a chat turn.
+const storageTimeout = 10 * time.Second
+
+// storageContext returns a context for storage operations that are not part of a chat turn.
+func storageContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), storageTimeout)
+}
+
+// writes tracks the asynchronous history writes in flight per storage key,
+// so restores can wait for pending writes and read their own data.
+type writes struct {
+	sync.Mutex
+	inflight map[string]chan struct{} // Completion channel of the latest write per key
+}
+
+// storeAsync persists a chat history in the background. Writes of the same key are
+// applied in the order they were issued.
+func (cm *ChatsManager) storeAsync(key string, his []*provider.Message) {
+	cm.writeAsync(cm.cnf.dataStorage, key, key, his)
+}
+
+// writeAsync stores msgs under key of st in the background, after the writes issued
+// before for the same slot, see awaitWrites.
+func (cm *ChatsManager) writeAsync(st storage.Storage, slot, key string, msgs []*provider.Message) {
+	done := make(chan struct{})
+	cm.writes.Lock()
+	prev := cm.writes.inflight[slot]
+	cm.writes.inflight[slot] = done
+	cm.writes.Unlock()
+	go func() {
+		defer func() {
+			cm.writes.Lock()
+			if cm.writes.inflight[slot] == done {
+				delete(cm.writes.inflight, slot)
+			}
+			cm.writes.Unlock()
+			close(done)
+		}()
+		if prev != nil {
+			<-prev
+		}
+		ctx, cancel := storageContext()
+		defer cancel()
+		if err := st.Store(ctx, key, msgs); err != nil {
+			cm.cnf.logg.Error("store chat history failed", LogKeyChatID, key, LogKeyError, err)
+		}
+	}()
+}
+
+// awaitWrites blocks until all writes of the key issued so far are finished.
+func (cm *ChatsManager) awaitWrites(key string) {
+	cm.writes.Lock()
+	done := cm.writes.inflight[key]
+	cm.writes.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// awaitAllWrites blocks until all writes issued so far are finished or ctx is done.
+func (cm *ChatsManager) awaitAllWrites(ctx context.Context) error {
+	cm.writes.Lock()
+	pending := slices.Collect(maps.Values(cm.writes.inflight))
+	cm.writes.Unlock()
+	for _, done := range pending {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Flush persists the history of a chat session synchronously and waits until all of
+// its pending background writes are finished, so a subsequent Load from storage sees
+// every message. For inactive sessions it only waits for pending writes.
+//
+// Parameters:
+//   - id: Identifier of the chat session
+//
+// Returns:
+//   - error: Any error encountered while storing the history
+func (cm *ChatsManager) Flush(id string) error {
+	ch, ok := cm.chats.LoadForUpdate(id)
+	if !ok {
+		cm.awaitWrites(cm.lookupID(id))
+		return nil
+	}
+	return cm.flush(ch)
+}
+
+// flush persists the history and the state of the session ch synchronously after its
+// pending background writes, whether or not it is still active.
+func (cm *ChatsManager) flush(ch *chat.Chat) error {
+	cm.saveIDMap()
+	cm.awaitWrites(ch.ID())
+	ctx, cancel := storageContext()
+	defer cancel()
+	if err := cm.cnf.dataStorage.Store(ctx, ch.ID(), ch.History()); err != nil {
+		return storageError(err)
+	}
+	return cm.storeState(ctx, ch)
+}
+
+// FlushAll persists the histories of all active sessions synchronously and waits until
+// all pending background writes are finished, e.g. as a checkpoint before a deployment.
+//
+// Returns:
+//   - error: The joined errors of storing the histories
+func (cm *ChatsManager) FlushAll() error {
+	return cm.flushAll(context.Background())
+}
+
+// flushAll persists the histories of all active sessions, each bounded by storageTimeout
+// within ctx, and waits for the pending background writes until ctx is done.
+func (cm *ChatsManager) flushAll(ctx context.Context) error {
+	cm.saveIDMap()
+	var errs []error
+	for _, key := range cm.chats.Keys() {
+		ch, ok := cm.chats.LoadForUpdate(key)
+		if !ok {
+			continue
+		}
+		cm.awaitWrites(ch.ID())
+		sctx, cancel := context.WithTimeout(ctx, storageTimeout)
+		if err := cm.cnf.dataStorage.Store(sctx, ch.ID(), ch.History()); err != nil {
+			errs = append(errs, storageError(err))
+		} else if err = cm.storeState(sctx, ch); err != nil {
+			errs = append(errs, err)
+		}
+		cancel()
+	}
+	if err := cm.awaitAllWrites(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,144 @@
+package llm
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/xyzj/llm/chat"
+	"github.com/xyzj/llm/clock"
+	"github.com/xyzj/llm/provider"
+)
+
+// prefetcher runs speculative background work after a response, within a budget of
+// requests per hour, and keeps its results until the next turn needs them.
+type prefetcher struct {
+	sync.Mutex
+	clock     clock.Clock            // Clock of the budget windows, see WithClock
+	budget    int                    // Maximum speculative requests per hour
+	used      int                    // Requests spent in the current window
+	window    time.Time              // Start of the current budget window
+	summaries map[string]*prefetched // Handoff summaries keyed by chat key
+}
+
+// prefetched is a handoff summary generated ahead of time.
+type prefetched struct {
+	summary string      // Generated summary, empty while pending
+	history fingerprint // History the summary was generated for
+}
+
+// fingerprint identifies the content of a history. Its length alone does not, as it stays
+// the same once the history is full and old messages are dropped for new ones.
+type fingerprint struct {
+	messages int               // Length of the history
+	last     *provider.Message // Last message of the history, compared by identity
+}
+
+// historyFingerprint returns the fingerprint of the history his.
+func historyFingerprint(his []*provider.Message) fingerprint {
+	fp := fingerprint{messages: len(his)}
+	if len(his) > 0 {
+		fp.last = his[len(his)-1]
+	}
+	return fp
+}
+
+func newPrefetcher(budget int, clk clock.Clock) *prefetcher {
+	return &prefetcher{
+		clock:     clk,
+		budget:    budget,
+		window:    clk.Now(),
+		summaries: make(map[string]*prefetched),
+	}
+}
+
+// take spends one request of the budget, reporting false if the budget is exhausted.
+func (p *prefetcher) take() bool {
+	p.Lock()
+	defer p.Unlock()
+	if now := p.clock.Now(); now.Sub(p.window) >= time.Hour {
+		p.window, p.used = now, 0
+	}
+	if p.used >= p.budget {
+		return false
+	}
+	p.used++
+	return true
+}
+
+// summary returns and removes the prefetched handoff summary of a chat, if it was
+// generated for the history his.
+func (p *prefetcher) summary(key string, his []*provider.Message) (string, bool) {
+	p.Lock()
+	defer p.Unlock()
+	pf, ok := p.summaries[key]
+	if !ok || pf.summary == "" {
+		return "", false
+	}
+	delete(p.summaries, key)
+	return pf.summary, pf.history == historyFingerprint(his)
+}
+
+// drop discards the prefetched results of a chat.
+func (p *prefetcher) drop(key string) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.summaries, key)
+}
+
+// prefetch speculatively prepares the next turn of a chat in the background:
+// the provider connection is warmed if the provider implements provider.Warmer, and the
+// handoff summary is generated if the next turn is likely to close the session box.
+// Nothing is done unless enabled with WithPrefetch and budget is left.
+func (cm *ChatsManager) prefetch(ch *chat.Chat) {
+	if cm.pf == nil {
+		return
+	}
+	if wm, ok := cm.cnf.provider.(provider.Warmer); ok && cm.pf.take() {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := wm.Warm(ctx); err != nil {
+				cm.cnf.logg.Error("prefetch warm failed", LogKeyError, err)
+			}
+		}()
+	}
+	if !cm.handoffLikely(ch) {
+		return
+	}
+	his := ch.History()
+	fp := historyFingerprint(his)
+	cm.pf.Lock()
+	if pf, ok := cm.pf.summaries[ch.ID()]; ok && pf.history == fp {
+		cm.pf.Unlock()
+		return
+	}
+	cm.pf.summaries[ch.ID()] = &prefetched{history: fp}
+	cm.pf.Unlock()
+	if !cm.pf.take() {
+		cm.pf.drop(ch.ID())
+		return
+	}
+	go func() {
+		summary, err := cm.summarize(context.Background(), ch, his)
+		if err != nil {
+			cm.pf.drop(ch.ID())
+			cm.cnf.logg.Error("prefetch summary failed", LogKeyChatID, ch.ID(), LogKeyError, err)
+			return
+		}
+		cm.pf.Lock()
+		if pf, ok := cm.pf.summaries[ch.ID()]; ok && pf.history == fp {
+			pf.summary = summary
+		}
+		cm.pf.Unlock()
+	}()
+}
+
+// handoffLikely reports whether the next turn of the chat will probably close its
+// session box, i.e. the box is already exhausted or 90% of its duration has passed.
+func (cm *ChatsManager) handoffLikely(ch *chat.Chat) bool {
+	if cm.boxExpired(ch) {
+		return true
+	}
+	return cm.cnf.boxDuration > 0 && cm.cnf.clock.Now().Sub(ch.Started()) >= cm.cnf.boxDuration*9/10
+}
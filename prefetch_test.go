@@ -0,0 +1,26 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/xyzj/llm/clock"
+	"github.com/xyzj/llm/provider"
+)
+
+func TestPrefetchedSummaryOfFullHistory(t *testing.T) {
+	msg := func(s string) *provider.Message {
+		return &provider.Message{Role: provider.RoleUser, Content: &provider.MessageContent{StringValue: &s}}
+	}
+	his := []*provider.Message{msg("a"), msg("b")}
+	pf := newPrefetcher(10, clock.Real())
+	pf.summaries["user"] = &prefetched{summary: "summary", history: historyFingerprint(his)}
+	// a full history drops its oldest message for each new one, keeping its length
+	if _, ok := pf.summary("user", []*provider.Message{his[1], msg("c")}); ok {
+		t.Error("summary of an older history reported current")
+	}
+
+	pf.summaries["user"] = &prefetched{summary: "summary", history: historyFingerprint(his)}
+	if s, ok := pf.summary("user", his); !ok || s != "summary" {
+		t.Errorf("summary = %q, %v, want the prefetched one", s, ok)
+	}
+}
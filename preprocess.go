@@ -0,0 +1,68 @@
+package llm
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Preprocessor rewrites a raw user message before it is stored in the history and sent
+// to the model. It receives the external chat identifier passed to ChatsManager.Chat.
+// Returning an empty string drops the message.
+type Preprocessor func(id, message string) string
+
+// TrimMessage removes leading and trailing whitespace from the message.
+func TrimMessage() Preprocessor {
+	return func(id, message string) string {
+		return strings.TrimSpace(message)
+	}
+}
+
+var reMarkup = regexp.MustCompile(`<[a-zA-Z/!][^<>]*>`)
+
+// StripMarkup removes HTML/XML tags from the message, keeping the enclosed text.
+// Useful for messages relayed from rich text channels.
+func StripMarkup() Preprocessor {
+	return func(id, message string) string {
+		return reMarkup.ReplaceAllString(message, "")
+	}
+}
+
+// AppendContext appends the text returned by f to the message, separated by a blank line,
+// e.g. to tell the model which channel or user the message comes from.
+// Nothing is appended if f returns an empty string.
+func AppendContext(f func(id string) string) Preprocessor {
+	return func(id, message string) string {
+		if c := f(id); c != "" {
+			return message + "\n\n" + c
+		}
+		return message
+	}
+}
+
+// ExpandCommands replaces a leading slash-command with its expansion, keeping any
+// arguments after the command, e.g. with {"/tldr": "Summarize the following text:"}
+// the message "/tldr some text" becomes "Summarize the following text: some text".
+func ExpandCommands(commands map[string]string) Preprocessor {
+	return func(id, message string) string {
+		cmd, args, _ := strings.Cut(strings.TrimSpace(message), " ")
+		exp, ok := commands[cmd]
+		if !ok {
+			return message
+		}
+		if args = strings.TrimSpace(args); args != "" {
+			return exp + " " + args
+		}
+		return exp
+	}
+}
+
+// preprocess runs the message through the configured preprocessors in order.
+func (cm *ChatsManager) preprocess(id, message string) string {
+	for _, p := range cm.cnf.preprocessors {
+		message = p(id, message)
+		if message == "" {
+			break
+		}
+	}
+	return message
+}
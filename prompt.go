@@ -0,0 +1,41 @@
+package llm
+
+import (
+	"maps"
+
+	"github.com/xyzj/llm/chat"
+	"github.com/xyzj/llm/provider"
+
+	"github.com/volcengine/volcengine-go-sdk/volcengine"
+)
+
+// systemPrompt returns the system prompt of a turn of ch: the session system prompt, or
+// the one set with WithRoleSystem if the session has none, rendered with the variables of
+// the turn if WithPrompts is set. The stored prompts are not changed.
+func (cm *ChatsManager) systemPrompt(ch *chat.Chat, opt *TurnOpt) []*provider.Message {
+	sys := ch.SystemPrompt()
+	if len(sys) == 0 {
+		sys = cm.cnf.roleSystem
+	}
+	if cm.cnf.prompts == nil || len(sys) == 0 {
+		return sys
+	}
+	vars := ch.Metadata()
+	maps.Copy(vars, opt.vars)
+	msgs := make([]*provider.Message, len(sys))
+	for i, msg := range sys {
+		msgs[i] = msg
+		if msg.Content == nil || msg.Content.StringValue == nil {
+			continue
+		}
+		s, err := cm.cnf.prompts.Render(*msg.Content.StringValue, vars)
+		if err != nil {
+			cm.cnf.logg.Error("system prompt rendering failed", LogKeyChatID, ch.ID(), LogKeyError, err)
+			continue
+		}
+		m := *msg
+		m.Content = &provider.MessageContent{StringValue: volcengine.String(s)}
+		msgs[i] = &m
+	}
+	return msgs
+}
@@ -0,0 +1,116 @@
+// Package prompts renders prompts from templates in the text/template syntax, e.g. a
+// system prompt "You are {{.persona}} serving {{.customer}}" filled with the variables of
+// each chat session at request time, see llm.WithPrompts. A Library holds named templates,
+// which prompts and other templates include as partials with {{template "name" .}}.
+package prompts
+
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Library is a set of named templates. It is safe for concurrent use; templates may be
+// defined while others are rendered.
+type Library struct {
+	locker sync.RWMutex
+	root   *template.Template // Named templates, the root itself is empty
+}
+
+// New creates an empty Library. Variables missing when rendering are rendered as empty
+// text.
+func New() *Library {
+	return &Library{
+		root: template.New("").Option("missingkey=zero"),
+	}
+}
+
+// Define adds the template text under name, replacing a template of the same name.
+// Templates and rendered texts include it with {{template "name" .}}.
+//
+// Parameters:
+//   - name: Name of the template, e.g. "persona"
+//   - text: Template in the text/template syntax
+//
+// Returns:
+//   - error: If text is not a valid template
+func (l *Library) Define(name, text string) error {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	if _, err := l.root.New(name).Parse(text); err != nil {
+		return fmt.Errorf("template [%s]: %w", name, err)
+	}
+	return nil
+}
+
+// ParseFS adds the templates of the files in fsys matching the patterns, see fs.Glob,
+// each named after its file name without directory, e.g. "support.tmpl".
+func (l *Library) ParseFS(fsys fs.FS, patterns ...string) error {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	_, err := l.root.ParseFS(fsys, patterns...)
+	return err
+}
+
+// Has reports whether a template named name is defined.
+func (l *Library) Has(name string) bool {
+	l.locker.RLock()
+	defer l.locker.RUnlock()
+	return l.root.Lookup(name) != nil
+}
+
+// Execute renders the template named name with vars.
+//
+// Parameters:
+//   - name: Name of the template
+//   - vars: Variables of the template, usually a map[string]string or map[string]any
+//
+// Returns:
+//   - string: The rendered text
+//   - error: If the template is not defined or its execution failed
+func (l *Library) Execute(name string, vars any) (string, error) {
+	l.locker.RLock()
+	defer l.locker.RUnlock()
+	b := strings.Builder{}
+	if err := l.root.ExecuteTemplate(&b, name, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Render renders text as template with vars. Text may include the templates of the
+// library; text without actions is returned as is.
+//
+// Example:
+//
+//	lib.Define("tone", "Answer briefly and politely.")
+//	s, err := lib.Render(`You are {{.persona}}. {{template "tone" .}}`, map[string]string{"persona": "a travel agent"})
+func (l *Library) Render(text string, vars any) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	l.locker.RLock()
+	t, err := l.root.Clone()
+	l.locker.RUnlock()
+	if err != nil {
+		return "", err
+	}
+	// the text replaces the empty root of the copy
+	if t, err = t.New("").Parse(text); err != nil {
+		return "", err
+	}
+	b := strings.Builder{}
+	if err := t.Execute(&b, vars); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Use returns a prompt consisting of the template named name, e.g. to set a system
+// prompt that is defined in the library.
+func Use(name string) string {
+	return "{{template " + strconv.Quote(name) + " .}}"
+}
@@ -0,0 +1,151 @@
+package provider
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/volcengine/volcengine-go-sdk/service/arkruntime"
+	"github.com/volcengine/volcengine-go-sdk/service/arkruntime/model"
+)
+
+// defaultContextTTL is the lifetime of ARK prefix contexts of hints without TTL.
+const defaultContextTTL = time.Hour
+
+// NewArk creates a Provider backed by the VolcEngine ARK runtime.
+//
+// Requests with a CacheHint send their prefix messages as a common prefix context of the
+// ARK context API, which is created on first use and reused until it expires. Tool
+// definitions cannot be part of an ARK context and are sent with every request. If the
+// context cannot be created, e.g. as the endpoint does not support context caching, the
+// requests are sent as usual for the TTL of the hint.
+//
+// Parameters:
+//   - apikey: API key for VolcEngine ARK runtime authentication
+//   - opts: Optional ARK client configuration, e.g. arkruntime.WithBaseUrl
+//
+// Returns a Provider ready for use.
+func NewArk(apikey string, opts ...arkruntime.ConfigOption) Provider {
+	return &ark{
+		cli:      arkruntime.NewClientWithApiKey(apikey, opts...),
+		contexts: make(map[string]*arkContext),
+	}
+}
+
+// ark adapts *arkruntime.Client to the Provider interface.
+type ark struct {
+	cli      *arkruntime.Client     // VolcEngine ARK runtime client
+	locker   sync.Mutex             // Guards contexts
+	contexts map[string]*arkContext // Prefix contexts keyed by PrefixKey
+}
+
+// arkContext is a prefix context created with the ARK context API.
+type arkContext struct {
+	id      string    // Context id, empty if the creation failed
+	expires time.Time // Time the context expires on the server
+}
+
+func (a *ark) CreateCompletion(ctx context.Context, req Request) (Response, error) {
+	var resp Response
+	var err error
+	if id, n := a.prefixContext(ctx, req); id != "" {
+		resp, err = a.cli.CreateContextChatCompletion(ctx, contextRequest(id, req, n))
+	} else {
+		resp, err = a.cli.CreateChatCompletion(ctx, req)
+	}
+	return resp, classify(err)
+}
+
+func (a *ark) CreateCompletionStream(ctx context.Context, req Request) (Stream, error) {
+	if id, n := a.prefixContext(ctx, req); id != "" {
+		stream, err := a.cli.CreateContextChatCompletionStream(ctx, contextRequest(id, req, n))
+		if err != nil {
+			return nil, classify(err)
+		}
+		return stream, nil
+	}
+	stream, err := a.cli.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return nil, classify(err)
+	}
+	return stream, nil
+}
+
+// prefixContext returns the id of the context holding the prefix of req described by the
+// CacheHint of ctx, creating it if necessary, and the number of prefix messages. It
+// returns an empty id if ctx has no hint with messages or the context is not available.
+func (a *ark) prefixContext(ctx context.Context, req Request) (string, int) {
+	h, ok := CacheHintFrom(ctx)
+	if !ok || h.Messages <= 0 || h.Messages >= len(req.Messages) {
+		return "", 0
+	}
+	ttl := h.TTL
+	if ttl <= 0 {
+		ttl = defaultContextTTL
+	}
+	key := PrefixKey(Request{Model: req.Model, Messages: req.Messages}, CacheHint{Messages: h.Messages})
+	a.locker.Lock()
+	c, ok := a.contexts[key]
+	a.locker.Unlock()
+	// renew contexts shortly before they expire, so requests do not hit expired ones
+	if ok && time.Until(c.expires) > time.Minute {
+		return c.id, h.Messages
+	}
+	secs := int(ttl / time.Second)
+	c = &arkContext{expires: time.Now().Add(ttl)}
+	resp, err := a.cli.CreateContext(ctx, model.CreateContextRequest{
+		Model:    req.Model,
+		Mode:     model.ContextModeCommonPrefix,
+		Messages: req.Messages[:h.Messages],
+		TTL:      &secs,
+	})
+	if err != nil && ctx.Err() != nil {
+		// the request is cancelled, not the endpoint lacking support
+		return "", 0
+	}
+	if err == nil {
+		c.id = resp.ID
+	}
+	a.locker.Lock()
+	defer a.locker.Unlock()
+	now := time.Now()
+	for k, old := range a.contexts {
+		if now.After(old.expires) {
+			delete(a.contexts, k)
+		}
+	}
+	a.contexts[key] = c
+	return c.id, h.Messages
+}
+
+// contextRequest converts req to a request of the ARK context API, leaving out the first
+// n messages held by the context.
+func contextRequest(id string, req Request, n int) model.ContextChatCompletionRequest {
+	r := model.ContextChatCompletionRequest{
+		ContextID:     id,
+		Mode:          model.ContextModeCommonPrefix,
+		Model:         req.Model,
+		Messages:      req.Messages[n:],
+		Stop:          req.Stop,
+		LogitBias:     req.LogitBias,
+		Tools:         req.Tools,
+		ToolChoice:    req.ToolChoice,
+		StreamOptions: req.StreamOptions,
+	}
+	if req.MaxTokens != nil {
+		r.MaxTokens = *req.MaxTokens
+	}
+	if req.Temperature != nil {
+		r.Temperature = *req.Temperature
+	}
+	if req.TopP != nil {
+		r.TopP = *req.TopP
+	}
+	if req.FrequencyPenalty != nil {
+		r.FrequencyPenalty = *req.FrequencyPenalty
+	}
+	if req.Stream != nil {
+		r.Stream = *req.Stream
+	}
+	return r
+}
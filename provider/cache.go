@@ -0,0 +1,212 @@
+package provider
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+type (
+	// CacheOpt configures the response cache created by NewCache.
+	CacheOpt struct {
+		maxEntries int  // Maximum number of cached responses
+		all        bool // Whether requests with a non-zero temperature are cached too
+	}
+	// CacheOpts is a function type for configuring the response cache.
+	CacheOpts func(opt *CacheOpt)
+)
+
+// WithMaxEntries limits the number of cached responses, evicting the oldest ones first.
+// Defaults to 1000.
+func WithMaxEntries(n int) CacheOpts {
+	return func(opt *CacheOpt) {
+		if n > 0 {
+			opt.maxEntries = n
+		}
+	}
+}
+
+// WithCacheAll caches the responses of all requests, not only those with temperature 0.
+// Replayed answers then no longer vary between identical requests.
+func WithCacheAll() CacheOpts {
+	return func(opt *CacheOpt) {
+		opt.all = true
+	}
+}
+
+// NewCache wraps a Provider with an exact-match response cache, so identical requests
+// with deterministic settings, e.g. replayed regression suites or repeated classification
+// prompts, are answered without calling the backend again. The cache key is a hash of the
+// complete request, including the model, messages, tools, generation settings and whether
+// the response is streamed. Only requests with temperature 0 are cached unless
+// WithCacheAll is set; failed requests and streams ending with an error are not cached.
+// Cached responses are shared between callers and must not be modified.
+//
+// Parameters:
+//   - inner: Provider answering the requests not found in the cache
+//   - ttl: Time a response is served from the cache
+//   - opts: Optional configuration, e.g. WithMaxEntries
+//
+// Example:
+//
+//	p := provider.NewCache(provider.NewArk(key), time.Hour)
+func NewCache(inner Provider, ttl time.Duration, opts ...CacheOpts) Provider {
+	opt := &CacheOpt{
+		maxEntries: 1000,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	return &cache{
+		inner:   inner,
+		cnf:     opt,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// cacheEntry is a cached response, either complete or as recorded stream chunks.
+type cacheEntry struct {
+	key     string
+	resp    Response
+	chunks  []StreamResponse
+	expires time.Time
+}
+
+// cache is a Provider decorator caching responses by request hash.
+type cache struct {
+	locker  sync.Mutex // Guards entries and order
+	inner   Provider
+	cnf     *CacheOpt
+	ttl     time.Duration
+	entries map[string]*list.Element // Cached entries by key
+	order   *list.List               // Entries in insertion order, which is also expiry order
+}
+
+// key returns the cache key of req, or an empty string if req must not be cached.
+func (c *cache) key(req Request) string {
+	if !c.cnf.all && (req.Temperature == nil || *req.Temperature != 0) {
+		return ""
+	}
+	// encoding/json sorts map keys, so tool schemas always hash the same
+	b, err := json.Marshal(req)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// get returns the live entry of key.
+func (c *cache) get(key string) *cacheEntry {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+	if time.Now().After(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil
+	}
+	return e
+}
+
+// put stores an entry, evicting expired entries and the oldest ones beyond the limit.
+func (c *cache) put(e *cacheEntry) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	e.expires = time.Now().Add(c.ttl)
+	if el, ok := c.entries[e.key]; ok {
+		c.order.Remove(el)
+	}
+	c.entries[e.key] = c.order.PushBack(e)
+	now := time.Now()
+	for el := c.order.Front(); el != nil; el = c.order.Front() {
+		old := el.Value.(*cacheEntry)
+		if c.order.Len() <= c.cnf.maxEntries && now.Before(old.expires) {
+			break
+		}
+		c.order.Remove(el)
+		delete(c.entries, old.key)
+	}
+}
+
+func (c *cache) CreateCompletion(ctx context.Context, req Request) (Response, error) {
+	key := c.key(req)
+	if key == "" {
+		return c.inner.CreateCompletion(ctx, req)
+	}
+	if e := c.get(key); e != nil {
+		return e.resp, nil
+	}
+	resp, err := c.inner.CreateCompletion(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	c.put(&cacheEntry{key: key, resp: resp})
+	return resp, nil
+}
+
+func (c *cache) CreateCompletionStream(ctx context.Context, req Request) (Stream, error) {
+	key := c.key(req)
+	if key == "" {
+		return c.inner.CreateCompletionStream(ctx, req)
+	}
+	if e := c.get(key); e != nil {
+		return &replayStream{chunks: e.chunks}, nil
+	}
+	stream, err := c.inner.CreateCompletionStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &recordStream{Stream: stream, c: c, key: key}, nil
+}
+
+// recordStream records the chunks of a stream and caches them once it completed.
+type recordStream struct {
+	Stream
+	c      *cache
+	key    string
+	chunks []StreamResponse
+}
+
+func (s *recordStream) Recv() (StreamResponse, error) {
+	resp, err := s.Stream.Recv()
+	switch err {
+	case nil:
+		s.chunks = append(s.chunks, resp)
+	case io.EOF:
+		if s.c != nil {
+			s.c.put(&cacheEntry{key: s.key, chunks: s.chunks})
+			s.c = nil
+		}
+	}
+	return resp, err
+}
+
+// replayStream replays cached stream chunks.
+type replayStream struct {
+	chunks []StreamResponse
+}
+
+func (s *replayStream) Recv() (StreamResponse, error) {
+	if len(s.chunks) == 0 {
+		return StreamResponse{}, io.EOF
+	}
+	resp := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return resp, nil
+}
+
+func (s *replayStream) Close() error {
+	return nil
+}
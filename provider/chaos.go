@@ -0,0 +1,215 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInjected is returned for failures injected by the chaos decorator.
+	ErrInjected = errors.New("chaos: injected failure")
+	// ErrDisconnected is returned when the chaos decorator cuts a stream mid-response.
+	ErrDisconnected = errors.New("chaos: stream disconnected")
+)
+
+type (
+	// ChaosOpt configures the faults injected by NewChaos.
+	// Rates are probabilities between 0 and 1.
+	ChaosOpt struct {
+		minLatency     time.Duration // Minimum latency added before each request
+		maxLatency     time.Duration // Maximum latency added before each request
+		timeoutRate    float64       // Probability a request times out
+		errorRate      float64       // Probability a request fails with ErrInjected
+		malformedRate  float64       // Probability a streamed chunk is replaced by a malformed delta
+		disconnectRate float64       // Probability a stream is cut before a chunk
+		seed           uint64        // Random seed, 0 for a random seed
+	}
+	// ChaosOpts is a function type for configuring the chaos decorator.
+	ChaosOpts func(opt *ChaosOpt)
+)
+
+// WithLatency adds a random latency between min and max before every request.
+func WithLatency(min, max time.Duration) ChaosOpts {
+	return func(opt *ChaosOpt) {
+		opt.minLatency = min
+		opt.maxLatency = max
+	}
+}
+
+// WithTimeoutRate makes requests block until their context is done, or fail with
+// context.DeadlineExceeded if the context has no deadline, with probability p.
+func WithTimeoutRate(p float64) ChaosOpts {
+	return func(opt *ChaosOpt) {
+		opt.timeoutRate = p
+	}
+}
+
+// WithErrorRate makes requests fail with ErrInjected with probability p.
+func WithErrorRate(p float64) ChaosOpts {
+	return func(opt *ChaosOpt) {
+		opt.errorRate = p
+	}
+}
+
+// WithMalformedRate replaces streamed chunks with malformed deltas with probability p,
+// e.g. chunks without choices or tool call fragments for an unknown call.
+func WithMalformedRate(p float64) ChaosOpts {
+	return func(opt *ChaosOpt) {
+		opt.malformedRate = p
+	}
+}
+
+// WithDisconnectRate cuts streams with ErrDisconnected before each chunk with probability p.
+func WithDisconnectRate(p float64) ChaosOpts {
+	return func(opt *ChaosOpt) {
+		opt.disconnectRate = p
+	}
+}
+
+// WithSeed sets the random seed, making the injected faults reproducible.
+func WithSeed(seed uint64) ChaosOpts {
+	return func(opt *ChaosOpt) {
+		opt.seed = seed
+	}
+}
+
+// NewChaos wraps a Provider with fault injection, so applications can test their
+// error handling, retries and fallbacks against realistic failures:
+// latency, timeouts, request errors, malformed deltas and mid-stream disconnects.
+//
+// Example:
+//
+//	p := provider.NewChaos(provider.NewArk(key),
+//		provider.WithLatency(100*time.Millisecond, time.Second),
+//		provider.WithDisconnectRate(0.05),
+//	)
+func NewChaos(inner Provider, opts ...ChaosOpts) Provider {
+	opt := &ChaosOpt{}
+	for _, o := range opts {
+		o(opt)
+	}
+	seed := opt.seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	return &chaos{
+		inner: inner,
+		cnf:   opt,
+		rnd:   rand.New(rand.NewPCG(seed, seed)),
+	}
+}
+
+// chaos is a Provider decorator injecting faults.
+type chaos struct {
+	locker sync.Mutex // Guards rnd
+	inner  Provider
+	cnf    *ChaosOpt
+	rnd    *rand.Rand
+}
+
+// hit reports whether an event with probability p occurs.
+func (c *chaos) hit(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return c.rnd.Float64() < p
+}
+
+// before injects latency, timeouts and errors ahead of a request.
+func (c *chaos) before(ctx context.Context) error {
+	if c.cnf.maxLatency > 0 {
+		d := c.cnf.minLatency
+		if c.cnf.maxLatency > c.cnf.minLatency {
+			c.locker.Lock()
+			d += time.Duration(c.rnd.Int64N(int64(c.cnf.maxLatency - c.cnf.minLatency)))
+			c.locker.Unlock()
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	if c.hit(c.cnf.timeoutRate) {
+		if _, ok := ctx.Deadline(); !ok {
+			return context.DeadlineExceeded
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if c.hit(c.cnf.errorRate) {
+		return ErrInjected
+	}
+	return nil
+}
+
+func (c *chaos) CreateCompletion(ctx context.Context, req Request) (Response, error) {
+	if err := c.before(ctx); err != nil {
+		return Response{}, err
+	}
+	return c.inner.CreateCompletion(ctx, req)
+}
+
+func (c *chaos) CreateCompletionStream(ctx context.Context, req Request) (Stream, error) {
+	if err := c.before(ctx); err != nil {
+		return nil, err
+	}
+	stream, err := c.inner.CreateCompletionStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &chaosStream{Stream: stream, c: c}, nil
+}
+
+// chaosStream injects malformed deltas and disconnects into a stream.
+type chaosStream struct {
+	Stream
+	c    *chaos
+	dead bool
+}
+
+func (s *chaosStream) Recv() (StreamResponse, error) {
+	if s.dead {
+		return StreamResponse{}, ErrDisconnected
+	}
+	if s.c.hit(s.c.cnf.disconnectRate) {
+		s.dead = true
+		s.Stream.Close()
+		return StreamResponse{}, ErrDisconnected
+	}
+	resp, err := s.Stream.Recv()
+	if err != nil {
+		return resp, err
+	}
+	if s.c.hit(s.c.cnf.malformedRate) {
+		return s.malformed(resp), nil
+	}
+	return resp, nil
+}
+
+// malformed returns a broken variant of resp.
+func (s *chaosStream) malformed(resp StreamResponse) StreamResponse {
+	if s.c.hit(0.5) {
+		// chunk without any choices
+		resp.Choices = nil
+		return resp
+	}
+	// argument fragment for a tool call that was never announced
+	resp.Choices = []*StreamChoice{{
+		Delta: StreamDelta{
+			Role: RoleAssistant,
+			ToolCalls: []*ToolCall{{
+				Type:     ToolTypeFunction,
+				Function: FunctionCall{Arguments: `{"broken":`},
+			}},
+		},
+	}}
+	return resp
+}
@@ -0,0 +1,145 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/volcengine/volcengine-go-sdk/service/arkruntime/model"
+)
+
+var (
+	// ErrContextLengthExceeded is matched by errors of requests exceeding the context
+	// window of the model, e.g. errors.Is(err, provider.ErrContextLengthExceeded).
+	ErrContextLengthExceeded = errors.New("context length exceeded")
+	// ErrAuth is matched by errors of requests the backend did not authenticate or
+	// authorize, i.e. of a missing, invalid or insufficient API key.
+	ErrAuth = errors.New("authentication failed")
+	// ErrToolNotFound is returned by tool providers and MCP clients for calls to tools
+	// they do not offer.
+	ErrToolNotFound = errors.New("unknown tool")
+)
+
+// StatusError is returned by providers when the backend answers with an error status. It
+// matches ErrRateLimited, ErrAuth and ErrContextLengthExceeded with errors.Is.
+type StatusError struct {
+	StatusCode int           // HTTP status code of the response
+	Code       string        // Error code of the response body, e.g. "context_length_exceeded"
+	Message    string        // Error message of the response body
+	RetryAfter time.Duration // Delay requested by the Retry-After header, 0 if not set
+}
+
+func (e *StatusError) Error() string {
+	return "http status " + strconv.Itoa(e.StatusCode) + ": " + e.Message
+}
+
+// Is reports whether the status matches the kind of error target.
+func (e *StatusError) Is(target error) bool {
+	return target != nil && errorKind(e.StatusCode, e.Code, e.Message) == target
+}
+
+// kindError adds the kind of a backend error, see errorKind, to an error of a client
+// library, keeping its message and type.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// classify adds the kind of the ARK runtime errors to err, so they match ErrRateLimited,
+// ErrAuth and ErrContextLengthExceeded as the errors of the other providers do.
+func classify(err error) error {
+	var kind error
+	var ae *model.APIError
+	var re *model.RequestError
+	switch {
+	case errors.As(err, &ae):
+		kind = errorKind(ae.HTTPStatusCode, ae.Code, ae.Message)
+	case errors.As(err, &re):
+		kind = errorKind(re.HTTPStatusCode, "", "")
+	}
+	if kind == nil {
+		return err
+	}
+	return &kindError{kind: kind, err: err}
+}
+
+// errorKind returns the sentinel error matching an error response, or nil if there is none.
+func errorKind(status int, code, message string) error {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrAuth
+	case status == http.StatusBadRequest || status == http.StatusRequestEntityTooLarge:
+		code, message = strings.ToLower(code), strings.ToLower(message)
+		if strings.Contains(code, "context_length") || strings.Contains(message, "context length") ||
+			strings.Contains(message, "context window") || strings.Contains(message, "maximum context") {
+			return ErrContextLengthExceeded
+		}
+	}
+	return nil
+}
+
+// Retryable reports whether a failed request may succeed when sent again, i.e. the
+// backend was rate limited, temporarily unavailable or not reachable.
+//
+// Parameters:
+//   - err: Error returned by CreateCompletion or CreateCompletionStream
+//
+// Returns:
+//   - bool: true if the request should be retried
+//   - time.Duration: Delay requested by the backend, 0 if none
+func Retryable(err error) (bool, time.Duration) {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false, 0
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return retryableStatus(se.StatusCode), se.RetryAfter
+	}
+	var ae *model.APIError
+	if errors.As(err, &ae) {
+		return retryableStatus(ae.HTTPStatusCode), 0
+	}
+	var re *model.RequestError
+	if errors.As(err, &re) {
+		return retryableStatus(re.HTTPStatusCode), 0
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return true, 0
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF), 0
+}
+
+// retryableStatus reports whether an error status is transient.
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(s, 0)) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
@@ -0,0 +1,328 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned by the limiter decorator for requests it rejects, see
+// NewLimiter, and matched by the errors of requests the backend rate limited. Rejections
+// of the limiter are not retried by Retryable, as retrying would only add to the load.
+var ErrRateLimited = errors.New("rate limit: request rejected")
+
+// limitWindow is the sliding window of the request and token rates.
+const limitWindow = time.Minute
+
+type (
+	// LimitOpt configures the limits enforced by NewLimiter. Zero values disable a limit.
+	LimitOpt struct {
+		rpm        int           // Maximum requests started per minute
+		tpm        int           // Maximum tokens per minute, prompt and completion
+		concurrent int           // Maximum requests in flight
+		queue      int           // Maximum requests waiting, 0 for no limit
+		maxWait    time.Duration // Maximum time a request waits, 0 to wait until its context is done
+		reject     bool          // Whether requests over the limits fail instead of waiting
+	}
+	// LimitOpts is a function type for configuring the limiter.
+	LimitOpts func(opt *LimitOpt)
+)
+
+// WithRequestsPerMinute limits the requests started within any minute.
+func WithRequestsPerMinute(n int) LimitOpts {
+	return func(opt *LimitOpt) {
+		opt.rpm = max(n, 0)
+	}
+}
+
+// WithTokensPerMinute limits the tokens of the requests started within any minute. The
+// tokens of a request are estimated from its size when it starts and corrected with the
+// usage reported by the provider once it completed.
+func WithTokensPerMinute(n int) LimitOpts {
+	return func(opt *LimitOpt) {
+		opt.tpm = max(n, 0)
+	}
+}
+
+// WithMaxConcurrent limits the requests in flight. Streamed requests count until the
+// stream ended or was closed.
+func WithMaxConcurrent(n int) LimitOpts {
+	return func(opt *LimitOpt) {
+		opt.concurrent = max(n, 0)
+	}
+}
+
+// WithMaxQueue limits the requests waiting for the limits, further requests fail with
+// ErrRateLimited right away. Defaults to no limit.
+func WithMaxQueue(n int) LimitOpts {
+	return func(opt *LimitOpt) {
+		opt.queue = max(n, 0)
+	}
+}
+
+// WithMaxWait limits the time a request waits for the limits before it fails with
+// ErrRateLimited. Defaults to waiting until the context of the request is done.
+func WithMaxWait(d time.Duration) LimitOpts {
+	return func(opt *LimitOpt) {
+		opt.maxWait = max(d, 0)
+	}
+}
+
+// WithReject makes requests over the limits fail with ErrRateLimited right away instead
+// of waiting, e.g. for interactive chats that should rather show a "busy" notice.
+func WithReject() LimitOpts {
+	return func(opt *LimitOpt) {
+		opt.reject = true
+	}
+}
+
+// NewLimiter wraps a Provider with client-side limits of the request rate, the token rate
+// and the requests in flight, so bursts of chats sharing an API key queue up instead of
+// being answered with HTTP 429 or exceeding a budget. Waiting requests are served in
+// arrival order. The limiter also implements Warmer if inner does; warm-ups are not
+// limited.
+//
+// Example:
+//
+//	p := provider.NewLimiter(provider.NewArk(key),
+//		provider.WithRequestsPerMinute(60),
+//		provider.WithTokensPerMinute(100000),
+//		provider.WithMaxConcurrent(8),
+//		provider.WithMaxWait(30*time.Second),
+//	)
+func NewLimiter(inner Provider, opts ...LimitOpts) Provider {
+	opt := &LimitOpt{}
+	for _, o := range opts {
+		o(opt)
+	}
+	return &limiter{
+		inner: inner,
+		cnf:   opt,
+		wake:  make(chan struct{}),
+	}
+}
+
+// limiter is a Provider decorator enforcing request, token and concurrency limits.
+type limiter struct {
+	inner   Provider
+	cnf     *LimitOpt
+	locker  sync.Mutex    // Guards the fields below
+	active  int           // Requests in flight
+	starts  []time.Time   // Starts of the requests within the window
+	tokens  []*tokenCount // Tokens of the requests within the window
+	waiting []*waiter     // Waiting requests in arrival order
+	wake    chan struct{} // Closed and replaced whenever capacity may have become free
+}
+
+// waiter is a request waiting for the limits.
+type waiter struct {
+	tokens int // Estimated tokens of the request
+}
+
+// tokenCount is the token count of a request started at a time.
+type tokenCount struct {
+	at time.Time
+	n  int
+}
+
+// acquire waits until req may be sent and returns the function releasing its slot, which
+// is called with the token usage of the request, 0 if unknown.
+func (l *limiter) acquire(ctx context.Context, req Request) (func(used int), error) {
+	est := 0
+	if l.cnf.tpm > 0 {
+		est = estimateTokens(req)
+	}
+	var timeout <-chan time.Time
+	if l.cnf.maxWait > 0 {
+		t := time.NewTimer(l.cnf.maxWait)
+		defer t.Stop()
+		timeout = t.C
+	}
+	w := &waiter{tokens: est}
+	l.locker.Lock()
+	if l.cnf.queue > 0 && len(l.waiting) >= l.cnf.queue {
+		l.locker.Unlock()
+		return nil, ErrRateLimited
+	}
+	l.waiting = append(l.waiting, w)
+	for {
+		now := time.Now()
+		l.prune(now)
+		wait, ok := l.admits(now, w.tokens)
+		if ok && l.waiting[0] == w {
+			l.waiting = l.waiting[1:]
+			l.active++
+			l.starts = append(l.starts, now)
+			tc := &tokenCount{at: now, n: w.tokens}
+			l.tokens = append(l.tokens, tc)
+			l.notify()
+			l.locker.Unlock()
+			return l.releaser(tc), nil
+		}
+		if l.cnf.reject {
+			l.leave(w)
+			l.locker.Unlock()
+			return nil, ErrRateLimited
+		}
+		wake := l.wake
+		l.locker.Unlock()
+		retry := time.NewTimer(wait)
+		if wait <= 0 {
+			// waiting for a request to complete
+			retry.Stop()
+		}
+		var err error
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-timeout:
+			err = ErrRateLimited
+		case <-wake:
+		case <-retry.C:
+		}
+		retry.Stop()
+		l.locker.Lock()
+		if err != nil {
+			l.leave(w)
+			l.locker.Unlock()
+			return nil, err
+		}
+	}
+}
+
+// admits reports whether a request of est tokens may start now, or else how long until
+// the rate windows free up, 0 if it waits for a request to complete.
+func (l *limiter) admits(now time.Time, est int) (time.Duration, bool) {
+	if l.cnf.concurrent > 0 && l.active >= l.cnf.concurrent {
+		return 0, false
+	}
+	if l.cnf.rpm > 0 && len(l.starts) >= l.cnf.rpm {
+		return l.starts[0].Add(limitWindow).Sub(now), false
+	}
+	if l.cnf.tpm > 0 && len(l.tokens) > 0 {
+		sum := est
+		for _, tc := range l.tokens {
+			sum += tc.n
+		}
+		// a request larger than the limit is admitted into an empty window
+		if sum > l.cnf.tpm {
+			return l.tokens[0].at.Add(limitWindow).Sub(now), false
+		}
+	}
+	return 0, true
+}
+
+// prune drops the starts and token counts that left the window.
+func (l *limiter) prune(now time.Time) {
+	from := now.Add(-limitWindow)
+	i := 0
+	for i < len(l.starts) && !l.starts[i].After(from) {
+		i++
+	}
+	l.starts = l.starts[i:]
+	i = 0
+	for i < len(l.tokens) && !l.tokens[i].at.After(from) {
+		i++
+	}
+	l.tokens = l.tokens[i:]
+}
+
+// leave removes a waiting request, letting the next one try.
+func (l *limiter) leave(w *waiter) {
+	l.waiting = slices.DeleteFunc(l.waiting, func(o *waiter) bool { return o == w })
+	l.notify()
+}
+
+// notify wakes the waiting requests.
+func (l *limiter) notify() {
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// releaser returns the function releasing the slot of a request, which may be called
+// more than once.
+func (l *limiter) releaser(tc *tokenCount) func(used int) {
+	once := sync.Once{}
+	return func(used int) {
+		once.Do(func() {
+			l.locker.Lock()
+			defer l.locker.Unlock()
+			l.active--
+			if used > 0 {
+				tc.n = used
+			}
+			l.notify()
+		})
+	}
+}
+
+func (l *limiter) CreateCompletion(ctx context.Context, req Request) (Response, error) {
+	release, err := l.acquire(ctx, req)
+	if err != nil {
+		return Response{}, err
+	}
+	resp, err := l.inner.CreateCompletion(ctx, req)
+	release(resp.Usage.TotalTokens)
+	return resp, err
+}
+
+func (l *limiter) CreateCompletionStream(ctx context.Context, req Request) (Stream, error) {
+	release, err := l.acquire(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := l.inner.CreateCompletionStream(ctx, req)
+	if err != nil {
+		release(0)
+		return nil, err
+	}
+	return &limitStream{Stream: stream, release: release}, nil
+}
+
+// Warm passes warm-ups to the wrapped provider if it implements Warmer.
+func (l *limiter) Warm(ctx context.Context) error {
+	if wm, ok := l.inner.(Warmer); ok {
+		return wm.Warm(ctx)
+	}
+	return nil
+}
+
+// limitStream releases the slot of a streamed request once it ended or was closed.
+type limitStream struct {
+	Stream
+	release func(used int)
+	used    int
+}
+
+func (s *limitStream) Recv() (StreamResponse, error) {
+	resp, err := s.Stream.Recv()
+	if resp.Usage != nil {
+		s.used = resp.Usage.TotalTokens
+	}
+	if err != nil {
+		s.release(s.used)
+	}
+	return resp, err
+}
+
+func (s *limitStream) Close() error {
+	s.release(s.used)
+	return s.Stream.Close()
+}
+
+// estimateTokens estimates the tokens of req from the size of its messages and tools,
+// plus the completion limit if set.
+func estimateTokens(req Request) int {
+	b, _ := json.Marshal(struct {
+		Messages []*Message `json:"messages"`
+		Tools    []*Tool    `json:"tools,omitempty"`
+	}{req.Messages, req.Tools})
+	n := len(b) / 4
+	if req.MaxTokens != nil {
+		n += *req.MaxTokens
+	}
+	return n
+}
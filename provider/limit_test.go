@@ -0,0 +1,172 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// heldProvider answers each request once it is released, reporting its start first.
+type heldProvider struct {
+	started chan string
+	release chan struct{}
+}
+
+func newHeldProvider() *heldProvider {
+	return &heldProvider{started: make(chan string, 16), release: make(chan struct{})}
+}
+
+func (p *heldProvider) CreateCompletion(ctx context.Context, req Request) (Response, error) {
+	p.started <- req.Model
+	select {
+	case <-p.release:
+		return Response{}, nil
+	case <-ctx.Done():
+		return Response{}, ctx.Err()
+	}
+}
+
+func (p *heldProvider) CreateCompletionStream(ctx context.Context, req Request) (Stream, error) {
+	p.started <- req.Model
+	return heldStream{}, nil
+}
+
+type heldStream struct{}
+
+func (heldStream) Recv() (StreamResponse, error) { return StreamResponse{}, io.EOF }
+func (heldStream) Close() error                  { return nil }
+
+// complete sends a request for model in the background and returns its error channel.
+func complete(ctx context.Context, p Provider, model string) <-chan error {
+	errc := make(chan error, 1)
+	go func() {
+		_, err := p.CreateCompletion(ctx, Request{Model: model})
+		errc <- err
+	}()
+	return errc
+}
+
+func TestLimiterConcurrencyInOrder(t *testing.T) {
+	inner := newHeldProvider()
+	l := NewLimiter(inner, WithMaxConcurrent(1))
+	ctx := context.Background()
+	first := complete(ctx, l, "first")
+	if m := <-inner.started; m != "first" {
+		t.Fatalf("started %s, want first", m)
+	}
+	second := complete(ctx, l, "second")
+	// let the second request queue up before the third
+	time.Sleep(10 * time.Millisecond)
+	third := complete(ctx, l, "third")
+	select {
+	case m := <-inner.started:
+		t.Fatalf("%s started beyond the limit", m)
+	case <-time.After(20 * time.Millisecond):
+	}
+	for _, want := range []string{"second", "third"} {
+		inner.release <- struct{}{}
+		if m := <-inner.started; m != want {
+			t.Errorf("started %s, want %s", m, want)
+		}
+	}
+	inner.release <- struct{}{}
+	for _, errc := range []<-chan error{first, second, third} {
+		if err := <-errc; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestLimiterReject(t *testing.T) {
+	inner := newHeldProvider()
+	l := NewLimiter(inner, WithMaxConcurrent(1), WithReject())
+	ctx := context.Background()
+	first := complete(ctx, l, "first")
+	<-inner.started
+	if _, err := l.CreateCompletion(ctx, Request{Model: "second"}); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("error = %v, want ErrRateLimited", err)
+	}
+	inner.release <- struct{}{}
+	if err := <-first; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLimiterRequestsPerMinute(t *testing.T) {
+	inner := newHeldProvider()
+	close(inner.release)
+	l := NewLimiter(inner, WithRequestsPerMinute(2), WithReject())
+	ctx := context.Background()
+	for range 2 {
+		if _, err := l.CreateCompletion(ctx, Request{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := l.CreateCompletion(ctx, Request{}); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("error = %v, want ErrRateLimited", err)
+	}
+}
+
+func TestLimiterQueueAndWait(t *testing.T) {
+	inner := newHeldProvider()
+	l := NewLimiter(inner, WithMaxConcurrent(1), WithMaxQueue(1), WithMaxWait(50*time.Millisecond))
+	ctx := context.Background()
+	first := complete(ctx, l, "first")
+	<-inner.started
+	second := complete(ctx, l, "second")
+	time.Sleep(10 * time.Millisecond)
+	if _, err := l.CreateCompletion(ctx, Request{Model: "third"}); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("error beyond the queue = %v, want ErrRateLimited", err)
+	}
+	if err := <-second; !errors.Is(err, ErrRateLimited) {
+		t.Errorf("error after the wait = %v, want ErrRateLimited", err)
+	}
+	inner.release <- struct{}{}
+	if err := <-first; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLimiterCanceledWaitLeavesQueue(t *testing.T) {
+	inner := newHeldProvider()
+	l := NewLimiter(inner, WithMaxConcurrent(1))
+	first := complete(context.Background(), l, "first")
+	<-inner.started
+	ctx, cancel := context.WithCancel(context.Background())
+	second := complete(ctx, l, "second")
+	time.Sleep(10 * time.Millisecond)
+	third := complete(context.Background(), l, "third")
+	cancel()
+	if err := <-second; !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+	inner.release <- struct{}{}
+	if m := <-inner.started; m != "third" {
+		t.Errorf("started %s, want third", m)
+	}
+	inner.release <- struct{}{}
+	for _, errc := range []<-chan error{first, third} {
+		if err := <-errc; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestLimiterStreamReleasesOnClose(t *testing.T) {
+	inner := newHeldProvider()
+	l := NewLimiter(inner, WithMaxConcurrent(1), WithReject())
+	ctx := context.Background()
+	s, err := l.CreateCompletionStream(ctx, Request{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.CreateCompletionStream(ctx, Request{}); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("error while streaming = %v, want ErrRateLimited", err)
+	}
+	s.Close()
+	if _, err = l.CreateCompletionStream(ctx, Request{}); err != nil {
+		t.Errorf("error after Close = %v", err)
+	}
+}
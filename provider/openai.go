@@ -0,0 +1,298 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/xyzj/toolbox/json"
+)
+
+type (
+	// OpenAIOpt configures the OpenAI-compatible provider.
+	OpenAIOpt struct {
+		client  *http.Client      // HTTP client sending the requests
+		headers map[string]string // Additional headers sent with every request
+	}
+	// OpenAIOpts is a function type for configuring the OpenAI-compatible provider.
+	OpenAIOpts func(opt *OpenAIOpt)
+)
+
+// WithHTTPClient sets the HTTP client used by the OpenAI-compatible provider,
+// e.g. to configure proxies or TLS.
+func WithHTTPClient(c *http.Client) OpenAIOpts {
+	return func(opt *OpenAIOpt) {
+		if c != nil {
+			opt.client = c
+		}
+	}
+}
+
+// WithHeader adds a header sent with every request of the OpenAI-compatible provider,
+// e.g. an organization or project id.
+func WithHeader(key, value string) OpenAIOpts {
+	return func(opt *OpenAIOpt) {
+		opt.headers[key] = value
+	}
+}
+
+// NewOpenAI creates a Provider for OpenAI-compatible chat completion endpoints,
+// e.g. Ollama, vLLM, LM Studio or OpenAI itself. Streamed responses are read as
+// server-sent events. The provider also implements Warmer.
+//
+// Parameters:
+//   - baseURI: Base URI of the service, e.g. "http://127.0.0.1:11434"; "/v1" is appended unless present
+//   - apikey: API key sent as bearer token, empty to send none
+//   - opts: Optional configuration, e.g. WithHTTPClient
+//
+// Returns a Provider ready for use.
+func NewOpenAI(baseURI, apikey string, opts ...OpenAIOpts) Provider {
+	opt := &OpenAIOpt{
+		client:  &http.Client{},
+		headers: make(map[string]string),
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	base := strings.TrimSuffix(baseURI, "/")
+	if !strings.HasSuffix(base, "/v1") {
+		base += "/v1"
+	}
+	return &openai{
+		base:   base,
+		apikey: apikey,
+		cnf:    opt,
+	}
+}
+
+// openai is a Provider speaking the OpenAI chat completions HTTP API.
+type openai struct {
+	base   string     // Base URI including the /v1 prefix
+	apikey string     // API key sent as bearer token
+	cnf    *OpenAIOpt // Configuration options
+}
+
+// apiError is the error body returned by OpenAI-compatible services.
+type apiError struct {
+	Error *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+		Code    any    `json:"code"`
+	} `json:"error"`
+}
+
+func (o *openai) CreateCompletion(ctx context.Context, req Request) (Response, error) {
+	var resp Response
+	stream := false
+	req.Stream = &stream
+	req.StreamOptions = nil
+	r, err := o.post(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	defer r.Body.Close()
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return resp, err
+	}
+	if err = json.Unmarshal(b, &resp); err != nil {
+		return resp, err
+	}
+	for i, r := range parseRefusals(b) {
+		if r != "" && i < len(resp.Choices) {
+			c := resp.Choices[i]
+			c.Message.Role = RoleAssistant
+			c.Message.Content = &MessageContent{StringValue: &r}
+			c.FinishReason = FinishReasonRefusal
+		}
+	}
+	return resp, nil
+}
+
+// refusals holds the refusals of the choices of a response or stream chunk, which the
+// types of the package have no field for.
+type refusals struct {
+	Choices []struct {
+		Message struct {
+			Refusal string `json:"refusal"`
+		} `json:"message"`
+		Delta struct {
+			Refusal string `json:"refusal"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+// parseRefusals returns the refusals of the choices of the response or stream chunk b, at
+// most one per choice, or nil if the model did not refuse.
+func parseRefusals(b []byte) []string {
+	if !bytes.Contains(b, []byte(`"refusal":"`)) {
+		return nil
+	}
+	var rs refusals
+	if json.Unmarshal(b, &rs) != nil {
+		return nil
+	}
+	x := make([]string, len(rs.Choices))
+	for i, c := range rs.Choices {
+		x[i] = c.Message.Refusal + c.Delta.Refusal
+	}
+	return x
+}
+
+func (o *openai) CreateCompletionStream(ctx context.Context, req Request) (Stream, error) {
+	stream := true
+	req.Stream = &stream
+	r, err := o.post(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &sseStream{body: r.Body, rd: bufio.NewReader(r.Body), roles: make(map[int]string)}, nil
+}
+
+// Warm requests the model list to establish a connection to the service.
+func (o *openai) Warm(ctx context.Context) error {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, o.base+"/models", nil)
+	if err != nil {
+		return err
+	}
+	o.setHeaders(r)
+	resp, err := o.cnf.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+// post sends a chat completion request and returns the response if its status is 200.
+// Requests with a CacheHint carry the prompt_cache_key of their prefix, which routes
+// requests sharing the prefix to the same cache, and requests with a thinking budget, see
+// WithThinkingBudget, carry it as thinking_budget.
+func (o *openai) post(ctx context.Context, req Request) (*http.Response, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if h, ok := CacheHintFrom(ctx); ok && len(b) > 0 && b[len(b)-1] == '}' {
+		// Request has no field for the key; the key is hex and needs no escaping
+		b = append(b[:len(b)-1], `,"prompt_cache_key":"`+PrefixKey(req, h)+`"}`...)
+	}
+	if n, ok := ThinkingBudgetFrom(ctx); ok && len(b) > 0 && b[len(b)-1] == '}' {
+		b = append(b[:len(b)-1], `,"thinking_budget":`+strconv.Itoa(n)+`}`...)
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, o.base+"/chat/completions", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	o.setHeaders(r)
+	resp, err := o.cnf.client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, NewStatusError(resp.StatusCode, resp.Header, body)
+	}
+	return resp, nil
+}
+
+func (o *openai) setHeaders(r *http.Request) {
+	if o.apikey != "" {
+		r.Header.Set("Authorization", "Bearer "+o.apikey)
+	}
+	for k, v := range o.cnf.headers {
+		r.Header.Set(k, v)
+	}
+}
+
+// NewStatusError converts an error response into a *StatusError, using the error message
+// of the body if it has the OpenAI error format. It serves other clients of
+// OpenAI-compatible services, e.g. embeddings, so their errors work with Retryable.
+//
+// Parameters:
+//   - status: HTTP status code of the response
+//   - header: Response headers, read for Retry-After
+//   - body: Response body, or its beginning
+func NewStatusError(status int, header http.Header, body []byte) error {
+	e := &StatusError{
+		StatusCode: status,
+		Message:    strings.TrimSpace(json.String(body)),
+		RetryAfter: parseRetryAfter(header.Get("Retry-After")),
+	}
+	ae := &apiError{}
+	if json.Unmarshal(body, ae) == nil && ae.Error != nil {
+		e.Message = ae.Error.Message
+		if code, ok := ae.Error.Code.(string); ok {
+			e.Code = code
+		}
+	}
+	return e
+}
+
+// sseStream reads a streamed chat completion sent as server-sent events. Refusals are
+// streamed as content and end with FinishReasonRefusal. Services send the role of a
+// choice with its first chunk only, the stream sets it on every chunk.
+type sseStream struct {
+	body    io.ReadCloser  // Response body
+	rd      *bufio.Reader  // Buffered reader of the body
+	refused bool           // Whether the model streamed a refusal
+	roles   map[int]string // Roles of the choices by index, sent with their first chunk
+}
+
+// Recv returns the next chunk of the response, or io.EOF after the [DONE] event.
+func (s *sseStream) Recv() (StreamResponse, error) {
+	var chunk StreamResponse
+	for {
+		line, err := s.rd.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return chunk, err
+		}
+		line = strings.TrimSpace(line)
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			// blank lines separate events, other fields and comments are not used
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return chunk, io.EOF
+		}
+		ae := &apiError{}
+		if json.Unmarshal(json.Bytes(data), ae) == nil && ae.Error != nil {
+			return chunk, fmt.Errorf("stream error: %s", ae.Error.Message)
+		}
+		if err = json.Unmarshal(json.Bytes(data), &chunk); err != nil {
+			return chunk, err
+		}
+		for i, r := range parseRefusals(json.Bytes(data)) {
+			if r != "" && i < len(chunk.Choices) {
+				chunk.Choices[i].Delta.Role = RoleAssistant
+				chunk.Choices[i].Delta.Content = r
+				s.refused = true
+			}
+		}
+		for _, c := range chunk.Choices {
+			if c.Delta.Role != "" {
+				s.roles[c.Index] = c.Delta.Role
+			} else {
+				c.Delta.Role = s.roles[c.Index]
+			}
+			if s.refused && c.FinishReason != "" {
+				c.FinishReason = FinishReasonRefusal
+			}
+		}
+		return chunk, nil
+	}
+}
+
+func (s *sseStream) Close() error {
+	return s.body.Close()
+}
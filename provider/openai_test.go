@@ -0,0 +1,52 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// sseHandler serves the chunks as a streamed chat completion.
+func sseHandler(chunks ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, c := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", c)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}
+}
+
+func TestOpenAIStreamRole(t *testing.T) {
+	srv := httptest.NewServer(sseHandler(
+		`{"model":"m","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}`,
+		`{"model":"m","choices":[{"index":0,"delta":{"content":"lo"}}]}`,
+		`{"model":"m","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+	))
+	defer srv.Close()
+	s, err := NewOpenAI(srv.URL, "").CreateCompletionStream(context.Background(), Request{Model: "m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var n int
+	for {
+		chunk, err := s.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if role := chunk.Choices[0].Delta.Role; role != RoleAssistant {
+			t.Errorf("chunk %d: role = %q, want %q", n, role, RoleAssistant)
+		}
+		n++
+	}
+	if n != 3 {
+		t.Errorf("received %d chunks, want 3", n)
+	}
+}
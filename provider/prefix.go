@@ -0,0 +1,55 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// CacheHint marks the beginning of a request as a prompt prefix that is repeated by the
+// following requests, e.g. the system prompt and the tool definitions, so providers with
+// prompt or context caching can keep it cached and charge less for it. Providers without
+// caching ignore it.
+type CacheHint struct {
+	Messages int           // Number of leading messages belonging to the prefix
+	Tools    bool          // Whether the tool definitions belong to the prefix
+	TTL      time.Duration // Time the prefix should stay cached, 0 for the provider default
+}
+
+// cacheHintKey is the context key of the CacheHint of a request.
+type cacheHintKey struct{}
+
+// WithCacheHint returns a copy of ctx carrying h to the provider serving a request sent
+// with it. Hints without prefix messages or tools are ignored.
+func WithCacheHint(ctx context.Context, h CacheHint) context.Context {
+	return context.WithValue(ctx, cacheHintKey{}, h)
+}
+
+// CacheHintFrom returns the CacheHint carried by ctx, see WithCacheHint.
+func CacheHintFrom(ctx context.Context) (CacheHint, bool) {
+	h, ok := ctx.Value(cacheHintKey{}).(CacheHint)
+	return h, ok && (h.Messages > 0 || h.Tools)
+}
+
+// PrefixKey returns a hash identifying the cacheable prefix of req described by h, i.e.
+// the model, the prefix messages and, if marked, the tools. Requests with the same prefix
+// have the same key.
+func PrefixKey(req Request, h CacheHint) string {
+	prefix := struct {
+		Model    string     `json:"model"`
+		Messages []*Message `json:"messages"`
+		Tools    []*Tool    `json:"tools,omitempty"`
+	}{
+		Model:    req.Model,
+		Messages: req.Messages[:min(max(h.Messages, 0), len(req.Messages))],
+	}
+	if h.Tools {
+		prefix.Tools = req.Tools
+	}
+	// encoding/json sorts map keys, so tool schemas always hash the same
+	b, _ := json.Marshal(prefix)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16])
+}
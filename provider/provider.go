@@ -0,0 +1,36 @@
+// Package provider defines the interface between chat sessions and the completion
+// backends serving them, together with the message types used throughout the module,
+// aliases of the ARK runtime SDK types. The VolcEngine ARK runtime is the default
+// implementation; other backends are plugged in by implementing Provider, and decorators
+// such as the fault injector wrap any Provider to add behavior.
+package provider
+
+import (
+	"context"
+)
+
+type (
+	// Stream is a streaming chat completion response.
+	// Recv returns io.EOF once the stream is finished.
+	Stream interface {
+		// Recv returns the next chunk of the response.
+		Recv() (StreamResponse, error)
+		// Close releases the underlying connection.
+		Close() error
+	}
+
+	// Provider creates chat completions.
+	Provider interface {
+		// CreateCompletion sends a request and waits for the complete response.
+		CreateCompletion(ctx context.Context, req Request) (Response, error)
+		// CreateCompletionStream sends a request and returns the response as a stream.
+		CreateCompletionStream(ctx context.Context, req Request) (Stream, error)
+	}
+
+	// Warmer is an optional interface implemented by providers that can establish or
+	// refresh their connections ahead of the next request, reducing its latency.
+	Warmer interface {
+		// Warm prepares the connection to the backend.
+		Warm(ctx context.Context) error
+	}
+)
@@ -0,0 +1,20 @@
+package provider
+
+import "context"
+
+// thinkingBudgetKey is the context key of the thinking budget of a request.
+type thinkingBudgetKey struct{}
+
+// WithThinkingBudget returns a copy of ctx carrying the maximum number of reasoning tokens
+// of a request sent with it to the provider serving it. Request has no field for the
+// budget, so providers without a way to send it ignore it.
+func WithThinkingBudget(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, thinkingBudgetKey{}, n)
+}
+
+// ThinkingBudgetFrom returns the positive thinking budget carried by ctx, see
+// WithThinkingBudget.
+func ThinkingBudgetFrom(ctx context.Context) (int, bool) {
+	n, ok := ctx.Value(thinkingBudgetKey{}).(int)
+	return n, ok && n > 0
+}
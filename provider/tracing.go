@@ -0,0 +1,166 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans of the package.
+const tracerName = "github.com/xyzj/llm/provider"
+
+// NewTracing wraps a Provider with OpenTelemetry spans of its completion requests, named
+// "chat <model>" after the GenAI semantic conventions. The spans carry the requested and
+// responding model, the size of the request, the finish reason and the token usage;
+// spans of streamed requests end with the stream and record the time to the first token.
+// The tracing provider also implements Warmer if inner does.
+//
+// Parameters:
+//   - inner: The provider serving the requests
+//   - tp: Provider of the tracer, e.g. otel.GetTracerProvider()
+func NewTracing(inner Provider, tp trace.TracerProvider) Provider {
+	return &tracing{inner: inner, tracer: tp.Tracer(tracerName)}
+}
+
+// tracing is a Provider decorator recording a span per completion request.
+type tracing struct {
+	inner  Provider
+	tracer trace.Tracer
+}
+
+// start starts the span of req.
+func (t *tracing) start(ctx context.Context, req Request, stream bool) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("gen_ai.operation.name", "chat"),
+		attribute.String("gen_ai.request.model", req.Model),
+		attribute.Int("llm.request.messages", len(req.Messages)),
+		attribute.Int("llm.request.tools", len(req.Tools)),
+		attribute.Bool("llm.request.stream", stream),
+	}
+	if req.MaxTokens != nil {
+		attrs = append(attrs, attribute.Int("gen_ai.request.max_tokens", *req.MaxTokens))
+	}
+	if req.Temperature != nil {
+		attrs = append(attrs, attribute.Float64("gen_ai.request.temperature", float64(*req.Temperature)))
+	}
+	return t.tracer.Start(ctx, "chat "+req.Model, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+func (t *tracing) CreateCompletion(ctx context.Context, req Request) (Response, error) {
+	ctx, span := t.start(ctx, req, false)
+	defer span.End()
+	resp, err := t.inner.CreateCompletion(ctx, req)
+	if err != nil {
+		spanError(span, err)
+		return resp, err
+	}
+	if resp.ID != "" {
+		span.SetAttributes(attribute.String("gen_ai.response.id", resp.ID))
+	}
+	if resp.Model != "" {
+		span.SetAttributes(attribute.String("gen_ai.response.model", resp.Model))
+	}
+	if len(resp.Choices) > 0 && resp.Choices[0].FinishReason != "" {
+		span.SetAttributes(attribute.StringSlice("gen_ai.response.finish_reasons", []string{string(resp.Choices[0].FinishReason)}))
+	}
+	spanUsage(span, &resp.Usage)
+	return resp, nil
+}
+
+func (t *tracing) CreateCompletionStream(ctx context.Context, req Request) (Stream, error) {
+	start := time.Now()
+	ctx, span := t.start(ctx, req, true)
+	stream, err := t.inner.CreateCompletionStream(ctx, req)
+	if err != nil {
+		spanError(span, err)
+		span.End()
+		return nil, err
+	}
+	return &tracingStream{Stream: stream, span: span, start: start}, nil
+}
+
+// Warm passes warm-ups to the wrapped provider if it implements Warmer.
+func (t *tracing) Warm(ctx context.Context) error {
+	if wm, ok := t.inner.(Warmer); ok {
+		return wm.Warm(ctx)
+	}
+	return nil
+}
+
+// tracingStream records the chunks of a streamed request on its span and ends the span
+// once the stream ended or was closed.
+type tracingStream struct {
+	Stream
+	span   trace.Span
+	start  time.Time
+	first  bool   // Whether the first content was received
+	finish string // Finish reason reported by the stream
+	once   sync.Once
+}
+
+func (s *tracingStream) Recv() (StreamResponse, error) {
+	resp, err := s.Stream.Recv()
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			spanError(s.span, err)
+		}
+		s.end()
+		return resp, err
+	}
+	if resp.ID != "" {
+		s.span.SetAttributes(attribute.String("gen_ai.response.id", resp.ID))
+	}
+	if resp.Model != "" {
+		s.span.SetAttributes(attribute.String("gen_ai.response.model", resp.Model))
+	}
+	if resp.Usage != nil {
+		spanUsage(s.span, resp.Usage)
+	}
+	if len(resp.Choices) > 0 {
+		c := resp.Choices[0]
+		if !s.first && (c.Delta.Content != "" || len(c.Delta.ToolCalls) > 0) {
+			s.first = true
+			s.span.SetAttributes(attribute.Float64("llm.response.time_to_first_token", time.Since(s.start).Seconds()))
+		}
+		if c.FinishReason != "" {
+			s.finish = string(c.FinishReason)
+		}
+	}
+	return resp, nil
+}
+
+func (s *tracingStream) Close() error {
+	s.end()
+	return s.Stream.Close()
+}
+
+// end ends the span, which may happen more than once.
+func (s *tracingStream) end() {
+	s.once.Do(func() {
+		if s.finish != "" {
+			s.span.SetAttributes(attribute.StringSlice("gen_ai.response.finish_reasons", []string{s.finish}))
+		}
+		s.span.End()
+	})
+}
+
+// spanUsage records the token usage u on span.
+func spanUsage(span trace.Span, u *Usage) {
+	span.SetAttributes(
+		attribute.Int("gen_ai.usage.input_tokens", u.PromptTokens),
+		attribute.Int("gen_ai.usage.output_tokens", u.CompletionTokens),
+		attribute.Int("llm.usage.cached_tokens", u.PromptTokensDetails.CachedTokens),
+	)
+}
+
+// spanError records err as the error of span.
+func spanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
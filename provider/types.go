@@ -0,0 +1,93 @@
+package provider
+
+import (
+	"github.com/volcengine/volcengine-go-sdk/service/arkruntime/model"
+)
+
+// Message and request types.
+//
+// The types are aliases of the types of the ARK runtime SDK (arkruntime/model), which
+// follow the OpenAI chat completions schema shared by ARK, OpenAI, Ollama, vLLM and most
+// other backends. Chat sessions, histories and storage backends refer to them by these
+// names, but they are the SDK types: the SDK is a dependency of every user of the module,
+// and a backend is plugged in by implementing Provider and translating its own wire
+// format, if it differs, to them.
+type (
+	// Message is a single message of a conversation.
+	Message = model.ChatCompletionMessage
+	// MessageContent is the content of a message, either text or multi-part content.
+	MessageContent = model.ChatCompletionMessageContent
+	// ContentPart is one part of multi-part message content, e.g. text or an image.
+	ContentPart = model.ChatCompletionMessageContentPart
+	// ImageURL is the image of an image content part, as URL or data URL.
+	ImageURL = model.ChatMessageImageURL
+	// Request is a chat completion request.
+	Request = model.CreateChatCompletionRequest
+	// Response is a complete, non-streamed chat completion response.
+	Response = model.ChatCompletionResponse
+	// StreamResponse is one chunk of a streamed chat completion response.
+	StreamResponse = model.ChatCompletionStreamResponse
+	// StreamChoice is one choice of a streamed response chunk.
+	StreamChoice = model.ChatCompletionStreamChoice
+	// StreamDelta is the content increment of a streamed choice.
+	StreamDelta = model.ChatCompletionStreamChoiceDelta
+	// StreamOptions configures streamed responses, e.g. to include token usage.
+	StreamOptions = model.StreamOptions
+	// FinishReason is the reason the model stopped generating, e.g. FinishReasonStop.
+	FinishReason = model.FinishReason
+	// Usage is the token usage reported for a request.
+	Usage = model.Usage
+	// Tool is a tool offered to the model.
+	Tool = model.Tool
+	// ToolType is the type of a tool, e.g. ToolTypeFunction.
+	ToolType = model.ToolType
+	// ToolCall is a call of a tool made by the model.
+	ToolCall = model.ToolCall
+	// FunctionDefinition describes a function tool.
+	FunctionDefinition = model.FunctionDefinition
+	// FunctionCall is the function name and arguments of a tool call.
+	FunctionCall = model.FunctionCall
+	// LogProb is the log probability of a generated token, with the most likely
+	// alternatives if requested.
+	LogProb = model.LogProb
+	// TopLogProb is the log probability of an alternative of a generated token.
+	TopLogProb = model.TopLogProbs
+	// Thinking switches the reasoning of thinking models on or off for a request.
+	Thinking = model.Thinking
+)
+
+// Message roles.
+const (
+	RoleSystem    = model.ChatMessageRoleSystem
+	RoleUser      = model.ChatMessageRoleUser
+	RoleAssistant = model.ChatMessageRoleAssistant
+	RoleTool      = model.ChatMessageRoleTool
+)
+
+// Finish reasons.
+const (
+	FinishReasonStop          = model.FinishReasonStop
+	FinishReasonLength        = model.FinishReasonLength
+	FinishReasonToolCalls     = model.FinishReasonToolCalls
+	FinishReasonContentFilter = model.FinishReasonContentFilter
+	// FinishReasonRefusal is reported by providers whose models refuse a request with a
+	// dedicated refusal instead of content, e.g. OpenAI. The refusal is the message content.
+	FinishReasonRefusal FinishReason = "refusal"
+)
+
+// Thinking modes.
+const (
+	ThinkingEnabled  = model.ThinkingTypeEnabled
+	ThinkingDisabled = model.ThinkingTypeDisabled
+	ThinkingAuto     = model.ThinkingTypeAuto
+)
+
+// ToolTypeFunction is the type of tools implemented as functions by the caller.
+const ToolTypeFunction = model.ToolTypeFunction
+
+// Content part types.
+const (
+	ContentPartText     = model.ChatCompletionMessageContentPartTypeText
+	ContentPartImageURL = model.ChatCompletionMessageContentPartTypeImageURL
+	ContentPartVideoURL = model.ChatCompletionMessageContentPartTypeVideoURL
+)
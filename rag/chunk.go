@@ -0,0 +1,177 @@
+package rag
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type (
+	// Document is a text added to the knowledge base, e.g. a manual page or a FAQ entry.
+	Document struct {
+		ID       string            // Unique id of the document, e.g. its path or URL
+		Text     string            // Text of the document
+		Metadata map[string]string // Optional attributes copied to its chunks, e.g. "title"
+	}
+	// Chunk is a part of a document small enough to be embedded and sent to the model.
+	Chunk struct {
+		ID       string            // Unique id of the chunk, the document id followed by "#" and Index
+		DocID    string            // Id of the document the chunk belongs to
+		Index    int               // Position of the chunk in the document, starting at 0
+		Text     string            // Text of the chunk
+		Metadata map[string]string // Attributes of the document
+		Vector   []float32         // Embedding of Text, set by Retriever.Index
+	}
+
+	// SplitOpt configures the splitting of documents into chunks.
+	SplitOpt struct {
+		size    int // Maximum length of a chunk in runes
+		overlap int // Length of the end of a chunk repeated at the start of the next one, in runes
+	}
+	// SplitOpts is a function type for configuring the splitting of documents.
+	SplitOpts func(opt *SplitOpt)
+)
+
+// WithChunkSize sets the maximum length of a chunk in runes. Defaults to 1000.
+func WithChunkSize(n int) SplitOpts {
+	return func(opt *SplitOpt) {
+		if n > 0 {
+			opt.size = n
+		}
+	}
+}
+
+// WithChunkOverlap sets how much of the end of a chunk is repeated at the start of the
+// next one, in runes, so text cut at a chunk boundary keeps some of its context. It is
+// capped at half the chunk size. Defaults to 100.
+func WithChunkOverlap(n int) SplitOpts {
+	return func(opt *SplitOpt) {
+		if n >= 0 {
+			opt.overlap = n
+		}
+	}
+}
+
+// Split cuts a document into chunks of at most the configured size. Chunks end at
+// paragraph or sentence boundaries where possible; only sentences longer than a chunk
+// are cut in between.
+//
+// Parameters:
+//   - doc: Document to split
+//   - opts: Optional configuration, e.g. WithChunkSize
+//
+// Returns the chunks in document order, without vectors.
+func Split(doc Document, opts ...SplitOpts) []*Chunk {
+	opt := &SplitOpt{
+		size:    1000,
+		overlap: 100,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	opt.overlap = min(opt.overlap, opt.size/2)
+	texts := make([]string, 0)
+	cur := strings.Builder{}
+	n, fresh := 0, false
+	for _, u := range units(doc.Text, opt.size) {
+		l := utf8.RuneCountInString(u)
+		if fresh && n+l > opt.size {
+			t := cur.String()
+			texts = append(texts, strings.TrimSpace(t))
+			tail := tail(t, opt.overlap)
+			cur.Reset()
+			cur.WriteString(tail)
+			n, fresh = utf8.RuneCountInString(tail), false
+			if n+l > opt.size {
+				cur.Reset()
+				n = 0
+			}
+		}
+		cur.WriteString(u)
+		n += l
+		fresh = fresh || strings.TrimSpace(u) != ""
+	}
+	if fresh {
+		texts = append(texts, strings.TrimSpace(cur.String()))
+	}
+	chunks := make([]*Chunk, len(texts))
+	for i, t := range texts {
+		chunks[i] = &Chunk{
+			ID:       doc.ID + "#" + strconv.Itoa(i),
+			DocID:    doc.ID,
+			Index:    i,
+			Text:     t,
+			Metadata: doc.Metadata,
+		}
+	}
+	return chunks
+}
+
+// units cuts text into sentences and paragraphs, each with its trailing white space.
+// Units longer than size runes are cut into pieces of size runes.
+func units(text string, size int) []string {
+	us := make([]string, 0)
+	add := func(u string) {
+		for utf8.RuneCountInString(u) > size {
+			i := 0
+			for range size {
+				_, l := utf8.DecodeRuneInString(u[i:])
+				i += l
+			}
+			us = append(us, u[:i])
+			u = u[i:]
+		}
+		if u != "" {
+			us = append(us, u)
+		}
+	}
+	start := 0
+	for i := 0; i < len(text); {
+		r, l := utf8.DecodeRuneInString(text[i:])
+		i += l
+		switch r {
+		case '\n', '。', '！', '？', '；':
+		case '.', '!', '?', ';':
+			if n, _ := utf8.DecodeRuneInString(text[i:]); i < len(text) && !unicode.IsSpace(n) {
+				continue
+			}
+		default:
+			continue
+		}
+		// keep the white space following the end of the unit with it
+		for i < len(text) {
+			n, l := utf8.DecodeRuneInString(text[i:])
+			if !unicode.IsSpace(n) {
+				break
+			}
+			i += l
+		}
+		add(text[start:i])
+		start = i
+	}
+	add(text[start:])
+	return us
+}
+
+// tail returns the last n runes of s, starting at a word boundary if there is one.
+func tail(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	i := len(s)
+	for range n {
+		if i == 0 {
+			break
+		}
+		_, l := utf8.DecodeLastRuneInString(s[:i])
+		i -= l
+	}
+	t := s[i:]
+	if i > 0 {
+		if j := strings.IndexFunc(t, unicode.IsSpace); j >= 0 {
+			t = t[j:]
+		}
+	}
+	return strings.TrimLeftFunc(t, unicode.IsSpace)
+}
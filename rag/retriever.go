@@ -0,0 +1,169 @@
+// Package rag implements retrieval-augmented generation: documents are split into chunks,
+// embedded and kept in a VectorStore, and a Retriever finds the chunks relevant to a user
+// message, which are sent to the model as context of the turn, see llm.WithRetriever.
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/xyzj/llm/embeddings"
+	"github.com/xyzj/llm/provider"
+
+	"github.com/volcengine/volcengine-go-sdk/volcengine"
+)
+
+// defaultPrompt introduces the retrieved chunks to the model.
+const defaultPrompt = "The following excerpts of the knowledge base may help to answer the next message. " +
+	"Use them where relevant and say so if they do not contain the answer."
+
+type (
+	// RetrieverOpt configures a Retriever.
+	RetrieverOpt struct {
+		topK     int         // Maximum number of chunks retrieved per query
+		minScore float32     // Minimum similarity of retrieved chunks
+		prompt   string      // Text introducing the retrieved chunks to the model
+		split    []SplitOpts // Options splitting indexed documents
+	}
+	// RetrieverOpts is a function type for configuring a Retriever.
+	RetrieverOpts func(opt *RetrieverOpt)
+)
+
+// WithTopK sets the maximum number of chunks retrieved per query. Defaults to 4.
+func WithTopK(k int) RetrieverOpts {
+	return func(opt *RetrieverOpt) {
+		if k > 0 {
+			opt.topK = k
+		}
+	}
+}
+
+// WithMinScore leaves out chunks whose cosine similarity to the query is below s, so
+// unrelated chunks are not sent for off-topic messages. The useful threshold depends on
+// the embedding model. Defaults to 0, i.e. the top k chunks are always sent.
+func WithMinScore(s float32) RetrieverOpts {
+	return func(opt *RetrieverOpt) {
+		opt.minScore = s
+	}
+}
+
+// WithPrompt sets the text introducing the retrieved chunks to the model.
+func WithPrompt(p string) RetrieverOpts {
+	return func(opt *RetrieverOpt) {
+		if p != "" {
+			opt.prompt = p
+		}
+	}
+}
+
+// WithSplitOptions sets the options splitting indexed documents into chunks, e.g.
+// WithChunkSize.
+func WithSplitOptions(opts ...SplitOpts) RetrieverOpts {
+	return func(opt *RetrieverOpt) {
+		opt.split = opts
+	}
+}
+
+// Retriever indexes documents and retrieves the chunks relevant to a query. It is safe
+// for concurrent use if its VectorStore is.
+type Retriever struct {
+	emb   *embeddings.Embeddings
+	store VectorStore
+	cnf   *RetrieverOpt
+}
+
+// NewRetriever creates a Retriever embedding documents and queries with emb and keeping
+// the chunks in store. Documents and queries must be embedded with the same model.
+//
+// Parameters:
+//   - emb: Embeddings used for documents and queries
+//   - store: Store holding the indexed chunks, e.g. NewMemoryStore
+//   - opts: Optional configuration, e.g. WithTopK
+//
+// Example:
+//
+//	r := rag.NewRetriever(emb, rag.NewMemoryStore(), rag.WithTopK(3))
+//	err := r.Index(ctx, rag.Document{ID: "faq", Text: faq})
+func NewRetriever(emb *embeddings.Embeddings, store VectorStore, opts ...RetrieverOpts) *Retriever {
+	opt := &RetrieverOpt{
+		topK:   4,
+		prompt: defaultPrompt,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	return &Retriever{emb: emb, store: store, cnf: opt}
+}
+
+// Index splits, embeds and stores documents, replacing the chunks of documents indexed
+// before with the same id.
+func (r *Retriever) Index(ctx context.Context, docs ...Document) error {
+	for _, doc := range docs {
+		chunks := Split(doc, r.cnf.split...)
+		texts := make([]string, len(chunks))
+		for i, c := range chunks {
+			texts[i] = c.Text
+		}
+		vecs, err := r.emb.Embed(ctx, texts)
+		if err != nil {
+			return fmt.Errorf("index document [%s]: %w", doc.ID, err)
+		}
+		for i, c := range chunks {
+			c.Vector = vecs[i]
+		}
+		if err := r.store.Delete(ctx, doc.ID); err != nil {
+			return fmt.Errorf("index document [%s]: %w", doc.ID, err)
+		}
+		if err := r.store.Upsert(ctx, chunks...); err != nil {
+			return fmt.Errorf("index document [%s]: %w", doc.ID, err)
+		}
+	}
+	return nil
+}
+
+// Remove removes the chunks of the document with the given id.
+func (r *Retriever) Remove(ctx context.Context, docID string) error {
+	return r.store.Delete(ctx, docID)
+}
+
+// Retrieve returns the chunks most similar to query, the closest first.
+func (r *Retriever) Retrieve(ctx context.Context, query string) ([]Match, error) {
+	vec, err := r.emb.EmbedOne(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := r.store.Search(ctx, vec, r.cnf.topK)
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range matches {
+		if m.Score < r.cnf.minScore {
+			return matches[:i], nil
+		}
+	}
+	return matches, nil
+}
+
+// Messages retrieves the chunks relevant to query and returns them as system message to
+// send with the query, nil if no chunk was found.
+func (r *Retriever) Messages(ctx context.Context, query string) ([]*provider.Message, error) {
+	matches, err := r.Retrieve(ctx, query)
+	if err != nil || len(matches) == 0 {
+		return nil, err
+	}
+	b := strings.Builder{}
+	b.WriteString(r.cnf.prompt)
+	for i, m := range matches {
+		fmt.Fprintf(&b, "\n\n[%d] source: %s", i+1, m.Chunk.DocID)
+		if t := m.Chunk.Metadata["title"]; t != "" {
+			fmt.Fprintf(&b, " (%s)", t)
+		}
+		b.WriteString("\n")
+		b.WriteString(m.Chunk.Text)
+	}
+	return []*provider.Message{{
+		Role:    provider.RoleSystem,
+		Content: &provider.MessageContent{StringValue: volcengine.String(b.String())},
+	}}, nil
+}
@@ -0,0 +1,109 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"slices"
+	"sync"
+)
+
+type (
+	// Match is a chunk found by a search with its similarity to the query.
+	Match struct {
+		Chunk *Chunk  // Matching chunk
+		Score float32 // Cosine similarity between the query and the chunk, higher is closer
+	}
+
+	// VectorStore stores chunks with their embeddings and finds the chunks closest to a
+	// query vector. NewMemoryStore keeps them in memory; vector databases are plugged in
+	// by implementing the interface. Implementations must be safe for concurrent use.
+	VectorStore interface {
+		// Upsert adds chunks, replacing stored chunks with the same id.
+		Upsert(ctx context.Context, chunks ...*Chunk) error
+		// Delete removes all chunks of the document with the given id.
+		Delete(ctx context.Context, docID string) error
+		// Search returns up to k chunks closest to vector, the closest first.
+		Search(ctx context.Context, vector []float32, k int) ([]Match, error)
+	}
+)
+
+// NewMemoryStore creates a VectorStore holding the chunks in memory. Searches compare
+// the query with every chunk, which is fast enough for some ten thousand chunks.
+func NewMemoryStore() VectorStore {
+	return &memoryStore{
+		chunks: make(map[string]*storedChunk),
+	}
+}
+
+// memoryStore is an in-memory VectorStore searched exhaustively.
+type memoryStore struct {
+	locker sync.RWMutex
+	chunks map[string]*storedChunk // Chunks keyed by chunk id
+}
+
+// storedChunk is a chunk with the norm of its vector.
+type storedChunk struct {
+	chunk *Chunk
+	norm  float64
+}
+
+func (m *memoryStore) Upsert(ctx context.Context, chunks ...*Chunk) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	for _, c := range chunks {
+		m.chunks[c.ID] = &storedChunk{chunk: c, norm: norm(c.Vector)}
+	}
+	return nil
+}
+
+func (m *memoryStore) Delete(ctx context.Context, docID string) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	for id, c := range m.chunks {
+		if c.chunk.DocID == docID {
+			delete(m.chunks, id)
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) Search(ctx context.Context, vector []float32, k int) ([]Match, error) {
+	if k <= 0 {
+		return nil, nil
+	}
+	qn := norm(vector)
+	m.locker.RLock()
+	matches := make([]Match, 0, len(m.chunks))
+	for _, c := range m.chunks {
+		if len(c.chunk.Vector) != len(vector) || c.norm == 0 || qn == 0 {
+			continue
+		}
+		var dot float64
+		for i, v := range vector {
+			dot += float64(v) * float64(c.chunk.Vector[i])
+		}
+		matches = append(matches, Match{Chunk: c.chunk, Score: float32(dot / (qn * c.norm))})
+	}
+	m.locker.RUnlock()
+	slices.SortFunc(matches, func(a, b Match) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		// equal scores keep the document order
+		if c := cmp.Compare(a.Chunk.DocID, b.Chunk.DocID); c != 0 {
+			return c
+		}
+		return a.Chunk.Index - b.Chunk.Index
+	})
+	return matches[:min(k, len(matches))], nil
+}
+
+// norm returns the euclidean length of v.
+func norm(v []float32) float64 {
+	var s float64
+	for _, x := range v {
+		s += float64(x) * float64(x)
+	}
+	return math.Sqrt(s)
+}
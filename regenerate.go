@@ -0,0 +1,81 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/xyzj/llm/chat"
+	"github.com/xyzj/llm/provider"
+)
+
+// ErrNoUserMessage is the error of Regenerate and EditLastUserMessage for sessions without
+// user message in their history, e.g. because it was evicted.
+var ErrNoUserMessage = chat.ErrNoUserMessage
+
+// Regenerate answers the last user message of a chat session again, e.g. for a
+// "regenerate response" button: the previous reply, including its tool calls and
+// results, is removed from the history and the turn runs like ChatE without a new
+// message. The commands and preprocessors are not run again.
+//
+// Parameters:
+//   - ctx: Context of the turn, see ChatContext
+//   - id: Identifier of the chat session
+//   - w: Write function receiving the new reply, see Chat
+//   - opts: Options of the turn, e.g. WithAllowedTools
+//
+// Returns:
+//   - string: The assistant text of the new reply
+//   - error: A *TurnError, see ChatE, matching ErrNoUserMessage if the history holds no
+//     user message
+func (cm *ChatsManager) Regenerate(ctx context.Context, id string, w func(data []byte) error, opts ...TurnOpts) (string, error) {
+	trace := cm.turn(ctx, id, "", w, append(slices.Clip(opts), func(opt *TurnOpt) {
+		opt.regenerate = true
+	})...)
+	return trace.Reply(), trace.Err()
+}
+
+// EditLastUserMessage replaces the text of the last user message of a chat session, e.g.
+// after the user edited it, and removes the reply to it. Images of the message are kept.
+// The text runs through the preprocessors like a new message. Call Regenerate to answer
+// the edited message.
+//
+// Parameters:
+//   - id: Identifier of the chat session
+//   - text: New text of the message
+//
+// Returns:
+//   - error: ErrNoUserMessage if the history holds no user message, or an error if the
+//     preprocessors dropped the text
+func (cm *ChatsManager) EditLastUserMessage(id, text string) error {
+	if text = cm.preprocess(id, text); text == "" {
+		return errors.New("message dropped by the preprocessors")
+	}
+	ctx, cancel := storageContext()
+	defer cancel()
+	if err := cm.session(ctx, id).EditLastUserMessage(text); err != nil {
+		return err
+	}
+	if cm.cnf.persistEvery == 0 {
+		return cm.Flush(id)
+	}
+	return nil
+}
+
+// userText returns the text of a user message, or an empty string for nil.
+func userText(msg *provider.Message) string {
+	if msg == nil || msg.Content == nil {
+		return ""
+	}
+	if msg.Content.StringValue != nil {
+		return *msg.Content.StringValue
+	}
+	var b strings.Builder
+	for _, p := range msg.Content.ListValue {
+		if p.Type == provider.ContentPartText {
+			b.WriteString(p.Text)
+		}
+	}
+	return b.String()
+}
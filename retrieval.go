@@ -0,0 +1,33 @@
+package llm
+
+import (
+	"context"
+
+	"github.com/xyzj/llm/chat"
+)
+
+// MetaRetriever is the session metadata key holding the name of the retriever used by
+// the session, see WithRetriever. Sessions without it use the default retriever.
+const MetaRetriever = "retriever"
+
+// retrieve returns the request options sending the knowledge base excerpts relevant to
+// message, see WithRetriever, or none if the session has no retriever or nothing was
+// found. Failed retrievals are logged.
+func (cm *ChatsManager) retrieve(ctx context.Context, ch *chat.Chat, message string) []chat.Opts {
+	if len(cm.cnf.retrievers) == 0 || message == "" {
+		return nil
+	}
+	r, ok := cm.cnf.retrievers[ch.Metadata()[MetaRetriever]]
+	if !ok {
+		return nil
+	}
+	msgs, err := r.Messages(ctx, message)
+	if err != nil {
+		cm.cnf.logg.Warn("retrieval failed", LogKeyChatID, ch.ID(), LogKeyError, err)
+		return nil
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return []chat.Opts{chat.WithContextMessages(msgs...)}
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xyzj/llm"
+	"github.com/xyzj/llm/chat"
+	"github.com/xyzj/llm/llmhttp"
+	"github.com/xyzj/llm/provider"
+
+	"github.com/xyzj/toolbox/json"
+)
+
+// ChatIDHeader is the request header selecting the chat session of an OpenAI-compatible
+// request, see WithOpenAI.
+const ChatIDHeader = "X-Chat-ID"
+
+type (
+	// completionRequest is the subset of an OpenAI chat completion request used by the proxy.
+	completionRequest struct {
+		Model       string              `json:"model"`
+		Messages    []*provider.Message `json:"messages"`
+		Stream      bool                `json:"stream"`
+		Temperature *float64            `json:"temperature"`
+		MaxTokens   *int                `json:"max_tokens"`
+	}
+	// completion is an OpenAI chat completion response or stream chunk.
+	completion struct {
+		ID      string              `json:"id"`
+		Object  string              `json:"object"`
+		Created int64               `json:"created"`
+		Model   string              `json:"model"`
+		Choices []*completionChoice `json:"choices"`
+		Usage   *completionUsage    `json:"usage,omitempty"`
+	}
+	// completionChoice is the single choice of a completion, Message for complete
+	// responses and Delta for stream chunks.
+	completionChoice struct {
+		Index        int                `json:"index"`
+		Message      *completionMessage `json:"message,omitempty"`
+		Delta        *completionMessage `json:"delta,omitempty"`
+		FinishReason *string            `json:"finish_reason"`
+	}
+	completionMessage struct {
+		Role    string `json:"role,omitempty"`
+		Content string `json:"content"`
+	}
+	completionUsage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+		TotalTokens      int `json:"total_tokens"`
+	}
+)
+
+// completions handles OpenAI chat completion requests, see WithOpenAI.
+func completions(cm *llm.ChatsManager, opt *Opt) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &completionRequest{}
+		if err := decodeJSON(w, r, opt.maxBody, req); err != nil {
+			return
+		}
+		msgs := req.Messages
+		for i, msg := range msgs {
+			if msg == nil {
+				writeOpenAIError(w, http.StatusBadRequest, fmt.Errorf("message %d: null message", i))
+				return
+			}
+			switch msg.Role {
+			case provider.RoleSystem, provider.RoleUser, provider.RoleAssistant, provider.RoleTool:
+			default:
+				writeOpenAIError(w, http.StatusBadRequest, fmt.Errorf("message %d: unknown role [%s]", i, msg.Role))
+				return
+			}
+		}
+		if len(msgs) == 0 || msgs[len(msgs)-1].Role != provider.RoleUser {
+			writeOpenAIError(w, http.StatusBadRequest, errors.New("the last message must be a user message"))
+			return
+		}
+		if len(opt.models) > 0 && req.Model != "" && !slices.Contains(opt.models, req.Model) {
+			writeOpenAIError(w, http.StatusNotFound, fmt.Errorf("model [%s] does not exist", req.Model))
+			return
+		}
+		message := messageText(msgs[len(msgs)-1])
+		id := r.Header.Get(ChatIDHeader)
+		if id == "" {
+			// stateless request, the client sends the whole conversation every time
+			id = "openai-" + rand.Text()
+			cm.SetHistory(id, msgs[:len(msgs)-1])
+			defer cm.Delete(context.Background(), id)
+		}
+		// the parameters apply to this request only, unlike the settings of the session
+		var chatOpts []chat.Opts
+		if req.Model != "" {
+			chatOpts = append(chatOpts, chat.WithModel(req.Model))
+		}
+		if req.Temperature != nil {
+			chatOpts = append(chatOpts, chat.WithTemperature(float32(*req.Temperature)))
+		}
+		if req.MaxTokens != nil {
+			chatOpts = append(chatOpts, chat.WithMaxTokens(*req.MaxTokens))
+		}
+		opts := []llm.TurnOpts{llm.WithChatOptions(chatOpts...)}
+		resp := &completion{
+			ID:      "chatcmpl-" + rand.Text(),
+			Object:  "chat.completion",
+			Created: time.Now().Unix(),
+			Model:   req.Model,
+		}
+		if req.Stream {
+			streamCompletion(cm, opt, w, r, id, message, resp, opts)
+			return
+		}
+		var trace *llm.RunTrace
+		reply, err := cm.ChatE(r.Context(), id, message, func([]byte) error { return nil },
+			append(opts, llm.WithTraceFunc(func(t *llm.RunTrace) { trace = t }))...)
+		if err != nil && !errors.Is(err, llm.ErrToolCallFailed) {
+			writeOpenAIError(w, http.StatusBadGateway, err)
+			return
+		}
+		reason := resp.finish(trace)
+		resp.Choices = []*completionChoice{{
+			Message:      &completionMessage{Role: provider.RoleAssistant, Content: reply},
+			FinishReason: &reason,
+		}}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// streamCompletion runs a turn and streams its response as chat completion chunks.
+func streamCompletion(cm *llm.ChatsManager, opt *Opt, w http.ResponseWriter, r *http.Request, id, message string, resp *completion, opts []llm.TurnOpts) {
+	sse, err := llmhttp.NewSSEWriter(w, r, append(opt.sseOpts, llmhttp.WithDoneEvent(""))...)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer sse.Close()
+	resp.Object = "chat.completion.chunk"
+	chunk := func(delta *completionMessage, reason *string) error {
+		resp.Choices = []*completionChoice{{Delta: delta, FinishReason: reason}}
+		b, err := json.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		return sse.Event("", b)
+	}
+	role := provider.RoleAssistant
+	var trace *llm.RunTrace
+	_, err = cm.ChatE(sse.Context(), id, message, func(data []byte) error {
+		if len(data) == 0 {
+			return nil
+		}
+		// the role is only sent with the first chunk
+		defer func() { role = "" }()
+		return chunk(&completionMessage{Role: role, Content: string(data)}, nil)
+	}, append(opts, llm.WithTraceFunc(func(t *llm.RunTrace) { trace = t }))...)
+	if err != nil && !errors.Is(err, llm.ErrToolCallFailed) {
+		b, _ := json.Marshal(openAIError(err, "server_error"))
+		sse.Event("", b)
+	} else {
+		reason := resp.finish(trace)
+		chunk(&completionMessage{}, &reason)
+	}
+	sse.Event("", []byte("[DONE]"))
+}
+
+// finish sets the model and the usage of the response from the trace of the turn, if
+// any, and returns the finish reason.
+func (c *completion) finish(t *llm.RunTrace) string {
+	if t == nil || len(t.Rounds) == 0 {
+		return "stop"
+	}
+	if c.Model == "" {
+		c.Model = t.Rounds[0].Model
+	}
+	usage := &completionUsage{}
+	for _, round := range t.Rounds {
+		usage.PromptTokens += round.PromptTokens
+		usage.CompletionTokens += round.CompletionTokens
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	c.Usage = usage
+	last := t.Rounds[len(t.Rounds)-1]
+	if last.FinishReason == "" || last.FinishReason == provider.FinishReasonToolCalls {
+		// tool calls are handled by the manager, the client sees a completed answer
+		return "stop"
+	}
+	return string(last.FinishReason)
+}
+
+// messageText returns the text content of a message, joining the text parts of
+// multi-part contents.
+func messageText(msg *provider.Message) string {
+	if msg.Content == nil {
+		return ""
+	}
+	if msg.Content.StringValue != nil {
+		return *msg.Content.StringValue
+	}
+	parts := make([]string, 0, len(msg.Content.ListValue))
+	for _, p := range msg.Content.ListValue {
+		if p.Type == provider.ContentPartText {
+			parts = append(parts, p.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// openAIError returns err in the error format of the OpenAI API.
+func openAIError(err error, typ string) map[string]any {
+	return map[string]any{"error": map[string]string{"message": err.Error(), "type": typ}}
+}
+
+// writeOpenAIError writes err as an OpenAI error response.
+func writeOpenAIError(w http.ResponseWriter, status int, err error) {
+	typ := "server_error"
+	if status < http.StatusInternalServerError {
+		typ = "invalid_request_error"
+	}
+	writeJSON(w, status, openAIError(err, typ))
+}
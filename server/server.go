@@ -0,0 +1,230 @@
+// Package server exposes a ChatsManager as a REST API, so the package can be deployed as
+// a standalone chat service without boilerplate. Responses of chat turns are streamed as
+// Server-Sent Events, see llmhttp.SSEWriter.
+//
+// Example:
+//
+//	cm := llm.NewChatsManager(llm.WithAPIKey(key))
+//	log.Fatal(server.ListenAndServe(ctx, ":8080", cm))
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/xyzj/llm"
+	"github.com/xyzj/llm/llmhttp"
+	"github.com/xyzj/llm/provider"
+	"github.com/xyzj/llm/storage"
+
+	"github.com/xyzj/toolbox/json"
+)
+
+type (
+	// Opt configures the handler created by Handler.
+	Opt struct {
+		maxBody int64             // Maximum size of a request body in bytes
+		sseOpts []llmhttp.SSEOpts // Options of the SSE writers of chat turns
+		openai  bool              // Whether the OpenAI-compatible endpoint is served
+		models  []string          // Models OpenAI-compatible requests may select, nil for any
+	}
+	// Opts is a function type for configuring the handler.
+	Opts func(opt *Opt)
+)
+
+// WithMaxBodySize limits the size of request bodies, larger messages are rejected with
+// status 413. Defaults to 1 MiB.
+func WithMaxBodySize(n int64) Opts {
+	return func(opt *Opt) {
+		if n > 0 {
+			opt.maxBody = n
+		}
+	}
+}
+
+// WithSSEOptions sets the options of the Server-Sent Events streams of chat turns,
+// e.g. llmhttp.WithHeartbeat.
+func WithSSEOptions(opts ...llmhttp.SSEOpts) Opts {
+	return func(opt *Opt) {
+		opt.sseOpts = append(opt.sseOpts, opts...)
+	}
+}
+
+// WithOpenAI additionally serves POST /v1/chat/completions in the OpenAI wire format, so
+// existing OpenAI clients and SDKs can talk to the manager, with its local and MCP tools
+// attached, by only changing their base URL. Tool calls are run by the manager, the
+// client receives the final answer; tools sent by the client are ignored.
+//
+// The chat session is selected by the X-Chat-ID header. Such sessions keep their history
+// on the server, so only the last user message of the request is used. Requests without
+// the header are stateless: the conversation sent by the client is used as history of a
+// temporary session. The model, temperature and max_tokens of a request apply to that
+// request only; models can be restricted with WithModels.
+func WithOpenAI() Opts {
+	return func(opt *Opt) {
+		opt.openai = true
+	}
+}
+
+// WithModels restricts the models OpenAI-compatible requests may select, see WithOpenAI.
+// Requests for other models are rejected with status 404. Requests without model use the
+// model of the session. By default any model is passed to the provider.
+func WithModels(names ...string) Opts {
+	return func(opt *Opt) {
+		opt.models = append(opt.models, names...)
+	}
+}
+
+// messageRequest is the body of POST /chats/{id}/messages.
+type messageRequest struct {
+	Message string `json:"message"` // User message of the turn
+}
+
+// historyResponse is the body returned by GET /chats/{id}/history.
+type historyResponse struct {
+	ID       string              `json:"id"`       // Identifier of the chat session
+	Messages []*provider.Message `json:"messages"` // Messages in chronological order
+}
+
+// Handler returns an http.Handler serving the chat sessions of cm. Errors are returned
+// as JSON objects with an "error" field.
+//
+// Routes (relative to where the handler is mounted):
+//   - POST /chats/{id}/messages: runs a turn with the "message" field of the JSON body and
+//     streams the response as Server-Sent Events; a failed turn sends an "error" event
+//     before the final "done" event
+//   - GET /chats/{id}/history: history of the chat as JSON, read from storage if the chat
+//     is not active, 404 if unknown
+//   - DELETE /chats/{id}: ends the chat and removes its stored history, 404 if unknown
+//   - POST /v1/chat/completions: OpenAI-compatible chat completions, see WithOpenAI
+//
+// Parameters:
+//   - cm: Manager of the chat sessions
+//   - opts: Optional configuration, e.g. WithMaxBodySize
+func Handler(cm *llm.ChatsManager, opts ...Opts) http.Handler {
+	opt := &Opt{
+		maxBody: 1 << 20,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /chats/{id}/messages", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		req := &messageRequest{}
+		if err := decodeJSON(w, r, opt.maxBody, req); err != nil {
+			return
+		}
+		if req.Message == "" {
+			writeError(w, http.StatusBadRequest, errors.New("message is empty"))
+			return
+		}
+		sse, err := llmhttp.NewSSEWriter(w, r, opt.sseOpts...)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		defer sse.Close()
+		_, err = cm.ChatE(sse.Context(), id, req.Message, sse.Write, llm.WithEventFunc(sse.WriteEvent))
+		if err != nil && !errors.Is(err, llm.ErrToolCallFailed) {
+			b, _ := json.Marshal(map[string]string{"error": err.Error()})
+			sse.Event("error", b)
+		}
+	})
+	mux.HandleFunc("GET /chats/{id}/history", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		his, err := cm.ReadHistory(r.Context(), id)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			writeError(w, http.StatusNotFound, errors.New("chat not found"))
+			return
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, &historyResponse{ID: id, Messages: his})
+	})
+	mux.HandleFunc("DELETE /chats/{id}", func(w http.ResponseWriter, r *http.Request) {
+		err := cm.Delete(r.Context(), r.PathValue("id"))
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			writeError(w, http.StatusNotFound, errors.New("chat not found"))
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, err)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	if opt.openai {
+		mux.HandleFunc("POST /v1/chat/completions", completions(cm, opt))
+	}
+	return mux
+}
+
+// ListenAndServe serves the chat sessions of cm on addr, see Handler, until ctx is
+// cancelled, then shuts the server down gracefully, waiting up to 30 seconds for
+// running turns to finish.
+//
+// Parameters:
+//   - ctx: Context whose cancellation stops the server
+//   - addr: TCP address to listen on, e.g. ":8080"
+//   - cm: Manager of the chat sessions
+//   - opts: Optional configuration of the handler
+//
+// Returns:
+//   - error: Any error starting or shutting down the server, nil after a graceful shutdown
+func ListenAndServe(ctx context.Context, addr string, cm *llm.ChatsManager, opts ...Opts) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           Handler(cm, opts...),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	sctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return srv.Shutdown(sctx)
+}
+
+// decodeJSON decodes the JSON request body into v, writing an error response if it fails.
+func decodeJSON(w http.ResponseWriter, r *http.Request, limit int64, v any) error {
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if mbe := (*http.MaxBytesError)(nil); errors.As(err, &mbe) {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return err
+	}
+	if err == nil {
+		err = json.Unmarshal(b, v)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+	}
+	return err
+}
+
+// writeError writes err as a JSON error response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeJSON writes v as a JSON response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
@@ -0,0 +1,103 @@
+package llm
+
+import (
+	"strconv"
+
+	"github.com/xyzj/llm/chat"
+)
+
+type (
+	// SessionOpt holds the settings of a chat session overriding the defaults of the
+	// manager, see ChatsManager.Configure.
+	SessionOpt struct {
+		model       string   // Model name, empty for the default of WithModelName
+		temperature *float32 // Sampling temperature, nil for the default of the model
+		maxTokens   int      // Maximum number of tokens to generate, 0 for the default of the model
+		maxHistory  int      // Maximum number of messages to keep in history, 0 for the default of WithMaxHistory
+	}
+	// SessionOpts is a function type for configuring a chat session.
+	SessionOpts func(opt *SessionOpt)
+)
+
+// WithSessionModel sets the model used by the chat session instead of the default set
+// with WithModelName, e.g. a larger model for a premium channel.
+func WithSessionModel(name string) SessionOpts {
+	return func(opt *SessionOpt) {
+		opt.model = name
+	}
+}
+
+// WithSessionTemperature sets the sampling temperature of the requests of the chat
+// session, see chat.MetaTemperature.
+func WithSessionTemperature(t float32) SessionOpts {
+	return func(opt *SessionOpt) {
+		opt.temperature = &t
+	}
+}
+
+// WithSessionMaxTokens sets the maximum number of tokens generated by the requests of the
+// chat session, see chat.MetaMaxTokens.
+func WithSessionMaxTokens(n int) SessionOpts {
+	return func(opt *SessionOpt) {
+		opt.maxTokens = n
+	}
+}
+
+// WithSessionMaxHistory sets the maximum number of messages kept in the history of the
+// chat session instead of the default set with WithMaxHistory. Lowering it drops the
+// oldest messages of an active session.
+func WithSessionMaxHistory(n int) SessionOpts {
+	return func(opt *SessionOpt) {
+		opt.maxHistory = n
+	}
+}
+
+// Configure overrides the model and generation settings of a chat session, creating the
+// session if necessary, so different users or channels can run different models under
+// one manager. Settings not given keep their current values. The settings are kept by
+// the manager until the session is deleted, so they are applied again when an evicted
+// session is restored.
+//
+// Parameters:
+//   - id: Identifier of the chat session
+//   - opts: Settings of the session, e.g. WithSessionModel or WithSessionTemperature
+//
+// Example:
+//
+//	cm.Configure("support-42", llm.WithSessionModel("qwen3:32b"), llm.WithSessionTemperature(0.2))
+func (cm *ChatsManager) Configure(id string, opts ...SessionOpts) {
+	// copy on write, the stored settings may be read by a restoring session or shared
+	// with a clone, see Clone
+	so := &SessionOpt{}
+	if prev, ok := cm.settings.Load(id); ok {
+		*so = *prev
+	}
+	for _, o := range opts {
+		o(so)
+	}
+	cm.settings.Store(id, so)
+	if ch, ok := cm.chats.LoadForUpdate(id); ok {
+		so.apply(ch)
+		return
+	}
+	// new sessions apply the stored settings themselves, see openSession
+	ctx, cancel := storageContext()
+	defer cancel()
+	cm.session(ctx, id)
+}
+
+// apply applies the settings to the chat session ch.
+func (so *SessionOpt) apply(ch *chat.Chat) {
+	if so.model != "" {
+		ch.SetModel(so.model)
+	}
+	if so.temperature != nil {
+		ch.SetMetadata(chat.MetaTemperature, strconv.FormatFloat(float64(*so.temperature), 'f', -1, 32))
+	}
+	if so.maxTokens > 0 {
+		ch.SetMetadata(chat.MetaMaxTokens, strconv.Itoa(so.maxTokens))
+	}
+	if so.maxHistory > 0 {
+		ch.SetMaxHistory(so.maxHistory)
+	}
+}
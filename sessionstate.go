@@ -0,0 +1,163 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/xyzj/llm/chat"
+	"github.com/xyzj/llm/provider"
+	"github.com/xyzj/llm/storage"
+
+	"github.com/xyzj/toolbox/json"
+)
+
+// sessionRole is the role of the message holding a persisted session state.
+const sessionRole = "session"
+
+// sessionState is the state of a chat session persisted next to its history, so the
+// metadata, e.g. the generation settings of chat.MetaTemperature, the system prompt set
+// with SetSystemPrompt and the pinned messages survive eviction, expiry and restarts.
+type sessionState struct {
+	Metadata     map[string]string   `json:"metadata,omitempty"`      // Session metadata
+	SystemPrompt []*provider.Message `json:"system_prompt,omitempty"` // System prompt of the session
+	Pinned       int                 `json:"pinned,omitempty"`        // Number of pinned messages stored first in the history, see chat.Chat.Pin
+}
+
+// stateSlot returns the key tracking the background writes of the state of the session
+// stored under key, see writes, and its storage key if the states share the storage of the
+// histories.
+func stateSlot(key string) string {
+	return key + "_session"
+}
+
+// sessionStorage returns the storage of the session states for the history storage st: the
+// namespace "<namespace>.sessions" of its backend, so states cannot collide with or be
+// listed as chats, or st itself if the backend does not report its namespace, see
+// storage.Namespaced. The states are then stored under the key of the history with the
+// suffix "_session".
+func sessionStorage(st storage.Storage) storage.Storage {
+	named, ok := st.(storage.Namespaced)
+	if !ok || named.NamespaceName() == "" {
+		return st
+	}
+	if ns, ok := st.(storage.Namespacer); ok {
+		return ns.Namespace(named.NamespaceName() + ".sessions")
+	}
+	return st
+}
+
+// stateKey returns the storage key of the state of the session stored under key.
+func (cm *ChatsManager) stateKey(key string) string {
+	if cm.sessions == cm.cnf.dataStorage {
+		return stateSlot(key)
+	}
+	return key
+}
+
+// sessionState returns the state of ch as the messages to persist under its state key,
+// and whether it changed since it was last persisted or restored.
+func (cm *ChatsManager) sessionState(ch *chat.Chat) ([]*provider.Message, bool) {
+	content, err := json.MarshalToString(&sessionState{
+		Metadata:     ch.Metadata(),
+		SystemPrompt: ch.SystemPrompt(),
+		Pinned:       len(ch.Pinned()),
+	})
+	if err != nil {
+		cm.cnf.logg.Error("encode session state failed", LogKeyChatID, ch.ID(), LogKeyError, err)
+		return nil, false
+	}
+	if prev, ok := cm.states.Load(ch.ID()); ok && prev == content {
+		return nil, false
+	}
+	if !cm.states.Has(ch.ID()) && content == "{}" {
+		// nothing to persist for a session without state
+		return nil, false
+	}
+	cm.states.Store(ch.ID(), content)
+	return []*provider.Message{{
+		Role:    sessionRole,
+		Content: &provider.MessageContent{StringValue: &content},
+	}}, true
+}
+
+// storeState persists the state of ch if it changed.
+func (cm *ChatsManager) storeState(ctx context.Context, ch *chat.Chat) error {
+	msgs, ok := cm.sessionState(ch)
+	if !ok {
+		return nil
+	}
+	cm.awaitWrites(stateSlot(ch.ID()))
+	if err := cm.sessions.Store(ctx, cm.stateKey(ch.ID()), msgs); err != nil {
+		// write the state again next time
+		cm.states.Delete(ch.ID())
+		return storageError(err)
+	}
+	return nil
+}
+
+// storeStateAsync persists the state of ch in the background if it changed.
+func (cm *ChatsManager) storeStateAsync(ch *chat.Chat) {
+	if msgs, ok := cm.sessionState(ch); ok {
+		cm.writeAsync(cm.sessions, stateSlot(ch.ID()), cm.stateKey(ch.ID()), msgs)
+	}
+}
+
+// restoreHistory sets the history of ch to the stored history his, pinning its first
+// messages again as recorded by the state st, see sessionState.Pinned.
+func restoreHistory(ch *chat.Chat, his []*provider.Message, st *sessionState) {
+	pinned := 0
+	if st != nil {
+		pinned = min(st.Pinned, len(his))
+	}
+	for _, msg := range his[:pinned] {
+		ch.Pin(msg)
+	}
+	ch.SetHistory(his[pinned:])
+}
+
+// applyState restores the persisted state st of a session into ch.
+func (cm *ChatsManager) applyState(ch *chat.Chat, st *sessionState) {
+	if st == nil {
+		return
+	}
+	for k, v := range st.Metadata {
+		ch.SetMetadata(k, v)
+	}
+	if len(st.SystemPrompt) > 0 {
+		ch.SetSystemPrompt(st.SystemPrompt...)
+	}
+	// the restored state needs no write until it changes
+	cm.sessionState(ch)
+}
+
+// readState returns the persisted state of the session stored under key, or nil if there
+// is none.
+func (cm *ChatsManager) readState(ctx context.Context, key string) (*sessionState, error) {
+	cm.awaitWrites(stateSlot(key))
+	msgs, err := cm.sessions.Load(ctx, cm.stateKey(key))
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && len(msgs) == 0) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+	if msgs[0].Role != sessionRole || msgs[0].Content == nil || msgs[0].Content.StringValue == nil {
+		return nil, fmt.Errorf("chat [%s]: invalid session state", key)
+	}
+	st := &sessionState{}
+	if err = json.UnmarshalFromString(*msgs[0].Content.StringValue, st); err != nil {
+		return nil, fmt.Errorf("chat [%s]: invalid session state: %w", key, err)
+	}
+	return st, nil
+}
+
+// deleteState removes the persisted state of the session stored under key.
+func (cm *ChatsManager) deleteState(ctx context.Context, key string) error {
+	cm.states.Delete(key)
+	cm.awaitWrites(stateSlot(key))
+	if err := cm.sessions.Delete(ctx, cm.stateKey(key)); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return storageError(err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/xyzj/llm/chat"
+	"github.com/xyzj/llm/provider"
+	"github.com/xyzj/llm/storage"
+)
+
+func TestSessionStatePersisted(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewMemoryStorage()
+	cm, _ := newTestManager(t, newTestProvider(), WithStorage(st))
+	prompt := "You are a travel agent."
+	cm.SetMetadata("user", chat.MetaTemperature, "0.2")
+	cm.SetSystemPrompt("user", &provider.Message{Role: provider.RoleSystem, Content: &provider.MessageContent{StringValue: &prompt}})
+	if _, err := cm.ChatE(ctx, "user", "hi", discard); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, _ := newTestManager(t, newTestProvider(), WithStorage(st))
+	if _, err := restarted.LoadHistory(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if v := restarted.Metadata("user")[chat.MetaTemperature]; v != "0.2" {
+		t.Errorf("restored temperature = %q, want %q", v, "0.2")
+	}
+	if sys := restarted.SystemPrompt("user"); len(sys) != 1 || *sys[0].Content.StringValue != prompt {
+		t.Errorf("restored system prompt = %v, want %q", sys, prompt)
+	}
+
+	if err := restarted.Delete(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.(storage.Namespacer).Namespace(storage.DefaultNamespace+".sessions").Load(ctx, HashIDMapper.MapID("user")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("state after Delete: %v, want ErrNotFound", err)
+	}
+}
+
+func TestSessionStateSurvivesEviction(t *testing.T) {
+	ctx := context.Background()
+	cm, _ := newTestManager(t, newTestProvider(), WithMaxChats(1), WithPersistEvery(time.Hour))
+	cm.SetMetadata("first", chat.MetaMaxTokens, "64")
+	// opening a second session evicts the first one
+	cm.SetMetadata("second", chat.MetaMaxTokens, "128")
+	if cm.chats.Has("first") {
+		t.Fatal("first session not evicted")
+	}
+	if _, err := cm.LoadHistory(ctx, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if v := cm.Metadata("first")[chat.MetaMaxTokens]; v != "64" {
+		t.Errorf("max tokens after eviction = %q, want %q", v, "64")
+	}
+}
+
+func TestSessionStateSeparateFromChats(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewMemoryStorage()
+	cm, _ := newTestManager(t, newTestProvider(), WithStorage(st), WithIDMapper(PassthroughIDMapper))
+	cm.SetMetadata("alice", chat.MetaTemperature, "0.2")
+	if _, err := cm.ChatE(ctx, "alice", "hi", discard); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.ChatE(ctx, "alice_session", "hello", discard); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := st.(storage.Lister).List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != "alice" || ids[1] != "alice_session" {
+		t.Errorf("stored chats = %v, want [alice alice_session]", ids)
+	}
+	restarted, _ := newTestManager(t, newTestProvider(), WithStorage(st), WithIDMapper(PassthroughIDMapper))
+	his, err := restarted.LoadHistory(ctx, "alice_session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(his) != 2 || his[0].Role != provider.RoleUser {
+		t.Errorf("history of alice_session = %v, want the chat", his)
+	}
+	if _, err := restarted.LoadHistory(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if v := restarted.Metadata("alice")[chat.MetaTemperature]; v != "0.2" {
+		t.Errorf("restored temperature = %q, want %q", v, "0.2")
+	}
+}
+
+func TestPinnedMessagesRestored(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewMemoryStorage()
+	cm, _ := newTestManager(t, newTestProvider(), WithStorage(st))
+	fact := "The user is vegetarian."
+	storageCtx, cancel := storageContext()
+	defer cancel()
+	cm.session(storageCtx, "user").Pin(&provider.Message{Role: provider.RoleUser, Content: &provider.MessageContent{StringValue: &fact}})
+	if _, err := cm.ChatE(ctx, "user", "hi", discard); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, _ := newTestManager(t, newTestProvider(), WithStorage(st))
+	his, err := restarted.LoadHistory(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(his) != 3 {
+		t.Fatalf("restored %d messages, want 3", len(his))
+	}
+	ch, _ := restarted.chats.LoadForUpdate("user")
+	if pinned := ch.Pinned(); len(pinned) != 1 || *pinned[0].Content.StringValue != fact {
+		t.Errorf("restored pinned messages = %v, want %q", pinned, fact)
+	}
+}
@@ -0,0 +1,43 @@
+package llm
+
+import (
+	"context"
+	"encoding/base64"
+
+	"github.com/xyzj/llm/chat"
+	"github.com/xyzj/llm/tts"
+)
+
+// speech returns the request options converting the assistant text of a turn to speech,
+// see WithTTS, and the function synthesizing the rest of the text at the end of the turn.
+// Without TTS it returns no options. Failed syntheses are logged, the text is streamed
+// regardless.
+func (cm *ChatsManager) speech(ctx context.Context, ch *chat.Chat, ev func(e *Event) error) ([]chat.Opts, func()) {
+	if cm.cnf.tts == nil {
+		return nil, func() {}
+	}
+	st := tts.NewStream(ctx, cm.cnf.tts, func(text string, a *tts.Audio) error {
+		cm.emit(ev, &Event{
+			Type:    EventAudio,
+			ChatID:  ChatID(ch.ID()),
+			Message: text,
+			Data: map[string]any{
+				"format": a.Format,
+				"audio":  base64.StdEncoding.EncodeToString(a.Data),
+			},
+		})
+		return nil
+	}, cm.cnf.ttsOpts...)
+	logErr := func(err error) {
+		if err != nil {
+			cm.cnf.logg.Error("speech synthesis failed", LogKeyChatID, ch.ID(), LogKeyError, err)
+		}
+	}
+	opts := []chat.Opts{chat.WithStreamHandler(chat.StreamFuncs{
+		Content: func(text string) error {
+			logErr(st.Write(text))
+			return nil
+		},
+	})}
+	return opts, func() { logErr(st.Flush()) }
+}
@@ -0,0 +1,171 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/xyzj/llm/chat"
+	"github.com/xyzj/llm/export"
+	"github.com/xyzj/llm/history"
+	mcpcli "github.com/xyzj/llm/mcp"
+	"github.com/xyzj/llm/storage"
+)
+
+// ChatInfo describes an active chat session.
+type ChatInfo struct {
+	ID          string    `json:"id"`           // Internal key of the chat session
+	Chat        string    `json:"chat"`         // Identifier of the chat session as passed to Chat, see Delete and Touch
+	LastMessage time.Time `json:"last_message"` // Time of the last message sent or received, see Touch
+	Messages    int       `json:"messages"`     // Number of messages in the history
+	Tokens      int       `json:"tokens"`       // Estimated tokens occupied by the history
+	Usage       Cost      `json:"usage"`        // Token usage and cost of the model requests, see ChatsManager.Cost
+}
+
+// Stats is a snapshot of the manager state for monitoring.
+type Stats struct {
+	Started      time.Time           `json:"started"`                 // Time the manager was created
+	ActiveChats  int                 `json:"active_chats"`            // Number of chat sessions held in memory
+	Messages     int                 `json:"messages"`                // Total messages across active chats
+	Tokens       int                 `json:"tokens"`                  // Total estimated tokens across active chats
+	Tools        int                 `json:"tools"`                   // Number of available MCP tools
+	McpServers   []mcpcli.ServerInfo `json:"mcp_servers"`             // Status of the configured MCP servers
+	StorageOK    bool                `json:"storage_ok"`              // Whether the storage backend is healthy
+	StorageError string              `json:"storage_error,omitempty"` // Storage health check error, if any
+	Model        string              `json:"model"`                   // Default model name
+	Now          time.Time           `json:"now"`                     // Time of the snapshot on the clock of the manager, see WithClock
+}
+
+// List returns information about all active chat sessions, most recently active first.
+func (cm *ChatsManager) List() []ChatInfo {
+	list := make([]ChatInfo, 0, cm.chats.Len())
+	usage := cm.costs.chats()
+	cm.chats.ForEachWithRLocker(func(key string, value *chat.Chat) bool {
+		list = append(list, ChatInfo{
+			ID:          value.ID(),
+			Chat:        key,
+			LastMessage: value.LastMessage(),
+			Messages:    len(value.History()),
+			Tokens:      value.Tokens(),
+			Usage:       usage[key],
+		})
+		return true
+	})
+	slices.SortFunc(list, func(a, b ChatInfo) int {
+		return b.LastMessage.Compare(a.LastMessage)
+	})
+	return list
+}
+
+// Touch marks an active chat session as active now, extending its lifetime, e.g. while the
+// user is still reading a long answer, see WithChatLifeTime.
+//
+// Parameters:
+//   - id: Identifier of the chat session
+//
+// Returns:
+//   - bool: false if the session is not active
+func (cm *ChatsManager) Touch(id string) bool {
+	ch, ok := cm.chats.LoadForUpdate(id)
+	if ok {
+		ch.Touch()
+	}
+	return ok
+}
+
+// Stats returns a snapshot of the manager state, including aggregated chat usage,
+// MCP server status and storage health. Storage backends implementing
+// storage.HealthChecker are pinged, all others are reported as healthy.
+func (cm *ChatsManager) Stats() *Stats {
+	st := &Stats{
+		Started:    cm.started,
+		Tools:      cm.mcpCli.ToolCount(),
+		McpServers: cm.mcpCli.Servers(),
+		StorageOK:  true,
+		Model:      cm.cnf.modelName,
+		Now:        cm.cnf.clock.Now(),
+	}
+	for _, ci := range cm.List() {
+		st.ActiveChats++
+		st.Messages += ci.Messages
+		st.Tokens += ci.Tokens
+	}
+	if hc, ok := cm.cnf.dataStorage.(storage.HealthChecker); ok {
+		ctx, cancel := storageContext()
+		defer cancel()
+		if err := hc.Ping(ctx); err != nil {
+			st.StorageOK = false
+			st.StorageError = err.Error()
+		}
+	}
+	return st
+}
+
+// ExportHTML writes a standalone HTML transcript of a chat session to w, with collapsible
+// tool calls, tool results and reasoning sections. Active sessions are rendered with
+// message timestamps; inactive sessions are restored from storage without timestamps.
+//
+// Parameters:
+//   - id: Identifier of the chat session
+//   - w: Destination of the HTML document
+//
+// Returns:
+//   - error: If the chat does not exist or rendering fails
+func (cm *ChatsManager) ExportHTML(id string, w io.Writer) error {
+	records, err := cm.records(id)
+	if err != nil {
+		return err
+	}
+	return export.HTML(w, id, records)
+}
+
+// ExportAnonymized writes a standalone HTML transcript of a chat session like ExportHTML,
+// with user identifiers and detected PII replaced by stable pseudonyms, so the conversation
+// can be shared with model vendors or annotators. The chat id is replaced as well. Reusing
+// one Anonymizer for several chats keeps the pseudonyms consistent across the transcripts.
+//
+// Parameters:
+//   - id: Identifier of the chat session
+//   - w: Destination of the HTML document
+//   - a: Anonymizer holding the known identifiers and the pseudonyms issued so far,
+//     nil for one with the built-in PII detectors only
+//
+// Returns:
+//   - error: If the chat does not exist or rendering fails
+func (cm *ChatsManager) ExportAnonymized(id string, w io.Writer, a *export.Anonymizer) error {
+	if a == nil {
+		a = export.NewAnonymizer()
+	}
+	records, err := cm.records(id)
+	if err != nil {
+		return err
+	}
+	return export.HTML(w, a.Pseudonym("CHAT", id), a.Records(records))
+}
+
+// records returns the history records of a chat session, restoring inactive sessions
+// from storage without timestamps.
+func (cm *ChatsManager) records(id string) ([]history.Record, error) {
+	var records []history.Record
+	if ch, ok := cm.chats.LoadForUpdate(id); ok {
+		records = ch.Records()
+	} else {
+		key := cm.lookupID(id)
+		cm.awaitWrites(key)
+		ctx, cancel := storageContext()
+		defer cancel()
+		his, err := cm.cnf.dataStorage.Load(ctx, key)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return nil, storageError(err)
+		}
+		for _, msg := range his {
+			records = append(records, history.Record{Message: msg})
+		}
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("chat [%s] not found", id)
+	}
+	return records, nil
+}
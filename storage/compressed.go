@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/xyzj/llm/provider"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/xyzj/toolbox/json"
+)
+
+// Compression is a compression algorithm of CompressedStorage.
+type Compression string
+
+const (
+	// CompressionGzip compresses with gzip, readable with standard tools.
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd compresses with Zstandard, faster and smaller than gzip.
+	CompressionZstd Compression = "zstd"
+)
+
+// compressedRole is the role of the envelope message stored in place of a compressed history.
+const compressedRole = "compressed"
+
+// WithCompression sets the compression algorithm of CompressedStorage.
+// Defaults to CompressionZstd.
+func WithCompression(c Compression) Opts {
+	return func(opt *Opt) {
+		opt.codec = c
+	}
+}
+
+// WithMinCompressSize sets the serialized size in bytes from which CompressedStorage
+// compresses a history; smaller histories are stored unchanged, as compressing them
+// saves little. Defaults to 1024.
+func WithMinCompressSize(n int) Opts {
+	return func(opt *Opt) {
+		opt.minSize = max(n, 0)
+	}
+}
+
+// CompressedStorage compresses chat histories before delegating them to another storage
+// backend, cutting Redis memory and BoltDB file size for long conversations. The inner
+// backend stores a single envelope message per chat holding the compressed JSON of the
+// history. Histories stored uncompressed, or with another algorithm, are loaded as well.
+//
+// Combined with EncryptedStorage, the CompressedStorage must wrap the EncryptedStorage,
+// as encrypted data does not compress.
+type CompressedStorage struct {
+	decorator
+	codec   Compression
+	minSize int
+	enc     *zstd.Encoder // Shared zstd encoder, safe for concurrent EncodeAll calls
+	dec     *zstd.Decoder // Shared zstd decoder, safe for concurrent DecodeAll calls
+}
+
+// NewCompressedStorage wraps a storage backend with compression.
+//
+// Parameters:
+//   - inner: Backend storing the compressed histories, e.g. RedisStorage or FileStorage
+//   - opts: Optional configuration, e.g. WithCompression or WithMinCompressSize
+//
+// Returns:
+//   - Storage: A CompressedStorage instance implementing the Storage interface
+//   - error: If the compression algorithm is unknown
+func NewCompressedStorage(inner Storage, opts ...Opts) (Storage, error) {
+	opt := newOpt(append([]Opts{WithCompression(CompressionZstd), WithMinCompressSize(1024)}, opts...)...)
+	switch opt.codec {
+	case CompressionGzip, CompressionZstd:
+	default:
+		return nil, fmt.Errorf("unknown compression [%s]", opt.codec)
+	}
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &CompressedStorage{
+		decorator: decorator{inner: inner},
+		codec:     opt.codec,
+		minSize:   opt.minSize,
+		enc:       enc,
+		dec:       dec,
+	}, nil
+}
+
+// Store compresses the history and stores it in the inner backend.
+func (s *CompressedStorage) Store(ctx context.Context, chatid string, history []*provider.Message) error {
+	data, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	if len(data) < s.minSize {
+		return s.inner.Store(ctx, chatid, history)
+	}
+	var packed []byte
+	switch s.codec {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err = zw.Write(data); err != nil {
+			return err
+		}
+		if err = zw.Close(); err != nil {
+			return err
+		}
+		packed = buf.Bytes()
+	default:
+		packed = s.enc.EncodeAll(data, nil)
+	}
+	content := string(s.codec) + ":" + base64.StdEncoding.EncodeToString(packed)
+	return s.inner.Store(ctx, chatid, []*provider.Message{{
+		Role:    compressedRole,
+		Content: &provider.MessageContent{StringValue: &content},
+	}})
+}
+
+// Load loads the history from the inner backend and decompresses it.
+// It returns ErrNotFound if no history is stored for the chat ID.
+func (s *CompressedStorage) Load(ctx context.Context, chatid string) ([]*provider.Message, error) {
+	msgs, err := s.inner.Load(ctx, chatid)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) != 1 || msgs[0].Role != compressedRole || msgs[0].Content == nil || msgs[0].Content.StringValue == nil {
+		// small or stored before compression was enabled
+		return msgs, nil
+	}
+	data, err := s.unpack(*msgs[0].Content.StringValue)
+	if err != nil {
+		return nil, fmt.Errorf("chat [%s]: %w", chatid, err)
+	}
+	history := make([]*provider.Message, 0)
+	if err = json.Unmarshal(data, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// unpack decompresses the content of an envelope message with the algorithm it names.
+func (s *CompressedStorage) unpack(content string) ([]byte, error) {
+	codec, payload, _ := strings.Cut(content, ":")
+	packed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	switch Compression(codec) {
+	case CompressionGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(packed))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(zr)
+	case CompressionZstd:
+		return s.dec.DecodeAll(packed, nil)
+	default:
+		return nil, fmt.Errorf("unknown compression [%s]", codec)
+	}
+}
+
+// Namespace returns a CompressedStorage with the same settings wrapping the given
+// namespace of the inner backend. It panics if the inner backend does not implement
+// Namespacer.
+func (s *CompressedStorage) Namespace(prefix string) Storage {
+	ns := *s
+	ns.decorator = s.namespace(prefix)
+	return &ns
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func newTestCompressed(t *testing.T, inner Storage, opts ...Opts) Storage {
+	t.Helper()
+	st, err := NewCompressedStorage(inner, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func TestCompressedRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	long := strings.Repeat("a long answer ", 200)
+	for _, codec := range []Compression{CompressionGzip, CompressionZstd} {
+		inner := NewMemoryStorage()
+		st := newTestCompressed(t, inner, WithCompression(codec))
+		if err := st.Store(ctx, "chat", testHistory("question", long)); err != nil {
+			t.Fatal(err)
+		}
+		raw, err := inner.Load(ctx, "chat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(raw) != 1 || raw[0].Role != compressedRole || !strings.HasPrefix(*raw[0].Content.StringValue, string(codec)+":") {
+			t.Errorf("%s: stored %v, want a compressed envelope", codec, raw)
+		}
+		got, err := st.Load(ctx, "chat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertHistory(t, got, "question", long)
+	}
+}
+
+func TestCompressedSmallHistoryUnchanged(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStorage()
+	st := newTestCompressed(t, inner)
+	if err := st.Store(ctx, "chat", testHistory("hi")); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := inner.Load(ctx, "chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHistory(t, raw, "hi")
+}
+
+func TestCompressedLoadsOtherCodecs(t *testing.T) {
+	ctx := context.Background()
+	long := strings.Repeat("a long answer ", 200)
+	inner := NewMemoryStorage()
+	if err := inner.Store(ctx, "plain", testHistory("stored before compression")); err != nil {
+		t.Fatal(err)
+	}
+	if err := newTestCompressed(t, inner, WithCompression(CompressionGzip)).Store(ctx, "gzip", testHistory(long)); err != nil {
+		t.Fatal(err)
+	}
+	st := newTestCompressed(t, inner, WithCompression(CompressionZstd))
+	got, err := st.Load(ctx, "plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHistory(t, got, "stored before compression")
+	if got, err = st.Load(ctx, "gzip"); err != nil {
+		t.Fatal(err)
+	}
+	assertHistory(t, got, long)
+}
+
+func TestCompressedUnknownCodec(t *testing.T) {
+	if _, err := NewCompressedStorage(NewMemoryStorage(), WithCompression("lz4")); err == nil {
+		t.Error("created with an unknown compression")
+	}
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// decorator implements the pass-through methods of storage wrappers transforming the
+// stored histories, like EncryptedStorage and CompressedStorage.
+type decorator struct {
+	inner Storage // Wrapped backend
+}
+
+// Delete removes the history of the chat from the inner backend.
+func (d decorator) Delete(ctx context.Context, chatid string) error {
+	return d.inner.Delete(ctx, chatid)
+}
+
+// Clear removes all histories of the namespace from the inner backend.
+func (d decorator) Clear(ctx context.Context) error {
+	return d.inner.Clear(ctx)
+}
+
+// List returns the IDs of the stored chats if the inner backend implements Lister.
+func (d decorator) List(ctx context.Context) ([]string, error) {
+	l, ok := d.inner.(Lister)
+	if !ok {
+		return nil, fmt.Errorf("storage %T cannot list chats: %w", d.inner, errors.ErrUnsupported)
+	}
+	return l.List(ctx)
+}
+
+// Ping checks the inner backend if it implements HealthChecker.
+func (d decorator) Ping(ctx context.Context) error {
+	if hc, ok := d.inner.(HealthChecker); ok {
+		return hc.Ping(ctx)
+	}
+	return nil
+}
+
+// NamespaceName returns the namespace of the inner backend if it implements Namespaced.
+func (d decorator) NamespaceName() string {
+	if ns, ok := d.inner.(Namespaced); ok {
+		return ns.NamespaceName()
+	}
+	return ""
+}
+
+// Close closes the inner backend if it implements io.Closer.
+func (d decorator) Close() error {
+	if c, ok := d.inner.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// namespace returns a decorator of the given namespace of the inner backend.
+// It panics if the inner backend does not implement Namespacer.
+func (d decorator) namespace(prefix string) decorator {
+	return decorator{inner: d.inner.(Namespacer).Namespace(prefix)}
+}
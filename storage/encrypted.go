@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xyzj/llm/provider"
+
+	"github.com/xyzj/toolbox/json"
+)
+
+const (
+	// encryptedRole is the role of the envelope message stored in place of an encrypted history.
+	encryptedRole = "encrypted"
+	// encryptedVersion prefixes the content of the envelope message.
+	encryptedVersion = "aesgcm1"
+)
+
+// WithPreviousKeys sets retired keys of EncryptedStorage, so histories encrypted before a
+// key rotation can still be read. Such histories are encrypted with the current key the
+// next time they are stored, or all at once with EncryptedStorage.Rotate.
+func WithPreviousKeys(keys ...[]byte) Opts {
+	return func(opt *Opt) {
+		opt.prevKeys = keys
+	}
+}
+
+// gcmKey is an AES-GCM cipher and the id of its key.
+type gcmKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// EncryptedStorage encrypts chat histories with AES-GCM before delegating them to another
+// storage backend, so chat content is not stored in plaintext. The inner backend stores a
+// single envelope message per chat holding the encrypted JSON of the history; the chat ID
+// is authenticated with it, so an envelope copied to another chat fails to decrypt.
+// Histories stored in plaintext before the wrapper was introduced are loaded unchanged.
+type EncryptedStorage struct {
+	decorator
+	keys []*gcmKey // Current key followed by the retired keys
+}
+
+// NewEncryptedStorage wraps a storage backend with encryption at rest.
+//
+// Parameters:
+//   - inner: Backend storing the encrypted histories, e.g. FileStorage or RedisStorage
+//   - key: AES key of 16, 24 or 32 bytes used to encrypt and decrypt
+//   - opts: Optional configuration, e.g. WithPreviousKeys
+//
+// Returns:
+//   - Storage: An EncryptedStorage instance implementing the Storage interface
+//   - error: If a key has an invalid length
+func NewEncryptedStorage(inner Storage, key []byte, opts ...Opts) (Storage, error) {
+	opt := newOpt(opts...)
+	keys := make([]*gcmKey, 0, len(opt.prevKeys)+1)
+	for _, k := range append([][]byte{key}, opt.prevKeys...) {
+		gk, err := newGCMKey(k)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, gk)
+	}
+	return &EncryptedStorage{
+		decorator: decorator{inner: inner},
+		keys:      keys,
+	}, nil
+}
+
+// newGCMKey creates the AES-GCM cipher of a key.
+func newGCMKey(key []byte) (*gcmKey, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &gcmKey{id: hex.EncodeToString(sum[:4]), aead: aead}, nil
+}
+
+// Store encrypts the history with the current key and stores it in the inner backend.
+func (s *EncryptedStorage) Store(ctx context.Context, chatid string, history []*provider.Message) error {
+	data, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	k := s.keys[0]
+	nonce := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(data)+k.aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := k.aead.Seal(nonce, nonce, data, []byte(chatid))
+	content := encryptedVersion + ":" + k.id + ":" + base64.StdEncoding.EncodeToString(sealed)
+	return s.inner.Store(ctx, chatid, []*provider.Message{{
+		Role:    encryptedRole,
+		Content: &provider.MessageContent{StringValue: &content},
+	}})
+}
+
+// Load loads the history from the inner backend and decrypts it.
+// It returns ErrNotFound if no history is stored for the chat ID.
+func (s *EncryptedStorage) Load(ctx context.Context, chatid string) ([]*provider.Message, error) {
+	msgs, err := s.inner.Load(ctx, chatid)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) != 1 || msgs[0].Role != encryptedRole || msgs[0].Content == nil || msgs[0].Content.StringValue == nil {
+		// stored before encryption was enabled
+		return msgs, nil
+	}
+	data, err := s.open(chatid, *msgs[0].Content.StringValue)
+	if err != nil {
+		return nil, fmt.Errorf("chat [%s]: %w", chatid, err)
+	}
+	history := make([]*provider.Message, 0)
+	if err = json.Unmarshal(data, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// open decrypts the content of an envelope message with the key it was encrypted with.
+func (s *EncryptedStorage) open(chatid, content string) ([]byte, error) {
+	version, rest, _ := strings.Cut(content, ":")
+	id, payload, ok := strings.Cut(rest, ":")
+	if version != encryptedVersion || !ok {
+		return nil, errors.New("unsupported encrypted history format")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range s.keys {
+		if k.id != id {
+			continue
+		}
+		if len(sealed) < k.aead.NonceSize() {
+			return nil, errors.New("encrypted history is truncated")
+		}
+		nonce, ciphertext := sealed[:k.aead.NonceSize()], sealed[k.aead.NonceSize():]
+		return k.aead.Open(nil, nonce, ciphertext, []byte(chatid))
+	}
+	return nil, fmt.Errorf("history is encrypted with unknown key [%s]", id)
+}
+
+// Namespace returns an EncryptedStorage with the same keys wrapping the given namespace
+// of the inner backend. It panics if the inner backend does not implement Namespacer.
+func (s *EncryptedStorage) Namespace(prefix string) Storage {
+	return &EncryptedStorage{
+		decorator: s.namespace(prefix),
+		keys:      s.keys,
+	}
+}
+
+// Rotate re-encrypts all stored histories of the namespace with the current key, so
+// retired keys can be dropped afterwards. The inner backend must implement Lister.
+//
+// Parameters:
+//   - ctx: Context controlling cancellation and deadline of the storage operations
+//
+// Returns:
+//   - error: The first error encountered, histories re-encrypted before it are kept
+func (s *EncryptedStorage) Rotate(ctx context.Context) error {
+	ids, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		history, err := s.Load(ctx, id)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return err
+		}
+		if err = s.Store(ctx, id, history); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/xyzj/llm/provider"
+)
+
+func testHistory(texts ...string) []*provider.Message {
+	msgs := make([]*provider.Message, 0, len(texts))
+	for _, text := range texts {
+		msgs = append(msgs, &provider.Message{Role: provider.RoleUser, Content: &provider.MessageContent{StringValue: &text}})
+	}
+	return msgs
+}
+
+func assertHistory(t *testing.T, got []*provider.Message, texts ...string) {
+	t.Helper()
+	if len(got) != len(texts) {
+		t.Fatalf("loaded %d messages, want %d", len(got), len(texts))
+	}
+	for i, msg := range got {
+		if msg.Content == nil || msg.Content.StringValue == nil || *msg.Content.StringValue != texts[i] {
+			t.Errorf("message %d = %v, want %q", i, msg.Content, texts[i])
+		}
+	}
+}
+
+func newTestEncrypted(t *testing.T, inner Storage, key []byte, opts ...Opts) *EncryptedStorage {
+	t.Helper()
+	st, err := NewEncryptedStorage(inner, key, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st.(*EncryptedStorage)
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStorage()
+	st := newTestEncrypted(t, inner, bytes.Repeat([]byte{1}, 32))
+	if err := st.Store(ctx, "chat", testHistory("my card number", "noted")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := st.Load(ctx, "chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHistory(t, got, "my card number", "noted")
+
+	raw, err := inner.Load(ctx, "chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 1 || raw[0].Role != encryptedRole || strings.Contains(*raw[0].Content.StringValue, "card") {
+		t.Errorf("stored envelope = %v, want the encrypted history", raw)
+	}
+}
+
+func TestEncryptedRotate(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStorage()
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	if err := newTestEncrypted(t, inner, oldKey).Store(ctx, "chat", testHistory("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTestEncrypted(t, inner, newKey).Load(ctx, "chat"); err == nil {
+		t.Fatal("loaded with an unknown key")
+	}
+
+	rotating := newTestEncrypted(t, inner, newKey, WithPreviousKeys(oldKey))
+	got, err := rotating.Load(ctx, "chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHistory(t, got, "hello")
+	if err = rotating.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got, err = newTestEncrypted(t, inner, newKey).Load(ctx, "chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHistory(t, got, "hello")
+}
+
+func TestEncryptedRejectsCopiedEnvelope(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStorage()
+	st := newTestEncrypted(t, inner, bytes.Repeat([]byte{1}, 32))
+	if err := st.Store(ctx, "alice", testHistory("secret")); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := inner.Load(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = inner.Store(ctx, "mallory", raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = st.Load(ctx, "mallory"); err == nil {
+		t.Error("loaded the envelope of another chat")
+	}
+}
+
+func TestEncryptedLoadsPlaintext(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStorage()
+	if err := inner.Store(ctx, "chat", testHistory("stored before encryption")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := newTestEncrypted(t, inner, bytes.Repeat([]byte{1}, 16)).Load(ctx, "chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHistory(t, got, "stored before encryption")
+}
@@ -1,13 +1,19 @@
 package storage
 
 import (
-	"github.com/volcengine/volcengine-go-sdk/service/arkruntime/model"
+	"context"
+	"slices"
+
+	"github.com/xyzj/llm/provider"
+
 	"github.com/xyzj/toolbox/db"
 	"github.com/xyzj/toolbox/json"
 )
 
 // FileStorage provides a file-based implementation of the Storage interface using BoltDB.
 // It persists chat conversation histories to disk, ensuring data survives application restarts.
+// Each history is stored as a JSON array under the chat ID as its key, in the bucket
+// named after the namespace of the storage.
 //
 // Characteristics:
 //   - Persistent storage that survives application restarts
@@ -17,65 +23,134 @@ import (
 //   - JSON serialization for message data
 //   - Thread-safe operations through BoltDB's concurrency control
 type FileStorage struct {
-	f  string     // File path for the BoltDB database
-	db *db.BoltDB // BoltDB instance for persistent storage
+	f      string     // File path for the BoltDB database
+	db     *db.BoltDB // BoltDB instance for persistent storage
+	bucket string     // Bucket holding the histories of the namespace
 }
 
 // NewFileStorage creates a new file-based storage instance using the specified file path.
 // The database file is created automatically if it doesn't exist, along with any
 // necessary parent directories.
 //
+// A database file can only be opened once, use Namespace to share it between
+// several ChatsManager instances.
+//
 // Parameters:
 //   - filename: Path to the BoltDB database file
+//   - opts: Optional configuration, e.g. WithNamespace
 //
 // Returns:
 //   - Storage: A new FileStorage instance implementing the Storage interface
 //   - error: Any error encountered during database initialization
-func NewFileStorage(filename string) (Storage, error) {
+func NewFileStorage(filename string, opts ...Opts) (Storage, error) {
+	opt := newOpt(opts...)
 	d, err := db.NewBolt(filename)
 	if err != nil {
 		return nil, err
 	}
 	return &FileStorage{
-		f:  filename,
-		db: d,
+		f:      filename,
+		db:     d,
+		bucket: opt.namespace,
 	}, nil
 }
 
-// Clear removes all stored conversation histories from the database file.
+// Namespace returns a FileStorage using the same database file in another namespace.
+func (s *FileStorage) Namespace(prefix string) Storage {
+	return &FileStorage{
+		f:      s.f,
+		db:     s.db,
+		bucket: newOpt(WithNamespace(prefix)).namespace,
+	}
+}
+
+// NamespaceName returns the namespace of the storage.
+func (s *FileStorage) NamespaceName() string {
+	return s.bucket
+}
+
+// Close closes the database file, which is shared by the namespaces of the storage.
+func (s *FileStorage) Close() error {
+	return s.db.Close()
+}
+
+// Clear removes all conversation histories of the namespace from the database file.
 // This operation iterates through all keys and deletes them individually.
 // The operation is performed within BoltDB's transaction system for consistency.
-func (s *FileStorage) Clear() error {
-	s.db.ForEach(func(k, v string) error {
-		s.db.Delete(k)
-		return nil
-	})
+func (s *FileStorage) Clear(ctx context.Context) error {
+	ids, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err = s.db.Delete(id, s.bucket); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Load retrieves the conversation history for the specified chat ID from the database.
-// The method currently has a bug - it loads ALL conversations instead of filtering by chatid.
-// This should be fixed to only load the specific chat's history.
+// Load retrieves the conversation history stored under the specified chat ID.
+//
+// Parameters:
+//   - ctx: Context checked before reading the database file
+//   - chatid: Unique identifier for the chat session
 //
-// TODO: Fix implementation to filter by chatid parameter
+// Returns:
+//   - []*provider.Message: Stored messages in chronological order
+//   - error: ErrNotFound if no history is stored for chatid, or any deserialization error
+func (s *FileStorage) Load(ctx context.Context, chatid string) ([]*provider.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	v := s.db.Read(chatid, s.bucket)
+	if v == "" {
+		return nil, ErrNotFound
+	}
+	data := make([]*provider.Message, 0)
+	if err := json.UnmarshalFromString(v, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// List returns the IDs of all chats with a stored history, sorted in ascending order.
 //
 // Parameters:
-//   - chatid: Unique identifier for the chat session (currently unused due to bug)
+//   - ctx: Context checked before reading the database file
 //
 // Returns:
-//   - []*model.ChatCompletionMessage: All stored messages (should be filtered by chatid)
-func (s *FileStorage) Load(chatid string) ([]*model.ChatCompletionMessage, error) {
-	data := make([]*model.ChatCompletionMessage, 0, 1000)
+//   - []string: Stored chat IDs
+//   - error: Any error encountered while reading the database file
+func (s *FileStorage) List(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0)
 	s.db.ForEach(func(k, v string) error {
-		x := &model.ChatCompletionMessage{}
-		err := json.UnmarshalFromString(v, x)
-		if err != nil {
-			return err
-		}
-		data = append(data, x)
+		ids = append(ids, k)
 		return nil
-	})
-	return data, nil
+	}, s.bucket)
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// Delete removes the conversation history of the specified chat ID from the database file.
+//
+// Parameters:
+//   - ctx: Context checked before writing the database file
+//   - chatid: Unique identifier for the chat session
+//
+// Returns:
+//   - error: ErrNotFound if no history is stored for chatid, or any database error
+func (s *FileStorage) Delete(ctx context.Context, chatid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.db.Read(chatid, s.bucket) == "" {
+		return ErrNotFound
+	}
+	return s.db.Delete(chatid, s.bucket)
 }
 
 // Store persists a conversation history for the specified chat ID to the database file.
@@ -83,15 +158,19 @@ func (s *FileStorage) Load(chatid string) ([]*model.ChatCompletionMessage, error
 // The operation is atomic and thread-safe through BoltDB's transaction system.
 //
 // Parameters:
+//   - ctx: Context checked before writing the database file
 //   - chatid: Unique identifier for the chat session
 //   - history: Slice of chat completion messages to persist
 //
 // Returns:
 //   - error: Any error encountered during JSON serialization or database write
-func (s *FileStorage) Store(chatid string, history []*model.ChatCompletionMessage) error {
+func (s *FileStorage) Store(ctx context.Context, chatid string, history []*provider.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	xs, err := json.MarshalToString(history)
 	if err != nil {
 		return err
 	}
-	return s.db.Write(chatid, xs)
+	return s.db.Write(chatid, xs, s.bucket)
 }
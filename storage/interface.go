@@ -4,9 +4,61 @@
 package storage
 
 import (
-	"github.com/volcengine/volcengine-go-sdk/service/arkruntime/model"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/xyzj/llm/provider"
+
+	"github.com/minio/minio-go/v7/pkg/encrypt"
 )
 
+// DefaultNamespace is the namespace of storages created without WithNamespace.
+const DefaultNamespace = "default"
+
+type (
+	// Opt contains configuration options for creating a storage backend.
+	Opt struct {
+		namespace string             // Namespace separating the stored chats from other users of the backend
+		keyPrefix string             // Object key prefix of S3Storage
+		sse       encrypt.ServerSide // Server-side encryption of S3Storage, nil for the bucket default
+		ttl       time.Duration      // Expiry of the per-chat keys of RedisStorage, 0 for the single hash mode
+		prevKeys  [][]byte           // Retired keys EncryptedStorage can still decrypt with
+		codec     Compression        // Compression algorithm of CompressedStorage
+		minSize   int                // Serialized size from which CompressedStorage compresses
+	}
+	// Opts is a function type for configuring storage backends.
+	Opts func(opt *Opt)
+)
+
+// WithNamespace sets the namespace of a storage backend, so several ChatsManager instances
+// can share one backend without key collisions. Chats stored in one namespace are not
+// visible in any other. The namespace is mapped to a BoltDB bucket by FileStorage, to the
+// Redis hash key by RedisStorage and to a separate map by MemoryStorage.
+// Defaults to DefaultNamespace.
+func WithNamespace(prefix string) Opts {
+	return func(opt *Opt) {
+		if prefix != "" {
+			opt.namespace = prefix
+		}
+	}
+}
+
+// newOpt returns the storage options with the defaults applied.
+func newOpt(opts ...Opts) *Opt {
+	opt := &Opt{
+		namespace: DefaultNamespace,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	return opt
+}
+
+// ErrNotFound is returned by Storage.Load and Storage.Delete when no history is
+// stored for the chat ID, so callers can tell a new chat from a failing backend.
+var ErrNotFound = errors.New("storage: chat history not found")
+
 // Storage defines the interface for persisting and retrieving chat conversation histories.
 // Implementations must provide thread-safe operations and handle serialization of
 // chat completion messages.
@@ -14,31 +66,77 @@ import (
 // The interface supports:
 //   - Per-chat storage with unique identifiers
 //   - Efficient retrieval of conversation histories
-//   - Bulk clearing of all stored data
-//   - Error handling for storage operations
+//   - Removal of single chats and bulk clearing of all stored data
+//   - Cancellation and deadlines of storage operations through ctx
+//   - Error handling for storage operations, see ErrNotFound
+//
+// Backends holding resources they opened themselves, like the database file of
+// FileStorage, also implement io.Closer, and so do the decorators wrapping them. Backends
+// using a client passed by the caller, like RedisStorage and PostgresStorage, leave
+// closing it to the caller.
 type Storage interface {
 	// Store persists a chat conversation history for the specified chat ID.
 	// The history slice contains messages in chronological order.
 	//
 	// Parameters:
+	//   - ctx: Context controlling cancellation and deadline of the operation
 	//   - chatid: Unique identifier for the chat session
 	//   - history: Slice of messages to store
 	//
 	// Returns:
 	//   - error: Any error encountered during storage operation
-	Store(chatid string, history []*model.ChatCompletionMessage) error
+	Store(ctx context.Context, chatid string, history []*provider.Message) error
 
 	// Load retrieves the conversation history for the specified chat ID.
-	// Returns an empty slice if no history exists for the given ID.
 	//
 	// Parameters:
+	//   - ctx: Context controlling cancellation and deadline of the operation
 	//   - chatid: Unique identifier for the chat session
 	//
 	// Returns:
-	//   - []*model.ChatCompletionMessage: Retrieved messages in chronological order
-	Load(chatid string) ([]*model.ChatCompletionMessage, error)
+	//   - []*provider.Message: Retrieved messages in chronological order
+	//   - error: ErrNotFound if no history exists for the given ID, or any backend error
+	Load(ctx context.Context, chatid string) ([]*provider.Message, error)
 
-	// Clear removes all stored conversation histories from the storage backend.
+	// Delete removes the conversation history of the specified chat ID.
+	//
+	// Parameters:
+	//   - ctx: Context controlling cancellation and deadline of the operation
+	//   - chatid: Unique identifier for the chat session
+	//
+	// Returns:
+	//   - error: ErrNotFound if no history exists for the given ID, or any backend error
+	Delete(ctx context.Context, chatid string) error
+
+	// Clear removes all stored conversation histories of the namespace from the storage backend.
 	// This operation is irreversible and should be used with caution.
-	Clear() error
+	Clear(ctx context.Context) error
+}
+
+// Lister is an optional interface implemented by storage backends that can enumerate
+// the stored chats, e.g. for admin dashboards or migrations.
+type Lister interface {
+	// List returns the IDs of all chats with a stored history, sorted in ascending order.
+	List(ctx context.Context) ([]string, error)
+}
+
+// Namespacer is implemented by storage backends supporting namespaces, see WithNamespace.
+type Namespacer interface {
+	// Namespace returns a view of the backend in the given namespace, sharing the
+	// connection or file of the receiver, e.g. for another ChatsManager instance.
+	Namespace(prefix string) Storage
+}
+
+// Namespaced is an optional interface implemented by storage backends reporting their
+// namespace, e.g. to derive the namespace of related data from it.
+type Namespaced interface {
+	// NamespaceName returns the namespace of the backend, or an empty string if unknown.
+	NamespaceName() string
+}
+
+// HealthChecker is an optional interface implemented by storage backends that can
+// verify their connection, e.g. for monitoring or admin dashboards.
+type HealthChecker interface {
+	// Ping reports whether the storage backend is reachable and usable.
+	Ping(ctx context.Context) error
 }
@@ -1,9 +1,12 @@
 package storage
 
 import (
+	"context"
+	"maps"
+	"slices"
 	"sync"
 
-	"github.com/volcengine/volcengine-go-sdk/service/arkruntime/model"
+	"github.com/xyzj/llm/provider"
 )
 
 // MemoryStorage provides an in-memory implementation of the Storage interface.
@@ -16,29 +19,51 @@ import (
 //   - Suitable for temporary storage or testing scenarios
 //   - Memory usage grows with the number and size of stored conversations
 type MemoryStorage struct {
+	shared    *memoryData // Histories of all namespaces, shared with Namespace views
+	namespace string      // Namespace of this storage
+}
+
+// memoryData holds the histories of all namespaces of a MemoryStorage.
+type memoryData struct {
 	locker sync.RWMutex                              // Read-write mutex for thread safety
-	data   map[string][]*model.ChatCompletionMessage // In-memory storage map
+	data   map[string]map[string][]*provider.Message // Histories keyed by namespace and chat ID
 }
 
 // NewMemoryStorage creates a new in-memory storage instance.
 // The storage is ready for immediate use and provides thread-safe operations.
 //
+// Parameters:
+//   - opts: Optional configuration, e.g. WithNamespace
+//
 // Returns:
 //   - Storage: A new MemoryStorage instance implementing the Storage interface
-func NewMemoryStorage() Storage {
+func NewMemoryStorage(opts ...Opts) Storage {
+	return &MemoryStorage{
+		shared:    &memoryData{data: make(map[string]map[string][]*provider.Message)},
+		namespace: newOpt(opts...).namespace,
+	}
+}
+
+// Namespace returns a MemoryStorage sharing the memory of s in another namespace.
+func (s *MemoryStorage) Namespace(prefix string) Storage {
 	return &MemoryStorage{
-		data:   make(map[string][]*model.ChatCompletionMessage),
-		locker: sync.RWMutex{},
+		shared:    s.shared,
+		namespace: newOpt(WithNamespace(prefix)).namespace,
 	}
 }
 
-// Clear removes all stored conversation histories from memory.
+// NamespaceName returns the namespace of the storage.
+func (s *MemoryStorage) NamespaceName() string {
+	return s.namespace
+}
+
+// Clear removes all conversation histories of the namespace from memory.
 // This operation acquires a write lock and is thread-safe.
 // The operation is immediate and irreversible.
-func (s *MemoryStorage) Clear() error {
-	s.locker.Lock()
-	defer s.locker.Unlock()
-	s.data = make(map[string][]*model.ChatCompletionMessage)
+func (s *MemoryStorage) Clear(ctx context.Context) error {
+	s.shared.locker.Lock()
+	defer s.shared.locker.Unlock()
+	delete(s.shared.data, s.namespace)
 	return nil
 }
 
@@ -47,35 +72,65 @@ func (s *MemoryStorage) Clear() error {
 // This method is thread-safe and acquires a write lock during operation.
 //
 // Parameters:
+//   - ctx: Unused, in-memory operations don't block
 //   - chatid: Unique identifier for the chat session
 //   - msg: Slice of chat completion messages to store
 //
 // Returns:
 //   - error: Always returns nil for in-memory storage (kept for interface compliance)
-func (s *MemoryStorage) Store(chatid string, msg []*model.ChatCompletionMessage) error {
-	s.locker.Lock()
-	defer s.locker.Unlock()
-	if _, ok := s.data[chatid]; !ok {
-		s.data[chatid] = make([]*model.ChatCompletionMessage, 0)
+func (s *MemoryStorage) Store(ctx context.Context, chatid string, msg []*provider.Message) error {
+	s.shared.locker.Lock()
+	defer s.shared.locker.Unlock()
+	if _, ok := s.shared.data[s.namespace]; !ok {
+		s.shared.data[s.namespace] = make(map[string][]*provider.Message)
 	}
-	s.data[chatid] = msg
+	s.shared.data[s.namespace][chatid] = msg
 	return nil
 }
 
 // Load retrieves the conversation history for the specified chat ID.
-// Returns an empty slice if no history exists for the given chat ID.
 // This method is thread-safe and acquires a read lock during operation.
 //
 // Parameters:
+//   - ctx: Unused, in-memory operations don't block
 //   - chatid: Unique identifier for the chat session
 //
 // Returns:
-//   - []*model.ChatCompletionMessage: Retrieved conversation history or empty slice
-func (s *MemoryStorage) Load(chatid string) ([]*model.ChatCompletionMessage, error) {
-	s.locker.RLock()
-	defer s.locker.RUnlock()
-	if _, ok := s.data[chatid]; !ok {
-		return make([]*model.ChatCompletionMessage, 0), nil
+//   - []*provider.Message: Retrieved conversation history
+//   - error: ErrNotFound if no history exists for the given chat ID
+func (s *MemoryStorage) Load(ctx context.Context, chatid string) ([]*provider.Message, error) {
+	s.shared.locker.RLock()
+	defer s.shared.locker.RUnlock()
+	msg, ok := s.shared.data[s.namespace][chatid]
+	if !ok {
+		return nil, ErrNotFound
 	}
-	return s.data[chatid], nil
+	return msg, nil
+}
+
+// List returns the IDs of all chats with a stored history, sorted in ascending order.
+// This method is thread-safe and acquires a read lock during operation.
+func (s *MemoryStorage) List(ctx context.Context) ([]string, error) {
+	s.shared.locker.RLock()
+	defer s.shared.locker.RUnlock()
+	return slices.Sorted(maps.Keys(s.shared.data[s.namespace])), nil
+}
+
+// Delete removes the conversation history of the specified chat ID.
+// This method is thread-safe and acquires a write lock during operation.
+//
+// Parameters:
+//   - ctx: Unused, in-memory operations don't block
+//   - chatid: Unique identifier for the chat session
+//
+// Returns:
+//   - error: ErrNotFound if no history exists for the given chat ID
+func (s *MemoryStorage) Delete(ctx context.Context, chatid string) error {
+	s.shared.locker.Lock()
+	defer s.shared.locker.Unlock()
+	if _, ok := s.shared.data[s.namespace][chatid]; !ok {
+		return ErrNotFound
+	}
+	delete(s.shared.data[s.namespace], chatid)
+	return nil
 }
@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/xyzj/llm/provider"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/xyzj/toolbox/json"
+)
+
+// pgMigrations are the schema migrations of PostgresStorage. Migration i brings the
+// schema to version i+1; released migrations must never be changed, only appended.
+var pgMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS llm_chats (
+		namespace  text        NOT NULL,
+		chat_id    text        NOT NULL,
+		updated_at timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (namespace, chat_id)
+	);
+	CREATE TABLE IF NOT EXISTS llm_chat_messages (
+		namespace         text    NOT NULL,
+		chat_id           text    NOT NULL,
+		seq               integer NOT NULL,
+		role              text    NOT NULL,
+		name              text,
+		content           text,
+		content_parts     jsonb,
+		reasoning_content text,
+		tool_calls        jsonb,
+		tool_call_id      text,
+		PRIMARY KEY (namespace, chat_id, seq),
+		FOREIGN KEY (namespace, chat_id) REFERENCES llm_chats (namespace, chat_id) ON DELETE CASCADE
+	);`,
+}
+
+// pgMigrationLock is the advisory lock key serializing migrations of concurrent instances.
+const pgMigrationLock = 0x6c6c6d5f6d6967 // "llm_mig"
+
+// PostgresStorage stores chat histories in PostgreSQL, one row per message, so
+// server deployments can share histories across instances and query them with SQL.
+// Tool calls and multi-part contents are stored as JSONB.
+//
+// Tables:
+//   - llm_chats: One row per stored chat, keyed by namespace and chat ID
+//   - llm_chat_messages: The messages of the chats in order of the seq column
+//   - llm_schema_migrations: The applied schema migrations
+type PostgresStorage struct {
+	db        *pgxpool.Pool // Connection pool for PostgreSQL
+	namespace string        // Namespace of the stored chats, see WithNamespace
+}
+
+// NewPostgresStorage creates a PostgreSQL storage backend. The schema is created or
+// migrated to the current version automatically; concurrent instances starting at the
+// same time are serialized with an advisory lock.
+//
+// Parameters:
+//   - ctx: Context controlling cancellation and deadline of the migration
+//   - pool: Connection pool used for all operations, e.g. from pgxpool.New
+//   - opts: Optional configuration, e.g. WithNamespace
+//
+// Returns:
+//   - Storage: A PostgresStorage instance implementing the Storage interface
+//   - error: Any error encountered while migrating the schema
+func NewPostgresStorage(ctx context.Context, pool *pgxpool.Pool, opts ...Opts) (Storage, error) {
+	if err := pgMigrate(ctx, pool); err != nil {
+		return nil, err
+	}
+	return &PostgresStorage{
+		db:        pool,
+		namespace: newOpt(opts...).namespace,
+	}, nil
+}
+
+// pgMigrate applies the pending schema migrations, each in its own transaction.
+func pgMigrate(ctx context.Context, pool *pgxpool.Pool) error {
+	return pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", int64(pgMigrationLock)); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS llm_schema_migrations (
+			version    integer     PRIMARY KEY,
+			applied_at timestamptz NOT NULL DEFAULT now()
+		)`); err != nil {
+			return err
+		}
+		var version int
+		if err := tx.QueryRow(ctx, "SELECT coalesce(max(version), 0) FROM llm_schema_migrations").Scan(&version); err != nil {
+			return err
+		}
+		for v := version + 1; v <= len(pgMigrations); v++ {
+			if _, err := tx.Exec(ctx, pgMigrations[v-1]); err != nil {
+				return fmt.Errorf("schema migration %d: %w", v, err)
+			}
+			if _, err := tx.Exec(ctx, "INSERT INTO llm_schema_migrations (version) VALUES ($1)", v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Namespace returns a PostgresStorage using the same connection pool in another namespace.
+func (s *PostgresStorage) Namespace(prefix string) Storage {
+	return &PostgresStorage{
+		db:        s.db,
+		namespace: newOpt(WithNamespace(prefix)).namespace,
+	}
+}
+
+// NamespaceName returns the namespace of the storage.
+func (s *PostgresStorage) NamespaceName() string {
+	return s.namespace
+}
+
+// Ping checks the connection to the PostgreSQL server.
+func (s *PostgresStorage) Ping(ctx context.Context) error {
+	return s.db.Ping(ctx)
+}
+
+// Store replaces the stored history of the chat within one transaction.
+//
+// Parameters:
+//   - ctx: Context controlling cancellation and deadline of the transaction
+//   - chatid: Unique identifier for the chat session
+//   - history: Slice of chat completion messages to persist
+//
+// Returns:
+//   - error: Any error encountered during serialization or the database operations
+func (s *PostgresStorage) Store(ctx context.Context, chatid string, history []*provider.Message) error {
+	batch := &pgx.Batch{}
+	batch.Queue(`INSERT INTO llm_chats (namespace, chat_id) VALUES ($1, $2)
+		ON CONFLICT (namespace, chat_id) DO UPDATE SET updated_at = now()`, s.namespace, chatid)
+	batch.Queue("DELETE FROM llm_chat_messages WHERE namespace = $1 AND chat_id = $2", s.namespace, chatid)
+	for i, msg := range history {
+		var content *string
+		var parts, calls any
+		if msg.Content != nil {
+			content = msg.Content.StringValue
+			if msg.Content.ListValue != nil {
+				b, err := json.MarshalToString(msg.Content.ListValue)
+				if err != nil {
+					return err
+				}
+				parts = b
+			}
+		}
+		if len(msg.ToolCalls) > 0 {
+			b, err := json.MarshalToString(msg.ToolCalls)
+			if err != nil {
+				return err
+			}
+			calls = b
+		}
+		var callID *string
+		if msg.ToolCallID != "" {
+			callID = &msg.ToolCallID
+		}
+		batch.Queue(`INSERT INTO llm_chat_messages
+			(namespace, chat_id, seq, role, name, content, content_parts, reasoning_content, tool_calls, tool_call_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb, $8, $9::jsonb, $10)`,
+			s.namespace, chatid, i, msg.Role, msg.Name, content, parts, msg.ReasoningContent, calls, callID)
+	}
+	return pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		return tx.SendBatch(ctx, batch).Close()
+	})
+}
+
+// Load retrieves the stored history of the chat.
+//
+// Parameters:
+//   - ctx: Context controlling cancellation and deadline of the query
+//   - chatid: Unique identifier for the chat session
+//
+// Returns:
+//   - []*provider.Message: Stored messages in chronological order
+//   - error: ErrNotFound if no history is stored for chatid, or any database error
+func (s *PostgresStorage) Load(ctx context.Context, chatid string) ([]*provider.Message, error) {
+	var found bool
+	if err := s.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM llm_chats WHERE namespace = $1 AND chat_id = $2)",
+		s.namespace, chatid).Scan(&found); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	rows, err := s.db.Query(ctx, `SELECT role, name, content, content_parts, reasoning_content, tool_calls, tool_call_id
+		FROM llm_chat_messages WHERE namespace = $1 AND chat_id = $2 ORDER BY seq`, s.namespace, chatid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	msgs := make([]*provider.Message, 0)
+	for rows.Next() {
+		msg := &provider.Message{}
+		var content, callID *string
+		var parts, calls []byte
+		if err = rows.Scan(&msg.Role, &msg.Name, &content, &parts, &msg.ReasoningContent, &calls, &callID); err != nil {
+			return nil, err
+		}
+		if content != nil || parts != nil {
+			msg.Content = &provider.MessageContent{StringValue: content}
+			if parts != nil {
+				if err = json.Unmarshal(parts, &msg.Content.ListValue); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if calls != nil {
+			if err = json.Unmarshal(calls, &msg.ToolCalls); err != nil {
+				return nil, err
+			}
+		}
+		if callID != nil {
+			msg.ToolCallID = *callID
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, rows.Err()
+}
+
+// List returns the IDs of all chats of the namespace, sorted in ascending order.
+func (s *PostgresStorage) List(ctx context.Context) ([]string, error) {
+	rows, err := s.db.Query(ctx, "SELECT chat_id FROM llm_chats WHERE namespace = $1 ORDER BY chat_id", s.namespace)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// Delete removes the stored history of the chat.
+// It returns ErrNotFound if no history is stored for the chat ID.
+func (s *PostgresStorage) Delete(ctx context.Context, chatid string) error {
+	tag, err := s.db.Exec(ctx, "DELETE FROM llm_chats WHERE namespace = $1 AND chat_id = $2", s.namespace, chatid)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Clear removes all chat histories of the namespace.
+func (s *PostgresStorage) Clear(ctx context.Context) error {
+	_, err := s.db.Exec(ctx, "DELETE FROM llm_chats WHERE namespace = $1", s.namespace)
+	return err
+}
@@ -3,44 +3,66 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/xyzj/llm/provider"
+
 	"github.com/redis/go-redis/v9"
-	"github.com/volcengine/volcengine-go-sdk/service/arkruntime/model"
 )
 
 const chatHistoryPrefix = "llm_chats_histories_"
 
-type (
-	Opt struct {
-		historySuffix string // Suffix for chat history keys in storage
-	}
-	// Opts is a function type for configuring ChatsManager options.
-	Opts func(opt *Opt)
-)
-
 // WithHistorySuffix returns an Opts function that sets the history suffix for the Redis storage.
 // The suffix parameter specifies a custom suffix to be appended to history-related keys.
 // This is useful for organizing or namespacing history data in Redis.
+//
+// Deprecated: Use WithNamespace, which sets the same suffix and works with every backend.
 func WithHistorySuffix(suffix string) Opts {
+	return WithNamespace(suffix)
+}
+
+// WithTTL switches RedisStorage to storing each chat under its own key
+// "<hash key>:<chat id>" that expires d after the chat was last stored, so Redis
+// garbage-collects abandoned conversations itself. Histories stored in the single hash
+// mode are not visible in this mode. A zero or negative d keeps the single hash mode.
+func WithTTL(d time.Duration) Opts {
 	return func(opt *Opt) {
-		opt.historySuffix = suffix
+		opt.ttl = d
 	}
 }
 
+// RedisStorage stores the chat histories of a namespace as fields of one Redis hash,
+// or as separate expiring keys if created with WithTTL.
 type RedisStorage struct {
 	cnf        *Opt
 	db         *redis.Client // Redis client for persistent storage
-	historyKey string
+	historyKey string        // Key of the hash holding the histories of the namespace
 }
 
 // Clear removes the chat history from Redis storage by deleting the key
-// associated with this storage instance. It uses a 3-second timeout context
-// for the operation. Returns an error if the deletion fails.
-func (s *RedisStorage) Clear() error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
-	return s.db.Del(ctx, chatHistoryPrefix+s.cnf.historySuffix).Err()
+// associated with this storage instance. Returns an error if the deletion fails.
+func (s *RedisStorage) Clear(ctx context.Context) error {
+	if s.cnf.ttl > 0 {
+		keys, err := s.chatKeys(ctx)
+		if err != nil {
+			return err
+		}
+		for chunk := range slices.Chunk(keys, 500) {
+			if err = s.db.Del(ctx, chunk...).Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return s.db.Del(ctx, s.historyKey).Err()
+}
+
+// Ping checks the connection to the Redis server.
+func (s *RedisStorage) Ping(ctx context.Context) error {
+	return s.db.Ping(ctx).Err()
 }
 
 // Load retrieves the chat history for a given chat ID from Redis storage.
@@ -48,22 +70,28 @@ func (s *RedisStorage) Clear() error {
 // it into a slice of ChatCompletionMessage pointers.
 //
 // Parameters:
+//   - ctx: Context controlling cancellation and deadline of the Redis operation
 //   - chatid: The unique identifier for the chat session
 //
 // Returns:
-//   - []*model.ChatCompletionMessage: A slice of chat completion messages if successful
-//   - error: An error if the Redis operation fails, the chat ID doesn't exist,
-//     or JSON deserialization fails
-//
-// The function uses a 3-second timeout context for the Redis operation.
-func (s *RedisStorage) Load(chatid string) ([]*model.ChatCompletionMessage, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
-	val, err := s.db.HGet(ctx, s.historyKey, chatid).Result()
+//   - []*provider.Message: A slice of chat completion messages if successful
+//   - error: ErrNotFound if the chat ID doesn't exist, or an error if the Redis
+//     operation or JSON deserialization fails
+func (s *RedisStorage) Load(ctx context.Context, chatid string) ([]*provider.Message, error) {
+	var val string
+	var err error
+	if s.cnf.ttl > 0 {
+		val, err = s.db.Get(ctx, s.chatKey(chatid)).Result()
+	} else {
+		val, err = s.db.HGet(ctx, s.historyKey, chatid).Result()
+	}
 	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
-	var messages []*model.ChatCompletionMessage
+	var messages []*provider.Message
 	err = json.Unmarshal([]byte(val), &messages)
 	if err != nil {
 		return nil, err
@@ -73,45 +101,110 @@ func (s *RedisStorage) Load(chatid string) ([]*model.ChatCompletionMessage, erro
 
 // Store saves chat completion messages to Redis storage by marshaling the messages
 // to JSON and storing them in a hash set with the given chat ID as the key.
+// With WithTTL the messages are stored under the key of the chat and its expiry is reset.
 // It returns an error if JSON marshaling fails or if the Redis operation fails.
-// The operation has a timeout of 3 seconds.
-func (s *RedisStorage) Store(chatid string, messages []*model.ChatCompletionMessage) error {
+func (s *RedisStorage) Store(ctx context.Context, chatid string, messages []*provider.Message) error {
 	data, err := json.Marshal(messages)
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
+	if s.cnf.ttl > 0 {
+		return s.db.Set(ctx, s.chatKey(chatid), data, s.cnf.ttl).Err()
+	}
 	return s.db.HSet(ctx, s.historyKey, chatid, data).Err()
 }
 
+// List returns the IDs of all chats stored in the Redis hash, sorted in ascending order.
+func (s *RedisStorage) List(ctx context.Context) ([]string, error) {
+	var ids []string
+	var err error
+	if s.cnf.ttl > 0 {
+		ids, err = s.chatKeys(ctx)
+		for i, key := range ids {
+			ids[i] = strings.TrimPrefix(key, s.historyKey+":")
+		}
+	} else {
+		ids, err = s.db.HKeys(ctx, s.historyKey).Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(ids)
+	// SCAN may return a key more than once
+	return slices.Compact(ids), nil
+}
+
+// Delete removes the chat history of the given chat ID from the Redis hash.
+// It returns ErrNotFound if no history is stored for the chat ID.
+func (s *RedisStorage) Delete(ctx context.Context, chatid string) error {
+	var n int64
+	var err error
+	if s.cnf.ttl > 0 {
+		n, err = s.db.Del(ctx, s.chatKey(chatid)).Result()
+	} else {
+		n, err = s.db.HDel(ctx, s.historyKey, chatid).Result()
+	}
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Namespace returns a RedisStorage using the same client and TTL in another namespace.
+func (s *RedisStorage) Namespace(prefix string) Storage {
+	return NewRedisStorage(s.db, WithNamespace(prefix), WithTTL(s.cnf.ttl))
+}
+
+// NamespaceName returns the namespace of the storage.
+func (s *RedisStorage) NamespaceName() string {
+	return s.cnf.namespace
+}
+
+// chatKey returns the key of a chat in the WithTTL mode.
+func (s *RedisStorage) chatKey(chatid string) string {
+	return s.historyKey + ":" + chatid
+}
+
+// chatKeys returns the keys of all chats of the namespace in the WithTTL mode,
+// possibly with duplicates.
+func (s *RedisStorage) chatKeys(ctx context.Context) ([]string, error) {
+	match := redisGlobEscaper.Replace(s.historyKey) + ":*"
+	keys := make([]string, 0)
+	iter := s.db.Scan(ctx, 0, match, 500).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+// redisGlobEscaper escapes the special characters of Redis glob-style patterns.
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
 // NewRedisStorage creates a new Redis-based storage implementation for managing chat data.
 // It accepts a Redis client and optional configuration options to customize the storage behavior.
 //
 // Parameters:
 //   - cli: A *redis.Client instance used for Redis operations
-//   - opts: Variadic Opts functions to configure the storage (e.g., history suffix)
+//   - opts: Variadic Opts functions to configure the storage (e.g., WithNamespace or WithTTL)
 //
 // The function initializes a RedisStorage with:
-//   - A default history suffix of "default" if not specified
-//   - A history key constructed from chatHistoryPrefix and the configured suffix
+//   - The namespace DefaultNamespace if not specified
+//   - A history key constructed from chatHistoryPrefix and the configured namespace
 //
 // Returns:
 //   - Storage: A Storage interface implementation backed by Redis
 //
 // Example:
 //
-//	storage := NewRedisStorage(redisClient, WithHistorySuffix("session123"))
+//	storage := NewRedisStorage(redisClient, WithNamespace("session123"))
 func NewRedisStorage(cli *redis.Client, opts ...Opts) Storage {
-	opt := &Opt{
-		historySuffix: "default",
-	}
-	for _, o := range opts {
-		o(opt)
-	}
+	opt := newOpt(opts...)
 	return &RedisStorage{
 		db:         cli,
 		cnf:        opt,
-		historyKey: chatHistoryPrefix + opt.historySuffix,
+		historyKey: chatHistoryPrefix + opt.namespace,
 	}
 }
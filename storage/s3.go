@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/xyzj/llm/provider"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"github.com/xyzj/toolbox/json"
+)
+
+// s3Suffix is the file name suffix of the history objects.
+const s3Suffix = ".json.gz"
+
+// WithKeyPrefix sets the prefix of the object keys written by S3Storage, e.g. "archive/".
+// The keys are "<prefix><namespace>/<chat id>.json.gz".
+func WithKeyPrefix(prefix string) Opts {
+	return func(opt *Opt) {
+		opt.keyPrefix = prefix
+	}
+}
+
+// WithServerSideEncryption sets the server-side encryption of the objects written by
+// S3Storage, e.g. encrypt.NewSSE() for SSE-S3 or encrypt.NewSSEKMS for SSE-KMS.
+// SSE-C keys are also sent when reading objects.
+func WithServerSideEncryption(sse encrypt.ServerSide) Opts {
+	return func(opt *Opt) {
+		opt.sse = sse
+	}
+}
+
+// S3Storage stores each chat history as a gzip compressed JSON object in S3-compatible
+// object storage, e.g. AWS S3, MinIO or Cloudflare R2, for cheap and durable archives.
+type S3Storage struct {
+	cnf    *Opt          // Configuration options
+	db     *minio.Client // S3 client
+	bucket string        // Bucket holding the history objects
+}
+
+// NewS3Storage creates a storage backend writing to an S3-compatible bucket.
+// The bucket must exist.
+//
+// Parameters:
+//   - cli: S3 client, e.g. from minio.New
+//   - bucket: Name of the bucket holding the history objects
+//   - opts: Optional configuration, e.g. WithNamespace, WithKeyPrefix or WithServerSideEncryption
+//
+// Returns:
+//   - Storage: A Storage interface implementation backed by object storage
+func NewS3Storage(cli *minio.Client, bucket string, opts ...Opts) Storage {
+	return &S3Storage{
+		cnf:    newOpt(opts...),
+		db:     cli,
+		bucket: bucket,
+	}
+}
+
+// Namespace returns an S3Storage using the same client, bucket, key prefix and
+// encryption in another namespace.
+func (s *S3Storage) Namespace(prefix string) Storage {
+	cnf := *s.cnf
+	cnf.namespace = newOpt(WithNamespace(prefix)).namespace
+	return &S3Storage{
+		cnf:    &cnf,
+		db:     s.db,
+		bucket: s.bucket,
+	}
+}
+
+// NamespaceName returns the namespace of the storage.
+func (s *S3Storage) NamespaceName() string {
+	return s.cnf.namespace
+}
+
+// base returns the key prefix of the objects of the namespace.
+func (s *S3Storage) base() string {
+	return s.cnf.keyPrefix + s.cnf.namespace + "/"
+}
+
+// key returns the object key of a chat history.
+func (s *S3Storage) key(chatid string) string {
+	return s.base() + url.PathEscape(chatid) + s3Suffix
+}
+
+// Ping checks that the bucket exists and is accessible.
+func (s *S3Storage) Ping(ctx context.Context) error {
+	ok, err := s.db.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("bucket [%s] does not exist", s.bucket)
+	}
+	return nil
+}
+
+// Store writes the history of the chat as a compressed JSON object, replacing any
+// previous version.
+func (s *S3Storage) Store(ctx context.Context, chatid string, history []*provider.Message) error {
+	data, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err = zw.Write(data); err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	_, err = s.db.PutObject(ctx, s.bucket, s.key(chatid), &buf, int64(buf.Len()), minio.PutObjectOptions{
+		ContentType:          "application/gzip",
+		ServerSideEncryption: s.cnf.sse,
+	})
+	return err
+}
+
+// Load reads the history of the chat.
+// It returns ErrNotFound if no history is stored for the chat ID.
+func (s *S3Storage) Load(ctx context.Context, chatid string) ([]*provider.Message, error) {
+	obj, err := s.db.GetObject(ctx, s.bucket, s.key(chatid), minio.GetObjectOptions{ServerSideEncryption: s.cnf.sse})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	defer obj.Close()
+	zr, err := gzip.NewReader(obj)
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	msgs := make([]*provider.Message, 0)
+	if err = json.Unmarshal(data, &msgs); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// List returns the IDs of all chats of the namespace, sorted in ascending order.
+func (s *S3Storage) List(ctx context.Context) ([]string, error) {
+	base := s.base()
+	ids := make([]string, 0)
+	for obj := range s.db.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: base, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		name, ok := strings.CutSuffix(strings.TrimPrefix(obj.Key, base), s3Suffix)
+		if !ok {
+			continue
+		}
+		if id, err := url.PathUnescape(name); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// Delete removes the history object of the chat.
+// It returns ErrNotFound if no history is stored for the chat ID.
+func (s *S3Storage) Delete(ctx context.Context, chatid string) error {
+	if _, err := s.db.StatObject(ctx, s.bucket, s.key(chatid), minio.StatObjectOptions{ServerSideEncryption: s.cnf.sse}); err != nil {
+		return s3Error(err)
+	}
+	return s.db.RemoveObject(ctx, s.bucket, s.key(chatid), minio.RemoveObjectOptions{})
+}
+
+// Clear removes the history objects of all chats of the namespace.
+func (s *S3Storage) Clear(ctx context.Context) error {
+	ids, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err = s.db.RemoveObject(ctx, s.bucket, s.key(id), minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// s3Error maps missing objects to ErrNotFound.
+func s3Error(err error) error {
+	if minio.ToErrorResponse(err).Code == minio.NoSuchKey {
+		return ErrNotFound
+	}
+	return err
+}
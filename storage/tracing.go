@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/xyzj/llm/provider"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans of the package.
+const tracerName = "github.com/xyzj/llm/storage"
+
+// TracedStorage records an OpenTelemetry span for every operation of another storage
+// backend, named after the operation, e.g. "storage.Store", with the backend type, the
+// chat id and the number of messages stored or loaded. Loads of unknown chats are not
+// recorded as errors.
+type TracedStorage struct {
+	decorator
+	tracer  trace.Tracer
+	backend string // Type of the inner backend
+}
+
+// NewTracedStorage wraps a storage backend with tracing.
+//
+// Parameters:
+//   - inner: The backend to trace
+//   - tp: Provider of the tracer, e.g. otel.GetTracerProvider()
+func NewTracedStorage(inner Storage, tp trace.TracerProvider) Storage {
+	return &TracedStorage{
+		decorator: decorator{inner: inner},
+		tracer:    tp.Tracer(tracerName),
+		backend:   fmt.Sprintf("%T", inner),
+	}
+}
+
+// start starts the span of the operation op.
+func (s *TracedStorage) start(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "storage."+op, trace.WithAttributes(append(attrs, attribute.String("llm.storage.backend", s.backend))...))
+}
+
+// end records err on span unless it is ErrNotFound and ends the span.
+func (s *TracedStorage) end(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *TracedStorage) Store(ctx context.Context, chatid string, history []*provider.Message) error {
+	ctx, span := s.start(ctx, "Store", attribute.String("llm.chat.id", chatid), attribute.Int("llm.storage.messages", len(history)))
+	err := s.inner.Store(ctx, chatid, history)
+	s.end(span, err)
+	return err
+}
+
+func (s *TracedStorage) Load(ctx context.Context, chatid string) ([]*provider.Message, error) {
+	ctx, span := s.start(ctx, "Load", attribute.String("llm.chat.id", chatid))
+	his, err := s.inner.Load(ctx, chatid)
+	span.SetAttributes(attribute.Int("llm.storage.messages", len(his)))
+	s.end(span, err)
+	return his, err
+}
+
+func (s *TracedStorage) Delete(ctx context.Context, chatid string) error {
+	ctx, span := s.start(ctx, "Delete", attribute.String("llm.chat.id", chatid))
+	err := s.inner.Delete(ctx, chatid)
+	s.end(span, err)
+	return err
+}
+
+func (s *TracedStorage) Clear(ctx context.Context) error {
+	ctx, span := s.start(ctx, "Clear")
+	err := s.inner.Clear(ctx)
+	s.end(span, err)
+	return err
+}
+
+// Namespace returns a TracedStorage wrapping the given namespace of the inner backend. It
+// panics if the inner backend does not implement Namespacer.
+func (s *TracedStorage) Namespace(prefix string) Storage {
+	ns := *s
+	ns.decorator = s.namespace(prefix)
+	return &ns
+}
@@ -0,0 +1,58 @@
+package llm
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/xyzj/llm/provider"
+)
+
+// ToolHint steers the model's choice of a tool without editing the tool definition
+// of its server. Hints are rendered into the tool description sent to the model.
+type ToolHint struct {
+	Priority int      // Tools with higher priority are offered first, default 0
+	Hint     string   // Usage hint appended to the description, e.g. "Use for questions about internal projects."
+	Over     []string // Names of tools this tool should be preferred over
+}
+
+// applyToolHints returns the tools ordered by hint priority, highest first, with their
+// hints rendered into the descriptions. Tools without hint keep their relative order
+// and definition; hinted tools are copied, so the original definitions are not changed.
+func applyToolHints(tls []*provider.Tool, hints map[string]ToolHint) []*provider.Tool {
+	if len(hints) == 0 {
+		return tls
+	}
+	out := make([]*provider.Tool, 0, len(tls))
+	for _, t := range tls {
+		h, ok := ToolHint{}, false
+		if t.Function != nil {
+			h, ok = hints[t.Function.Name]
+		}
+		if !ok || (h.Hint == "" && len(h.Over) == 0) {
+			out = append(out, t)
+			continue
+		}
+		fn := *t.Function
+		desc := []string{strings.TrimSpace(fn.Description)}
+		if h.Hint != "" {
+			desc = append(desc, h.Hint)
+		}
+		if len(h.Over) > 0 {
+			desc = append(desc, "Prefer this tool over "+strings.Join(h.Over, ", ")+".")
+		}
+		fn.Description = strings.TrimSpace(strings.Join(desc, "\n"))
+		nt := *t
+		nt.Function = &fn
+		out = append(out, &nt)
+	}
+	priority := func(t *provider.Tool) int {
+		if t.Function == nil {
+			return 0
+		}
+		return hints[t.Function.Name].Priority
+	}
+	slices.SortStableFunc(out, func(a, b *provider.Tool) int {
+		return priority(b) - priority(a)
+	})
+	return out
+}
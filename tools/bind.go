@@ -0,0 +1,100 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/xyzj/toolbox/json"
+)
+
+var (
+	contextType = reflect.TypeFor[context.Context]()
+	errorType   = reflect.TypeFor[error]()
+)
+
+// BindFunc registers the Go function fn as a tool, deriving the JSON schema of its
+// arguments from the type of its argument struct with Schema. The arguments sent by the
+// model are decoded into a new value of that type for every call, missing or null
+// arguments into its zero value, and the return value is sent back to the model as is if
+// it is a string and JSON encoded otherwise; functions returning no value answer "ok".
+//
+// fn takes an optional context.Context followed by an optional argument struct, or
+// pointer to struct, and returns a value, an error, or a value and an error, e.g.
+//
+//	func(ctx context.Context, args SearchArgs) ([]Hit, error)
+//	func(args SearchArgs) string
+//	func(ctx context.Context) error
+//
+// Parameters:
+//   - name: Tool name exposed to the model, unique within the registry
+//   - description: Tool description exposed to the model
+//   - fn: Function implementing the tool
+//
+// Returns:
+//   - error: If fn is not a function of a supported signature, or Register fails
+func (r *Registry) BindFunc(name, description string, fn any) error {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return fmt.Errorf("tool [%s]: %T is not a function", name, fn)
+	}
+	t := v.Type()
+	withCtx := t.NumIn() > 0 && t.In(0) == contextType
+	var args reflect.Type
+	switch n := t.NumIn(); {
+	case withCtx && n > 2, !withCtx && n > 1:
+		return fmt.Errorf("tool [%s]: unsupported parameters %s", name, t)
+	case withCtx && n == 2, !withCtx && n == 1:
+		args = t.In(n - 1)
+		if args.Kind() != reflect.Struct && (args.Kind() != reflect.Pointer || args.Elem().Kind() != reflect.Struct) {
+			return fmt.Errorf("tool [%s]: the arguments must be a struct, not %s", name, args)
+		}
+	}
+	withErr := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	if t.NumOut() > 2 || (t.NumOut() == 2 && !withErr) {
+		return fmt.Errorf("tool [%s]: unsupported results %s", name, t)
+	}
+	var schema map[string]any
+	if args != nil {
+		schema = Schema(reflect.Zero(args).Interface())
+	}
+	return r.Register(name, description, schema, func(ctx context.Context, s string) (string, error) {
+		in := make([]reflect.Value, 0, 2)
+		if withCtx {
+			in = append(in, reflect.ValueOf(&ctx).Elem())
+		}
+		if args != nil {
+			p := reflect.New(args)
+			if args.Kind() == reflect.Pointer {
+				p.Elem().Set(reflect.New(args.Elem()))
+			}
+			// models omit the arguments of calls without any
+			if strings.TrimSpace(s) == "" {
+				s = "{}"
+			}
+			if err := json.UnmarshalFromString(s, p.Interface()); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			// null arguments reset the pointer, fn always gets a struct
+			if args.Kind() == reflect.Pointer && p.Elem().IsNil() {
+				p.Elem().Set(reflect.New(args.Elem()))
+			}
+			in = append(in, p.Elem())
+		}
+		out := v.Call(in)
+		if withErr {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return "", err
+			}
+			out = out[:len(out)-1]
+		}
+		if len(out) == 0 {
+			return "ok", nil
+		}
+		if res, ok := out[0].Interface().(string); ok {
+			return res, nil
+		}
+		return json.MarshalToString(out[0].Interface())
+	})
+}
@@ -0,0 +1,29 @@
+package tools
+
+import (
+	"context"
+	"testing"
+)
+
+type greetArgs struct {
+	Name string `json:"name"`
+}
+
+func TestBindFuncEmptyArguments(t *testing.T) {
+	r := NewRegistry()
+	if err := r.BindFunc("greet", "Greets someone", func(args *greetArgs) string {
+		return "hello " + args.Name
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range []string{"", " ", "null", "{}"} {
+		out, err := r.funcs["greet"](context.Background(), in)
+		if err != nil {
+			t.Errorf("arguments %q: %v", in, err)
+			continue
+		}
+		if out != "hello " {
+			t.Errorf("arguments %q: %q, want %q", in, out, "hello ")
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/xyzj/llm/provider"
+
+	"github.com/xyzj/toolbox/json"
+	"gopkg.in/yaml.v3"
+)
+
+// maxOutput limits the size of a tool result read from an HTTP response or command output.
+const maxOutput = 1 << 20
+
+// rePlaceholder matches {name} placeholders in URLs and command arguments.
+var rePlaceholder = regexp.MustCompile(`\{([a-zA-Z0-9_]+)\}`)
+
+type (
+	// Definition declares a tool backed by an HTTP endpoint or a local command.
+	// Exactly one of HTTP and Command must be set.
+	//
+	// Example (YAML):
+	//
+	//	name: get_weather
+	//	description: Get the current weather of a city
+	//	parameters:
+	//	  type: object
+	//	  properties:
+	//	    city: {type: string, description: Name of the city}
+	//	  required: [city]
+	//	http:
+	//	  method: GET
+	//	  url: https://weather.example.com/v1/{city}
+	//	  headers:
+	//	    Authorization: Bearer ${WEATHER_TOKEN}
+	//	timeout: 10s
+	Definition struct {
+		Name        string         `json:"name" yaml:"name"`                                 // Tool name exposed to the model
+		Description string         `json:"description" yaml:"description"`                   // Tool description exposed to the model
+		Parameters  map[string]any `json:"parameters,omitempty" yaml:"parameters,omitempty"` // JSON schema of the tool arguments
+		HTTP        *HTTPEndpoint  `json:"http,omitempty" yaml:"http,omitempty"`             // HTTP endpoint executing the tool
+		Command     []string       `json:"command,omitempty" yaml:"command,omitempty"`       // Command executing the tool, with its arguments
+		Timeout     string         `json:"timeout,omitempty" yaml:"timeout,omitempty"`       // Maximum execution time, e.g. "30s"
+		timeout     time.Duration  // Parsed Timeout
+	}
+	// HTTPEndpoint describes the request made for an HTTP backed tool.
+	//
+	// {name} placeholders in the URL are replaced by the escaped argument of the same name.
+	// The remaining arguments are sent as query parameters for GET and DELETE requests and
+	// as a JSON body otherwise. Header values are expanded with environment variables,
+	// so credentials don't need to be stored in the definition files.
+	HTTPEndpoint struct {
+		Method  string            `json:"method,omitempty" yaml:"method,omitempty"`   // HTTP method, defaults to POST
+		URL     string            `json:"url" yaml:"url"`                             // Request URL, may contain {name} placeholders
+		Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"` // Additional request headers
+	}
+)
+
+// Local is a Provider executing declaratively defined tools, see Definition.
+// Each tool call of an HTTP backed tool issues one request; each call of a command
+// backed tool runs the command with {name} placeholders in its arguments replaced
+// and the remaining arguments JSON encoded on its standard input.
+type Local struct {
+	defs   map[string]*Definition // Tool definitions keyed by name
+	tools  []*provider.Tool       // Tool definitions in the format expected by AI models
+	client *http.Client           // HTTP client for HTTP backed tools
+}
+
+// NewLocal creates a Provider for the given tool definitions.
+//
+// Parameters:
+//   - defs: Tool definitions, names must be unique
+//
+// Returns:
+//   - *Local: Provider executing the defined tools
+//   - error: If a definition is invalid or a name is duplicated
+func NewLocal(defs ...*Definition) (*Local, error) {
+	l := &Local{
+		defs:   make(map[string]*Definition, len(defs)),
+		tools:  make([]*provider.Tool, 0, len(defs)),
+		client: &http.Client{},
+	}
+	for _, d := range defs {
+		if err := d.validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := l.defs[d.Name]; ok {
+			return nil, fmt.Errorf("duplicate tool definition [%s]", d.Name)
+		}
+		params := d.Parameters
+		if params == nil {
+			params = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		l.defs[d.Name] = d
+		l.tools = append(l.tools, &provider.Tool{
+			Type: provider.ToolTypeFunction,
+			Function: &provider.FunctionDefinition{
+				Name:        d.Name,
+				Description: d.Description,
+				Parameters:  params,
+			},
+		})
+	}
+	return l, nil
+}
+
+// LoadLocal reads tool definitions from JSON (.json) and YAML (.yaml, .yml) files and
+// creates a Provider for them. Each file holds either a single definition or a list of
+// definitions. Directories are searched for definition files, not recursively.
+//
+// Parameters:
+//   - paths: Definition files or directories containing definition files
+//
+// Returns:
+//   - *Local: Provider executing the defined tools
+//   - error: Any error encountered while reading or validating the definitions
+func LoadLocal(paths ...string) (*Local, error) {
+	defs := make([]*Definition, 0)
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		files := []string{p}
+		if fi.IsDir() {
+			files = files[:0]
+			entries, err := os.ReadDir(p)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if !e.IsDir() && isDefinitionFile(e.Name()) {
+					files = append(files, filepath.Join(p, e.Name()))
+				}
+			}
+		}
+		for _, f := range files {
+			d, err := readDefinitions(f)
+			if err != nil {
+				return nil, fmt.Errorf("read tool definitions [%s]: %w", f, err)
+			}
+			defs = append(defs, d...)
+		}
+	}
+	return NewLocal(defs...)
+}
+
+// Tools returns the defined tools.
+func (l *Local) Tools() []*provider.Tool {
+	return l.tools
+}
+
+// Call executes a tool call by requesting the HTTP endpoint or running the command
+// of the tool definition. Responses with an HTTP status of 400 or above and commands
+// exiting with an error are reported as errors.
+func (l *Local) Call(ctx context.Context, tc *provider.ToolCall) (*provider.Message, error) {
+	d, ok := l.defs[tc.Function.Name]
+	if !ok {
+		return nil, fmt.Errorf("%w [%s]", provider.ErrToolNotFound, tc.Function.Name)
+	}
+	args := make(map[string]any)
+	if tc.Function.Arguments != "" {
+		if err := json.UnmarshalFromString(tc.Function.Arguments, &args); err != nil {
+			return nil, err
+		}
+	}
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+	var out string
+	var err error
+	if d.HTTP != nil {
+		out, err = l.callHTTP(ctx, d.HTTP, args)
+	} else {
+		out, err = callCommand(ctx, d.Command, args)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Result(tc, out), nil
+}
+
+func (l *Local) callHTTP(ctx context.Context, ep *HTTPEndpoint, args map[string]any) (string, error) {
+	method := strings.ToUpper(ep.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+	u := expand(ep.URL, args, url.PathEscape)
+	var body io.Reader
+	if len(args) > 0 {
+		if method == http.MethodGet || method == http.MethodDelete {
+			q := url.Values{}
+			for k, v := range args {
+				q.Set(k, fmt.Sprint(v))
+			}
+			sep := "?"
+			if strings.Contains(u, "?") {
+				sep = "&"
+			}
+			u += sep + q.Encode()
+		} else {
+			b, err := json.Marshal(args)
+			if err != nil {
+				return "", err
+			}
+			body = bytes.NewReader(b)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return "", err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range ep.Headers {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+	return doRequest(l.client, req)
+}
+
+// doRequest sends req and returns the response body, reporting responses with an
+// HTTP status of 400 or above as errors.
+func doRequest(client *http.Client, req *http.Request) (string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxOutput))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return "", fmt.Errorf("http status %d: %s", resp.StatusCode, json.String(b))
+	}
+	return json.String(b), nil
+}
+
+func callCommand(ctx context.Context, command []string, args map[string]any) (string, error) {
+	argv := make([]string, len(command))
+	for i, c := range command {
+		argv[i] = expand(c, args, nil)
+	}
+	in, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	out := stdout.Bytes()
+	if len(out) > maxOutput {
+		out = out[:maxOutput]
+	}
+	return string(out), nil
+}
+
+// expand replaces {name} placeholders in s with the matching arguments, escaped by esc
+// if given. Substituted arguments are removed from args.
+func expand(s string, args map[string]any, esc func(string) string) string {
+	return rePlaceholder.ReplaceAllStringFunc(s, func(m string) string {
+		name := m[1 : len(m)-1]
+		v, ok := args[name]
+		if !ok {
+			return m
+		}
+		delete(args, name)
+		if esc != nil {
+			return esc(fmt.Sprint(v))
+		}
+		return fmt.Sprint(v)
+	})
+}
+
+func (d *Definition) validate() error {
+	if d == nil || d.Name == "" {
+		return fmt.Errorf("tool definition without name")
+	}
+	if (d.HTTP == nil) == (len(d.Command) == 0) {
+		return fmt.Errorf("tool [%s] must define exactly one of http and command", d.Name)
+	}
+	if d.HTTP != nil && d.HTTP.URL == "" {
+		return fmt.Errorf("tool [%s] has no http url", d.Name)
+	}
+	if d.Timeout != "" {
+		t, err := time.ParseDuration(d.Timeout)
+		if err != nil {
+			return fmt.Errorf("tool [%s] timeout: %w", d.Name, err)
+		}
+		d.timeout = t
+	}
+	return nil
+}
+
+func isDefinitionFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// readDefinitions reads a single definition or a list of definitions from a file.
+func readDefinitions(name string) ([]*Definition, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	unmarshal := yaml.Unmarshal
+	if strings.ToLower(filepath.Ext(name)) == ".json" {
+		unmarshal = json.Unmarshal
+	}
+	defs := make([]*Definition, 0)
+	if err := unmarshal(b, &defs); err == nil {
+		return defs, nil
+	}
+	d := &Definition{}
+	if err := unmarshal(b, d); err != nil {
+		return nil, err
+	}
+	return []*Definition{d}, nil
+}
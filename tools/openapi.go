@@ -0,0 +1,402 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/xyzj/llm/provider"
+
+	"github.com/xyzj/toolbox/json"
+	"gopkg.in/yaml.v3"
+)
+
+// maxRefDepth limits the nesting of resolved $ref schemas, so recursive schemas terminate.
+const maxRefDepth = 3
+
+// bodyArg is the tool argument holding the JSON request body of an operation.
+const bodyArg = "body"
+
+var (
+	reToolName = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+	methods    = []string{"get", "post", "put", "patch", "delete", "head", "options"}
+)
+
+type (
+	// OpenAPIOpt contains configuration options for the OpenAPI tool provider.
+	OpenAPIOpt struct {
+		operations []string          // Operation ids exposed as tools, all operations if empty
+		baseURL    string            // Server URL overriding the servers of the spec
+		headers    map[string]string // Headers sent with every request, e.g. for authentication
+		query      url.Values        // Query parameters sent with every request, e.g. API keys
+		client     *http.Client      // HTTP client executing the requests
+	}
+	// OpenAPIOpts is a function type for configuring OpenAPIOpt.
+	OpenAPIOpts func(opt *OpenAPIOpt)
+)
+
+// WithOperations selects the operations exposed as tools by their operationId.
+// By default all operations of the spec are exposed.
+func WithOperations(ids ...string) OpenAPIOpts {
+	return func(opt *OpenAPIOpt) {
+		opt.operations = append(opt.operations, ids...)
+	}
+}
+
+// WithBaseURL sets the server URL requests are sent to, overriding the servers of the spec.
+func WithBaseURL(u string) OpenAPIOpts {
+	return func(opt *OpenAPIOpt) {
+		opt.baseURL = u
+	}
+}
+
+// WithHeader adds a header sent with every request, e.g. WithHeader("X-API-Key", key).
+func WithHeader(key, value string) OpenAPIOpts {
+	return func(opt *OpenAPIOpt) {
+		opt.headers[key] = value
+	}
+}
+
+// WithBearerToken authenticates every request with the given bearer token.
+func WithBearerToken(token string) OpenAPIOpts {
+	return WithHeader("Authorization", "Bearer "+token)
+}
+
+// WithQueryParam adds a query parameter sent with every request, e.g. for APIs expecting
+// the API key in the query string.
+func WithQueryParam(key, value string) OpenAPIOpts {
+	return func(opt *OpenAPIOpt) {
+		opt.query.Add(key, value)
+	}
+}
+
+// WithHTTPClient sets the HTTP client executing the requests, e.g. to configure TLS or proxies.
+func WithHTTPClient(c *http.Client) OpenAPIOpts {
+	return func(opt *OpenAPIOpt) {
+		if c != nil {
+			opt.client = c
+		}
+	}
+}
+
+// OpenAPI is a Provider exposing operations of an OpenAPI 3 spec as tools.
+// Path, query and header parameters of an operation become tool arguments of the same
+// name, and a JSON request body becomes the "body" argument. The argument schemas are
+// taken from the spec with local $ref references resolved.
+type OpenAPI struct {
+	cnf   *OpenAPIOpt           // Configuration options
+	base  string                // Server URL requests are sent to
+	ops   map[string]*operation // Exposed operations keyed by tool name
+	tools []*provider.Tool      // Tool definitions in the format expected by AI models
+}
+
+// operation describes how to execute a tool call of an OpenAPI operation.
+type operation struct {
+	method string            // HTTP method
+	path   string            // Path template, e.g. /pets/{petId}
+	params map[string]string // Parameter name to location (path, query or header)
+}
+
+// LoadOpenAPI reads an OpenAPI 3 spec in JSON or YAML format from a file or an http(s) URL
+// and creates a Provider for its operations.
+//
+// Parameters:
+//   - spec: File path or URL of the spec
+//   - opts: Configuration options, e.g. WithOperations or WithBearerToken
+//
+// Returns:
+//   - *OpenAPI: Provider exposing the selected operations as tools
+//   - error: Any error encountered while reading or parsing the spec
+func LoadOpenAPI(spec string, opts ...OpenAPIOpts) (*OpenAPI, error) {
+	var b []byte
+	var err error
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		var resp *http.Response
+		resp, err = http.Get(spec)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetch openapi spec [%s]: http status %d", spec, resp.StatusCode)
+		}
+		b, err = io.ReadAll(resp.Body)
+	} else {
+		b, err = os.ReadFile(spec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewOpenAPI(b, opts...)
+}
+
+// NewOpenAPI creates a Provider for the operations of an OpenAPI 3 spec.
+// Operations without operationId are named after their method and path.
+//
+// Parameters:
+//   - spec: OpenAPI 3 spec in JSON or YAML format
+//   - opts: Configuration options, e.g. WithOperations or WithBearerToken
+//
+// Returns:
+//   - *OpenAPI: Provider exposing the selected operations as tools
+//   - error: If the spec cannot be parsed, has no server URL or a selected operation is missing
+func NewOpenAPI(spec []byte, opts ...OpenAPIOpts) (*OpenAPI, error) {
+	opt := &OpenAPIOpt{
+		headers: make(map[string]string),
+		query:   url.Values{},
+		client:  &http.Client{},
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	doc := make(map[string]any)
+	if err := yaml.Unmarshal(spec, &doc); err != nil {
+		return nil, err
+	}
+	o := &OpenAPI{
+		cnf:  opt,
+		base: opt.baseURL,
+		ops:  make(map[string]*operation),
+	}
+	if o.base == "" {
+		for _, s := range asSlice(doc["servers"]) {
+			if u, ok := asMap(s)["url"].(string); ok {
+				o.base = u
+				break
+			}
+		}
+	}
+	if o.base == "" {
+		return nil, fmt.Errorf("openapi spec defines no server url, use WithBaseURL")
+	}
+	o.base = strings.TrimSuffix(o.base, "/")
+	paths := asMap(doc["paths"])
+	keys := make([]string, 0, len(paths))
+	for k := range paths {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, path := range keys {
+		item := asMap(resolve(doc, paths[path], 0))
+		for _, method := range methods {
+			op := asMap(item[method])
+			if op == nil {
+				continue
+			}
+			name, _ := op["operationId"].(string)
+			if name == "" {
+				name = method + path
+			}
+			if len(opt.operations) > 0 && !slices.Contains(opt.operations, name) {
+				continue
+			}
+			o.addOperation(doc, toolName(name), method, path, item, op)
+		}
+	}
+	for _, id := range opt.operations {
+		if _, ok := o.ops[toolName(id)]; !ok {
+			return nil, fmt.Errorf("openapi operation [%s] not found", id)
+		}
+	}
+	return o, nil
+}
+
+// addOperation registers an operation as a tool, building its argument schema from the
+// path and operation level parameters and the JSON request body.
+func (o *OpenAPI) addOperation(doc map[string]any, name, method, path string, item, op map[string]any) {
+	oper := &operation{
+		method: strings.ToUpper(method),
+		path:   path,
+		params: make(map[string]string),
+	}
+	props := make(map[string]any)
+	required := make([]string, 0)
+	for _, p := range append(asSlice(item["parameters"]), asSlice(op["parameters"])...) {
+		pm := asMap(resolve(doc, p, 0))
+		pname, _ := pm["name"].(string)
+		in, _ := pm["in"].(string)
+		if pname == "" || (in != "path" && in != "query" && in != "header") {
+			continue
+		}
+		schema := asMap(resolve(doc, pm["schema"], 0))
+		if schema == nil {
+			schema = map[string]any{"type": "string"}
+		}
+		if d, ok := pm["description"].(string); ok {
+			schema["description"] = d
+		}
+		props[pname] = schema
+		oper.params[pname] = in
+		if req, _ := pm["required"].(bool); req || in == "path" {
+			required = append(required, pname)
+		}
+	}
+	if rb := asMap(resolve(doc, op["requestBody"], 0)); rb != nil {
+		if js := asMap(asMap(rb["content"])["application/json"]); js != nil {
+			schema := asMap(resolve(doc, js["schema"], 0))
+			if schema == nil {
+				schema = map[string]any{"type": "object"}
+			}
+			props[bodyArg] = schema
+			if req, _ := rb["required"].(bool); req {
+				required = append(required, bodyArg)
+			}
+		}
+	}
+	params := map[string]any{
+		"type":       "object",
+		"properties": props,
+	}
+	if len(required) > 0 {
+		params["required"] = required
+	}
+	desc, _ := op["summary"].(string)
+	if d, ok := op["description"].(string); ok && d != "" {
+		if desc != "" {
+			desc += "\n"
+		}
+		desc += d
+	}
+	o.ops[name] = oper
+	o.tools = append(o.tools, &provider.Tool{
+		Type: provider.ToolTypeFunction,
+		Function: &provider.FunctionDefinition{
+			Name:        name,
+			Description: desc,
+			Parameters:  params,
+		},
+	})
+}
+
+// Tools returns the tools of the exposed operations.
+func (o *OpenAPI) Tools() []*provider.Tool {
+	return o.tools
+}
+
+// Call executes a tool call as a request to the matching operation, sending the
+// configured authentication headers and query parameters.
+func (o *OpenAPI) Call(ctx context.Context, tc *provider.ToolCall) (*provider.Message, error) {
+	op, ok := o.ops[tc.Function.Name]
+	if !ok {
+		return nil, fmt.Errorf("%w [%s]", provider.ErrToolNotFound, tc.Function.Name)
+	}
+	args := make(map[string]any)
+	if tc.Function.Arguments != "" {
+		if err := json.UnmarshalFromString(tc.Function.Arguments, &args); err != nil {
+			return nil, err
+		}
+	}
+	path := op.path
+	query := url.Values{}
+	for k, v := range o.cnf.query {
+		query[k] = v
+	}
+	header := http.Header{}
+	for name, in := range op.params {
+		v, ok := args[name]
+		if !ok {
+			continue
+		}
+		switch in {
+		case "path":
+			path = strings.ReplaceAll(path, "{"+name+"}", url.PathEscape(fmt.Sprint(v)))
+		case "query":
+			if vs, ok := v.([]any); ok {
+				for _, x := range vs {
+					query.Add(name, fmt.Sprint(x))
+				}
+			} else {
+				query.Set(name, fmt.Sprint(v))
+			}
+		case "header":
+			header.Set(name, fmt.Sprint(v))
+		}
+	}
+	u := o.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var body io.Reader
+	if v, ok := args[bodyArg]; ok {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, op.method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range o.cnf.headers {
+		req.Header.Set(k, v)
+	}
+	out, err := doRequest(o.cnf.client, req)
+	if err != nil {
+		return nil, err
+	}
+	return Result(tc, out), nil
+}
+
+// resolve returns a copy of v with local $ref references ("#/components/...") replaced
+// by the referenced objects of doc. References nested deeper than maxRefDepth are
+// replaced by an empty object schema.
+func resolve(doc map[string]any, v any, depth int) any {
+	switch x := v.(type) {
+	case map[string]any:
+		if ref, ok := x["$ref"].(string); ok {
+			if depth >= maxRefDepth || !strings.HasPrefix(ref, "#/") {
+				return map[string]any{"type": "object"}
+			}
+			var target any = doc
+			for _, part := range strings.Split(ref[2:], "/") {
+				part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+				target = asMap(target)[part]
+			}
+			return resolve(doc, target, depth+1)
+		}
+		m := make(map[string]any, len(x))
+		for k, val := range x {
+			m[k] = resolve(doc, val, depth)
+		}
+		return m
+	case []any:
+		s := make([]any, len(x))
+		for i, val := range x {
+			s[i] = resolve(doc, val, depth)
+		}
+		return s
+	}
+	return v
+}
+
+// toolName converts an operation id into a valid tool name.
+func toolName(id string) string {
+	name := strings.Trim(reToolName.ReplaceAllString(id, "_"), "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+func asMap(v any) map[string]any {
+	m, _ := v.(map[string]any)
+	return m
+}
+
+func asSlice(v any) []any {
+	s, _ := v.([]any)
+	return s
+}
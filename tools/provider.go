@@ -0,0 +1,49 @@
+// Package tools provides local tool providers, which expose tools to AI models and
+// execute their calls in-process instead of routing them through an MCP server.
+package tools
+
+import (
+	"context"
+
+	"github.com/xyzj/llm/provider"
+
+	"github.com/volcengine/volcengine-go-sdk/volcengine"
+)
+
+// Provider supplies tools to the AI model and executes the calls made to them.
+// Implementations must be safe for concurrent use, since tool calls of one response
+// are executed in parallel.
+type Provider interface {
+	// Tools returns the tool definitions offered to the model.
+	Tools() []*provider.Tool
+
+	// Call executes a tool call and returns its result as a tool message.
+	//
+	// Parameters:
+	//   - ctx: Context bounding the execution time of the call
+	//   - tc: Tool call containing function name, arguments, and call ID
+	//
+	// Returns:
+	//   - *provider.Message: Tool result message
+	//   - error: Any error encountered while executing the call
+	Call(ctx context.Context, tc *provider.ToolCall) (*provider.Message, error)
+}
+
+// Has reports whether the provider offers a tool with the given name.
+func Has(p Provider, name string) bool {
+	for _, t := range p.Tools() {
+		if t.Function != nil && t.Function.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Result formats s as the tool message answering the tool call tc.
+func Result(tc *provider.ToolCall, s string) *provider.Message {
+	return &provider.Message{
+		Role:       provider.RoleTool,
+		Content:    &provider.MessageContent{StringValue: volcengine.String(s)},
+		ToolCallID: tc.ID,
+	}
+}
@@ -0,0 +1,122 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/xyzj/llm/provider"
+)
+
+// Func implements a tool registered with Registry.Register.
+//
+// Parameters:
+//   - ctx: Context bounding the execution time of the call
+//   - args: Arguments of the call as JSON object, as sent by the model
+//
+// Returns:
+//   - string: Result of the call sent to the model
+//   - error: Any error executing the call, sent to the model as the result
+type Func func(ctx context.Context, args string) (string, error)
+
+// Registry is a Provider for in-process Go functions registered as tools. Pass it to
+// llm.WithToolProviders to offer its tools next to the MCP tools; tools can be registered
+// and unregistered at any time, later turns see the change.
+//
+// Example:
+//
+//	reg := tools.NewRegistry()
+//	reg.Register("get_time", "Get the current time", nil, func(ctx context.Context, args string) (string, error) {
+//		return time.Now().Format(time.RFC3339), nil
+//	})
+//	cm := llm.NewChatsManager(llm.WithToolProviders(reg))
+type Registry struct {
+	locker sync.RWMutex
+	funcs  map[string]Func  // Implementations keyed by tool name
+	tools  []*provider.Tool // Tool definitions in registration order
+}
+
+// NewRegistry creates an empty tool registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		funcs: make(map[string]Func),
+		tools: make([]*provider.Tool, 0),
+	}
+}
+
+// Register adds a tool implemented by f. The JSON schema of its arguments can be written
+// by hand or generated from a struct with Schema, e.g. Schema(SearchArgs{}).
+//
+// Parameters:
+//   - name: Tool name exposed to the model, unique within the registry
+//   - description: Tool description exposed to the model
+//   - schema: JSON schema of the tool arguments, nil for a tool without arguments
+//   - f: Implementation of the tool
+//
+// Returns:
+//   - error: If name is empty or already registered, or f is nil
+func (r *Registry) Register(name, description string, schema map[string]any, f Func) error {
+	if name == "" {
+		return errors.New("tool name is empty")
+	}
+	if f == nil {
+		return fmt.Errorf("tool [%s] has no implementation", name)
+	}
+	if schema == nil {
+		schema = map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if _, ok := r.funcs[name]; ok {
+		return fmt.Errorf("duplicate tool definition [%s]", name)
+	}
+	r.funcs[name] = f
+	r.tools = append(r.tools, &provider.Tool{
+		Type: provider.ToolTypeFunction,
+		Function: &provider.FunctionDefinition{
+			Name:        name,
+			Description: description,
+			Parameters:  schema,
+		},
+	})
+	return nil
+}
+
+// Unregister removes a tool, unknown names are ignored.
+func (r *Registry) Unregister(name string) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	delete(r.funcs, name)
+	// a new slice, slices returned by Tools are not modified
+	r.tools = slices.DeleteFunc(slices.Clone(r.tools), func(t *provider.Tool) bool {
+		return t.Function.Name == name
+	})
+}
+
+// Tools returns the definitions of the registered tools.
+func (r *Registry) Tools() []*provider.Tool {
+	r.locker.RLock()
+	defer r.locker.RUnlock()
+	return r.tools
+}
+
+// Call executes a tool call with the registered function.
+func (r *Registry) Call(ctx context.Context, tc *provider.ToolCall) (*provider.Message, error) {
+	r.locker.RLock()
+	f, ok := r.funcs[tc.Function.Name]
+	r.locker.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w [%s]", provider.ErrToolNotFound, tc.Function.Name)
+	}
+	args := tc.Function.Arguments
+	if args == "" {
+		args = "{}"
+	}
+	s, err := f(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return Result(tc, s), nil
+}
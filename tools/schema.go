@@ -0,0 +1,120 @@
+package tools
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schema generates the JSON schema of the tool arguments decoded into v, a struct or a
+// pointer to a struct, for Registry.Register. Registry.BindFunc uses it for the argument
+// structs of functions.
+//
+// Fields are named by their json tag and skipped with json:"-" or if unexported. Fields
+// are required unless their json tag has the omitempty or omitzero option. The tags
+// description and enum add the description and the comma-separated allowed values of a
+// field. Nested structs, slices and maps are described recursively, time.Time as string
+// in RFC 3339 format.
+//
+// Example:
+//
+//	type SearchArgs struct {
+//		Query string `json:"query" description:"Search terms"`
+//		Sort  string `json:"sort,omitempty" enum:"relevance,date"`
+//		Limit int    `json:"limit,omitempty" description:"Maximum number of results"`
+//	}
+//	reg.Register("search", "Search the knowledge base", tools.Schema(SearchArgs{}), search)
+func Schema(v any) map[string]any {
+	return typeSchema(reflect.TypeOf(v), make(map[reflect.Type]bool))
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// typeSchema returns the JSON schema of values of type t. Structs in seen are being
+// described already, references to them are described as plain objects.
+func typeSchema(t reflect.Type, seen map[reflect.Type]bool) map[string]any {
+	if t == nil {
+		return map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte is encoded as base64 string
+			return map[string]any{"type": "string"}
+		}
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return map[string]any{"type": "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		props := make(map[string]any)
+		required := make([]string, 0)
+		structFields(t, props, &required, seen)
+		s := map[string]any{"type": "object", "properties": props}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	// interfaces accept any value
+	return map[string]any{}
+}
+
+// structFields adds the schemas of the fields of struct type t to props, flattening
+// embedded structs like encoding/json, and the names of the required fields to required.
+func structFields(t reflect.Type, props map[string]any, required *[]string, seen map[reflect.Type]bool) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				structFields(ft, props, required, seen)
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s := typeSchema(f.Type, seen)
+		if d := f.Tag.Get("description"); d != "" {
+			s["description"] = d
+		}
+		if e := f.Tag.Get("enum"); e != "" {
+			s["enum"] = strings.Split(e, ",")
+		}
+		props[name] = s
+		if o := "," + opts + ","; !strings.Contains(o, ",omitempty,") && !strings.Contains(o, ",omitzero,") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/xyzj/llm/chat"
+	"github.com/xyzj/llm/provider"
+
+	"github.com/xyzj/toolbox/json"
+)
+
+type (
+	// RunTrace records the model requests and tool calls of one ChatsManager.Chat turn,
+	// for debugging multi-step tool use.
+	RunTrace struct {
+		ChatID   ChatID        `json:"chat_id"`         // Internal key of the chat
+		Message  string        `json:"message"`         // User message that started the turn
+		Start    time.Time     `json:"start"`           // Start time of the turn
+		Duration time.Duration `json:"duration"`        // Duration of the complete turn
+		Rounds   []*TraceRound `json:"rounds"`          // Model requests in the order they were sent
+		Error    string        `json:"error,omitempty"` // Error that ended the turn, if any
+		err      error         // Error that ended the turn, see Err
+	}
+	// TraceRound is a single model request and the tool calls it returned.
+	TraceRound struct {
+		Model            string                `json:"model"`                   // Requested model
+		Messages         int                   `json:"messages"`                // Messages in the history after the request
+		Tools            int                   `json:"tools"`                   // Tools offered to the model
+		Duration         time.Duration         `json:"duration"`                // Duration of the request
+		FinishReason     provider.FinishReason `json:"finish_reason,omitempty"` // Reason the model stopped
+		PromptTokens     int                   `json:"prompt_tokens"`           // Prompt tokens reported by the provider
+		CachedTokens     int                   `json:"cached_tokens,omitempty"` // Prompt tokens served from the provider's prompt cache
+		CompletionTokens int                   `json:"completion_tokens"`       // Completion tokens reported by the provider
+		Content          string                `json:"content,omitempty"`       // Assistant text of the response
+		ToolCalls        []*TraceToolCall      `json:"tool_calls,omitempty"`    // Tool calls returned by the model
+	}
+	// TraceToolCall is a single executed tool call.
+	TraceToolCall struct {
+		ID        string        `json:"id"`               // Tool call id
+		Name      string        `json:"name"`             // Tool name
+		Arguments string        `json:"arguments"`        // Arguments as sent by the model
+		Result    string        `json:"result,omitempty"` // Tool result sent back to the model
+		Error     string        `json:"error,omitempty"`  // Error of the call, if it failed
+		Duration  time.Duration `json:"duration"`         // Duration of the call
+		err       error         // Error of the call, see RunTrace.Err
+	}
+)
+
+// JSON returns the JSON encoding of the trace.
+func (t *RunTrace) JSON() []byte {
+	b, _ := json.Marshal(t)
+	return b
+}
+
+// fail records err as the error that ended the turn.
+func (t *RunTrace) fail(err error) {
+	t.Error, t.err = err.Error(), err
+}
+
+// Reply returns the assistant text of the last model response of the turn, or an empty
+// string if the model gave none, e.g. because the turn failed.
+func (t *RunTrace) Reply() string {
+	if len(t.Rounds) == 0 {
+		return ""
+	}
+	return t.Rounds[len(t.Rounds)-1].Content
+}
+
+// Err returns the error of the turn as a *TurnError: the error that ended it, or else the
+// errors of its failed tool calls. It returns nil for successful turns and for traces
+// restored from JSON, which do not carry the errors.
+func (t *RunTrace) Err() error {
+	if t.err != nil {
+		kind := ErrRequestFailed
+		switch {
+		case errors.Is(t.err, ErrTurnCanceled):
+			kind = ErrTurnCanceled
+		case errors.Is(t.err, context.DeadlineExceeded):
+			kind = ErrTurnTimeout
+		}
+		return &TurnError{ChatID: t.ChatID, Kind: kind, Err: t.err}
+	}
+	var tools []string
+	var errs []error
+	for _, r := range t.Rounds {
+		for _, c := range r.ToolCalls {
+			if c != nil && c.err != nil {
+				tools = append(tools, c.Name)
+				errs = append(errs, c.err)
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &TurnError{ChatID: t.ChatID, Kind: ErrToolCallFailed, Tools: tools, Err: errors.Join(errs...)}
+}
+
+// addRound records a completed model request of ch.
+func (t *RunTrace) addRound(ch *chat.Chat, res *chat.Result, tools int, start time.Time) *TraceRound {
+	r := &TraceRound{
+		Model:        ch.Model(),
+		Messages:     len(ch.History()),
+		Tools:        tools,
+		Duration:     time.Since(start),
+		FinishReason: res.FinishReason,
+		Content:      res.Content(),
+	}
+	if res.Usage != nil {
+		r.PromptTokens = res.Usage.PromptTokens
+		r.CompletionTokens = res.Usage.CompletionTokens
+		r.CachedTokens = res.Usage.PromptTokensDetails.CachedTokens
+	}
+	t.Rounds = append(t.Rounds, r)
+	return r
+}
+
+// traceToolCall records the execution of tc.
+func traceToolCall(tc *provider.ToolCall, msg *provider.Message, err error, start time.Time) *TraceToolCall {
+	c := &TraceToolCall{
+		ID:        tc.ID,
+		Name:      tc.Function.Name,
+		Arguments: tc.Function.Arguments,
+		Duration:  time.Since(start),
+	}
+	if err != nil {
+		c.Error, c.err = err.Error(), err
+	} else if msg != nil && msg.Content != nil && msg.Content.StringValue != nil {
+		c.Result = *msg.Content.StringValue
+	}
+	return c
+}
+
+// LastTrace returns the trace of the most recent turn of a chat session, see RunTrace.
+// Traces are kept in memory while the session is active.
+//
+// Parameters:
+//   - id: Identifier of the chat session
+//
+// Returns:
+//   - *RunTrace: The trace of the last turn
+//   - bool: false if the session has no completed turn
+func (cm *ChatsManager) LastTrace(id string) (*RunTrace, bool) {
+	return cm.traces.Load(id)
+}
@@ -0,0 +1,57 @@
+package llm
+
+import (
+	"context"
+
+	"github.com/xyzj/llm/chat"
+	"github.com/xyzj/llm/provider"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans of the manager.
+const tracerName = "github.com/xyzj/llm"
+
+// endTurn records the summary of the turn of ch traced by rt on its "llm.turn" span and
+// ends the span. The span is started before the session is loaded, so storage loads are
+// part of it.
+func endTurn(span trace.Span, ch *chat.Chat, rt *RunTrace) {
+	prompt, completion, calls := 0, 0, 0
+	for _, r := range rt.Rounds {
+		prompt += r.PromptTokens
+		completion += r.CompletionTokens
+		calls += len(r.ToolCalls)
+	}
+	span.SetAttributes(
+		attribute.String("llm.chat.id", ch.ID()),
+		attribute.String("gen_ai.request.model", ch.Model()),
+		attribute.Int("llm.turn.rounds", len(rt.Rounds)),
+		attribute.Int("llm.turn.tool_calls", calls),
+		attribute.Int("gen_ai.usage.input_tokens", prompt),
+		attribute.Int("gen_ai.usage.output_tokens", completion),
+	)
+	if rt.Error != "" {
+		span.SetStatus(codes.Error, rt.Error)
+	}
+	span.End()
+}
+
+// startTool starts the span of the execution of tc.
+func (cm *ChatsManager) startTool(ctx context.Context, tc *provider.ToolCall) (context.Context, trace.Span) {
+	return cm.tracer.Start(ctx, "execute_tool "+tc.Function.Name, trace.WithAttributes(
+		attribute.String("gen_ai.operation.name", "execute_tool"),
+		attribute.String("gen_ai.tool.name", tc.Function.Name),
+		attribute.String("gen_ai.tool.call.id", tc.ID),
+	))
+}
+
+// endTool records err on the span of a tool execution and ends it.
+func endTool(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,61 @@
+package transform
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// stream runs the chunks through a fresh stage of t and returns the emitted pieces.
+func stream(t Transformer, chunks ...string) []string {
+	st := t()
+	out := make([]string, 0, len(chunks)+1)
+	for _, c := range chunks {
+		out = append(out, st.Write(c))
+	}
+	return append(out, st.Flush())
+}
+
+func TestGuardRedactsAcrossChunks(t *testing.T) {
+	g := Guard(16, Redact(regexp.MustCompile(`sk-[a-z0-9]{8}`), "[key]"))
+	got := strings.Join(stream(g, "your key is sk-", "abcd", "1234 keep it safe"), "")
+	if want := "your key is [key] keep it safe"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestGuardBlocks(t *testing.T) {
+	g := Guard(0, Block(regexp.MustCompile(`(?i)forbidden`), "[removed]"))
+	got := stream(g, "this is fine, this is forbi", "dden and more", " text")
+	if joined := strings.Join(got, ""); joined != "this is fine, this is [removed]" {
+		t.Errorf("output = %q", joined)
+	}
+	if got[2] != "" || got[3] != "" {
+		t.Errorf("output after the block = %q, %q, want none", got[2], got[3])
+	}
+}
+
+func TestGuardFirstRuleWins(t *testing.T) {
+	g := Guard(0,
+		Redact(regexp.MustCompile(`alice@example\.com`), "[email]"),
+		Redact(regexp.MustCompile(`example\.com`), "[domain]"),
+	)
+	if got := Apply("mail alice@example.com or visit example.com", g); got != "mail [email] or visit [domain]" {
+		t.Errorf("output = %q", got)
+	}
+}
+
+func TestGuardKeepsRunes(t *testing.T) {
+	g := Guard(4, Redact(regexp.MustCompile(`密码`), "**"))
+	var got strings.Builder
+	for _, piece := range stream(g, "你好，", "我的密", "码是一二三") {
+		if !utf8.ValidString(piece) {
+			t.Errorf("piece %q splits a rune", piece)
+		}
+		got.WriteString(piece)
+	}
+	if got.String() != "你好，我的**是一二三" {
+		t.Errorf("output = %q", got.String())
+	}
+}
@@ -0,0 +1,461 @@
+// Package transform provides post-processing of assistant output.
+// Transformers are applied in order to every streamed delta and to non-streamed
+// responses, and the transformed text is what gets stored in the chat history,
+// so clients and persisted conversations always see the same content.
+package transform
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+type (
+	// Stage processes the output of one assistant response.
+	// Stages may buffer text internally, e.g. to match patterns that span
+	// several streamed chunks, and must return any held back text from Flush.
+	Stage interface {
+		// Write consumes a chunk of output and returns the text ready to be emitted.
+		Write(chunk string) string
+		// Flush returns the remaining buffered text at the end of the response.
+		Flush() string
+	}
+	// Transformer creates a new Stage for every assistant response,
+	// so stages can keep per-response state.
+	Transformer func() Stage
+)
+
+// Chain creates a Stage running a fresh stage of each transformer in order,
+// feeding the output of one stage into the next.
+// It returns nil if no transformers are given.
+func Chain(ts ...Transformer) Stage {
+	if len(ts) == 0 {
+		return nil
+	}
+	c := &chain{stages: make([]Stage, 0, len(ts))}
+	for _, t := range ts {
+		if t != nil {
+			c.stages = append(c.stages, t())
+		}
+	}
+	return c
+}
+
+// Apply runs the complete text s through a fresh chain of the given transformers.
+func Apply(s string, ts ...Transformer) string {
+	st := Chain(ts...)
+	if st == nil {
+		return s
+	}
+	return st.Write(s) + st.Flush()
+}
+
+// chain is a Stage that pipes text through several stages.
+type chain struct {
+	stages []Stage
+}
+
+func (c *chain) Write(chunk string) string {
+	for _, st := range c.stages {
+		chunk = st.Write(chunk)
+	}
+	return chunk
+}
+
+func (c *chain) Flush() string {
+	var out string
+	for _, st := range c.stages {
+		// text flushed by earlier stages still has to pass the later ones
+		out = st.Write(out) + st.Flush()
+	}
+	return out
+}
+
+// StripThink removes chain-of-thought sections enclosed in <think></think> tags.
+func StripThink() Transformer {
+	return StripTags("<think>", "</think>")
+}
+
+// StripTags removes every section enclosed by the open and close markers, including
+// the markers themselves. An unterminated section is dropped at the end of the response.
+func StripTags(open, close string) Transformer {
+	return func() Stage {
+		return &stripTags{open: open, close: close}
+	}
+}
+
+type stripTags struct {
+	open, close string
+	buf         string
+	inside      bool
+}
+
+func (s *stripTags) Write(chunk string) string {
+	s.buf += chunk
+	var out strings.Builder
+	for {
+		if !s.inside {
+			if idx := strings.Index(s.buf, s.open); idx >= 0 {
+				out.WriteString(s.buf[:idx])
+				s.buf = s.buf[idx+len(s.open):]
+				s.inside = true
+				continue
+			}
+			k := partialSuffix(s.buf, s.open)
+			out.WriteString(s.buf[:len(s.buf)-k])
+			s.buf = s.buf[len(s.buf)-k:]
+			return out.String()
+		}
+		if idx := strings.Index(s.buf, s.close); idx >= 0 {
+			s.buf = strings.TrimLeft(s.buf[idx+len(s.close):], "\r\n")
+			s.inside = false
+			continue
+		}
+		s.buf = s.buf[len(s.buf)-partialSuffix(s.buf, s.close):]
+		return out.String()
+	}
+}
+
+func (s *stripTags) Flush() string {
+	out := s.buf
+	if s.inside {
+		out = ""
+	}
+	s.buf, s.inside = "", false
+	return out
+}
+
+// NormalizeMarkdown cleans up markdown line by line: line endings are converted to "\n",
+// trailing spaces are removed (hard line breaks of two spaces are kept), runs of blank
+// lines are collapsed into one and an unterminated code fence is closed at the end.
+func NormalizeMarkdown() Transformer {
+	return func() Stage {
+		return &markdown{}
+	}
+}
+
+type markdown struct {
+	buf    string
+	blank  bool
+	inCode bool
+}
+
+func (m *markdown) Write(chunk string) string {
+	m.buf += strings.ReplaceAll(chunk, "\r\n", "\n")
+	idx := strings.LastIndexByte(m.buf, '\n')
+	if idx < 0 {
+		return ""
+	}
+	lines := strings.Split(m.buf[:idx], "\n")
+	m.buf = m.buf[idx+1:]
+	var out strings.Builder
+	for _, line := range lines {
+		if l, ok := m.line(line); ok {
+			out.WriteString(l)
+			out.WriteByte('\n')
+		}
+	}
+	return out.String()
+}
+
+func (m *markdown) Flush() string {
+	var out string
+	if m.buf != "" {
+		out, _ = m.line(m.buf)
+	}
+	if m.inCode {
+		out += "\n```"
+	}
+	m.buf, m.blank, m.inCode = "", false, false
+	return out
+}
+
+// line normalizes one line and reports whether it should be emitted.
+func (m *markdown) line(line string) (string, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	if strings.HasPrefix(strings.TrimSpace(line), "```") {
+		m.inCode = !m.inCode
+	}
+	if m.inCode {
+		m.blank = false
+		return line, true
+	}
+	trimmed := strings.TrimRight(line, " \t")
+	if strings.HasSuffix(line, "  ") && trimmed != "" {
+		trimmed += "  "
+	}
+	if trimmed == "" {
+		if m.blank {
+			return "", false
+		}
+		m.blank = true
+		return "", true
+	}
+	m.blank = false
+	return trimmed, true
+}
+
+// RewriteURLs replaces URL prefixes in the output, e.g. mapping internal hostnames
+// to their public counterparts. Keys are the prefixes to replace and values their
+// replacements; longer prefixes take precedence.
+func RewriteURLs(rules map[string]string) Transformer {
+	keys := make([]string, 0, len(rules))
+	for k := range rules {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b string) int { return len(b) - len(a) })
+	pairs := make([]string, 0, len(keys)*2)
+	for _, k := range keys {
+		pairs = append(pairs, k, rules[k])
+	}
+	r := strings.NewReplacer(pairs...)
+	return func() Stage {
+		return &rewriter{r: r}
+	}
+}
+
+// maxHold limits how much text a stage may hold back while waiting for a pattern to end.
+const maxHold = 2048
+
+type rewriter struct {
+	r   *strings.Replacer
+	buf string
+}
+
+func (w *rewriter) Write(chunk string) string {
+	w.buf += chunk
+	// URLs never contain whitespace, so everything up to the last whitespace is complete
+	idx := strings.LastIndexAny(w.buf, " \t\r\n")
+	if idx < 0 {
+		if len(w.buf) < maxHold {
+			return ""
+		}
+		idx = len(w.buf) - 1
+	}
+	out := w.buf[:idx+1]
+	w.buf = w.buf[idx+1:]
+	return w.r.Replace(out)
+}
+
+func (w *rewriter) Flush() string {
+	out := w.r.Replace(w.buf)
+	w.buf = ""
+	return out
+}
+
+var (
+	reTag  = regexp.MustCompile(`</?([a-zA-Z][a-zA-Z0-9]*)([^<>]*)>`)
+	reAttr = regexp.MustCompile(`([a-zA-Z-]+)\s*=\s*("[^"]*"|'[^']*'|[^\s>]+)`)
+)
+
+// safeAttrs lists the attributes kept on allowed HTML tags.
+var safeAttrs = []string{"href", "src", "alt", "title"}
+
+// AllowHTML removes every HTML tag whose name is not in the allow list while keeping
+// the enclosed text. Allowed tags are kept with only the href, src, alt and title
+// attributes, and attributes holding javascript: URLs are dropped.
+func AllowHTML(tags ...string) Transformer {
+	allowed := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		allowed[strings.ToLower(t)] = true
+	}
+	return func() Stage {
+		return &htmlFilter{allowed: allowed}
+	}
+}
+
+type htmlFilter struct {
+	allowed map[string]bool
+	buf     string
+}
+
+func (h *htmlFilter) Write(chunk string) string {
+	h.buf += chunk
+	out := h.buf
+	h.buf = ""
+	// hold back a trailing tag that is not complete yet
+	if idx := strings.LastIndexByte(out, '<'); idx >= 0 && !strings.Contains(out[idx:], ">") {
+		rest := out[idx+1:]
+		if len(rest) < maxHold && (rest == "" || rest[0] == '/' || isLetter(rest[0])) {
+			h.buf = out[idx:]
+			out = out[:idx]
+		}
+	}
+	return h.filter(out)
+}
+
+func (h *htmlFilter) Flush() string {
+	out := h.filter(h.buf)
+	h.buf = ""
+	return out
+}
+
+func (h *htmlFilter) filter(s string) string {
+	return reTag.ReplaceAllStringFunc(s, func(tag string) string {
+		m := reTag.FindStringSubmatch(tag)
+		name := strings.ToLower(m[1])
+		if !h.allowed[name] {
+			return ""
+		}
+		if strings.HasPrefix(tag, "</") {
+			return "</" + name + ">"
+		}
+		var b strings.Builder
+		b.WriteString("<" + name)
+		for _, a := range reAttr.FindAllStringSubmatch(m[2], -1) {
+			key := strings.ToLower(a[1])
+			val := strings.Trim(a[2], `"'`)
+			if !slices.Contains(safeAttrs, key) || strings.HasPrefix(strings.ToLower(strings.TrimSpace(val)), "javascript:") {
+				continue
+			}
+			b.WriteString(" " + key + `="` + strings.ReplaceAll(val, `"`, "&quot;") + `"`)
+		}
+		if strings.HasSuffix(strings.TrimSpace(m[2]), "/") {
+			b.WriteString(" /")
+		}
+		b.WriteString(">")
+		return b.String()
+	})
+}
+
+// partialSuffix returns the length of the longest suffix of s that is a proper prefix of pattern.
+func partialSuffix(s, pattern string) int {
+	for k := min(len(s), len(pattern)-1); k > 0; k-- {
+		if strings.HasSuffix(s, pattern[:k]) {
+			return k
+		}
+	}
+	return 0
+}
+
+func isLetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// Rule is a content policy checked by Guard. Text matching Pattern is either replaced
+// (see Redact) or ends the response (see Block).
+type Rule struct {
+	Pattern     *regexp.Regexp // Policy-violating content
+	Replacement string         // Text replacing the match, or the notice ending a blocked response
+	Block       bool           // Whether a match cuts off the rest of the response
+}
+
+// Redact creates a Rule replacing every match of re with replacement, e.g. to mask
+// credentials or personal data while the rest of the response is delivered.
+func Redact(re *regexp.Regexp, replacement string) Rule {
+	return Rule{Pattern: re, Replacement: replacement}
+}
+
+// Block creates a Rule cutting off the response at the first match of re: the text
+// before the match is delivered, followed by notice, and all remaining output is dropped.
+func Block(re *regexp.Regexp, notice string) Rule {
+	return Rule{Pattern: re, Replacement: notice, Block: true}
+}
+
+// defaultWindow is the window of Guard if none is given.
+const defaultWindow = 64
+
+// Guard applies content rules to the output while it is streamed, so policy-violating
+// content is redacted or cut off before it reaches the client instead of being detected
+// after the complete response was delivered. The last window bytes of the output are held
+// back and scanned together with the next chunk, so matches spanning several chunks are
+// found as long as they are no longer than window. A window of 0 or less uses 64 bytes.
+// When several rules match overlapping text, the rule listed first wins.
+func Guard(window int, rules ...Rule) Transformer {
+	if window <= 0 {
+		window = defaultWindow
+	}
+	return func() Stage {
+		return &guard{rules: rules, window: window}
+	}
+}
+
+type guard struct {
+	rules   []Rule
+	window  int
+	buf     string
+	blocked bool
+}
+
+// span is a rule match in the buffer of a guard.
+type span struct {
+	start, end int
+	rule       *Rule
+}
+
+func (g *guard) Write(chunk string) string {
+	if g.blocked {
+		return ""
+	}
+	g.buf += chunk
+	return g.scan(len(g.buf) - g.window)
+}
+
+func (g *guard) Flush() string {
+	out := ""
+	if !g.blocked {
+		out = g.scan(len(g.buf))
+	}
+	g.buf, g.blocked = "", false
+	return out
+}
+
+// scan applies the rules to the buffer and returns the text before cut, which is final.
+// Matches reaching past cut are held back with the rest of the buffer.
+func (g *guard) scan(cut int) string {
+	spans := g.matches()
+	for _, s := range spans {
+		if s.rule.Block {
+			out := g.redact(g.buf[:s.start], spans) + s.rule.Replacement
+			g.buf, g.blocked = "", true
+			return out
+		}
+	}
+	if cut <= 0 {
+		return ""
+	}
+	for _, s := range spans {
+		if s.start < cut && s.end > cut {
+			cut = s.start
+			break
+		}
+	}
+	for cut > 0 && cut < len(g.buf) && !utf8.RuneStart(g.buf[cut]) {
+		cut--
+	}
+	out := g.redact(g.buf[:cut], spans)
+	g.buf = g.buf[cut:]
+	return out
+}
+
+// matches returns the non-overlapping rule matches in the buffer, ordered by position.
+func (g *guard) matches() []span {
+	var spans []span
+	for i := range g.rules {
+		r := &g.rules[i]
+		for _, m := range r.Pattern.FindAllStringIndex(g.buf, -1) {
+			if m[1] > m[0] && !slices.ContainsFunc(spans, func(s span) bool { return m[0] < s.end && s.start < m[1] }) {
+				spans = append(spans, span{start: m[0], end: m[1], rule: r})
+			}
+		}
+	}
+	slices.SortFunc(spans, func(a, b span) int { return a.start - b.start })
+	return spans
+}
+
+// redact returns s, a prefix of the buffer, with the matches inside it replaced.
+func (g *guard) redact(s string, spans []span) string {
+	var out strings.Builder
+	last := 0
+	for _, sp := range spans {
+		if sp.end > len(s) {
+			break
+		}
+		out.WriteString(s[last:sp.start])
+		out.WriteString(sp.rule.Replacement)
+		last = sp.end
+	}
+	out.WriteString(s[last:])
+	return out.String()
+}
@@ -0,0 +1,136 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/xyzj/toolbox/json"
+)
+
+// formats maps the response formats of the OpenAI speech API to MIME types.
+var formats = map[string]string{
+	"mp3":  "audio/mpeg",
+	"opus": "audio/ogg",
+	"aac":  "audio/aac",
+	"flac": "audio/flac",
+	"wav":  "audio/wav",
+	"pcm":  "audio/pcm",
+}
+
+type (
+	// OpenAIOpt configures the OpenAI-compatible synthesizer.
+	OpenAIOpt struct {
+		client *http.Client // HTTP client sending the requests
+		model  string       // Speech model
+		voice  string       // Voice of the speech
+		format string       // Response format, see formats
+	}
+	// OpenAIOpts is a function type for configuring the OpenAI-compatible synthesizer.
+	OpenAIOpts func(opt *OpenAIOpt)
+)
+
+// WithModel sets the speech model. Defaults to "gpt-4o-mini-tts".
+func WithModel(m string) OpenAIOpts {
+	return func(opt *OpenAIOpt) {
+		if m != "" {
+			opt.model = m
+		}
+	}
+}
+
+// WithVoice sets the voice of the speech. Defaults to "alloy".
+func WithVoice(v string) OpenAIOpts {
+	return func(opt *OpenAIOpt) {
+		if v != "" {
+			opt.voice = v
+		}
+	}
+}
+
+// WithFormat sets the audio format: "mp3", "opus", "aac", "flac", "wav" or "pcm".
+// Defaults to "mp3".
+func WithFormat(f string) OpenAIOpts {
+	return func(opt *OpenAIOpt) {
+		if _, ok := formats[f]; ok {
+			opt.format = f
+		}
+	}
+}
+
+// WithHTTPClient sets the HTTP client of the synthesizer, e.g. to configure proxies or TLS.
+func WithHTTPClient(c *http.Client) OpenAIOpts {
+	return func(opt *OpenAIOpt) {
+		if c != nil {
+			opt.client = c
+		}
+	}
+}
+
+// NewOpenAI creates a Synthesizer for OpenAI-compatible speech endpoints
+// (POST /v1/audio/speech).
+//
+// Parameters:
+//   - baseURI: Base URI of the service, e.g. "https://api.openai.com"; "/v1" is appended unless present
+//   - apikey: API key sent as bearer token, empty to send none
+//   - opts: Optional configuration, e.g. WithVoice
+func NewOpenAI(baseURI, apikey string, opts ...OpenAIOpts) Synthesizer {
+	opt := &OpenAIOpt{
+		client: &http.Client{},
+		model:  "gpt-4o-mini-tts",
+		voice:  "alloy",
+		format: "mp3",
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	base := strings.TrimSuffix(baseURI, "/")
+	if !strings.HasSuffix(base, "/v1") {
+		base += "/v1"
+	}
+	return &openai{base: base, apikey: apikey, cnf: opt}
+}
+
+// openai is a Synthesizer speaking the OpenAI speech HTTP API.
+type openai struct {
+	base   string     // Base URI including the /v1 prefix
+	apikey string     // API key sent as bearer token
+	cnf    *OpenAIOpt // Configuration options
+}
+
+func (o *openai) Synthesize(ctx context.Context, text string) (*Audio, error) {
+	b, err := json.Marshal(map[string]string{
+		"model":           o.cnf.model,
+		"voice":           o.cnf.voice,
+		"input":           text,
+		"response_format": o.cnf.format,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, o.base+"/audio/speech", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if o.apikey != "" {
+		r.Header.Set("Authorization", "Bearer "+o.apikey)
+	}
+	resp, err := o.cnf.client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("speech request: http status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Audio{Data: data, Format: formats[o.cnf.format]}, nil
+}
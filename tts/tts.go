@@ -0,0 +1,142 @@
+// Package tts converts streamed assistant text to speech for voice interfaces. A Stream
+// collects the text deltas of a response, cuts them into sentences and synthesizes each
+// complete sentence with a pluggable Synthesizer, so playback can start while the model
+// is still generating.
+package tts
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type (
+	// Synthesizer converts text to audio, e.g. through a speech API.
+	// Implementations must be safe for concurrent use by several streams.
+	Synthesizer interface {
+		// Synthesize converts text, usually a single sentence, to audio.
+		//
+		// Parameters:
+		//   - ctx: Context bounding the synthesis
+		//   - text: Text to speak
+		//
+		// Returns:
+		//   - *Audio: The synthesized audio
+		//   - error: Any error during the synthesis
+		Synthesize(ctx context.Context, text string) (*Audio, error)
+	}
+	// Audio is a chunk of synthesized speech.
+	Audio struct {
+		Data   []byte // Encoded audio
+		Format string // MIME type of Data, e.g. "audio/mpeg"
+	}
+	// EmitFunc receives every synthesized sentence in order.
+	EmitFunc func(text string, audio *Audio) error
+
+	// StreamOpt configures a Stream.
+	StreamOpt struct {
+		minLength int // Minimum length of a synthesized chunk in runes
+	}
+	// StreamOpts is a function type for configuring a Stream.
+	StreamOpts func(opt *StreamOpt)
+)
+
+// WithMinLength merges sentences shorter than n runes with the following text, so very
+// short sentences like "Sure." are not synthesized as a chunk of their own, which sounds
+// choppy and costs a request each. Defaults to 20.
+func WithMinLength(n int) StreamOpts {
+	return func(opt *StreamOpt) {
+		if n >= 0 {
+			opt.minLength = n
+		}
+	}
+}
+
+// Stream synthesizes streamed text sentence by sentence. It is not safe for concurrent
+// use, its methods are meant to be called from the stream of a single response.
+type Stream struct {
+	ctx  context.Context
+	syn  Synthesizer
+	emit EmitFunc
+	cnf  *StreamOpt
+	buf  strings.Builder // Text not synthesized yet
+}
+
+// NewStream creates a Stream synthesizing text with syn and passing the audio to emit.
+//
+// Parameters:
+//   - ctx: Context bounding the syntheses of the stream
+//   - syn: Synthesizer converting the sentences
+//   - emit: Receives every synthesized sentence with its audio
+//   - opts: Optional configuration, e.g. WithMinLength
+func NewStream(ctx context.Context, syn Synthesizer, emit EmitFunc, opts ...StreamOpts) *Stream {
+	opt := &StreamOpt{
+		minLength: 20,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	return &Stream{ctx: ctx, syn: syn, emit: emit, cnf: opt}
+}
+
+// Write adds a text delta and synthesizes the sentences it completes. The synthesis runs
+// synchronously, so the caller's stream waits for it.
+func (s *Stream) Write(text string) error {
+	s.buf.WriteString(text)
+	t := s.buf.String()
+	end := sentenceEnd(t, s.cnf.minLength)
+	if end == 0 {
+		return nil
+	}
+	s.buf.Reset()
+	s.buf.WriteString(t[end:])
+	return s.speak(t[:end])
+}
+
+// Flush synthesizes the remaining text, e.g. a last sentence without final punctuation,
+// once the response is complete.
+func (s *Stream) Flush() error {
+	t := s.buf.String()
+	s.buf.Reset()
+	return s.speak(t)
+}
+
+// speak synthesizes text and emits the audio, blank text is skipped.
+func (s *Stream) speak(text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	a, err := s.syn.Synthesize(s.ctx, text)
+	if err != nil {
+		return err
+	}
+	return s.emit(text, a)
+}
+
+// sentenceEnd returns the end of the last complete sentence of t that is at least minLen
+// runes long, 0 if there is none. A sentence ends with a line break or with sentence
+// punctuation followed by white space; CJK full stops end a sentence by themselves.
+func sentenceEnd(t string, minLen int) int {
+	end := 0
+	for i, r := range t {
+		next := i + utf8.RuneLen(r)
+		switch r {
+		case '\n', '。', '！', '？', '；':
+		case '.', '!', '?', ';', ':':
+			if next >= len(t) {
+				continue
+			}
+			if n, _ := utf8.DecodeRuneInString(t[next:]); !unicode.IsSpace(n) {
+				continue
+			}
+		default:
+			continue
+		}
+		if utf8.RuneCountInString(t[:next]) >= minLen {
+			end = next
+		}
+	}
+	return end
+}
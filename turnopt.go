@@ -0,0 +1,135 @@
+package llm
+
+import (
+	"errors"
+	"maps"
+	"slices"
+
+	"github.com/xyzj/llm/chat"
+	"github.com/xyzj/llm/provider"
+)
+
+// ErrToolNotOffered is the error of tool calls to tools that were not offered in the turn,
+// see WithAllowedTools. It is sent to the model as the tool result.
+var ErrToolNotOffered = errors.New("tool is not available in this chat")
+
+type (
+	// TurnOpt configures a single chat turn, see ChatsManager.ChatContext.
+	TurnOpt struct {
+		allowed    []string           // Names of the tools offered in the turn, nil for all tools
+		denied     []string           // Names of the tools never offered in the turn
+		message    *chat.Message      // Additional parts of the user message, e.g. images
+		vars       map[string]string  // Template variables of the system prompts, see WithPrompts
+		regenerate bool               // Whether the turn answers the last user message again, see Regenerate
+		chatOpts   []chat.Opts        // Options of the model requests of the turn, see WithChatOptions
+		onTrace    func(*RunTrace)    // Called with the trace of the turn, see WithTraceFunc
+		events     func(*Event) error // Receives the events of the turn, see WithEventFunc
+	}
+	// TurnOpts is a function type for configuring a chat turn.
+	TurnOpts func(opt *TurnOpt)
+)
+
+// WithAllowedTools restricts the local and MCP tools offered to the model in the turn to
+// the given names, e.g. to give a chat access to search but not to file writes. The names
+// are the ones seen by the model, i.e. including the alias of their MCP server. Without
+// names no tools are offered.
+func WithAllowedTools(names ...string) TurnOpts {
+	return func(opt *TurnOpt) {
+		opt.allowed = append(slices.Clip(opt.allowed), names...)
+		if opt.allowed == nil {
+			opt.allowed = []string{}
+		}
+	}
+}
+
+// WithDeniedTools withholds the local and MCP tools with the given names from the model in
+// the turn. Denied tools are withheld even if allowed by WithAllowedTools.
+func WithDeniedTools(names ...string) TurnOpts {
+	return func(opt *TurnOpt) {
+		opt.denied = append(opt.denied, names...)
+	}
+}
+
+// WithMessage adds the parts of m, e.g. images for vision-capable models, to the user
+// message of the turn, after its text. The message may be empty then. The turn fails
+// with the error of m if building it failed.
+//
+// Example:
+//
+//	msg := chat.NewMessage("").ImageFile("/tmp/receipt.jpg")
+//	cm.ChatContext(ctx, id, "What is the total of this receipt?", w, llm.WithMessage(msg))
+func WithMessage(m *chat.Message) TurnOpts {
+	return func(opt *TurnOpt) {
+		opt.message = m
+	}
+}
+
+// WithVariables sets template variables of the system prompts for the turn, overriding
+// session metadata of the same name, see WithPrompts.
+func WithVariables(vars map[string]string) TurnOpts {
+	return func(opt *TurnOpt) {
+		if opt.vars == nil {
+			opt.vars = make(map[string]string, len(vars))
+		}
+		maps.Copy(opt.vars, vars)
+	}
+}
+
+// WithChatOptions sets options of the model requests of the turn, e.g. chat.WithModel or
+// chat.WithTemperature to override the settings of the session for this turn only. They
+// are applied after the options set by the manager.
+func WithChatOptions(opts ...chat.Opts) TurnOpts {
+	return func(opt *TurnOpt) {
+		opt.chatOpts = append(opt.chatOpts, opts...)
+	}
+}
+
+// WithTraceFunc sets a function called with the trace of the turn when it ended, e.g. to
+// report its token usage. Unlike LastTrace, it cannot return the trace of another turn of
+// the session running at the same time.
+func WithTraceFunc(f func(*RunTrace)) TurnOpts {
+	return func(opt *TurnOpt) {
+		opt.onTrace = f
+	}
+}
+
+// WithEventFunc sets the function receiving the events of the turn, e.g. context warnings
+// or the speech of WithTTS, see Event. Events are delivered separately from the response
+// text written to the write callback; without an event function they are dropped. Errors
+// of the function are logged and do not stop the turn.
+//
+// Example:
+//
+//	cm.ChatContext(ctx, id, message, sse.Write, llm.WithEventFunc(sse.WriteEvent))
+func WithEventFunc(f func(e *Event) error) TurnOpts {
+	return func(opt *TurnOpt) {
+		opt.events = f
+	}
+}
+
+// newTurnOpt applies the options of a turn.
+func newTurnOpt(opts []TurnOpts) *TurnOpt {
+	opt := &TurnOpt{}
+	for _, o := range opts {
+		o(opt)
+	}
+	return opt
+}
+
+// offers reports whether the tool with the given name may be offered in the turn.
+func (opt *TurnOpt) offers(name string) bool {
+	if slices.Contains(opt.denied, name) {
+		return false
+	}
+	return opt.allowed == nil || slices.Contains(opt.allowed, name)
+}
+
+// filter returns the tools of tls that may be offered in the turn.
+func (opt *TurnOpt) filter(tls []*provider.Tool) []*provider.Tool {
+	if opt.allowed == nil && len(opt.denied) == 0 {
+		return tls
+	}
+	return slices.DeleteFunc(slices.Clone(tls), func(t *provider.Tool) bool {
+		return !opt.offers(t.Function.Name)
+	})
+}
@@ -0,0 +1,91 @@
+package llm
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/xyzj/llm/provider"
+)
+
+// WarmReport is the result of ChatsManager.Warm.
+type WarmReport struct {
+	Sessions      int               `json:"sessions"`                 // Sessions active after warming
+	Restored      int               `json:"restored"`                 // Sessions whose history was restored from storage
+	Primed        int               `json:"primed"`                   // Sessions whose prompt was primed, see WithCachePriming
+	Errors        map[string]string `json:"errors,omitempty"`         // Restore and priming errors by chat identifier
+	ProviderOK    bool              `json:"provider_ok"`              // Whether the provider connection was warmed, true if it cannot be warmed
+	ProviderError string            `json:"provider_error,omitempty"` // Error of warming the provider
+	Tools         int               `json:"tools"`                    // Tools offered to the model
+	ToolsOK       bool              `json:"tools_ok"`                 // Whether an MCP server is reachable, true if none is configured
+	Duration      time.Duration     `json:"duration"`                 // Duration of the warm-up
+}
+
+// Warm is WarmContext with a context limited to 5 minutes.
+func (cm *ChatsManager) Warm(ids ...string) *WarmReport {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	return cm.WarmContext(ctx, ids...)
+}
+
+// WarmContext prepares the manager for expected traffic, e.g. before business hours,
+// so the first messages are not slowed down by cold connections and storage reads:
+//  1. The sessions of ids are created and their histories restored from storage
+//  2. The provider connection is warmed if the provider implements provider.Warmer
+//  3. The MCP servers are probed and their tool lists discovered
+//  4. The prompts of the restored sessions are primed in the provider's prompt cache,
+//     if enabled with WithCachePriming
+//
+// Sessions that are already active are left as they are. Up to 8 sessions are restored
+// and primed in parallel.
+//
+// Parameters:
+//   - ctx: Context controlling cancellation and deadline of the warm-up
+//   - ids: Identifiers of the chat sessions expected to be used, see ChatContext
+//
+// Returns:
+//   - *WarmReport: What was warmed and the errors encountered
+func (cm *ChatsManager) WarmContext(ctx context.Context, ids ...string) *WarmReport {
+	start := time.Now()
+	rep := &WarmReport{ProviderOK: true, ToolsOK: true, Errors: make(map[string]string)}
+	if wm, ok := cm.cnf.provider.(provider.Warmer); ok {
+		if err := wm.Warm(ctx); err != nil {
+			rep.ProviderOK, rep.ProviderError = false, err.Error()
+		}
+	}
+	if len(cm.mcpCli.Servers()) > 0 {
+		rep.ToolsOK = cm.mcpCli.Reachable(ctx)
+	}
+	tls := append(cm.allTools(), cm.cnf.builtinTools...)
+	rep.Tools = len(tls)
+	var locker sync.Mutex
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, 8)
+	for _, id := range ids {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			ch, restored, err := cm.openSession(ctx, id)
+			primed := false
+			if err == nil && restored && cm.cnf.cachePriming {
+				primed, err = ch.Prime(ctx, cm.systemPrompt(ch, &TurnOpt{}), tls)
+			}
+			locker.Lock()
+			defer locker.Unlock()
+			if restored {
+				rep.Restored++
+			}
+			if primed && err == nil {
+				rep.Primed++
+			}
+			if err != nil {
+				rep.Errors[id] = err.Error()
+				cm.cnf.logg.Warn("warm chat failed", LogKeyChatID, ch.ID(), LogKeyError, err)
+			}
+		})
+	}
+	wg.Wait()
+	rep.Sessions = len(cm.chats.Keys())
+	rep.Duration = time.Since(start)
+	return rep
+}